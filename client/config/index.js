@@ -35,6 +35,10 @@ module.exports = {
       '/api': {
         target: 'http://localhost:8083',
         changeOrigin: true
+      },
+      '/ws': {
+        target: 'ws://localhost:8083',
+        ws: true
       }
     },
     // CSS Sourcemaps off by default because relative paths are "buggy"
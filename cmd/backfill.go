@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "backfill-ocr",
+		Usage: "Add OCR text to volumes transcribed before it was recorded",
+		Run:   runBackfillOCR,
+	})
+}
+
+func runBackfillOCR(args []string) error {
+	flags := newFlagSet(Lookup("backfill-ocr"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	store, err := lib.NewDocumentStore(*repoPath)
+	if err != nil {
+		return err
+	}
+	idents := flags.Args()
+	if len(idents) == 0 {
+		if idents, err = store.Identifiers(); err != nil {
+			return err
+		}
+	}
+	numFailed := 0
+	for _, ident := range idents {
+		numUpdated, err := store.BackfillOCR(ident)
+		if err != nil {
+			log.Error().Err(err).Str("identifier", ident).Msg("Failed to backfill OCR text")
+			numFailed++
+			continue
+		}
+		log.Info().
+			Str("identifier", ident).
+			Int("numLines", numUpdated).
+			Msg("Backfilled OCR text")
+	}
+	if numFailed > 0 {
+		return fmt.Errorf("failed to backfill %d of %d volumes", numFailed, len(idents))
+	}
+	return nil
+}
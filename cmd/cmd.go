@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"archiscribe/lib"
+)
+
+// Version of the application, set at build time
+var Version = "v0"
+
+// BuildDate of the application, set at build time
+var BuildDate = ""
+
+// Command is a maintenance task that can be run instead of the server
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]*Command{}
+
+func init() {
+	lib.UserAgent = lib.DefaultUserAgent(Version)
+}
+
+func register(c *Command) {
+	commands[c.Name] = c
+}
+
+// Lookup returns the command with the given name or nil if there is none
+func Lookup(name string) *Command {
+	return commands[name]
+}
+
+// PrintCommands writes a short overview of all commands to stderr
+func PrintCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].Usage)
+	}
+}
+
+// newFlagSet creates the flag set for a command
+func newFlagSet(c *Command) *flag.FlagSet {
+	flags := flag.NewFlagSet(c.Name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: %s\n", c.Name, c.Usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// cacheDirFlag adds the flag for the cache directory to a command
+func cacheDirFlag(flags *flag.FlagSet) *string {
+	return flags.String("cacheDir", "", "Set cache directory, overrides the ARCHISCRIBE_CACHE environment variable")
+}
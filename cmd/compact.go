@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "compact-cache",
+		Usage: "Remove transcribed, expired and unreadable volumes from the cache",
+		Run:   runCompactCache,
+	})
+}
+
+func runCompactCache(args []string) error {
+	flags := newFlagSet(Lookup("compact-cache"))
+	cacheDir := cacheDirFlag(flags)
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	maxEntries := flags.Int("volumeCacheMaxEntries", 0, "Maximum number of cached volumes to keep, 0 for no limit")
+	maxAge := flags.Duration("volumeCacheMaxAge", lib.VolumeCacheMaxAge, "Age after which cached lines of a volume are removed, 0 to never expire")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	lib.VolumeCacheMaxAge = *maxAge
+	lib.InitCache(lib.ResolveCacheDir(*cacheDir))
+	store, err := lib.NewDocumentStore(*repoPath)
+	if err != nil {
+		return err
+	}
+	numRemoved, err := lib.CompactVolumeCache(store, nil, *maxEntries)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("numRemoved", numRemoved).Msg("Compacted volume cache")
+	return nil
+}
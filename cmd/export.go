@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "export",
+		Usage: "Export transcribed lines as a training set, optionally filtered by characters",
+		Run:   runExport,
+	})
+}
+
+func runExport(args []string) error {
+	flags := newFlagSet(Lookup("export"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	outDir := flags.String("out", "", "Directory to write line images and transcriptions to")
+	require := flags.String("require", "", "Only export lines containing one of these comma-separated characters or U+XXXX codepoints")
+	forbid := flags.String("forbid", "", "Skip lines containing any of these comma-separated characters or U+XXXX codepoints")
+	humanOnly := flags.Bool("humanOnly", false, "Skip lines that were accepted from the OCR as machine-verified")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	if *outDir == "" {
+		return fmt.Errorf("out must be set")
+	}
+	opts := lib.ExportOptions{HumanOnly: *humanOnly}
+	var err error
+	if opts.Charset.Required, err = lib.ParseCodepoints(*require); err != nil {
+		return err
+	}
+	if opts.Charset.Forbidden, err = lib.ParseCodepoints(*forbid); err != nil {
+		return err
+	}
+	store, err := lib.NewDocumentStore(*repoPath)
+	if err != nil {
+		return err
+	}
+	report, err := store.Export(*outDir, opts)
+	if err != nil {
+		return err
+	}
+	for char, count := range report.CharCounts {
+		log.Info().
+			Str("char", char).
+			Str("codepoint", fmt.Sprintf("U+%04X", []rune(char)[0])).
+			Int("numLines", count).
+			Msg("Exported lines with character")
+	}
+	log.Info().
+		Int("numLines", report.NumLines).
+		Int("numMatched", report.NumMatched).
+		Str("out", *outDir).
+		Msg("Exported lines")
+	return nil
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "export-identifiers",
+		Usage: "Write the identifier cache to a file that can be imported by other deployments",
+		Run:   runExportIdentifiers,
+	})
+	register(&Command{
+		Name:  "import-identifiers",
+		Usage: "Import an exported identifier cache, merging it with the existing one",
+		Run:   runImportIdentifiers,
+	})
+}
+
+func runExportIdentifiers(args []string) error {
+	flags := newFlagSet(Lookup("export-identifiers"))
+	cacheDir := cacheDirFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected the path of the export file")
+	}
+	cache, err := lib.OpenIdentifierCache(lib.ResolveCacheDir(*cacheDir))
+	if err != nil {
+		return err
+	}
+	if err := cache.Export(flags.Arg(0)); err != nil {
+		return err
+	}
+	log.Info().Str("path", flags.Arg(0)).Msg("Exported identifier cache")
+	return nil
+}
+
+func runImportIdentifiers(args []string) error {
+	flags := newFlagSet(Lookup("import-identifiers"))
+	cacheDir := cacheDirFlag(flags)
+	replace := flags.Bool("replace", false, "Replace the existing identifier cache instead of merging")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected the path of the export file")
+	}
+	cache, err := lib.OpenIdentifierCache(lib.ResolveCacheDir(*cacheDir))
+	if err != nil {
+		return err
+	}
+	numAdded, err := cache.Import(flags.Arg(0), *replace)
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Int("numAdded", numAdded).
+		Bool("replace", *replace).
+		Msg("Imported identifier cache")
+	return nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "export-jsonl",
+		Usage: "Export every transcribed line as JSON Lines, one object per line",
+		Run:   runExportJSONL,
+	})
+}
+
+func runExportJSONL(args []string) error {
+	flags := newFlagSet(Lookup("export-jsonl"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	outPath := flags.String("out", "", "File to write the lines to, stdout if empty")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	var out io.WriteCloser = os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	numLines, err := lib.ExportJSONL(*repoPath, out)
+	if *outPath != "" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Info().Int("numLines", numLines).Msg("Exported lines")
+	return nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "export-pagexml",
+		Usage: "Export the transcriptions of volumes as PAGE XML, one file per page",
+		Run:   runExportPageXML,
+	})
+}
+
+func runExportPageXML(args []string) error {
+	flags := newFlagSet(Lookup("export-pagexml"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	outDir := flags.String("out", "", "Directory to write PAGE XML files to")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	if *outDir == "" {
+		return fmt.Errorf("out must be set")
+	}
+	store, err := lib.NewDocumentStore(*repoPath)
+	if err != nil {
+		return err
+	}
+	idents := flags.Args()
+	if len(idents) == 0 {
+		if idents, err = store.Identifiers(); err != nil {
+			return err
+		}
+	}
+	numFailed := 0
+	for _, ident := range idents {
+		numPages, err := store.ExportPageXML(ident, *outDir)
+		if err != nil {
+			log.Error().Err(err).Str("identifier", ident).Msg("Failed to export volume")
+			numFailed++
+			continue
+		}
+		log.Info().
+			Str("identifier", ident).
+			Int("numPages", numPages).
+			Str("out", *outDir).
+			Msg("Exported volume as PAGE XML")
+	}
+	if numFailed > 0 {
+		return fmt.Errorf("failed to export %d of %d volumes", numFailed, len(idents))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "readme",
+		Usage: "Regenerate the README of the corpus from the transcriptions in the working copy",
+		Run:   runReadme,
+	})
+}
+
+func runReadme(args []string) error {
+	flags := newFlagSet(Lookup("readme"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	iiifBaseURL := flags.String("iiifBaseURL", lib.IIIFBaseURL, "Base URL of the IIIF manifest and viewer links to volumes")
+	archiveBaseURL := flags.String("archiveBaseURL", lib.ArchiveDetailsBaseURL, "Base URL of the links to the details pages of volumes")
+	sortBy := flags.String("sort", lib.ReadmeSort, "Order of the works table, 'date', 'title' or 'lines'")
+	contributors := flags.String("contributors", lib.ReadmeContributors, "List contributors with the number of their lines and works, 'omit', 'anonymous' or 'named'")
+	flags.Parse(args)
+	if *contributors != lib.ContributorsOmit && *contributors != lib.ContributorsAnonymous && *contributors != lib.ContributorsNamed {
+		return fmt.Errorf("contributors must be 'omit', 'anonymous' or 'named'")
+	}
+	lib.ReadmeContributors = *contributors
+	if *sortBy != lib.SortByDate && *sortBy != lib.SortByTitle && *sortBy != lib.SortByLines {
+		return fmt.Errorf("sort must be 'date', 'title' or 'lines'")
+	}
+	lib.ReadmeSort = *sortBy
+	lib.IIIFBaseURL = *iiifBaseURL
+	lib.ArchiveDetailsBaseURL = *archiveBaseURL
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	stats, err := lib.ComputeStats(*repoPath)
+	if err != nil {
+		return err
+	}
+	readme := lib.RenderReadme(stats)
+	readmePath := lib.ReadmePath
+	if !filepath.IsAbs(readmePath) {
+		readmePath = filepath.Join(*repoPath, readmePath)
+	}
+	previous, err := ioutil.ReadFile(readmePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	statsJSON, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	statsPath := filepath.Join(filepath.Dir(readmePath), lib.StatsFileName)
+	if err := lib.WriteFileAtomic(statsPath, append(statsJSON, '\n')); err != nil {
+		return err
+	}
+	added, removed := diffLines(string(previous), readme)
+	if added == 0 && removed == 0 {
+		log.Info().Str("path", readmePath).Msg("README is up to date")
+		return nil
+	}
+	if err := lib.WriteFileAtomic(readmePath, []byte(readme)); err != nil {
+		return err
+	}
+	log.Info().
+		Str("path", readmePath).
+		Int("linesAdded", added).
+		Int("linesRemoved", removed).
+		Msg("Regenerated README")
+	return nil
+}
+
+// diffLines counts the lines only present in the new or only in the old
+// text, regardless of their position
+func diffLines(previous string, current string) (added int, removed int) {
+	counts := make(map[string]int)
+	if previous != "" {
+		for _, line := range strings.Split(previous, "\n") {
+			counts[line]++
+		}
+	}
+	for _, line := range strings.Split(current, "\n") {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			added++
+		}
+	}
+	for _, count := range counts {
+		removed += count
+	}
+	return added, removed
+}
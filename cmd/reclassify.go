@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "reclassify",
+		Usage: "Classify the script of all identifiers classified by an older heuristic",
+		Run:   runReclassify,
+	})
+}
+
+func runReclassify(args []string) error {
+	flags := newFlagSet(Lookup("reclassify"))
+	cacheDir := cacheDirFlag(flags)
+	flags.Parse(args)
+	lib.InitCache(lib.ResolveCacheDir(*cacheDir))
+	numClassified := lib.IDCache.Reclassify(lib.ClassifyScript)
+	log.Info().
+		Int("numClassified", numClassified).
+		Int("heuristicVersion", lib.ScriptHeuristicVersion).
+		Msg("Reclassified identifiers")
+	return nil
+}
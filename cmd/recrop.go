@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "recrop",
+		Usage: "Recompute the line crops of transcribed volumes, keeping their transcriptions",
+		Run:   runRecrop,
+	})
+}
+
+func runRecrop(args []string) error {
+	flags := newFlagSet(Lookup("recrop"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	cropPadding := flags.Int("cropPadding", lib.CropPadding.Pixels, "Margin in pixels around line crops, with fixed padding")
+	cropPaddingFraction := flags.Float64("cropPaddingFraction", lib.CropPadding.Fraction, "Margin around line crops as a fraction of the line height, with proportional padding")
+	cropPaddingMode := flags.String("cropPaddingMode", lib.CropPadding.Mode, "Padding of line crops, 'fixed' or 'proportional'")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	if *cropPaddingMode != lib.PaddingFixed && *cropPaddingMode != lib.PaddingProportional {
+		return fmt.Errorf("cropPaddingMode must be 'fixed' or 'proportional'")
+	}
+	lib.CropPadding = lib.Padding{
+		Mode:     *cropPaddingMode,
+		Pixels:   *cropPadding,
+		Fraction: *cropPaddingFraction,
+	}
+	store, err := lib.NewDocumentStore(*repoPath)
+	if err != nil {
+		return err
+	}
+	idents := flags.Args()
+	if len(idents) == 0 {
+		if idents, err = store.Identifiers(); err != nil {
+			return err
+		}
+	}
+	numFailed := 0
+	for _, ident := range idents {
+		changed, err := store.Recrop(ident)
+		if err != nil {
+			log.Error().Err(err).Str("identifier", ident).Msg("Failed to recrop lines")
+			numFailed++
+			continue
+		}
+		for _, line := range changed {
+			log.Info().
+				Str("identifier", ident).
+				Str("lineId", line.Identifier).
+				Str("oldImageUrl", line.OldImageURL).
+				Str("newImageUrl", line.NewImageURL).
+				Msg("Recropped line")
+		}
+		log.Info().
+			Str("identifier", ident).
+			Int("numLines", len(changed)).
+			Msg("Recropped volume")
+	}
+	if numFailed > 0 {
+		return fmt.Errorf("failed to recrop %d of %d volumes", numFailed, len(idents))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "refresh-identifiers",
+		Usage: "Add identifiers that were added to Archive.org since the last refresh to the identifier cache",
+		Run:   runRefreshIdentifiers,
+	})
+}
+
+func runRefreshIdentifiers(args []string) error {
+	flags := newFlagSet(Lookup("refresh-identifiers"))
+	cacheDir := cacheDirFlag(flags)
+	repoPath := flags.String("repoPath", "", "Set repository path, volumes transcribed into it are not added again")
+	searchQuery := flags.String("query", lib.DefaultIdentifierQuery.Query, "Archive.org search query the identifier cache was built from")
+	collection := flags.String("collection", "", "Archive.org collection the identifier cache was built from")
+	flags.Parse(args)
+	lib.IdentifierSearch = lib.IdentifierQuery{Query: *searchQuery, Collection: *collection}
+	lib.InitCache(lib.ResolveCacheDir(*cacheDir))
+	var store *lib.DocumentStore
+	if *repoPath != "" {
+		var err error
+		if store, err = lib.NewDocumentStore(*repoPath); err != nil {
+			return err
+		}
+	}
+	since := lib.IDCache.LastRefreshed()
+	numAdded, err := lib.RefreshIdentifierCache(store)
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Int("numAdded", numAdded).
+		Time("since", since).
+		Msg("Refreshed identifier cache")
+	return nil
+}
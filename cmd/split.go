@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "split",
+		Usage: "Partition the transcribed lines into reproducible train, validation and test splits",
+		Run:   runSplit,
+	})
+}
+
+// parseRatios parses a comma-separated list of split ratios
+func parseRatios(spec string) ([]float64, error) {
+	var ratios []float64
+	for _, token := range strings.Split(spec, ",") {
+		ratio, err := strconv.ParseFloat(strings.TrimSpace(token), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid split ratio '%s'", token)
+		}
+		ratios = append(ratios, ratio)
+	}
+	return ratios, nil
+}
+
+func runSplit(args []string) error {
+	flags := newFlagSet(Lookup("split"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	outDir := flags.String("out", "", "Directory to write the train.txt, val.txt and test.txt manifests to")
+	ratioSpec := flags.String("ratios", "0.8,0.1,0.1", "Comma-separated relative sizes of the train, validation and test splits")
+	seed := flags.Int64("seed", 0, "Seed of the split, the same seed and corpus always produce the same splits")
+	byVolume := flags.Bool("byVolume", false, "Keep all lines of a volume in the same split")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	if *outDir == "" {
+		return fmt.Errorf("out must be set")
+	}
+	ratios, err := parseRatios(*ratioSpec)
+	if err != nil {
+		return err
+	}
+	opts := lib.SplitOptions{Ratios: ratios, Seed: *seed, ByVolume: *byVolume}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	store, err := lib.NewDocumentStore(*repoPath)
+	if err != nil {
+		return err
+	}
+	report, err := store.Split(*outDir, opts)
+	if err != nil {
+		return err
+	}
+	for _, name := range lib.SplitNames {
+		log.Info().
+			Str("split", name).
+			Int("numLines", report.NumLines[name]).
+			Int("numVolumes", report.NumVolumes[name]).
+			Msg("Wrote split manifest")
+	}
+	log.Info().Str("out", *outDir).Int64("seed", *seed).Bool("byVolume", *byVolume).
+		Msg("Split corpus")
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func init() {
+	register(&Command{
+		Name:  "verify",
+		Usage: "Check the transcriptions in the working copy for malformed metadata and missing files",
+		Run:   runVerify,
+	})
+}
+
+func runVerify(args []string) error {
+	flags := newFlagSet(Lookup("verify"))
+	repoPath := flags.String("repoPath", "", "Set repository path")
+	checkImages := flags.Bool("checkImages", false, "Also check that the line image URLs on Archive.org still resolve, slow")
+	flags.Parse(args)
+	if *repoPath == "" {
+		return fmt.Errorf("repoPath must be set")
+	}
+	problems, err := lib.VerifyCorpus(context.Background(), *repoPath, *checkImages)
+	if err != nil {
+		return err
+	}
+	volumes := make(map[string]bool)
+	for _, problem := range problems {
+		fmt.Println(problem)
+		volumes[problem.Path] = true
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems in %d volumes", len(problems), len(volumes))
+	}
+	log.Info().Str("repoPath", *repoPath).Msg("No problems found")
+	return nil
+}
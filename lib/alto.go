@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ALTONamespace is the namespace of the ALTO schema version the export
+// conforms to
+const ALTONamespace = "http://www.loc.gov/standards/alto/ns-v4#"
+
+const altoSchemaLocation = ALTONamespace + " http://www.loc.gov/alto/v4/alto-4-2.xsd"
+
+type altoDocument struct {
+	XMLName        xml.Name        `xml:"alto"`
+	Xmlns          string          `xml:"xmlns,attr"`
+	XmlnsXSI       string          `xml:"xmlns:xsi,attr"`
+	SchemaLocation string          `xml:"xsi:schemaLocation,attr"`
+	Description    altoDescription `xml:"Description"`
+	Pages          []altoPage      `xml:"Layout>Page"`
+}
+
+type altoDescription struct {
+	MeasurementUnit string `xml:"MeasurementUnit"`
+	FileName        string `xml:"sourceImageInformation>fileName"`
+}
+
+// altoGeometry is the position and size of an element, the attributes are
+// omitted if the geometry is unknown
+type altoGeometry struct {
+	HPos   *int `xml:"HPOS,attr,omitempty"`
+	VPos   *int `xml:"VPOS,attr,omitempty"`
+	Width  *int `xml:"WIDTH,attr,omitempty"`
+	Height *int `xml:"HEIGHT,attr,omitempty"`
+}
+
+type altoPage struct {
+	ID         string         `xml:"ID,attr"`
+	PhysicalNo int            `xml:"PHYSICAL_IMG_NR,attr"`
+	Width      int            `xml:"WIDTH,attr,omitempty"`
+	Height     int            `xml:"HEIGHT,attr,omitempty"`
+	PrintSpace altoPrintSpace `xml:"PrintSpace"`
+}
+
+type altoPrintSpace struct {
+	altoGeometry
+	Block altoTextBlock `xml:"TextBlock"`
+}
+
+type altoTextBlock struct {
+	ID string `xml:"ID,attr"`
+	altoGeometry
+	Lines []altoTextLine `xml:"TextLine"`
+}
+
+type altoTextLine struct {
+	ID string `xml:"ID,attr"`
+	altoGeometry
+	// altoString and altoSpace elements in reading order
+	Content []interface{}
+}
+
+type altoString struct {
+	XMLName xml.Name `xml:"String"`
+	Content string   `xml:"CONTENT,attr"`
+}
+
+type altoSpace struct {
+	XMLName xml.Name `xml:"SP"`
+}
+
+func newALTOGeometry(box *BBox) altoGeometry {
+	if box == nil {
+		return altoGeometry{}
+	}
+	width := box.Right - box.Left
+	height := box.Bottom - box.Top
+	return altoGeometry{
+		HPos: &box.Left, VPos: &box.Top, Width: &width, Height: &height,
+	}
+}
+
+// newALTOTextLine splits the transcription of a line into String elements,
+// one per word. Word positions are unknown, only the line has a geometry.
+func newALTOTextLine(line OCRLine) altoTextLine {
+	textLine := altoTextLine{
+		ID:           "line_" + line.Identifier,
+		altoGeometry: newALTOGeometry(line.BBox),
+	}
+	for idx, word := range strings.Fields(line.Transcription) {
+		if idx > 0 {
+			textLine.Content = append(textLine.Content, altoSpace{})
+		}
+		textLine.Content = append(textLine.Content, altoString{Content: word})
+	}
+	return textLine
+}
+
+// ExportALTO writes the transcribed lines of a document to a single ALTO
+// XML file named <identifier>.xml, with one Page element per page.
+// Coordinates are in pixels, lines without a bounding box are written
+// without them and lines whose page cannot be determined are skipped.
+// Returns the number of exported pages.
+func ExportALTO(doc *Document, outDir string) (int, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return 0, err
+	}
+	altoDoc := altoDocument{
+		Xmlns:          ALTONamespace,
+		XmlnsXSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: altoSchemaLocation,
+		Description: altoDescription{
+			MeasurementUnit: "pixel",
+			FileName:        ManifestURL(doc.Identifier),
+		},
+	}
+	pages := transcribedPages(doc)
+	for _, page := range pages {
+		block := altoTextBlock{
+			ID:           fmt.Sprintf("block_%04d", page.Number),
+			altoGeometry: newALTOGeometry(page.BBox),
+		}
+		for _, line := range page.Lines {
+			block.Lines = append(block.Lines, newALTOTextLine(line))
+		}
+		altoDoc.Pages = append(altoDoc.Pages, altoPage{
+			ID:         fmt.Sprintf("page_%04d", page.Number),
+			PhysicalNo: page.Number,
+			Width:      page.Width,
+			Height:     page.Height,
+			PrintSpace: altoPrintSpace{
+				altoGeometry: newALTOGeometry(page.BBox),
+				Block:        block,
+			},
+		})
+	}
+	raw, err := xml.MarshalIndent(altoDoc, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	data := append([]byte(xml.Header), raw...)
+	outPath := filepath.Join(outDir, doc.Identifier+".xml")
+	if err := WriteFileAtomic(outPath, append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(pages), nil
+}
+
+// ExportALTO writes the transcriptions of a volume in the repository as
+// ALTO XML, see ExportALTO
+func (s *DocumentStore) ExportALTO(ident string, outDir string) (int, error) {
+	metaPath := s.metaPath(ident)
+	if metaPath == "" {
+		return 0, ErrDocumentNotFound
+	}
+	doc, err := s.readDocument(metaPath)
+	if err != nil {
+		return 0, err
+	}
+	return ExportALTO(doc, outDir)
+}
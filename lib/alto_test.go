@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Subset of ALTO to check exported files against
+type parsedALTO struct {
+	XMLName         xml.Name `xml:"http://www.loc.gov/standards/alto/ns-v4# alto"`
+	SchemaLocation  string   `xml:"schemaLocation,attr"`
+	MeasurementUnit string   `xml:"Description>MeasurementUnit"`
+	Pages           []struct {
+		ID         string `xml:"ID,attr"`
+		PhysicalNo int    `xml:"PHYSICAL_IMG_NR,attr"`
+		Lines      []struct {
+			ID      string `xml:"ID,attr"`
+			HPos    string `xml:"HPOS,attr"`
+			Strings []struct {
+				Content string `xml:"CONTENT,attr"`
+			} `xml:"String"`
+		} `xml:"PrintSpace>TextBlock>TextLine"`
+	} `xml:"Layout>Page"`
+}
+
+func TestExportALTOGolden(t *testing.T) {
+	tests := []struct {
+		ident string
+		// Expected number of pages and words
+		numPages int
+		numWords int
+		// Whether the lines have a geometry
+		geometry bool
+	}{
+		{"bote_1848", 2, 15, true},
+		{"kalender_1873", 1, 3, false},
+	}
+	store := &DocumentStore{basePath: fixtureCorpus}
+	for _, tc := range tests {
+		t.Run(tc.ident, func(t *testing.T) {
+			outDir := t.TempDir()
+			numPages, err := store.ExportALTO(tc.ident, outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numPages != tc.numPages {
+				t.Errorf("exported %d pages, want %d", numPages, tc.numPages)
+			}
+			raw, err := ioutil.ReadFile(filepath.Join(outDir, tc.ident+".xml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, tc.ident+".alto.golden.xml", raw)
+
+			var parsed parsedALTO
+			if err := xml.Unmarshal(raw, &parsed); err != nil {
+				t.Fatalf("export is not well-formed ALTO: %v", err)
+			}
+			if parsed.MeasurementUnit != "pixel" || parsed.SchemaLocation != altoSchemaLocation {
+				t.Errorf("unexpected description %q, schema location %q",
+					parsed.MeasurementUnit, parsed.SchemaLocation)
+			}
+			numWords := 0
+			for _, page := range parsed.Pages {
+				for _, line := range page.Lines {
+					numWords += len(line.Strings)
+					if (line.HPos != "") != tc.geometry {
+						t.Errorf("line %s has position %q", line.ID, line.HPos)
+					}
+				}
+			}
+			if numWords != tc.numWords {
+				t.Errorf("exported %d words, want %d", numWords, tc.numWords)
+			}
+		})
+	}
+}
+
+func TestExportALTOUnknownVolume(t *testing.T) {
+	store := &DocumentStore{basePath: fixtureCorpus}
+	if _, err := store.ExportALTO("missing_1850", t.TempDir()); err != ErrDocumentNotFound {
+		t.Errorf("expected ErrDocumentNotFound, got %v", err)
+	}
+}
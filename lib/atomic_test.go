@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dirEntries returns the names of all files in a directory
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestWriteAtomicInterrupted(t *testing.T) {
+	const full = `[{"id": "a1b2", "text": "Erste Zeile"}, {"id": "c3d4", "text": "Zweite Zeile"}]`
+	errInterrupted := errors.New("interrupted")
+	tests := []struct {
+		name string
+		// Contents of the file before the write, none if empty
+		previous string
+	}{
+		{"new file", ""},
+		{"existing file", `[{"id": "a1b2", "text": "Alte Zeile"}]`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "kalender_1862.json")
+			if tc.previous != "" {
+				if err := ioutil.WriteFile(path, []byte(tc.previous), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Readers only see the previous contents while the write is under
+			// way and after it was interrupted
+			check := func(when string) {
+				got, err := ioutil.ReadFile(path)
+				if tc.previous == "" {
+					if !os.IsNotExist(err) {
+						t.Errorf("%s: file is visible with %q", when, got)
+					}
+				} else if string(got) != tc.previous {
+					t.Errorf("%s: file is %q, want %q", when, got, tc.previous)
+				}
+			}
+			err := writeAtomic(path, func(w io.Writer) error {
+				if _, err := io.WriteString(w, full[:len(full)/2]); err != nil {
+					return err
+				}
+				check("during the write")
+				return errInterrupted
+			})
+			if err != errInterrupted {
+				t.Fatalf("expected the interruption, got %v", err)
+			}
+			check("after the interruption")
+			want := 0
+			if tc.previous != "" {
+				want = 1
+			}
+			if names := dirEntries(t, dir); len(names) != want {
+				t.Errorf("temporary files were left behind: %v", names)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "README.md")
+	for _, contents := range []string{"# Korpus\n", "# Korpus\n\n42 Zeilen\n"} {
+		if err := WriteFileAtomic(path, []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != contents {
+			t.Errorf("file is %q, want %q", got, contents)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("file has mode %v, want 0644", info.Mode().Perm())
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("temporary files were left behind: %v", names)
+	}
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "README.md"), nil); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
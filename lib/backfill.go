@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Identifiers lists the identifiers of all documents in the store
+func (s *DocumentStore) Identifiers() ([]string, error) {
+	transPath := filepath.Join(s.basePath, "transcriptions")
+	metaPaths, err := filepath.Glob(filepath.Join(transPath, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	idents := make([]string, 0, len(metaPaths))
+	for _, metaPath := range metaPaths {
+		idents = append(idents, strings.TrimSuffix(filepath.Base(metaPath), ".json"))
+	}
+	return idents, nil
+}
+
+// hasOCRText checks if any of the document's lines has the OCR text stored
+func hasOCRText(doc *Document) bool {
+	for _, line := range doc.Lines {
+		if line.OCRText != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// BackfillOCR re-fetches the OCR for a stored document and adds the OCR
+// text to its lines. The human transcriptions are not touched. Documents
+// that already have OCR text are skipped, so an interrupted backfill can
+// simply be restarted. Returns the number of lines that were updated.
+func (s *DocumentStore) BackfillOCR(ident string) (int, error) {
+	logger := log.With().Str("identifier", ident).Logger()
+	metaPath := s.metaPath(ident)
+	if metaPath == "" {
+		return 0, ErrDocumentNotFound
+	}
+	doc, err := s.readDocument(metaPath)
+	if err != nil {
+		return 0, err
+	}
+	if hasOCRText(doc) {
+		logger.Info().Msg("Document already has OCR text, skipping")
+		return 0, nil
+	}
+
+	ocrLines, err := FetchAllLines(ident)
+	if err != nil {
+		return 0, err
+	}
+	ocrTexts := make(map[string]string, len(ocrLines))
+	for _, line := range ocrLines {
+		ocrTexts[line.ImageURL] = line.OCRText
+	}
+
+	unlockRepo, err := s.lockRepo()
+	if err != nil {
+		return 0, err
+	}
+	defer unlockRepo()
+	lock, err := lockVolume(s.lockDir, ident)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+	if err := s.syncRepo(logger); err != nil {
+		return 0, err
+	}
+	// Re-read after pulling, the document could have changed in the meantime
+	if doc, err = s.readDocument(metaPath); err != nil {
+		return 0, err
+	}
+	numUpdated := 0
+	for idx, line := range doc.Lines {
+		if text := ocrTexts[line.ImageURL]; text != "" {
+			doc.Lines[idx].OCRText = text
+			numUpdated++
+		}
+	}
+	if numUpdated == 0 {
+		logger.Warn().Msg("Could not match any lines to the OCR")
+		return 0, nil
+	}
+	if err := s.writeMetadata(*doc); err != nil {
+		return 0, err
+	}
+	commitMessage := fmt.Sprintf(
+		"Backfilled OCR text for %d lines from %s (%d)", numUpdated,
+		doc.Identifier, doc.Year)
+	if _, _, err := s.commitAndPush(doc.Identifier, commitMessage, "", "", logger); err != nil {
+		return 0, err
+	}
+	return numUpdated, nil
+}
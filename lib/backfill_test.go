@@ -0,0 +1,68 @@
+package lib
+
+import "testing"
+
+func TestBackfillOCR(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the stored lines already have their OCR text
+		backfilled bool
+		want       int
+	}{
+		{"missing OCR text", false, 3},
+		{"already backfilled", true, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			const ident = "intelligenzblatt_1857"
+			server := archiveServer(t, ident, 12, 3)
+			defer server.Close()
+			routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+			store, _ := newTestStore(t)
+
+			ocrLines, err := fetchAllLinesUncached(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transcriptions := []string{"Erste Zeile", "Zweite Zeile", "Dritte Zeile"}
+			doc := Document{Identifier: ident, Title: "Intelligenzblatt", Year: 1857}
+			for idx, line := range ocrLines {
+				line.Transcription = transcriptions[idx]
+				if !tc.backfilled {
+					line.OCRText = ""
+				}
+				cacheTestLine(t, ident, line)
+				doc.Lines = append(doc.Lines, line)
+			}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+			commits := git(t, store.basePath, "rev-list", "--count", "HEAD")
+
+			numUpdated, err := store.BackfillOCR(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numUpdated != tc.want {
+				t.Errorf("updated %d lines, want %d", numUpdated, tc.want)
+			}
+			stored := store.Details(ident)
+			for _, line := range stored.Lines {
+				if line.OCRText == "" {
+					t.Errorf("line %s has no OCR text", line.Identifier)
+				}
+			}
+			for idx, line := range ocrLines {
+				if got := stored.Lines[idx]; got.Identifier != line.Identifier || got.Transcription != transcriptions[idx] {
+					t.Errorf("line %d changed to %+v", idx, got)
+				}
+			}
+			after := git(t, store.basePath, "rev-list", "--count", "HEAD")
+			if tc.backfilled && after != commits {
+				t.Errorf("skipped volume was committed again")
+			} else if !tc.backfilled && after == commits {
+				t.Errorf("backfilled OCR text was not committed")
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BatchOptions configures how submissions are collected into shared commits
+type BatchOptions struct {
+	// A batch is committed at the latest this long after its first
+	// submission, batching is disabled if this is zero
+	Window time.Duration
+	// A batch is committed as soon as it holds this many submissions, 0 for
+	// no limit
+	MaxSize int
+}
+
+// Enabled checks if submissions are batched
+func (o BatchOptions) Enabled() bool {
+	return o.Window > 0
+}
+
+// CommitBatching collects submissions into shared commits instead of
+// committing and pushing every submission on its own
+var CommitBatching BatchOptions
+
+// batchedSubmission is a validated submission waiting for its batch to be
+// committed
+type batchedSubmission struct {
+	doc         Document
+	reviewFlags map[string][]string
+	author      string
+	email       string
+	comment     string
+	result      chan SubmitResult
+}
+
+// commitBatch collects submissions until they are committed. Batches are
+// committed in order by a single worker. A batch only holds submissions by
+// the same author, since a commit has a single author, and at most one per
+// volume, so that the changes of each can be told apart. A submission that
+// does not fit into the pending batch commits it early.
+type commitBatch struct {
+	mutex   sync.Mutex
+	pending []*batchedSubmission
+	timer   *time.Timer
+	closed  bool
+	queue   chan []*batchedSubmission
+	// Batches that are queued or being committed
+	inFlight sync.WaitGroup
+}
+
+// fits checks if a submission can join the pending batch
+func (b *commitBatch) fits(sub *batchedSubmission) bool {
+	for _, pending := range b.pending {
+		if pending.author != sub.author || pending.email != sub.email ||
+			pending.doc.Identifier == sub.doc.Identifier {
+			return false
+		}
+	}
+	return true
+}
+
+// flushLocked queues the pending batch for the worker, the mutex must be held
+func (b *commitBatch) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	b.inFlight.Add(1)
+	b.queue <- b.pending
+	b.pending = nil
+}
+
+// submitBatched adds a submission to the pending batch and waits until it
+// was committed
+func (s *DocumentStore) submitBatched(sub *batchedSubmission) SubmitResult {
+	sub.result = make(chan SubmitResult, 1)
+	b := &s.batch
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		s.commitSubmissions([]*batchedSubmission{sub})
+		return <-sub.result
+	}
+	if b.queue == nil {
+		b.queue = make(chan []*batchedSubmission, 16)
+		go s.commitBatchWorker()
+	}
+	if !b.fits(sub) {
+		b.flushLocked()
+	}
+	b.pending = append(b.pending, sub)
+	if CommitBatching.MaxSize > 0 && len(b.pending) >= CommitBatching.MaxSize {
+		b.flushLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(CommitBatching.Window, func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			b.flushLocked()
+		})
+	}
+	b.mutex.Unlock()
+	return <-sub.result
+}
+
+// FlushBatch commits the pending submissions and stops batching, later
+// submissions are committed on their own. Returns once all batches were
+// committed, it is called when shutting down so no submission is lost.
+func (s *DocumentStore) FlushBatch() {
+	b := &s.batch
+	b.mutex.Lock()
+	b.closed = true
+	if b.queue != nil {
+		b.flushLocked()
+	}
+	b.mutex.Unlock()
+	b.inFlight.Wait()
+}
+
+func (s *DocumentStore) commitBatchWorker() {
+	for subs := range s.batch.queue {
+		s.commitSubmissions(subs)
+		s.batch.inFlight.Done()
+	}
+}
+
+// commitSubmissions stages all submissions and commits them together, every
+// submission receives the result on its channel. A submission that cannot
+// be staged fails on its own, the others are staged again without it.
+func (s *DocumentStore) commitSubmissions(subs []*batchedSubmission) {
+	logger := log.With().Int("numSubmissions", len(subs)).Logger()
+	unlockRepo, err := s.lockRepo()
+	if err != nil {
+		for _, sub := range subs {
+			sub.result <- SubmitResult{Error: err}
+		}
+		return
+	}
+	defer unlockRepo()
+	remaining := make([]*batchedSubmission, 0, len(subs))
+	for _, sub := range subs {
+		lock, err := lockVolume(s.lockDir, sub.doc.Identifier)
+		if err != nil {
+			sub.result <- SubmitResult{Error: err}
+			continue
+		}
+		defer lock.Unlock()
+		remaining = append(remaining, sub)
+	}
+	failAll := func(err error) {
+		for _, sub := range remaining {
+			sub.result <- SubmitResult{Error: err}
+		}
+	}
+	if len(remaining) == 0 {
+		return
+	}
+	if err := s.syncRepo(logger); err != nil {
+		failAll(err)
+		return
+	}
+	var staged []*stagedVolume
+	for {
+		var failed int
+		var err error
+		if staged, failed, err = s.stageSubmissions(remaining); err == nil {
+			break
+		}
+		logger.Error().Err(err).Str("identifier", remaining[failed].doc.Identifier).
+			Msg("Could not stage submission, dropping it from the batch")
+		remaining[failed].result <- SubmitResult{Error: err}
+		remaining = append(remaining[:failed:failed], remaining[failed+1:]...)
+		// Discard everything that was staged and start over
+		if err := s.repo.CleanUp(); err != nil {
+			failAll(err)
+			return
+		}
+		if len(remaining) == 0 {
+			return
+		}
+	}
+	var commit, pullRequest string
+	if len(staged) > 0 {
+		var err error
+		author, email := remaining[0].author, remaining[0].email
+		commit, pullRequest, err = s.commitAndPush(
+			staged[0].ident, batchMessage(staged), author, email, logger)
+		if err != nil {
+			failAll(err)
+			return
+		}
+	}
+	for _, sub := range remaining {
+		result := SubmitResult{Commit: commit, PullRequest: pullRequest}
+		if doc := s.Details(sub.doc.Identifier); doc != nil {
+			result.Document = *doc
+		}
+		sub.result <- result
+	}
+}
+
+// stageSubmissions stages the submissions one after another. Returns the
+// staged volumes, submissions without changes are left out, or the index of
+// the submission that failed.
+func (s *DocumentStore) stageSubmissions(subs []*batchedSubmission) ([]*stagedVolume, int, error) {
+	var staged []*stagedVolume
+	for idx, sub := range subs {
+		logger := log.With().Str("identifier", sub.doc.Identifier).Logger()
+		alreadyStaged, err := s.repo.Diff(true)
+		if err != nil {
+			return nil, idx, err
+		}
+		volume, err := s.stageVolume(
+			sub.doc, sub.reviewFlags, sub.author, sub.email, sub.comment,
+			alreadyStaged, logger)
+		if err != nil {
+			return nil, idx, err
+		}
+		if volume != nil {
+			staged = append(staged, volume)
+		}
+	}
+	return staged, 0, nil
+}
+
+// batchMessage builds the commit message for a batch. A single volume gets
+// its usual message, otherwise the subjects of all volumes are listed in the
+// body, followed by their comments and the merged trailers.
+func batchMessage(staged []*stagedVolume) string {
+	if len(staged) == 1 {
+		return staged[0].message()
+	}
+	subjects := make([]string, 0, len(staged))
+	var comments []string
+	var trailers []string
+	seenTrailers := make(map[string]bool)
+	for _, volume := range staged {
+		subjects = append(subjects, volume.subject)
+		if volume.comment != "" {
+			comments = append(comments, fmt.Sprintf("%s: %s", volume.ident, volume.comment))
+		}
+		for _, trailer := range strings.Split(volume.trailers, "\n") {
+			if trailer != "" && !seenTrailers[trailer] {
+				seenTrailers[trailer] = true
+				trailers = append(trailers, trailer)
+			}
+		}
+	}
+	message := fmt.Sprintf("Submitted %d volumes\n\n%s", len(staged), strings.Join(subjects, "\n"))
+	if len(comments) > 0 {
+		message += "\n\n" + strings.Join(comments, "\n")
+	}
+	if len(trailers) > 0 {
+		message += "\n\n" + strings.Join(trailers, "\n")
+	}
+	return message
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MaxConsecutiveFailures is the number of failed fetches in a row after
+// which an identifier is blocked for the rest of the session, never if zero
+var MaxConsecutiveFailures = 3
+
+// Blocklist holds identifiers that are never picked or cached, both from a
+// file maintained by hand and from repeated fetch failures
+type Blocklist struct {
+	mutex sync.RWMutex
+	path  string
+	// Identifiers from the file, replaced on every reload
+	listed map[string]bool
+	// Identifiers blocked after failing too often, kept until restart
+	failed   map[string]bool
+	failures map[string]int
+}
+
+// NewBlocklist creates a blocklist that is loaded from a file with one
+// identifier per line. Blank lines and lines starting with # are ignored.
+// An empty path only blocks failing identifiers.
+func NewBlocklist(path string) *Blocklist {
+	return &Blocklist{
+		path:     path,
+		listed:   make(map[string]bool),
+		failed:   make(map[string]bool),
+		failures: make(map[string]int),
+	}
+}
+
+// Blocked is the global blocklist
+var Blocked = NewBlocklist("")
+
+// Path returns the path of the blocklist file, empty if there is none
+func (b *Blocklist) Path() string {
+	return b.path
+}
+
+// Reload reads the blocklist file again. The previous identifiers from the
+// file are kept if it cannot be read.
+func (b *Blocklist) Reload() error {
+	if b.path == "" {
+		return nil
+	}
+	in, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		ident := strings.TrimSpace(scanner.Text())
+		if ident != "" && !strings.HasPrefix(ident, "#") {
+			listed[ident] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	b.listed = listed
+	b.mutex.Unlock()
+	log.Info().Str("path", b.path).Int("numBlocked", len(listed)).Msg("Loaded blocklist")
+	return nil
+}
+
+// Contains checks if an identifier is blocked
+func (b *Blocklist) Contains(ident string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.listed[ident] || b.failed[ident]
+}
+
+// RecordFailure counts a failed fetch of an identifier and blocks it once
+// it failed too often in a row. Returns true if the identifier is blocked.
+func (b *Blocklist) RecordFailure(ident string, err error) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures[ident]++
+	if b.failed[ident] || MaxConsecutiveFailures <= 0 || b.failures[ident] < MaxConsecutiveFailures {
+		return b.listed[ident] || b.failed[ident]
+	}
+	b.failed[ident] = true
+	log.Warn().
+		Err(err).
+		Str("identifier", ident).
+		Int("numFailures", b.failures[ident]).
+		Msg("Blocking identifier after repeated failures")
+	return true
+}
+
+// RecordSuccess resets the failure count of an identifier
+func (b *Blocklist) RecordSuccess(ident string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.failures, ident)
+}
+
+// Len returns the number of blocked identifiers
+func (b *Blocklist) Len() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	num := len(b.listed)
+	for ident := range b.failed {
+		if !b.listed[ident] {
+			num++
+		}
+	}
+	return num
+}
+
+// sendFetchError reports a failed fetch to the consumer and counts it
+// against the identifier, unless the fetch was cancelled
+func sendFetchError(ctx context.Context, ident string, progressChan chan ProgressMessage, msg ProgressMessage) {
+	if ctx.Err() == nil {
+		Blocked.RecordFailure(ident, msg.Error)
+	}
+	sendProgress(ctx, progressChan, msg)
+}
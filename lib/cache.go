@@ -1,13 +1,20 @@
 package lib
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"io"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
 	"io/ioutil"
 	"math/rand"
-	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -20,12 +27,37 @@ import (
 type IdentifierCacheEntry struct {
 	Identifier string `json:"id"`
 	NumPages   int    `json:"numPages"`
+	MediaType  string `json:"mediatype,omitempty"`
+	// Script the identifier is set in and the version of the heuristic
+	// that determined it
+	Script        string `json:"script,omitempty"`
+	ScriptVersion int    `json:"scriptVersion,omitempty"`
 }
 
-// IdentifierCache stores suitable identifiers
+// IsClassified checks if the script of the entry was determined with the
+// current version of the heuristic
+func (e IdentifierCacheEntry) IsClassified() bool {
+	return e.Script != "" && e.ScriptVersion == ScriptHeuristicVersion
+}
+
+// IdentifierCache stores suitable identifiers. It is safe for concurrent
+// use, since it is shared by request handlers and background workers.
 type IdentifierCache struct {
 	path    string
+	mutex   sync.RWMutex
 	entries map[int][]IdentifierCacheEntry
+	// Time of the last query of Archive.org, zero if unknown
+	lastRefreshed time.Time
+	// Search query the identifiers were scraped with, empty if unknown
+	query string
+}
+
+// identifierCacheFile is the on-disk format of the cache. Older caches
+// only contain the entries.
+type identifierCacheFile struct {
+	Query         string                         `json:"query,omitempty"`
+	LastRefreshed time.Time                      `json:"lastRefreshed"`
+	Entries       map[int][]IdentifierCacheEntry `json:"entries"`
 }
 
 // NewIdentifierCache constructs a new cache
@@ -39,40 +71,244 @@ func NewIdentifierCache(path string) *IdentifierCache {
 func LoadIdentifierCache(path string) *IdentifierCache {
 	cacheJSON, _ := ioutil.ReadFile(path)
 	cache := IdentifierCache{path: path}
-	json.Unmarshal(cacheJSON, &cache.entries)
+	var file identifierCacheFile
+	if err := json.Unmarshal(cacheJSON, &file); err == nil && file.Entries != nil {
+		cache.entries = file.Entries
+		cache.lastRefreshed = file.LastRefreshed
+		cache.query = file.Query
+	} else {
+		json.Unmarshal(cacheJSON, &cache.entries)
+	}
+	if cache.entries == nil {
+		cache.entries = map[int][]IdentifierCacheEntry{}
+	}
 	return &cache
 }
 
 // Write the cache to disk
 func (c *IdentifierCache) Write() {
-	cacheJSON, _ := json.Marshal(c.entries)
-	ioutil.WriteFile(c.path, cacheJSON, 0644)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	c.write()
+}
+
+// write the cache to disk, the caller has to hold the mutex
+func (c *IdentifierCache) write() {
+	cacheJSON, _ := marshalJSON(identifierCacheFile{
+		Query:         c.query,
+		LastRefreshed: c.lastRefreshed,
+		Entries:       c.entries,
+	})
+	if err := WriteFileAtomic(c.path, cacheJSON); err != nil {
+		log.Error().Err(err).Str("path", c.path).Msg("Could not write identifier cache")
+	}
+}
+
+// LastRefreshed returns the time Archive.org was last queried for new
+// identifiers, zero if unknown
+func (c *IdentifierCache) LastRefreshed() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.lastRefreshed
+}
+
+// Query returns the search query the identifiers were scraped with, empty
+// for caches from before queries were recorded
+func (c *IdentifierCache) Query() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.query
 }
 
 // Add a new entry to the cache
-func (c *IdentifierCache) Add(ident string, numPages int, year int) {
+func (c *IdentifierCache) Add(ident string, numPages int, year int, mediaType string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.entries[year] = append(c.entries[year], IdentifierCacheEntry{
 		Identifier: ident,
-		NumPages:   numPages})
+		NumPages:   numPages,
+		MediaType:  mediaType})
+}
+
+// Prune removes all entries whose mediatype is not allowed anymore and
+// returns the number of removed entries. Entries without a mediatype were
+// cached from a texts-only query and are kept.
+func (c *IdentifierCache) Prune() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	numPruned := 0
+	for year, entries := range c.entries {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.MediaType == "" || isAllowedMediaType(entry.MediaType) {
+				kept = append(kept, entry)
+			} else {
+				numPruned++
+			}
+		}
+		c.entries[year] = kept
+	}
+	if numPruned > 0 {
+		c.write()
+	}
+	return numPruned
+}
+
+// Random returns a random identifier for a given year that is either set in
+// the given script or has not been classified with the current heuristic
+// yet. ScriptAny matches all identifiers.
+func (c *IdentifierCache) Random(year int, script string) (IdentifierCacheEntry, bool) {
+	return c.RandomWhere(year, func(entry IdentifierCacheEntry) bool {
+		return script == ScriptAny || !entry.IsClassified() || entry.Script == script
+	})
+}
+
+// RandomWhere returns a random identifier for a given year for which keep
+// returns true. Blocked identifiers and years outside of the served range
+// are never returned.
+func (c *IdentifierCache) RandomWhere(year int, keep func(entry IdentifierCacheEntry) bool) (IdentifierCacheEntry, bool) {
+	if CheckYear(year) != nil {
+		return IdentifierCacheEntry{}, false
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	candidates := make([]IdentifierCacheEntry, 0, len(c.entries[year]))
+	for _, entry := range c.entries[year] {
+		if keep(entry) && !Blocked.Contains(entry.Identifier) {
+			candidates = append(candidates, entry)
+		}
+	}
+	if len(candidates) == 0 {
+		return IdentifierCacheEntry{}, false
+	}
+	return candidates[rand.Intn(len(candidates))], true
+}
+
+// Counts returns the number of cached identifiers for every year
+func (c *IdentifierCache) Counts() map[int]int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	counts := make(map[int]int, len(c.entries))
+	for year, entries := range c.entries {
+		counts[year] = len(entries)
+	}
+	return counts
 }
 
-// Random returns a random identifier for a given year
-func (c *IdentifierCache) Random(year int) IdentifierCacheEntry {
-	pickIdx := rand.Intn(len(c.entries[year]))
-	entry := c.entries[year][pickIdx]
-	c.entries[year] = append(c.entries[year][:pickIdx], c.entries[year][pickIdx+1:]...)
+// Remove an identifier from the cache
+func (c *IdentifierCache) Remove(year int, ident string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for idx, entry := range c.entries[year] {
+		if entry.Identifier == ident {
+			c.entries[year] = append(c.entries[year][:idx], c.entries[year][idx+1:]...)
+			c.write()
+			return
+		}
+	}
+}
+
+// SetScript records the script of an identifier, as determined by the
+// current version of the heuristic
+func (c *IdentifierCache) SetScript(year int, ident string, script string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.setScript(year, ident, script) {
+		c.write()
+	}
+}
+
+// setScript updates the script of an entry without writing the cache, the
+// caller has to hold the mutex. Returns false if there is no such entry.
+func (c *IdentifierCache) setScript(year int, ident string, script string) bool {
+	for idx, entry := range c.entries[year] {
+		if entry.Identifier == ident {
+			c.entries[year][idx].Script = script
+			c.entries[year][idx].ScriptVersion = ScriptHeuristicVersion
+			return true
+		}
+	}
+	return false
+}
+
+// Reclassify determines the script of all entries that were not classified
+// with the current version of the heuristic. Entries that fail to classify
+// are kept as they are. Returns the number of reclassified entries.
+func (c *IdentifierCache) Reclassify(classify func(ident string) (string, error)) int {
+	// Collect the entries first, so that the cache isn't locked while
+	// classifying
+	type pendingEntry struct {
+		year  int
+		ident string
+	}
+	var pending []pendingEntry
+	c.mutex.RLock()
+	for year, entries := range c.entries {
+		for _, entry := range entries {
+			if !entry.IsClassified() {
+				pending = append(pending, pendingEntry{year, entry.Identifier})
+			}
+		}
+	}
+	c.mutex.RUnlock()
+	numClassified := 0
+	for _, entry := range pending {
+		script, err := classify(entry.ident)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("identifier", entry.ident).
+				Msg("Could not classify script")
+			continue
+		}
+		c.mutex.Lock()
+		if c.setScript(entry.year, entry.ident, script) {
+			numClassified++
+			if numClassified%100 == 0 {
+				// Checkpoint, classifying the whole cache takes a while
+				c.write()
+			}
+		}
+		c.mutex.Unlock()
+	}
 	c.Write()
-	return entry
+	return numClassified
 }
 
 // Line Image Cache
 // ==========================================================================
 
+// MaxImageDownloads is the maximum number of line images that are downloaded
+// concurrently when caching the lines of a volume
+var MaxImageDownloads = 4
+
 // LineImageCache handles cached line images on disk
 type LineImageCache struct {
 	path string
+	// Index of the cached files with their last access, persisted next to
+	// the images so that eviction order survives restarts
+	indexPath string
+	mutex     sync.Mutex
+	entries   map[string]*lineCacheEntry
+	usage     int64
+	// Maximum total size of the cached files, no limit if zero
+	maxSize int64
+	dirty   bool
+	// Content hash of the image of every line, lines whose images have the
+	// same content share a single file
+	blobIndexPath string
+	blobs         map[string]string
+}
+
+// lineCacheEntry is the index entry of a cached file
+type lineCacheEntry struct {
+	Size     int64     `json:"size"`
+	Accessed time.Time `json:"accessed"`
 }
 
+// Interval for persisting the access index of the line image cache
+const lineIndexInterval = time.Minute
+
 // NewLineImageCache creates a new line image cache
 func NewLineImageCache(cacheDir string) *LineImageCache {
 	path := filepath.Join(cacheDir, "line_images")
@@ -80,77 +316,785 @@ func NewLineImageCache(cacheDir string) *LineImageCache {
 		os.MkdirAll(path, 0755)
 	}
 	cache := LineImageCache{
-		path: filepath.Join(cacheDir, "line_images"),
+		path:          filepath.Join(cacheDir, "line_images"),
+		indexPath:     filepath.Join(cacheDir, "line_images.index.json"),
+		blobIndexPath: filepath.Join(cacheDir, "line_images.blobs.json"),
 	}
+	cache.loadIndex()
 	go cache.purgeCacheWorker()
+	go cache.indexWorker()
 	return &cache
 }
 
-// Purges files older than 7 days, once a day
-func (c *LineImageCache) purgeCacheWorker() {
+// loadIndex reads the persisted access index and reconciles it with the
+// files on disk. Files missing from the index count as accessed when they
+// were last modified.
+func (c *LineImageCache) loadIndex() {
+	index := make(map[string]*lineCacheEntry)
+	if raw, err := ioutil.ReadFile(c.indexPath); err == nil {
+		if err := json.Unmarshal(raw, &index); err != nil {
+			log.Warn().Err(err).Msg("Could not read line image cache index, rebuilding it")
+			index = make(map[string]*lineCacheEntry)
+		}
+	}
+	c.entries = make(map[string]*lineCacheEntry)
+	c.usage = 0
+	files, _ := ioutil.ReadDir(c.path)
+	for _, finfo := range files {
+		entry, ok := index[finfo.Name()]
+		if !ok {
+			entry = &lineCacheEntry{Accessed: finfo.ModTime()}
+		}
+		entry.Size = finfo.Size()
+		c.entries[finfo.Name()] = entry
+		c.usage += entry.Size
+	}
+	c.blobs = make(map[string]string)
+	if raw, err := ioutil.ReadFile(c.blobIndexPath); err == nil {
+		if err := json.Unmarshal(raw, &c.blobs); err != nil {
+			log.Warn().Err(err).Msg("Could not read line image content index, starting over")
+			c.blobs = make(map[string]string)
+		}
+	}
+	c.dirty = true
+}
+
+// writeIndex persists the access index if it changed
+func (c *LineImageCache) writeIndex() error {
+	c.mutex.Lock()
+	if !c.dirty {
+		c.mutex.Unlock()
+		return nil
+	}
+	raw, err := json.Marshal(c.entries)
+	if err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+	blobsRaw, err := json.Marshal(c.blobs)
+	c.dirty = false
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(c.indexPath, raw); err != nil {
+		return err
+	}
+	return WriteFileAtomic(c.blobIndexPath, blobsRaw)
+}
+
+func (c *LineImageCache) indexWorker() {
 	for {
-		currentTime := time.Now()
-		files, _ := ioutil.ReadDir(c.path)
-		for _, finfo := range files {
-			if currentTime.Sub(finfo.ModTime()).Hours() >= 7.0*24 {
-				os.Remove(filepath.Join(c.path, finfo.Name()))
-			}
+		time.Sleep(lineIndexInterval)
+		if err := c.writeIndex(); err != nil {
+			log.Error().Err(err).Msg("Could not write line image cache index")
 		}
+	}
+}
+
+// SetMaxSize sets the maximum total size of the cached files in bytes and
+// evicts the least recently used files if it is exceeded. Zero removes the
+// limit.
+func (c *LineImageCache) SetMaxSize(n int64) {
+	c.mutex.Lock()
+	c.maxSize = n
+	c.mutex.Unlock()
+	c.evict()
+}
+
+// UsageBytes returns the total size of the cached files
+func (c *LineImageCache) UsageBytes() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.usage
+}
+
+// touch records an access of a cached file
+func (c *LineImageCache) touch(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.entries[name]; ok {
+		entry.Accessed = time.Now()
+		c.dirty = true
+	}
+}
+
+// add records a newly written file and evicts old files if the cache is
+// over its size limit
+func (c *LineImageCache) add(name string) {
+	finfo, err := os.Stat(filepath.Join(c.path, name))
+	if err != nil {
+		return
+	}
+	c.mutex.Lock()
+	if entry, ok := c.entries[name]; ok {
+		c.usage -= entry.Size
+	}
+	c.entries[name] = &lineCacheEntry{Size: finfo.Size(), Accessed: time.Now()}
+	c.usage += finfo.Size()
+	c.dirty = true
+	c.mutex.Unlock()
+	c.evict()
+}
+
+// forget removes a deleted file from the index
+func (c *LineImageCache) forget(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.entries[name]; ok {
+		c.usage -= entry.Size
+		delete(c.entries, name)
+		c.dirty = true
+	}
+}
+
+// evict removes the least recently used files until the cache is below 90% of
+// its size limit, so that not every new file triggers another eviction
+func (c *LineImageCache) evict() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.maxSize <= 0 || c.usage <= c.maxSize {
+		return
+	}
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return c.entries[names[i]].Accessed.Before(c.entries[names[j]].Accessed)
+	})
+	target := c.maxSize / 10 * 9
+	evicted := make(map[string]bool)
+	for _, name := range names {
+		if c.usage <= target {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.path, name)); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Str("file", name).Msg("Could not evict cached line image")
+			continue
+		}
+		c.usage -= c.entries[name].Size
+		delete(c.entries, name)
+		evicted[name] = true
+	}
+	c.dirty = true
+	c.forgetBlobs(evicted)
+	log.Info().
+		Int("numEvicted", len(evicted)).
+		Int64("usageBytes", c.usage).
+		Int64("maxSizeBytes", c.maxSize).
+		Msg("Evicted line images from cache")
+}
+
+// lineCacheMaxAge is how long a cached file is kept after its last access
+const lineCacheMaxAge = 7 * 24 * time.Hour
+
+// Purges files that were not accessed for lineCacheMaxAge, once a day
+func (c *LineImageCache) purgeCacheWorker() {
+	for {
+		c.purge(time.Now().Add(-lineCacheMaxAge))
 		time.Sleep(24 * time.Hour)
 	}
 }
 
-// CacheLine downloads a line image and stores it on disk
+// purge removes the files that were last accessed before a time. Reused
+// images are only accessed through the index, so files missing from it count
+// as accessed when they were last modified.
+func (c *LineImageCache) purge(before time.Time) {
+	files, _ := ioutil.ReadDir(c.path)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	purged := make(map[string]bool)
+	for _, finfo := range files {
+		name := finfo.Name()
+		accessed := finfo.ModTime()
+		entry, indexed := c.entries[name]
+		if indexed {
+			accessed = entry.Accessed
+		}
+		if !accessed.Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.path, name)); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Str("file", name).Msg("Could not purge cached line image")
+			continue
+		}
+		if indexed {
+			c.usage -= entry.Size
+			delete(c.entries, name)
+		}
+		purged[name] = true
+	}
+	c.forgetBlobs(purged)
+}
+
+// forgetBlobs removes the lines whose images were stored in removed files
+// from the content index, the mutex must be held
+func (c *LineImageCache) forgetBlobs(removed map[string]bool) {
+	if len(removed) == 0 {
+		return
+	}
+	for id, hash := range c.blobs {
+		if removed[blobName(hash)] {
+			delete(c.blobs, id)
+		}
+	}
+	c.dirty = true
+}
+
+// blobName is the file name of a cached image with the given content hash
+func blobName(hash string) string {
+	return "blob_" + hash + ".png"
+}
+
+// CacheLine downloads a line image and stores it on disk. The image is
+// stored under the hash of its content, if the same image was already cached
+// for another line the existing file is reused.
 func (c *LineImageCache) CacheLine(url string, id string) (string, error) {
-	imgPath := filepath.Join(c.path, id+".png")
-	imgOut, err := os.Create(imgPath)
+	imgResp, err := httpGet(context.Background(), url)
 	if err != nil {
 		return "", err
 	}
-	defer imgOut.Close()
-	imgResp, err := http.Get(url)
+	defer imgResp.Body.Close()
+	data, err := ioutil.ReadAll(imgResp.Body)
 	if err != nil {
 		return "", err
 	}
-	defer imgResp.Body.Close()
-	if _, err := io.Copy(imgOut, imgResp.Body); err != nil {
-		return "", err
+	hash := Sha256Digest(data)
+	name := blobName(hash)
+	imgPath := filepath.Join(c.path, name)
+	if _, err := os.Stat(imgPath); err == nil {
+		c.touch(name)
+	} else {
+		err := retryFileOp(false, func() error {
+			return WriteFileAtomic(imgPath, data)
+		})
+		if err != nil {
+			return "", err
+		}
+		c.add(name)
 	}
+	c.mutex.Lock()
+	c.blobs[id] = hash
+	c.dirty = true
+	c.mutex.Unlock()
 	return imgPath, nil
 }
 
-// CacheLines caches all passed lines
-func (c *LineImageCache) CacheLines(lines []OCRLine, ident string) {
+// CacheLines caches all passed lines of a volume from the given year.
+// Skipped for blocked identifiers, years outside of the served range and if
+// another process is still caching the same volume.
+func (c *LineImageCache) CacheLines(lines []OCRLine, ident string, year int) {
+	if err := CheckYear(year); err != nil {
+		log.Warn().Err(err).Str("identifier", ident).Msg("Not caching lines")
+		return
+	}
+	if Blocked.Contains(ident) {
+		log.Info().Str("identifier", ident).Msg("Not caching lines of blocked identifier")
+		return
+	}
+	lock, err := lockVolume(cacheLockDir(filepath.Dir(c.path)), ident)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("identifier", ident).
+			Msg("Could not lock volume for caching lines")
+		return
+	}
+	defer lock.Unlock()
 	log.Info().
 		Str("identifier", ident).
 		Int("numLines", len(lines)).
 		Msg("Caching lines")
+	numWorkers := MaxImageDownloads
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	sem := make(chan struct{}, numWorkers)
+	var wg sync.WaitGroup
+	download := func(line OCRLine) {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			id := MakeLineIdentifier(ident, line)
+			imgPath, err := c.CacheLine(line.ImageURL, id)
+			if err == nil && CropQuality.Enabled() {
+				measureCachedCrop(id, imgPath)
+			}
+		}()
+	}
 	for _, line := range lines {
-		c.CacheLine(line.ImageURL, MakeLineIdentifier(ident, line))
+		download(line)
+		for _, dup := range line.Duplicates {
+			download(dup)
+		}
 	}
+	wg.Wait()
 	log.Info().
 		Str("identifier", ident).
 		Int("numLines", len(lines)).
 		Msg("Cached lines")
 }
 
+// LineImageCacheStats holds information about the contents of the cache
+type LineImageCacheStats struct {
+	NumFiles  int   `json:"numFiles"`
+	SizeBytes int64 `json:"sizeBytes"`
+}
+
+// Stats returns the number and total size of cached line images
+func (c *LineImageCache) Stats() LineImageCacheStats {
+	var stats LineImageCacheStats
+	files, _ := ioutil.ReadDir(c.path)
+	for _, finfo := range files {
+		stats.NumFiles++
+		stats.SizeBytes += finfo.Size()
+	}
+	return stats
+}
+
+// DedupStats holds the savings from sharing files between lines with the
+// same image
+type DedupStats struct {
+	NumLines   int   `json:"numLines"`
+	NumBlobs   int   `json:"numBlobs"`
+	BytesSaved int64 `json:"bytesSaved"`
+}
+
+// DedupStats returns the number of lines and distinct images in the cache
+// and the bytes saved by storing every distinct image only once
+func (c *LineImageCache) DedupStats() DedupStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	refs := make(map[string]int)
+	for _, hash := range c.blobs {
+		refs[hash]++
+	}
+	var stats DedupStats
+	for hash, numRefs := range refs {
+		entry, ok := c.entries[blobName(hash)]
+		if !ok {
+			// Evicted or purged, the lines are cache misses
+			continue
+		}
+		stats.NumLines += numRefs
+		stats.NumBlobs++
+		stats.BytesSaved += int64(numRefs-1) * entry.Size
+	}
+	return stats
+}
+
 // GetLinePath returns the file path for a given line image
 func (c *LineImageCache) GetLinePath(id string) string {
-	imgPath := filepath.Join(c.path, id+".png")
-	if _, err := os.Stat(imgPath); os.IsNotExist(err) {
+	c.mutex.Lock()
+	hash, ok := c.blobs[id]
+	c.mutex.Unlock()
+	// Images cached before content addressing are stored under the line id
+	name := id + ".png"
+	if ok {
+		name = blobName(hash)
+	}
+	imgPath := filepath.Join(c.path, name)
+	// Indexed images should exist, a missing one may only be missing
+	// temporarily on network file systems
+	err := retryFileOp(ok, func() error {
+		_, err := statFile(imgPath)
+		return err
+	})
+	if err != nil {
+		lineCacheLookups.WithLabelValues("miss").Inc()
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Str("path", imgPath).Msg("Could not read cached line image")
+		} else if ok {
+			c.mutex.Lock()
+			delete(c.blobs, id)
+			c.dirty = true
+			c.mutex.Unlock()
+		}
 		return ""
 	}
+	lineCacheLookups.WithLabelValues("hit").Inc()
+	c.touch(name)
 	absPath, _ := filepath.Abs(imgPath)
 	return absPath
 }
 
-// PurgeLines removes all cached line images that match the prefix
-func (c *LineImageCache) PurgeLines(prefix string) error {
-	lines, _ := filepath.Glob(filepath.Join(c.path, prefix+"*.png"))
+// ContentHash returns the SHA-256 hash of a cached line image, empty for
+// images that were cached before content addressing
+func (c *LineImageCache) ContentHash(id string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.blobs[id]
+}
+
+// ThumbnailHeight is the maximum height of line thumbnails in pixels, zero
+// disables thumbnails
+var ThumbnailHeight = 0
+
+// GetThumbnailPath returns the file path for the thumbnail of a given line
+// image. The thumbnail is generated from the cached image on first access.
+// Returns the path of the full image if thumbnails are disabled or the image
+// is not larger than a thumbnail.
+func (c *LineImageCache) GetThumbnailPath(id string) (string, error) {
+	imgPath := c.GetLinePath(id)
+	if imgPath == "" || ThumbnailHeight <= 0 {
+		return imgPath, nil
+	}
+	thumbPath := filepath.Join(filepath.Dir(imgPath), id+"_thumb.png")
+	err := retryFileOp(false, func() error {
+		_, err := statFile(thumbPath)
+		return err
+	})
+	if err == nil {
+		c.touch(id + "_thumb.png")
+		return thumbPath, nil
+	}
+	img, err := readPNG(imgPath)
+	if err != nil {
+		return "", err
+	}
+	if img.Bounds().Dy() <= ThumbnailHeight {
+		return imgPath, nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleToHeight(img, ThumbnailHeight)); err != nil {
+		return "", err
+	}
+	if err := WriteFileAtomic(thumbPath, buf.Bytes()); err != nil {
+		return "", err
+	}
+	c.add(id + "_thumb.png")
+	return thumbPath, nil
+}
+
+// scaleToHeight downscales an image to the given height, keeping the aspect
+// ratio. Every target pixel is the average of the source pixels it covers.
+func scaleToHeight(img image.Image, height int) image.Image {
+	bounds := img.Bounds()
+	width := bounds.Dx() * height / bounds.Dy()
+	if width < 1 {
+		width = 1
+	}
+	out := image.NewRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		srcY0 := bounds.Min.Y + y*bounds.Dy()/height
+		srcY1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if srcY1 <= srcY0 {
+			srcY1 = srcY0 + 1
+		}
+		for x := 0; x < width; x++ {
+			srcX0 := bounds.Min.X + x*bounds.Dx()/width
+			srcX1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if srcX1 <= srcX0 {
+				srcX1 = srcX0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := srcY0; sy < srcY1; sy++ {
+				for sx := srcX0; sx < srcX1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+			out.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return out
+}
+
+// isVolumeLine checks if a cached line identifier or thumbnail name belongs
+// to a volume. Volume identifiers may start with the identifier of another
+// volume, so the rest has to be a line digest.
+func isVolumeLine(name string, volumeID string) bool {
+	if !strings.HasPrefix(name, volumeID+"_") {
+		return false
+	}
+	digest := strings.TrimSuffix(strings.TrimPrefix(name, volumeID+"_"), "_thumb")
+	return lineDigestPat.MatchString(digest)
+}
+
+// PurgeLines removes all cached line images of a volume. Images that are
+// shared with lines of other volumes are kept.
+func (c *LineImageCache) PurgeLines(volumeID string) error {
+	var lines []string
+	candidates, _ := filepath.Glob(filepath.Join(c.path, volumeID+"_*.png"))
+	for _, fpath := range candidates {
+		if isVolumeLine(strings.TrimSuffix(filepath.Base(fpath), ".png"), volumeID) {
+			lines = append(lines, fpath)
+		}
+	}
+	c.mutex.Lock()
+	unused := make(map[string]bool)
+	for id, hash := range c.blobs {
+		if isVolumeLine(id, volumeID) {
+			delete(c.blobs, id)
+			unused[hash] = true
+			c.dirty = true
+		}
+	}
+	for _, hash := range c.blobs {
+		delete(unused, hash)
+	}
+	c.mutex.Unlock()
+	for hash := range unused {
+		lines = append(lines, filepath.Join(c.path, blobName(hash)))
+	}
 	for _, fpath := range lines {
-		if err := os.Remove(fpath); err != nil {
+		if err := os.Remove(fpath); err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		c.forget(filepath.Base(fpath))
 	}
 	return nil
 }
+
+// Volume Line Cache
+// ==========================================================================
+
+// VolumeCacheMaxAge is the age after which cached lines of a volume are
+// fetched again, zero means they never expire
+var VolumeCacheMaxAge time.Duration
+
+// VolumeCache stores the parsed OCR lines of volumes on disk, so that they
+// do not have to be fetched and parsed again
+type VolumeCache struct {
+	path   string
+	maxAge time.Duration
+}
+
+// NewVolumeCache creates a new volume cache whose entries expire after
+// maxAge, or never if maxAge is zero
+func NewVolumeCache(cacheDir string, maxAge time.Duration) *VolumeCache {
+	path := filepath.Join(cacheDir, "volumes")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		os.MkdirAll(path, 0755)
+	}
+	return &VolumeCache{path: path, maxAge: maxAge}
+}
+
+func (c *VolumeCache) volumePath(ident string) string {
+	return filepath.Join(c.path, ident+".json")
+}
+
+// Get returns the cached lines for a volume. Returns false if the volume
+// is not cached or its entry is stale.
+func (c *VolumeCache) Get(ident string) ([]OCRLine, bool) {
+	volPath := c.volumePath(ident)
+	finfo, err := os.Stat(volPath)
+	if err != nil {
+		return nil, false
+	}
+	if c.maxAge > 0 && time.Since(finfo.ModTime()) > c.maxAge {
+		log.Info().
+			Str("identifier", ident).
+			Time("cachedAt", finfo.ModTime()).
+			Msg("Cached lines are stale")
+		return nil, false
+	}
+	lines, err := c.read(ident)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("identifier", ident).
+			Msg("Could not read cached lines")
+		if isUnmarshalError(err) {
+			c.quarantine(ident, err)
+		}
+		return nil, false
+	}
+	return lines, true
+}
+
+// read parses the cached lines of a volume
+func (c *VolumeCache) read(ident string) ([]OCRLine, error) {
+	raw, err := ioutil.ReadFile(c.volumePath(ident))
+	if err != nil {
+		return nil, err
+	}
+	var lines []OCRLine
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return nil, &corruptCacheError{err}
+	}
+	return lines, nil
+}
+
+// corruptCacheError is returned for cache files that could be read, but not
+// be parsed
+type corruptCacheError struct {
+	err error
+}
+
+func (e *corruptCacheError) Error() string {
+	return "corrupt cache file: " + e.err.Error()
+}
+
+func (e *corruptCacheError) Unwrap() error {
+	return e.err
+}
+
+// isUnmarshalError checks if the cache file could not be parsed
+func isUnmarshalError(err error) bool {
+	var corruptErr *corruptCacheError
+	return errors.As(err, &corruptErr)
+}
+
+// corruptDir returns the directory that corrupt cache files are moved to
+func (c *VolumeCache) corruptDir() string {
+	return filepath.Join(c.path, "corrupt")
+}
+
+// quarantine moves a corrupt cache file out of the way, so that the volume
+// is fetched again and the file can be inspected later
+func (c *VolumeCache) quarantine(ident string, reason error) {
+	dir := c.corruptDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Error().Err(err).Str("path", dir).Msg("Could not create quarantine directory")
+		return
+	}
+	target := filepath.Join(dir, ident+".json")
+	if err := os.Rename(c.volumePath(ident), target); err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Str("identifier", ident).Msg("Could not quarantine corrupt cache file")
+		}
+		return
+	}
+	quarantinedCacheFiles.Inc()
+	log.Warn().
+		Err(reason).
+		Str("identifier", ident).
+		Str("path", target).
+		Msg("Quarantined corrupt cache file")
+}
+
+// NumQuarantined returns the number of corrupt cache files that were moved
+// to the quarantine directory
+func (c *VolumeCache) NumQuarantined() int {
+	files, err := ioutil.ReadDir(c.corruptDir())
+	if err != nil {
+		return 0
+	}
+	num := 0
+	for _, finfo := range files {
+		if strings.HasSuffix(finfo.Name(), ".json") {
+			num++
+		}
+	}
+	return num
+}
+
+// Put stores the lines of a volume in the cache
+func (c *VolumeCache) Put(ident string, lines []OCRLine) error {
+	raw, err := marshalJSON(lines)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(c.volumePath(ident), raw)
+}
+
+// Age after which temporary files of the volume cache are considered to be
+// left over from an interrupted write
+const staleTempFileAge = time.Hour
+
+// Compact removes left over temporary files, unreadable and expired entries
+// and the entries of volumes for which isDone returns true. Entries that
+// cannot be parsed are quarantined and do not count against maxEntries. If
+// maxEntries is positive, only that many of the most recently cached entries
+// are kept. Entries of volumes whose lines are currently being cached, and
+// those for which inUse returns true, are skipped. inUse may be nil. Returns
+// the number of removed entries.
+func (c *VolumeCache) Compact(isDone func(ident string) bool, inUse func(ident string) bool, maxEntries int) (int, error) {
+	files, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return 0, err
+	}
+	lockDir := cacheLockDir(filepath.Dir(c.path))
+	numRemoved := 0
+	// Only try once, the volume is in use if its lock is held
+	withLock := func(ident string, fn func()) {
+		lock, err := LockFile(filepath.Join(lockDir, ident+".lock"), 0)
+		if err != nil {
+			return
+		}
+		defer lock.Unlock()
+		fn()
+	}
+	remove := func(ident string) {
+		withLock(ident, func() {
+			if err := os.Remove(c.volumePath(ident)); err == nil {
+				numRemoved++
+			}
+		})
+	}
+	kept := make([]os.FileInfo, 0, len(files))
+	for _, finfo := range files {
+		name := finfo.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			if time.Since(finfo.ModTime()) > staleTempFileAge {
+				os.Remove(filepath.Join(c.path, name))
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		ident := strings.TrimSuffix(name, ".json")
+		if inUse != nil && inUse(ident) {
+			continue
+		}
+		if isDone(ident) || (c.maxAge > 0 && time.Since(finfo.ModTime()) > c.maxAge) {
+			remove(ident)
+			continue
+		}
+		if _, err := c.read(ident); isUnmarshalError(err) {
+			withLock(ident, func() { c.quarantine(ident, err) })
+			continue
+		} else if err != nil {
+			remove(ident)
+			continue
+		}
+		kept = append(kept, finfo)
+	}
+	if maxEntries > 0 && len(kept) > maxEntries {
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].ModTime().After(kept[j].ModTime())
+		})
+		for _, finfo := range kept[maxEntries:] {
+			remove(strings.TrimSuffix(finfo.Name(), ".json"))
+		}
+	}
+	return numRemoved, nil
+}
+
+// cacheLockDir returns the directory for the volume locks of the caches in
+// cacheDir
+func cacheLockDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "locks")
+}
+
+// CompactVolumeCache compacts the global volume cache, removing the entries
+// of volumes that were already transcribed into the store, see Compact
+func CompactVolumeCache(store *DocumentStore, inUse func(ident string) bool, maxEntries int) (int, error) {
+	idents, err := store.Identifiers()
+	if err != nil {
+		return 0, err
+	}
+	transcribed := make(map[string]bool, len(idents))
+	for _, ident := range idents {
+		transcribed[ident] = true
+	}
+	return VolumeLines.Compact(func(ident string) bool {
+		return transcribed[ident]
+	}, inUse, maxEntries)
+}
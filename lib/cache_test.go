@@ -0,0 +1,517 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdentifierCacheConcurrentAccess(t *testing.T) {
+	const numWorkers = 4
+	const perWorker = 25
+	cache := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+	year := MinYear
+	for w := 0; w < numWorkers; w++ {
+		for i := 0; i < perWorker; i++ {
+			cache.Add(fmt.Sprintf("seeded_%d_%d", w, i), 4, year, "texts")
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(4)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				cache.Add(fmt.Sprintf("added_%d_%d", w, i), 4, year, "texts")
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				cache.Remove(year, fmt.Sprintf("seeded_%d_%d", w, i))
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				if entry, ok := cache.Random(year, ScriptAny); ok && entry.Identifier == "" {
+					t.Error("random entry without identifier")
+				}
+				cache.RandomWhere(year, func(entry IdentifierCacheEntry) bool {
+					return strings.HasPrefix(entry.Identifier, "added_")
+				})
+			}
+		}()
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				cache.SetScript(year, fmt.Sprintf("added_%d_%d", w, i), ScriptFraktur)
+				cache.Counts()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := cache.Counts()[year]; got != numWorkers*perWorker {
+		t.Errorf("expected %d identifiers after adding and removing as many, got %d",
+			numWorkers*perWorker, got)
+	}
+	if entry, ok := cache.RandomWhere(year, func(entry IdentifierCacheEntry) bool {
+		return strings.HasPrefix(entry.Identifier, "seeded_")
+	}); ok {
+		t.Errorf("removed identifier %s is still returned", entry.Identifier)
+	}
+}
+
+func TestLineImageCacheConcurrentGetPut(t *testing.T) {
+	// Every second line shares its image with another line
+	images := map[string][]byte{
+		"/a.png": testPNG(t, 100, 20),
+		"/b.png": testPNG(t, 120, 20),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(images[r.URL.Path])
+	}))
+	defer server.Close()
+	withoutRateLimit(t)
+	cache := NewLineImageCache(t.TempDir())
+
+	const numLines = 32
+	var wg sync.WaitGroup
+	for i := 0; i < numLines; i++ {
+		id := fmt.Sprintf("chronik_1880_%08x", i)
+		url := server.URL + "/a.png"
+		if i%2 == 1 {
+			url = server.URL + "/b.png"
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.CacheLine(url, id); err != nil {
+				t.Errorf("caching %s: %v", id, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			cache.GetLinePath(id)
+			cache.ContentHash(id)
+			cache.DedupStats()
+			cache.UsageBytes()
+		}()
+	}
+	wg.Wait()
+
+	paths := make(map[string]bool)
+	for i := 0; i < numLines; i++ {
+		path := cache.GetLinePath(fmt.Sprintf("chronik_1880_%08x", i))
+		if path == "" {
+			t.Fatalf("line %d is not cached", i)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+		paths[path] = true
+	}
+	if len(paths) != len(images) {
+		t.Errorf("expected lines with the same image to share %d files, got %d", len(images), len(paths))
+	}
+	if err := cache.PurgeLines("chronik_1880"); err != nil {
+		t.Fatal(err)
+	}
+	for path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not purged", path)
+		}
+	}
+}
+
+func TestPurgeKeepsReusedLineImages(t *testing.T) {
+	images := map[string][]byte{
+		"/a.png": testPNG(t, 100, 20),
+		"/b.png": testPNG(t, 120, 20),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(images[r.URL.Path])
+	}))
+	defer server.Close()
+	withoutRateLimit(t)
+	cache := NewLineImageCache(t.TempDir())
+
+	// The image of the first line was written long ago and has just been
+	// reused for another line
+	old := time.Now().Add(-2 * lineCacheMaxAge)
+	shared, err := cache.CacheLine(server.URL+"/a.png", "chronik_1880_00000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(shared, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.CacheLine(server.URL+"/a.png", "chronik_1880_00000002"); err != nil {
+		t.Fatal(err)
+	}
+	// The image of the third line was not accessed for long
+	if _, err := cache.CacheLine(server.URL+"/b.png", "chronik_1880_00000003"); err != nil {
+		t.Fatal(err)
+	}
+	cache.mutex.Lock()
+	cache.entries[blobName(cache.blobs["chronik_1880_00000003"])].Accessed = old
+	cache.mutex.Unlock()
+
+	cache.purge(time.Now().Add(-lineCacheMaxAge))
+	if hash := cache.ContentHash("chronik_1880_00000003"); hash != "" {
+		t.Errorf("purged image is still indexed as %s", hash)
+	}
+	for _, id := range []string{"chronik_1880_00000001", "chronik_1880_00000002"} {
+		if cache.GetLinePath(id) == "" {
+			t.Errorf("reused image of %s was purged", id)
+		}
+	}
+	if cache.GetLinePath("chronik_1880_00000003") != "" {
+		t.Errorf("image that was not accessed for long was kept")
+	}
+}
+
+func TestReclassifyOutdatedScripts(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   IdentifierCacheEntry
+		wantRun bool
+	}{
+		{"current version", IdentifierCacheEntry{Script: ScriptFraktur, ScriptVersion: ScriptHeuristicVersion}, false},
+		{"older version", IdentifierCacheEntry{Script: ScriptFraktur, ScriptVersion: ScriptHeuristicVersion - 1}, true},
+		{"no version", IdentifierCacheEntry{Script: ScriptFraktur}, true},
+		{"unclassified", IdentifierCacheEntry{}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+			entry := tc.entry
+			entry.Identifier = "gesangbuch_1865"
+			entry.NumPages = 200
+			cache.entries[1865] = []IdentifierCacheEntry{entry}
+			var classified []string
+			numClassified := cache.Reclassify(func(ident string) (string, error) {
+				classified = append(classified, ident)
+				return ScriptAntiqua, nil
+			})
+			wantScript, wantCount := entry.Script, 0
+			if tc.wantRun {
+				wantScript, wantCount = ScriptAntiqua, 1
+			}
+			if numClassified != wantCount || len(classified) != wantCount {
+				t.Errorf("reclassified %d entries (%v), want %d", numClassified, classified, wantCount)
+			}
+			stored := LoadIdentifierCache(cache.path).entries[1865][0]
+			if stored.Script != wantScript {
+				t.Errorf("stored script %q, want %q", stored.Script, wantScript)
+			}
+			if tc.wantRun && stored.ScriptVersion != ScriptHeuristicVersion {
+				t.Errorf("stored version %d, want %d", stored.ScriptVersion, ScriptHeuristicVersion)
+			}
+		})
+	}
+}
+
+func TestReclassifyKeepsEntriesThatFail(t *testing.T) {
+	cache := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+	old := IdentifierCacheEntry{Identifier: "gesangbuch_1866", NumPages: 200, Script: ScriptFraktur}
+	cache.entries[1866] = []IdentifierCacheEntry{old}
+	numClassified := cache.Reclassify(func(ident string) (string, error) {
+		return "", fmt.Errorf("manifest of %s not found", ident)
+	})
+	if numClassified != 0 {
+		t.Errorf("reclassified %d entries, want none", numClassified)
+	}
+	if got := cache.entries[1866][0]; got != old {
+		t.Errorf("failed entry changed to %+v", got)
+	}
+}
+
+func TestVolumeCacheMaxAge(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		// Age of the cached entry
+		age       time.Duration
+		refetched bool
+	}{
+		{"fresh entry", time.Hour, time.Minute, false},
+		{"stale entry", time.Hour, 2 * time.Hour, true},
+		{"entries never expire", 0, 1000 * time.Hour, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			const ident = "landbote_1871"
+			var requests int64
+			server := archiveServer(t, ident, 12, 2)
+			defer server.Close()
+			counting := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&requests, 1)
+				server.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer counting.Close()
+			routeToServer(t, counting, newPooledTransport(DefaultMaxConcurrentRequests))
+			cache := useVolumeCache(t, tc.maxAge)
+
+			cached := []OCRLine{testLine(ident, 0, "")}
+			cached[0].OCRText = "Zwischenstand aus dem Cache"
+			if err := cache.Put(ident, cached); err != nil {
+				t.Fatal(err)
+			}
+			cachedAt := time.Now().Add(-tc.age)
+			if err := os.Chtimes(cache.volumePath(ident), cachedAt, cachedAt); err != nil {
+				t.Fatal(err)
+			}
+
+			lines, err := FetchAllLines(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			refetched := atomic.LoadInt64(&requests) > 0
+			if refetched != tc.refetched {
+				t.Errorf("re-fetched is %v, want %v", refetched, tc.refetched)
+			}
+			if tc.refetched {
+				if len(lines) != 2 || lines[0].OCRText != "Zeile 0" {
+					t.Errorf("expected the fetched lines, got %+v", lines)
+				}
+				if recached, ok := cache.Get(ident); !ok || len(recached) != 2 {
+					t.Errorf("fetched lines were not cached again")
+				}
+			} else if len(lines) != 1 || lines[0].OCRText != cached[0].OCRText {
+				t.Errorf("expected the cached lines, got %+v", lines)
+			}
+		})
+	}
+}
+
+func TestCacheLinesLimitsConcurrentDownloads(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxDownloads int
+	}{
+		{"sequential", 1},
+		{"three", 3},
+		{"six", 6},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := MaxImageDownloads
+			MaxImageDownloads = tc.maxDownloads
+			defer func() { MaxImageDownloads = previous }()
+			var running, maxRunning int64
+			var mutex sync.Mutex
+			image := testPNG(t, 300, 40)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mutex.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(image)
+			}))
+			defer server.Close()
+			withoutRateLimit(t)
+			cache := NewLineImageCache(t.TempDir())
+
+			const ident = "wochenblatt_1874"
+			lines := make([]OCRLine, 24)
+			for idx := range lines {
+				lines[idx] = testLine(ident, idx, "")
+				lines[idx].ImageURL = fmt.Sprintf("%s/%s/line%02d.png", server.URL, ident, idx)
+			}
+			cache.CacheLines(lines, ident, MinYear)
+			if maxRunning > int64(tc.maxDownloads) {
+				t.Errorf("%d downloads ran at the same time, want at most %d", maxRunning, tc.maxDownloads)
+			}
+			for _, line := range lines {
+				if cache.GetLinePath(MakeLineIdentifier(ident, line)) == "" {
+					t.Errorf("line %s was not cached", line.ImageURL)
+				}
+			}
+		})
+	}
+}
+
+func TestLineThumbnails(t *testing.T) {
+	tests := []struct {
+		name            string
+		thumbnailHeight int
+		imageHeight     int
+		// Height of the served image, the full one if it matches
+		wantHeight int
+	}{
+		{"large line", 20, 80, 20},
+		{"line smaller than a thumbnail", 20, 16, 16},
+		{"thumbnails disabled", 0, 80, 80},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := ThumbnailHeight
+			ThumbnailHeight = tc.thumbnailHeight
+			defer func() { ThumbnailHeight = previous }()
+			cache := NewLineImageCache(t.TempDir())
+			const id = "generalanzeiger_1875_1a2b3c4d"
+			imgPath := filepath.Join(cache.path, id+".png")
+			if err := ioutil.WriteFile(imgPath, testPNG(t, 8*tc.imageHeight, tc.imageHeight), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			thumbPath, err := cache.GetThumbnailPath(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := readPNG(thumbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds().Dy() != tc.wantHeight || img.Bounds().Dx() != 8*tc.wantHeight {
+				t.Errorf("served image is %v, want a height of %d with the aspect ratio kept",
+					img.Bounds().Size(), tc.wantHeight)
+			}
+			if tc.wantHeight == tc.imageHeight {
+				if thumbPath != imgPath {
+					t.Errorf("expected the full image, got %s", thumbPath)
+				}
+				return
+			}
+			full, _ := os.Stat(imgPath)
+			thumb, _ := os.Stat(thumbPath)
+			if thumb.Size() >= full.Size() {
+				t.Errorf("thumbnail has %d bytes, the image only %d", thumb.Size(), full.Size())
+			}
+			// Derived once, later accesses return the stored thumbnail
+			past := time.Now().Add(-time.Hour)
+			os.Chtimes(thumbPath, past, past)
+			if again, err := cache.GetThumbnailPath(id); err != nil || again != thumbPath {
+				t.Fatalf("second access returned %s, %v", again, err)
+			}
+			if finfo, _ := os.Stat(thumbPath); finfo.ModTime().After(past.Add(time.Second)) {
+				t.Errorf("thumbnail was generated again")
+			}
+		})
+	}
+}
+
+func TestCompactVolumeCache(t *testing.T) {
+	fixture := []struct {
+		ident string
+		age   time.Duration
+		// Whether the file is not a valid cache entry
+		corrupt bool
+		// Whether the volume was transcribed already
+		done bool
+		// Whether the lines of the volume are being cached
+		inUse bool
+		// Whether the volume is in use by the caller, like a prefetched one
+		reserved bool
+	}{
+		{ident: "fresh_1", age: time.Minute},
+		{ident: "fresh_2", age: 2 * time.Minute},
+		{ident: "fresh_3", age: 3 * time.Minute},
+		{ident: "old_1", age: 48 * time.Hour},
+		{ident: "done_1", age: time.Minute, done: true},
+		{ident: "inuse_1", age: time.Minute, done: true, inUse: true},
+		{ident: "corrupt_1", age: time.Minute, corrupt: true},
+		{ident: "prefetched_1", age: 48 * time.Hour, reserved: true},
+	}
+	tests := []struct {
+		name       string
+		maxAge     time.Duration
+		maxEntries int
+		wantKept   []string
+	}{
+		{"transcribed and corrupt", 0, 0, []string{"fresh_1", "fresh_2", "fresh_3", "inuse_1", "old_1", "prefetched_1"}},
+		{"expired", 24 * time.Hour, 0, []string{"fresh_1", "fresh_2", "fresh_3", "inuse_1", "prefetched_1"}},
+		{"max entries", 0, 2, []string{"fresh_1", "fresh_2", "inuse_1", "prefetched_1"}},
+		{"expired and max entries", 24 * time.Hour, 3, []string{"fresh_1", "fresh_2", "fresh_3", "inuse_1", "prefetched_1"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := NewVolumeCache(t.TempDir(), tc.maxAge)
+			done := make(map[string]bool)
+			reserved := make(map[string]bool)
+			for _, entry := range fixture {
+				if entry.corrupt {
+					if err := ioutil.WriteFile(cache.volumePath(entry.ident), []byte("[{\"id\": "), 0644); err != nil {
+						t.Fatal(err)
+					}
+				} else if err := cache.Put(entry.ident, []OCRLine{testLine(entry.ident, 0, "")}); err != nil {
+					t.Fatal(err)
+				}
+				modTime := time.Now().Add(-entry.age)
+				if err := os.Chtimes(cache.volumePath(entry.ident), modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+				done[entry.ident] = entry.done
+				reserved[entry.ident] = entry.reserved
+				if entry.inUse {
+					lockPath := filepath.Join(cacheLockDir(filepath.Dir(cache.path)), entry.ident+".lock")
+					lock, err := LockFile(lockPath, 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer lock.Unlock()
+				}
+			}
+			// Temporary files of writes that were interrupted long ago
+			// are removed, recent ones may still be written to
+			for name, age := range map[string]time.Duration{"stale.json.tmp": 2 * time.Hour, "recent.json.tmp": 0} {
+				path := filepath.Join(cache.path, name)
+				if err := ioutil.WriteFile(path, []byte("[]"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				modTime := time.Now().Add(-age)
+				os.Chtimes(path, modTime, modTime)
+			}
+
+			isDone := func(ident string) bool { return done[ident] }
+			inUse := func(ident string) bool { return reserved[ident] }
+			numRemoved, err := cache.Compact(isDone, inUse, tc.maxEntries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			files, _ := ioutil.ReadDir(cache.path)
+			for _, finfo := range files {
+				if strings.HasSuffix(finfo.Name(), ".json") {
+					kept = append(kept, strings.TrimSuffix(finfo.Name(), ".json"))
+				}
+			}
+			if strings.Join(kept, ",") != strings.Join(tc.wantKept, ",") {
+				t.Errorf("kept %v, want %v", kept, tc.wantKept)
+			}
+			// The corrupt entry is quarantined rather than removed
+			if wantRemoved := len(fixture) - len(tc.wantKept) - 1; numRemoved != wantRemoved {
+				t.Errorf("removed %d entries, want %d", numRemoved, wantRemoved)
+			}
+			if n := cache.NumQuarantined(); n != 1 {
+				t.Errorf("quarantined %d entries, want 1", n)
+			}
+			if _, err := os.Stat(filepath.Join(cache.path, "stale.json.tmp")); !os.IsNotExist(err) {
+				t.Errorf("stale temporary file was kept")
+			}
+			if _, err := os.Stat(filepath.Join(cache.path, "recent.json.tmp")); err != nil {
+				t.Errorf("recent temporary file was removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// CharacterSet is the set of characters that are expected in
+// transcriptions. Whitespace is always allowed.
+type CharacterSet struct {
+	runes map[rune]bool
+}
+
+// LoadCharacterSet reads a character set from a file. Every character on a
+// line is allowed, lines starting with # are comments.
+func LoadCharacterSet(path string) (*CharacterSet, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	set := &CharacterSet{runes: make(map[rune]bool)}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, r := range line {
+			if !unicode.IsSpace(r) {
+				set.runes[r] = true
+			}
+		}
+	}
+	return set, scanner.Err()
+}
+
+// Contains checks if a character is in the set
+func (s *CharacterSet) Contains(r rune) bool {
+	return unicode.IsSpace(r) || s.runes[r]
+}
+
+// DisallowedCharacter is a character of a transcription that is not in the
+// allowed character set
+type DisallowedCharacter struct {
+	Char string `json:"char"`
+	// Index of the character in the transcription, counted in code points
+	Position int `json:"position"`
+}
+
+// ValidateTranscription returns the characters of a transcription that are
+// not in the allowed set, in the order they occur
+func ValidateTranscription(text string, allowed *CharacterSet) []DisallowedCharacter {
+	var disallowed []DisallowedCharacter
+	pos := 0
+	for _, r := range text {
+		if !allowed.Contains(r) {
+			disallowed = append(disallowed, DisallowedCharacter{Char: string(r), Position: pos})
+		}
+		pos++
+	}
+	return disallowed
+}
+
+// LoadCharacterSets reads the character sets for the validator from a comma
+// separated list of files. Entries of the form script=path only apply to
+// volumes set in that script, a plain path applies to all other volumes.
+func LoadCharacterSets(spec string) (map[string]*CharacterSet, error) {
+	sets := make(map[string]*CharacterSet)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		script := ScriptAny
+		path := entry
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			script, path = entry[:idx], entry[idx+1:]
+			if script != ScriptFraktur && script != ScriptAntiqua {
+				return nil, fmt.Errorf("unknown script %q for character set %s", script, path)
+			}
+		}
+		set, err := LoadCharacterSet(path)
+		if err != nil {
+			return nil, err
+		}
+		sets[script] = set
+	}
+	return sets, nil
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Clock provides the current time and waiting, so that time-dependent
+// behavior can be tested without waiting
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// SystemClock is the clock backed by the system time
+var SystemClock Clock = systemClock{}
+
+// CommitClock provides the timestamps of commits to the corpus
+var CommitClock = SystemClock
+
+// commitTime returns the timestamp for a new commit. It is taken from
+// SOURCE_DATE_EPOCH if set, for reproducible builds of the corpus, and from
+// CommitClock otherwise.
+func commitTime() time.Time {
+	if epoch, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err == nil {
+			return time.Unix(secs, 0).UTC()
+		}
+		log.Warn().Str("sourceDateEpoch", epoch).Msg("Ignoring invalid SOURCE_DATE_EPOCH")
+	}
+	return CommitClock.Now()
+}
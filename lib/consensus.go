@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConsensusOptions configures how a submission is compared against the
+// transcriptions already stored for a volume
+type ConsensusOptions struct {
+	// Volumes where more than this ratio of the lines transcribed in both
+	// submissions disagree are flagged for review, disabled if zero
+	MaxDisagreement float64
+	// Minimum number of lines transcribed in both submissions for the
+	// disagreement to be considered
+	MinOverlap int
+}
+
+// Consensus is the global configuration for comparing submissions
+var Consensus = ConsensusOptions{MinOverlap: 5}
+
+// disagreements compares the submitted lines with the previously stored
+// transcriptions and returns review flags for the lines that differ, if the
+// ratio of differing lines exceeds the threshold. Returns nil otherwise.
+func (o ConsensusOptions) disagreements(doc Document, previous *Document) map[string][]string {
+	if o.MaxDisagreement <= 0 || previous == nil {
+		return nil
+	}
+	prevTexts := make(map[string]string, len(previous.Lines))
+	for _, line := range previous.Lines {
+		prevTexts[line.Identifier] = line.Transcription
+	}
+	numOverlap := 0
+	flags := make(map[string][]string)
+	for _, line := range doc.Lines {
+		prevText, found := prevTexts[line.Identifier]
+		text := strings.TrimSpace(line.Transcription)
+		if !found || prevText == "" || text == "" {
+			continue
+		}
+		numOverlap++
+		if text != prevText {
+			flags[line.Identifier] = []string{
+				fmt.Sprintf("disagrees with previous transcription %q", prevText)}
+		}
+	}
+	if numOverlap == 0 || numOverlap < o.MinOverlap {
+		return nil
+	}
+	if float64(len(flags))/float64(numOverlap) <= o.MaxDisagreement {
+		return nil
+	}
+	return flags
+}
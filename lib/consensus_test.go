@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDisagreementIsFlaggedForReview(t *testing.T) {
+	const ident = "landbote_1849"
+	first := []string{"Bekanntmachung", "Die Gemeinde Oberdorf", "verkauft am Montag", "mehrere Fuder Heu"}
+	second := []string{"Bekanntmachung", "Die Gemeinde Oberhof", "verkauft am Dienstag", "mehrere Fuder Heu"}
+	tests := []struct {
+		name      string
+		consensus ConsensusOptions
+		// Whether the two differing lines are flagged
+		wantFlagged bool
+	}{
+		{"above the threshold", ConsensusOptions{MaxDisagreement: 0.25, MinOverlap: 2}, true},
+		{"at the threshold", ConsensusOptions{MaxDisagreement: 0.5, MinOverlap: 2}, false},
+		{"too little overlap", ConsensusOptions{MaxDisagreement: 0.25, MinOverlap: 5}, false},
+		{"disabled", ConsensusOptions{MaxDisagreement: 0, MinOverlap: 2}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withGlobals(t, func() { Consensus = tc.consensus })
+			store, _ := newTestStore(t)
+			submit := func(texts []string, author string) {
+				t.Helper()
+				doc := Document{Identifier: ident, Title: "Landbote", Year: 1849}
+				for idx, text := range texts {
+					line := regionLine(ident, 11, 150, 100+60*idx, text)
+					cacheTestLine(t, ident, line)
+					doc.Lines = append(doc.Lines, line)
+				}
+				if _, err := Submit(store, doc, author, strings.ToLower(author)+"@example.org", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			submit(first, "Erste")
+			submit(second, "Zweite")
+
+			flagged, err := store.Flagged()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.wantFlagged {
+				if len(flagged) != 0 {
+					t.Errorf("expected no lines in the review list, got %+v", flagged)
+				}
+				return
+			}
+			if len(flagged) != 2 {
+				t.Fatalf("expected the two differing lines in the review list, got %+v", flagged)
+			}
+			for idx, line := range flagged {
+				prevText := first[idx+1]
+				want := fmt.Sprintf("disagrees with previous transcription %q", prevText)
+				if line.Line.Transcription != second[idx+1] {
+					t.Errorf("flagged line is %q, want the resubmitted %q", line.Line.Transcription, second[idx+1])
+				}
+				if len(line.Line.Flags) != 1 || line.Line.Flags[0] != want {
+					t.Errorf("line %q has flags %v, want %q", line.Line.Transcription, line.Line.Flags, want)
+				}
+			}
+		})
+	}
+}
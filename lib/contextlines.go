@@ -0,0 +1,34 @@
+package lib
+
+// ContextLines is the number of neighbouring lines on each side whose images
+// are sent along with a line, to help with reading abbreviations
+var ContextLines = 1
+
+// addContextLines links every line with the images of up to ContextLines
+// lines before and after it, in page order. The lines are not part of the
+// volume cache, which only stores the direct neighbours.
+func addContextLines(lines []OCRLine) {
+	window := ContextLines
+	if window < 1 {
+		window = 1
+	}
+	for idx := range lines {
+		start := idx - window
+		if start < 0 {
+			start = 0
+		}
+		end := idx + window + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		var previous, next []string
+		for _, line := range lines[start:idx] {
+			previous = append(previous, line.ImageURL)
+		}
+		for _, line := range lines[idx+1 : end] {
+			next = append(next, line.ImageURL)
+		}
+		lines[idx].PreviousImageURLs = previous
+		lines[idx].NextImageURLs = next
+	}
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var transcribedPat = regexp.MustCompile(`^Transcribed (\d+) lines from (\S+) \((\d+)\)`)
+var reviewedPat = regexp.MustCompile(`^Reviewed (\S+) \((\d+)\)(?:, corrected (\d+))?`)
+var batchPat = regexp.MustCompile(`^Submitted \d+ volumes`)
+
+// ContributedVolume summarizes the contributions of a single contributor to
+// a volume
+type ContributedVolume struct {
+	Identifier string    `json:"id"`
+	Year       int       `json:"year"`
+	NumCommits int       `json:"numCommits"`
+	NumLines   int       `json:"numLines"`
+	First      time.Time `json:"first"`
+	Last       time.Time `json:"last"`
+}
+
+// ContributorHistory lists the volumes a contributor transcribed or reviewed
+type ContributorHistory struct {
+	Handle   string              `json:"handle"`
+	NumLines int                 `json:"numLines"`
+	Volumes  []ContributedVolume `json:"volumes"`
+}
+
+// parseContribution reads the volume and the number of transcribed or
+// corrected lines from the subject of a submission commit
+func parseContribution(subject string) (ident string, year int, numLines int, ok bool) {
+	if match := transcribedPat.FindStringSubmatch(subject); match != nil {
+		numLines, _ = strconv.Atoi(match[1])
+		year, _ = strconv.Atoi(match[3])
+		return match[2], year, numLines, true
+	}
+	if match := reviewedPat.FindStringSubmatch(subject); match != nil {
+		year, _ = strconv.Atoi(match[2])
+		if match[3] != "" {
+			numLines, _ = strconv.Atoi(match[3])
+		}
+		return match[1], year, numLines, true
+	}
+	return "", 0, 0, false
+}
+
+// contribution is a volume that a submission commit transcribed or reviewed
+type contribution struct {
+	ident    string
+	year     int
+	numLines int
+}
+
+// contributions parses the volumes a commit contributed to, batched commits
+// list the subjects of their volumes in the body
+func (e LogEntry) contributions() []contribution {
+	lines := []string{e.Subject}
+	if batchPat.MatchString(e.Subject) {
+		lines = strings.Split(e.Body, "\n")
+	}
+	var found []contribution
+	for _, line := range lines {
+		if ident, year, numLines, ok := parseContribution(line); ok {
+			found = append(found, contribution{ident: ident, year: year, numLines: numLines})
+		}
+	}
+	return found
+}
+
+// ContributorHistory collects all volumes attributed to a contributor from
+// the commit authorship. The handle is matched case-insensitively against
+// the author name and email, unknown contributors have no volumes.
+func (s *DocumentStore) ContributorHistory(handle string) (*ContributorHistory, error) {
+	history := &ContributorHistory{
+		Handle:  handle,
+		Volumes: make([]ContributedVolume, 0),
+	}
+	if handle == "" {
+		return history, nil
+	}
+	entries, err := s.repo.Log("transcriptions")
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]*ContributedVolume)
+	for _, entry := range entries {
+		if !strings.EqualFold(entry.Author.Name, handle) &&
+			!strings.EqualFold(entry.Author.Email, handle) {
+			continue
+		}
+		for _, contrib := range entry.contributions() {
+			vol, found := volumes[contrib.ident]
+			if !found {
+				vol = &ContributedVolume{
+					Identifier: contrib.ident,
+					Year:       contrib.year,
+					First:      entry.Date,
+					Last:       entry.Date,
+				}
+				volumes[contrib.ident] = vol
+			}
+			vol.NumCommits++
+			vol.NumLines += contrib.numLines
+			history.NumLines += contrib.numLines
+			if entry.Date.Before(vol.First) {
+				vol.First = entry.Date
+			}
+			if entry.Date.After(vol.Last) {
+				vol.Last = entry.Date
+			}
+		}
+	}
+	for _, vol := range volumes {
+		history.Volumes = append(history.Volumes, *vol)
+	}
+	sort.Slice(history.Volumes, func(i, j int) bool {
+		return history.Volumes[i].Last.After(history.Volumes[j].Last)
+	})
+	return history, nil
+}
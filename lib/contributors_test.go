@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestContributorHistory(t *testing.T) {
+	store, _ := newTestStore(t)
+	clock := &fakeClock{now: time.Date(2001, 5, 1, 12, 0, 0, 0, time.UTC)}
+	previous := CommitClock
+	CommitClock = clock
+	defer func() { CommitClock = previous }()
+
+	submissions := []struct {
+		name, email string
+		ident       string
+		year        int
+		numLines    int
+	}{
+		{"Anna", "anna@example.org", "kurier_1850", 1850, 2},
+		{"Anna", "anna@example.org", "bote_1861", 1861, 1},
+		{"Bernd", "bernd@example.org", "kurier_1850", 1850, 3},
+		{"Anna", "anna@example.org", "kurier_1850", 1850, 4},
+	}
+	for idx, sub := range submissions {
+		doc := Document{Identifier: sub.ident, Title: "Test", Year: sub.year}
+		for lineIdx := 0; lineIdx < sub.numLines; lineIdx++ {
+			line := regionLine(sub.ident, 11, 150, 100+60*lineIdx, fmt.Sprintf("Zeile %d von %s", lineIdx, sub.name))
+			cacheTestLine(t, sub.ident, line)
+			doc.Lines = append(doc.Lines, line)
+		}
+		if _, err := store.Save(doc, sub.name, sub.email, ""); err != nil {
+			t.Fatalf("submission %d: %v", idx, err)
+		}
+		clock.Sleep(time.Hour)
+	}
+	// Reviews of a volume count the corrected lines, not the added ones
+	at := func(idx int) time.Time { return time.Date(2001, 5, 1, 12+idx, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		handle string
+		want   []ContributedVolume
+	}{
+		{"by name", "Anna", []ContributedVolume{
+			{Identifier: "kurier_1850", Year: 1850, NumCommits: 2, NumLines: 5, First: at(0), Last: at(3)},
+			{Identifier: "bote_1861", Year: 1861, NumCommits: 1, NumLines: 1, First: at(1), Last: at(1)},
+		}},
+		{"by email in other case", "ANNA@example.org", []ContributedVolume{
+			{Identifier: "kurier_1850", Year: 1850, NumCommits: 2, NumLines: 5, First: at(0), Last: at(3)},
+			{Identifier: "bote_1861", Year: 1861, NumCommits: 1, NumLines: 1, First: at(1), Last: at(1)},
+		}},
+		{"other contributor", "bernd", []ContributedVolume{
+			{Identifier: "kurier_1850", Year: 1850, NumCommits: 1, NumLines: 2, First: at(2), Last: at(2)},
+		}},
+		{"unknown contributor", "Clara", nil},
+		{"empty handle", "", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			history, err := store.ContributorHistory(tc.handle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if history.Volumes == nil {
+				t.Fatal("volumes must be an empty list rather than null")
+			}
+			if len(history.Volumes) != len(tc.want) {
+				t.Fatalf("got volumes %+v, want %+v", history.Volumes, tc.want)
+			}
+			numLines := 0
+			for idx, want := range tc.want {
+				got := history.Volumes[idx]
+				if got.Identifier != want.Identifier || got.Year != want.Year || got.NumCommits != want.NumCommits ||
+					got.NumLines != want.NumLines || !got.First.Equal(want.First) || !got.Last.Equal(want.Last) {
+					t.Errorf("volume %d is %+v, want %+v", idx, got, want)
+				}
+				numLines += got.NumLines
+			}
+			if history.NumLines != numLines {
+				t.Errorf("history has %d lines, its volumes %d", history.NumLines, numLines)
+			}
+		})
+	}
+}
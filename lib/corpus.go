@@ -0,0 +1,66 @@
+package lib
+
+import "github.com/rs/zerolog/log"
+
+// CorpusStore persists transcribed volumes. DocumentStore, backed by a git
+// repository, is the default implementation. Saving a volume records it
+// durably, stores decide themselves how to group their commits. Commit
+// records changes that were not recorded together with a volume.
+type CorpusStore interface {
+	// SaveVolume stores a validated document with the review flags for its
+	// lines and commits it
+	SaveVolume(doc Document, reviewFlags map[string][]string, author string, email string, comment string) (*Document, error)
+	// LoadVolume reads a single document with its history, returns
+	// ErrDocumentNotFound if there is none
+	LoadVolume(ident string) (*Document, error)
+	// ListVolumes returns all documents without their lines
+	ListVolumes() ([]*Document, error)
+	// Commit records all pending changes, it does nothing if there are none
+	Commit(message string, author string, email string) error
+}
+
+// Submit validates a submitted document and saves it to the store. Returns a
+// *ValidationError if lines failed hard validation, lines that failed soft
+// validation or disagree with too many stored transcriptions are saved with
+// review flags.
+func Submit(store CorpusStore, doc Document, author string, email string, comment string) (*Document, error) {
+	logger := log.With().Str("identifier", doc.Identifier).Logger()
+	doc.Lines = ExpandDuplicateLines(doc.Lines)
+	normalizeLines(doc.Lines)
+	for idx, line := range doc.Lines {
+		// Lines whose OCR text was edited count as human transcriptions
+		if line.Provenance == ProvenanceMachine && line.Transcription != NormalizeTranscription(line.OCRText) {
+			doc.Lines[idx].Provenance = ""
+		}
+	}
+	reviewFlags := make(map[string][]string)
+	var rejected []LineValidation
+	for _, result := range Validation.ValidateDocument(doc) {
+		switch result.Level {
+		case ValidationHard:
+			rejected = append(rejected, result)
+		case ValidationSoft:
+			reviewFlags[result.Identifier] = result.Reasons
+		}
+	}
+	if len(rejected) > 0 {
+		logger.Warn().Int("numRejected", len(rejected)).Msg("Rejected invalid lines")
+		return nil, &ValidationError{Lines: rejected}
+	}
+	if Consensus.MaxDisagreement > 0 {
+		previous, err := store.LoadVolume(doc.Identifier)
+		if err != nil && err != ErrDocumentNotFound {
+			return nil, err
+		}
+		disagreeing := Consensus.disagreements(doc, previous)
+		if len(disagreeing) > 0 {
+			logger.Warn().
+				Int("numDisagreeing", len(disagreeing)).
+				Msg("Submission disagrees with stored transcriptions, flagging for review")
+		}
+		for ident, reasons := range disagreeing {
+			reviewFlags[ident] = append(reviewFlags[ident], reasons...)
+		}
+	}
+	return store.SaveVolume(doc, reviewFlags, author, email, comment)
+}
@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSubmitToMemoryStore(t *testing.T) {
+	const ident = "kalender_1862"
+	tests := []struct {
+		name  string
+		lines []OCRLine
+		// Expected transcriptions by line identifier, nil if the submission
+		// is rejected
+		want map[string]string
+		// Expected review flags by line identifier
+		flags map[string][]string
+	}{
+		{
+			name: "transcribed lines are stored",
+			lines: []OCRLine{
+				testLine(ident, 0, "Januar hat 31 Tage"),
+				testLine(ident, 1, "Februar hat 28 Tage"),
+			},
+			want: map[string]string{
+				testLine(ident, 0, "").Identifier: "Januar hat 31 Tage",
+				testLine(ident, 1, "").Identifier: "Februar hat 28 Tage",
+			},
+		},
+		{
+			name: "untranscribed lines are dropped",
+			lines: []OCRLine{
+				testLine(ident, 0, "März hat 31 Tage"),
+				testLine(ident, 1, ""),
+			},
+			want: map[string]string{
+				testLine(ident, 0, "").Identifier: "März hat 31 Tage",
+			},
+		},
+		{
+			name: "suspicious lines are flagged",
+			lines: []OCRLine{
+				testLine(ident, 0, "April hat 30 Tage"),
+				testLine(ident, 1, "x"),
+			},
+			want: map[string]string{
+				testLine(ident, 0, "").Identifier: "April hat 30 Tage",
+				testLine(ident, 1, "").Identifier: "x",
+			},
+			flags: map[string][]string{
+				testLine(ident, 1, "").Identifier: {ReasonTooShort},
+			},
+		},
+		{
+			name: "invalid lines reject the submission",
+			lines: []OCRLine{
+				testLine(ident, 0, "Mai hat 31 Tage"),
+				testLine(ident, 1, "Juni hat\x0730 Tage"),
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			doc := Document{Identifier: ident, Title: "Kalender", Year: 1862, Lines: tc.lines}
+			saved, err := Submit(store, doc, "Test", "test@example.org", "Erste Seite")
+			if tc.want == nil {
+				if _, ok := err.(*ValidationError); !ok {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				if _, err := store.LoadVolume(ident); err != ErrDocumentNotFound {
+					t.Errorf("rejected volume was stored: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := store.LoadVolume(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, doc := range []*Document{saved, loaded} {
+				got := make(map[string]string)
+				for _, line := range doc.Lines {
+					got[line.Identifier] = line.Transcription
+					if want := strings.Join(tc.flags[line.Identifier], ","); strings.Join(line.Flags, ",") != want {
+						t.Errorf("line %s has flags %v, want %v", line.Identifier, line.Flags, want)
+					}
+				}
+				if len(got) != len(tc.want) {
+					t.Errorf("stored lines %v, want %v", got, tc.want)
+				}
+				for id, text := range tc.want {
+					if got[id] != text {
+						t.Errorf("line %s is %q, want %q", id, got[id], text)
+					}
+				}
+				if len(doc.History) != 1 || doc.History[0].Author.Email != "test@example.org" ||
+					doc.History[0].Body != "Erste Seite" {
+					t.Errorf("unexpected history %+v", doc.History)
+				}
+			}
+			volumes, _ := store.ListVolumes()
+			if len(volumes) != 1 || volumes[0].NumLines != len(tc.want) || len(volumes[0].Lines) != 0 {
+				t.Errorf("unexpected volume list %+v", volumes)
+			}
+		})
+	}
+}
+
+func TestSubmitToMemoryStoreFlagsDisagreement(t *testing.T) {
+	withGlobals(t, func() { Consensus = ConsensusOptions{MaxDisagreement: 0.5, MinOverlap: 2} })
+	const ident = "kalender_1863"
+	store := NewMemoryStore()
+	first := Document{Identifier: ident, Year: 1863, Lines: []OCRLine{
+		testLine(ident, 0, "Sonntag nach Ostern"),
+		testLine(ident, 1, "Montag nach Ostern"),
+		testLine(ident, 2, "Dienstag nach Ostern"),
+	}}
+	if _, err := Submit(store, first, "Erste", "erste@example.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	second := Document{Identifier: ident, Year: 1863, Lines: []OCRLine{
+		testLine(ident, 0, "Sonntag vor Pfingsten"),
+		testLine(ident, 1, "Montag vor Pfingsten"),
+		testLine(ident, 2, "Dienstag nach Ostern"),
+	}}
+	saved, err := Submit(store, second, "Zweite", "zweite@example.org", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagged := 0
+	for _, line := range saved.Lines {
+		if len(line.Flags) > 0 {
+			flagged++
+		}
+	}
+	if flagged != 2 {
+		t.Errorf("expected the two disagreeing lines to be flagged, got %d", flagged)
+	}
+	if len(saved.History) != 2 || saved.History[0].Author.Name != "Zweite" {
+		t.Errorf("expected the second submission on top of the history, got %+v", saved.History)
+	}
+}
+
+func TestMemoryStoreListsVolumes(t *testing.T) {
+	store := NewMemoryStore()
+	docs := []Document{
+		{Identifier: "kalender_1864", Title: "Kalender", Year: 1864, Lines: []OCRLine{
+			testLine("kalender_1864", 0, "Neujahr fällt auf einen Freitag"),
+		}},
+		{Identifier: "kalender_1865", Title: "Volkskalender", Year: 1865, Lines: []OCRLine{
+			testLine("kalender_1865", 0, "Neujahr fällt auf einen Sonntag"),
+			testLine("kalender_1865", 1, "Ostern fällt auf den 16. April"),
+		}},
+	}
+	for _, doc := range docs {
+		if _, err := Submit(store, doc, "Test", "test@example.org", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	volumes, err := store.ListVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != len(docs) {
+		t.Fatalf("got %d volumes, want %d", len(volumes), len(docs))
+	}
+	for idx, doc := range docs {
+		got := volumes[idx]
+		if got.Identifier != doc.Identifier || got.Title != doc.Title ||
+			got.NumLines != len(doc.Lines) || len(got.Lines) != 0 {
+			t.Errorf("volume %d is %s %q with %d lines, want %s %q with %d lines",
+				idx, got.Identifier, got.Title, got.NumLines,
+				doc.Identifier, doc.Title, len(doc.Lines))
+		}
+	}
+}
+
+func TestDocumentStoreCommitsStagedChanges(t *testing.T) {
+	store, origin := newTestStore(t)
+	if err := store.Commit("Nothing to commit", "Test", "test@example.org"); err != nil {
+		t.Fatalf("commit without staged changes failed: %v", err)
+	}
+	if count := git(t, origin, "rev-list", "--count", "master"); count != "1" {
+		t.Fatalf("commit without staged changes was recorded, %s commits on origin", count)
+	}
+	notePath := filepath.Join(store.basePath, "NOTES.md")
+	if err := ioutil.WriteFile(notePath, []byte("Hinweise\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, store.basePath, "add", "NOTES.md")
+	if err := store.Commit("Add notes", "Test", "test@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if subject := git(t, origin, "log", "-1", "--format=%s", "master"); subject != "Add notes" {
+		t.Errorf("origin is at %q, want the staged changes committed", subject)
+	}
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Minimum number of characters of each part of a compound word
+const minCompoundPart = 3
+
+// Dictionary is a list of known words, used to check whether transcriptions
+// are plausible historical German
+type Dictionary struct {
+	words map[string]bool
+}
+
+// LoadDictionary reads a wordlist with one word per line
+func LoadDictionary(path string) (*Dictionary, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	dict := &Dictionary{words: make(map[string]bool)}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if word := normalizeWord(scanner.Text()); word != "" {
+			dict.words[word] = true
+		}
+	}
+	return dict, scanner.Err()
+}
+
+// normalizeWord lowercases a word and replaces historic glyph variants
+func normalizeWord(word string) string {
+	word = strings.TrimSpace(strings.ToLower(word))
+	return strings.NewReplacer("ſ", "s", "aͤ", "ä", "oͤ", "ö", "uͤ", "ü").Replace(word)
+}
+
+// UnknownRatio returns the fraction of words in a line that are neither in
+// the dictionary nor compounds of known words, along with the number of
+// words that were considered. Capitalized unknown words are assumed to be
+// proper nouns and are not considered.
+func (d *Dictionary) UnknownRatio(text string) (float64, int) {
+	tokens := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.Is(unicode.Mn, r)
+	})
+	numConsidered := 0
+	numUnknown := 0
+	for _, token := range tokens {
+		if utf8.RuneCountInString(token) < 2 {
+			continue
+		}
+		word := normalizeWord(token)
+		if d.isKnown(word) {
+			numConsidered++
+			continue
+		}
+		first, _ := utf8.DecodeRuneInString(token)
+		if unicode.IsUpper(first) {
+			continue
+		}
+		numConsidered++
+		numUnknown++
+	}
+	if numConsidered == 0 {
+		return 0, 0
+	}
+	return float64(numUnknown) / float64(numConsidered), numConsidered
+}
+
+func (d *Dictionary) isKnown(word string) bool {
+	if d.words[word] {
+		return true
+	}
+	return d.isCompound(word)
+}
+
+// isCompound checks if a word can be split into known words, optionally
+// joined with a linking "s"
+func (d *Dictionary) isCompound(word string) bool {
+	runes := []rune(word)
+	for split := minCompoundPart; split <= len(runes)-minCompoundPart; split++ {
+		head := string(runes[:split])
+		if !d.words[head] {
+			continue
+		}
+		tail := string(runes[split:])
+		if d.words[tail] || d.isCompound(tail) {
+			return true
+		}
+		if runes[split] == 's' && split+1 <= len(runes)-minCompoundPart {
+			tail = string(runes[split+1:])
+			if d.words[tail] || d.isCompound(tail) {
+				return true
+			}
+		}
+	}
+	return false
+}
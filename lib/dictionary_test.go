@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlausibilityCheck(t *testing.T) {
+	dict, err := LoadDictionary(filepath.Join("testdata", "wordlist.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := &Validator{SoftValidation: true, MaxUnknownRatio: 0.5, Dictionary: dict}
+	tests := []struct {
+		name string
+		text string
+		// Whether the line is flagged as implausible
+		flagged bool
+	}{
+		{"valid German", "die neue Schule wurde heute eröffnet", false},
+		{"gibberish", "xqzt vbnm das qwrtz plkj", true},
+		{"compounds", "das Rathhaus und die Feuerwehrversammlung", false},
+		{"linking s", "die Stadtsschule ist neu", false},
+		{"proper nouns", "in Wernigerode und Quedlinburg", false},
+		{"long s and ligatures", "die Schule iſt in der Stadt", false},
+		{"too few words", "xqzt", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := validator.ValidateLine(OCRLine{Identifier: "line", Transcription: tc.text})
+			flagged := strings.Contains(strings.Join(result.Reasons, ","), ReasonImplausibleWords)
+			if flagged != tc.flagged {
+				t.Errorf("flagged is %v, want %v (reasons %v)", flagged, tc.flagged, result.Reasons)
+			}
+			if tc.flagged && result.Level != ValidationSoft {
+				t.Errorf("implausible line must only fail soft validation, got %s", result.Level)
+			}
+		})
+	}
+}
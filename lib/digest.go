@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Digest algorithms for line identifiers
+const (
+	DigestSHA1   = "sha1"
+	DigestSHA256 = "sha256"
+)
+
+// DigestOptions configures the digest of the image URL that identifies a
+// line
+type DigestOptions struct {
+	Algorithm string
+	// Number of hex digits kept, the full digest if zero
+	Length int
+}
+
+// LineDigest is the digest used for new line identifiers. The default of
+// truncated SHA1 matches the identifiers in existing repositories.
+//
+// Changing it only affects lines fetched afterwards: stored volumes keep the
+// identifiers in their metadata, so their transcriptions and images keep
+// resolving, and resubmissions of them keep their line identifiers. Cached
+// line images are looked up under the new identifiers, so they are
+// downloaded again once.
+var LineDigest = DigestOptions{Algorithm: DigestSHA1, Length: 8}
+
+// Validate checks for a known algorithm and a length that fits its digests
+func (o DigestOptions) Validate() error {
+	var maxLength int
+	switch o.Algorithm {
+	case DigestSHA1:
+		maxLength = 40
+	case DigestSHA256:
+		maxLength = 64
+	default:
+		return fmt.Errorf("unknown digest algorithm '%s'", o.Algorithm)
+	}
+	if o.Length < 0 || o.Length > maxLength {
+		return fmt.Errorf("digest length must be between 0 and %d for %s", maxLength, o.Algorithm)
+	}
+	return nil
+}
+
+// Digest returns the hex digest of the data, truncated to the configured
+// length
+func (o DigestOptions) Digest(inp []byte) string {
+	var digest string
+	if o.Algorithm == DigestSHA256 {
+		digest = Sha256Digest(inp)
+	} else {
+		digest = fmt.Sprintf("%x", sha1Sum(inp))
+	}
+	if o.Length > 0 && o.Length < len(digest) {
+		digest = digest[:o.Length]
+	}
+	return digest
+}
+
+// warnIdentifierCollisions logs lines of a volume whose different image URLs
+// share a truncated identifier, only the first of them can be cached and
+// stored
+func warnIdentifierCollisions(ident string, lines []OCRLine) {
+	urls := make(map[string]string, len(lines))
+	for _, line := range lines {
+		other, ok := urls[line.Identifier]
+		if !ok {
+			urls[line.Identifier] = line.ImageURL
+			continue
+		}
+		if other != line.ImageURL {
+			log.Warn().
+				Str("identifier", ident).
+				Str("lineId", line.Identifier).
+				Str("imageUrl", line.ImageURL).
+				Str("collidingImageUrl", other).
+				Msg("Line identifiers collide, consider a longer -lineDigestLength")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DryRunStore reads volumes from another store, but writes submitted volumes
+// to a temporary directory instead of committing them. Line images are not
+// copied and the line cache is left untouched.
+type DryRunStore struct {
+	CorpusStore
+	dir string
+}
+
+// NewDryRunStore wraps a store so that submissions are not committed
+func NewDryRunStore(store CorpusStore) (*DryRunStore, error) {
+	dir, err := ioutil.TempDir("", "archiscribe-dryrun")
+	if err != nil {
+		return nil, err
+	}
+	return &DryRunStore{CorpusStore: store, dir: dir}, nil
+}
+
+// Dir returns the directory the submitted volumes are written to
+func (s *DryRunStore) Dir() string {
+	return s.dir
+}
+
+// SaveVolume writes the transcriptions and metadata of a document below the
+// temporary directory, in the same layout as the repository. The returned
+// document has a fake commit on top of its stored history.
+func (s *DryRunStore) SaveVolume(doc Document, reviewFlags map[string][]string, author string, email string, comment string) (*Document, error) {
+	logger := log.With().Str("identifier", doc.Identifier).Logger()
+	previous, err := s.CorpusStore.LoadVolume(doc.Identifier)
+	if err == ErrDocumentNotFound {
+		previous = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if previous != nil && doc.Script == "" {
+		doc.Script = previous.Script
+	}
+	yearPath := filepath.Join(s.dir, "transcriptions", strconv.Itoa(doc.Year))
+	if err := os.MkdirAll(yearPath, 0755); err != nil {
+		return nil, err
+	}
+	lines := make([]OCRLine, 0, len(doc.Lines))
+	for _, line := range doc.Lines {
+		if line.Transcription == "" {
+			continue
+		}
+		line.Flags = keepReviewFlags(line, previous, reviewFlags[line.Identifier])
+		line.Session = keepSession(line, previous)
+		textPath := filepath.Join(
+			yearPath, fmt.Sprintf("%s_%s.txt", doc.Identifier, line.Identifier))
+		if err := WriteFileAtomic(textPath, []byte(line.Transcription+"\n")); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	sortLines(lines)
+	if AnnotateHyphenation {
+		annotateHyphenation(lines)
+	}
+	doc.Lines = lines
+	doc.NumLines = 0
+	metaJSON, err := metadataJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	metaPath := filepath.Join(yearPath, doc.Identifier+".json")
+	if err := WriteFileAtomic(metaPath, metaJSON); err != nil {
+		return nil, err
+	}
+
+	var subject string
+	if previous != nil {
+		subject = fmt.Sprintf("Reviewed %s (%d)", doc.Identifier, doc.Year)
+		doc.History = previous.History
+	} else {
+		subject = fmt.Sprintf(
+			"Transcribed %d lines from %s (%d)", len(doc.Lines), doc.Identifier,
+			doc.Year)
+		doc.History = nil
+	}
+	entry := LogEntry{
+		Date:    commitTime(),
+		Commit:  Sha1Digest(metaJSON),
+		Subject: subject,
+		Body:    comment,
+	}
+	entry.Author.Name = author
+	entry.Author.Email = email
+	doc.History = append([]LogEntry{entry}, doc.History...)
+	doc.Commit = entry.Commit
+	logger.Info().
+		Str("path", metaPath).
+		Str("commit", entry.Commit).
+		Msg("Dry run, wrote volume without committing")
+	return &doc, nil
+}
+
+// Commit only logs the message, nothing is recorded in dry-run mode
+func (s *DryRunStore) Commit(message string, author string, email string) error {
+	log.Info().Str("message", message).Msg("Dry run, skipping commit")
+	return nil
+}
@@ -0,0 +1,71 @@
+package lib
+
+import "math"
+
+// Maximum relative difference in width and height for two lines with the
+// same OCR text to be considered duplicates
+const duplicateTolerance = 0.2
+
+// GroupDuplicateLines merges lines of a volume that have identical OCR text
+// and a similar size, like running headers, so they only have to be
+// transcribed once. The first line of each group carries the other lines
+// of the group as its duplicates.
+func GroupDuplicateLines(lines []OCRLine) []OCRLine {
+	grouped := make([]OCRLine, 0, len(lines))
+	groupsByText := make(map[string][]int)
+	for _, line := range lines {
+		if line.OCRText == "" {
+			grouped = append(grouped, line)
+			continue
+		}
+		found := false
+		for _, groupIdx := range groupsByText[line.OCRText] {
+			if similarRegions(grouped[groupIdx], line) {
+				grouped[groupIdx].Duplicates = append(grouped[groupIdx].Duplicates, line)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groupsByText[line.OCRText] = append(groupsByText[line.OCRText], len(grouped))
+			grouped = append(grouped, line)
+		}
+	}
+	return grouped
+}
+
+// ExpandDuplicateLines turns grouped lines back into individual lines that
+// share the transcription of their group
+func ExpandDuplicateLines(lines []OCRLine) []OCRLine {
+	expanded := make([]OCRLine, 0, len(lines))
+	for _, line := range lines {
+		dups := line.Duplicates
+		line.Duplicates = nil
+		expanded = append(expanded, line)
+		for _, dup := range dups {
+			dup.Transcription = line.Transcription
+			dup.Provenance = line.Provenance
+			dup.Duplicates = nil
+			expanded = append(expanded, dup)
+		}
+	}
+	return expanded
+}
+
+func similarRegions(a OCRLine, b OCRLine) bool {
+	ra, okA := parseRegion(a.ImageURL)
+	rb, okB := parseRegion(b.ImageURL)
+	if !okA || !okB {
+		return false
+	}
+	return relativeDifference(ra.Width, rb.Width) <= duplicateTolerance &&
+		relativeDifference(ra.Height, rb.Height) <= duplicateTolerance
+}
+
+func relativeDifference(a int, b int) float64 {
+	max := math.Max(float64(a), float64(b))
+	if max == 0 {
+		return 0
+	}
+	return math.Abs(float64(a-b)) / max
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+)
+
+// ocrLine returns an untranscribed line on a page with the given OCR text
+// and size
+func ocrLine(volumeID string, page int, y int, width int, text string) OCRLine {
+	url := fmt.Sprintf(
+		"https://iiif.archivelab.org/iiif/%s$%d/150,%d,%d,60/full/0/default.png", volumeID, page, y, width)
+	return OCRLine{Identifier: LineDigest.Digest([]byte(url)), ImageURL: url, OCRText: text}
+}
+
+func TestGroupDuplicateLines(t *testing.T) {
+	const ident = "hausfreund_1858"
+	tests := []struct {
+		name  string
+		lines []OCRLine
+		// Number of duplicates of every served line
+		want []int
+	}{
+		{
+			"identical running headers",
+			[]OCRLine{
+				ocrLine(ident, 11, 100, 1400, "Der Hausfreund."),
+				ocrLine(ident, 11, 200, 1400, "Erste Zeile"),
+				ocrLine(ident, 12, 100, 1380, "Der Hausfreund."),
+				ocrLine(ident, 13, 100, 1420, "Der Hausfreund."),
+			},
+			[]int{2, 0},
+		},
+		{
+			"same text with a different size",
+			[]OCRLine{
+				ocrLine(ident, 11, 100, 1400, "Der Hausfreund."),
+				ocrLine(ident, 12, 100, 600, "Der Hausfreund."),
+			},
+			[]int{0, 0},
+		},
+		{
+			"lines without OCR text",
+			[]OCRLine{
+				ocrLine(ident, 11, 100, 1400, ""),
+				ocrLine(ident, 12, 100, 1400, ""),
+			},
+			[]int{0, 0},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			grouped := GroupDuplicateLines(tc.lines)
+			if len(grouped) != len(tc.want) {
+				t.Fatalf("expected %d served lines, got %d", len(tc.want), len(grouped))
+			}
+			for idx, line := range grouped {
+				if len(line.Duplicates) != tc.want[idx] {
+					t.Errorf("line %d has %d duplicates, want %d", idx, len(line.Duplicates), tc.want[idx])
+				}
+			}
+			if expanded := ExpandDuplicateLines(grouped); len(expanded) != len(tc.lines) {
+				t.Errorf("expanded to %d lines, want %d", len(expanded), len(tc.lines))
+			}
+		})
+	}
+}
+
+func TestDuplicateLinesAreStoredIndividually(t *testing.T) {
+	const ident = "hausfreund_1859"
+	headers := []OCRLine{
+		ocrLine(ident, 11, 100, 1400, "Der Hausfreund."),
+		ocrLine(ident, 12, 100, 1400, "Der Hausfreund."),
+		ocrLine(ident, 13, 100, 1400, "Der Hausfreund."),
+	}
+	grouped := GroupDuplicateLines(headers)
+	if len(grouped) != 1 {
+		t.Fatalf("expected the headers to be grouped into one task, got %d", len(grouped))
+	}
+	grouped[0].Transcription = "Der Hausfreund."
+	store := NewMemoryStore()
+	doc := Document{Identifier: ident, Title: "Hausfreund", Year: 1859, Lines: grouped}
+	if _, err := Submit(store, doc, "Test", "test@example.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.LoadVolume(ident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Lines) != len(headers) {
+		t.Fatalf("expected %d stored lines, got %d", len(headers), len(stored.Lines))
+	}
+	for idx, line := range stored.Lines {
+		if line.Identifier != headers[idx].Identifier || line.Transcription != "Der Hausfreund." {
+			t.Errorf("line %d stored as %s %q", idx, line.Identifier, line.Transcription)
+		}
+		if len(line.Duplicates) != 0 {
+			t.Errorf("line %d was stored with its duplicates", idx)
+		}
+	}
+}
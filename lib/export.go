@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CharsetFilter selects lines by the characters in their transcription
+type CharsetFilter struct {
+	// Lines have to contain at least one of these characters, every line
+	// matches if this is empty
+	Required []rune
+	// Lines must not contain any of these characters
+	Forbidden []rune
+}
+
+// Match checks if a transcription passes the filter
+func (f CharsetFilter) Match(text string) bool {
+	for _, r := range f.Forbidden {
+		if strings.ContainsRune(text, r) {
+			return false
+		}
+	}
+	if len(f.Required) == 0 {
+		return true
+	}
+	for _, r := range f.Required {
+		if strings.ContainsRune(text, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCodepoints parses a comma-separated list of characters, either as
+// literal characters or in U+XXXX notation
+func ParseCodepoints(spec string) ([]rune, error) {
+	runes := make([]rune, 0)
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.ToUpper(token), "U+") {
+			val, err := strconv.ParseUint(token[2:], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(val)) {
+				return nil, fmt.Errorf("invalid codepoint '%s'", token)
+			}
+			runes = append(runes, rune(val))
+			continue
+		}
+		runes = append(runes, []rune(token)...)
+	}
+	return runes, nil
+}
+
+// ExportOptions selects the lines that are exported
+type ExportOptions struct {
+	Charset CharsetFilter
+	// Skip lines that were accepted from the OCR without a human
+	// transcription
+	HumanOnly bool
+}
+
+// ExportReport summarizes an export of the corpus
+type ExportReport struct {
+	NumLines   int `json:"numLines"`
+	NumMatched int `json:"numMatched"`
+	// Number of exported lines containing each required character
+	CharCounts map[string]int `json:"charCounts,omitempty"`
+}
+
+// Export writes all transcribed lines selected by the options to outDir as a
+// training set, with every line image next to its transcription in a
+// .gt.txt file
+func (s *DocumentStore) Export(outDir string, opts ExportOptions) (*ExportReport, error) {
+	filter := opts.Charset
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	report := &ExportReport{CharCounts: make(map[string]int)}
+	err := s.forEachDocument(func(doc *Document) error {
+		for _, line := range doc.Lines {
+			if line.Transcription == "" {
+				continue
+			}
+			report.NumLines++
+			if opts.HumanOnly && line.Provenance == ProvenanceMachine {
+				continue
+			}
+			if !filter.Match(line.Transcription) {
+				continue
+			}
+			name := fmt.Sprintf("%s_%s", doc.Identifier, line.Identifier)
+			imgPath := filepath.Join(
+				s.basePath, "transcriptions", strconv.Itoa(doc.Year), name+".png")
+			if err := copyFile(imgPath, filepath.Join(outDir, name+".png")); err != nil {
+				return err
+			}
+			gtPath := filepath.Join(outDir, name+".gt.txt")
+			if err := WriteFileAtomic(gtPath, []byte(line.Transcription+"\n")); err != nil {
+				return err
+			}
+			report.NumMatched++
+			for _, r := range filter.Required {
+				if strings.ContainsRune(line.Transcription, r) {
+					report.CharCounts[string(r)]++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExportCharsetSubset(t *testing.T) {
+	fixture := map[string][]string{
+		"intelligenzblatt_1832": {"Das Hauſs am Markte", "Die neue Brücke", "Zum ſchwarzen Adler"},
+		"wochenblatt_1871":      {"Kirchliche Nachrichten", "Getauft: ein Sohn", "Geſtorben: eine Wittwe"},
+	}
+	tests := []struct {
+		name      string
+		required  string
+		forbidden string
+		want      []string
+		// Expected number of exported lines per required character
+		counts map[string]int
+	}{
+		{
+			name:     "long s",
+			required: "ſ",
+			want:     []string{"Das Hauſs am Markte", "Geſtorben: eine Wittwe", "Zum ſchwarzen Adler"},
+			counts:   map[string]int{"ſ": 3},
+		},
+		{
+			name:     "long s as codepoint",
+			required: "U+017F",
+			want:     []string{"Das Hauſs am Markte", "Geſtorben: eine Wittwe", "Zum ſchwarzen Adler"},
+			counts:   map[string]int{"ſ": 3},
+		},
+		{
+			name:     "any of two characters",
+			required: "ſ,ü",
+			want:     []string{"Das Hauſs am Markte", "Die neue Brücke", "Geſtorben: eine Wittwe", "Zum ſchwarzen Adler"},
+			counts:   map[string]int{"ſ": 3, "ü": 1},
+		},
+		{
+			name:      "without long s",
+			forbidden: "ſ",
+			want:      []string{"Die neue Brücke", "Getauft: ein Sohn", "Kirchliche Nachrichten"},
+			counts:    map[string]int{},
+		},
+		{
+			name:      "long s without umlauts",
+			required:  "ſ",
+			forbidden: "ä,ö,ü",
+			want:      []string{"Das Hauſs am Markte", "Geſtorben: eine Wittwe", "Zum ſchwarzen Adler"},
+			counts:    map[string]int{"ſ": 3},
+		},
+	}
+	store, _ := newTestStore(t)
+	for ident, texts := range fixture {
+		doc := Document{Identifier: ident, Title: "Test", Year: 1850}
+		for idx, text := range texts {
+			line := regionLine(ident, 11, 150, 100+60*idx, text)
+			cacheTestLine(t, ident, line)
+			doc.Lines = append(doc.Lines, line)
+		}
+		if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			required, err := ParseCodepoints(tc.required)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forbidden, err := ParseCodepoints(tc.forbidden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outDir := t.TempDir()
+			report, err := store.Export(outDir, ExportOptions{
+				Charset: CharsetFilter{Required: required, Forbidden: forbidden},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.NumLines != 6 || report.NumMatched != len(tc.want) {
+				t.Errorf("report has %d of %d lines matched, want %d of 6",
+					report.NumMatched, report.NumLines, len(tc.want))
+			}
+			if len(report.CharCounts) != len(tc.counts) {
+				t.Errorf("report has character counts %v, want %v", report.CharCounts, tc.counts)
+			}
+			for char, count := range tc.counts {
+				if report.CharCounts[char] != count {
+					t.Errorf("%d lines with %q reported, want %d", report.CharCounts[char], char, count)
+				}
+			}
+
+			transcriptions, _ := filepath.Glob(filepath.Join(outDir, "*.gt.txt"))
+			images, _ := filepath.Glob(filepath.Join(outDir, "*.png"))
+			if len(images) != len(tc.want) {
+				t.Errorf("exported %d images, want %d", len(images), len(tc.want))
+			}
+			got := make([]string, 0, len(transcriptions))
+			for _, path := range transcriptions {
+				raw, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, strings.TrimSuffix(string(raw), "\n"))
+			}
+			sort.Strings(got)
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("exported %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
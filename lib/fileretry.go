@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CacheRetries is the number of times a file operation on the cache that
+// failed with a transient error is retried
+var CacheRetries = 3
+
+// CacheRetryBackoff is the wait before the first retry of a cache file
+// operation, it doubles with every further retry
+var CacheRetryBackoff = 50 * time.Millisecond
+
+// CacheClock is used for waiting between retries of cache file operations
+var CacheClock = SystemClock
+
+// File operations on the cache that are retried, replaced in tests to
+// simulate the errors of network file systems
+var (
+	statFile = os.Stat
+	openFile = os.Open
+)
+
+// isPermanentFileError checks for errors that retrying will not fix
+func isPermanentFileError(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.ENOSPC, syscall.EACCES, syscall.EPERM, syscall.EROFS,
+		syscall.EDQUOT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientFileError checks for errors that network file systems report
+// temporarily. A missing file only counts if it is expected to exist, e.g.
+// right after it was created by another process.
+func isTransientFileError(err error, expectExists bool) bool {
+	if expectExists && errors.Is(err, syscall.ENOENT) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{
+		syscall.EAGAIN, syscall.EINTR, syscall.ESTALE, syscall.EBUSY} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryFileOp runs a file operation on the cache and retries it with
+// backoff if it fails with a transient error
+func retryFileOp(expectExists bool, op func() error) error {
+	backoff := CacheRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		} else if isPermanentFileError(err) {
+			return fmt.Errorf("cache directory is not usable: %w", err)
+		} else if attempt >= CacheRetries || !isTransientFileError(err, expectExists) {
+			return err
+		}
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt+1).
+			Dur("backoff", backoff).
+			Msg("Retrying cache file operation")
+		CacheClock.Sleep(backoff)
+		backoff *= 2
+	}
+}
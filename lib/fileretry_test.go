@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeClock records the waits instead of sleeping
+type fakeClock struct {
+	sync.Mutex
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+}
+
+// useFakeCacheClock makes retries of cache file operations wait on a fake
+// clock for the duration of the test
+func useFakeCacheClock(t *testing.T) *fakeClock {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(1850, 1, 1, 0, 0, 0, 0, time.UTC)}
+	previous, previousBackoff := CacheClock, CacheRetryBackoff
+	CacheClock, CacheRetryBackoff = clock, 50*time.Millisecond
+	t.Cleanup(func() { CacheClock, CacheRetryBackoff = previous, previousBackoff })
+	return clock
+}
+
+// failingStat makes the first stats of a path fail with the given errors
+func failingStat(t *testing.T, path string, errs ...error) *int {
+	t.Helper()
+	calls := 0
+	previous := statFile
+	statFile = func(name string) (os.FileInfo, error) {
+		if name == path {
+			calls++
+			if calls <= len(errs) {
+				return nil, &os.PathError{Op: "stat", Path: name, Err: errs[calls-1]}
+			}
+		}
+		return previous(name)
+	}
+	t.Cleanup(func() { statFile = previous })
+	return &calls
+}
+
+func TestRetryFileOp(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name         string
+		errs         []error
+		expectExists bool
+		wantErr      string
+		wantSleeps   []time.Duration
+	}{
+		{"success", nil, false, "", nil},
+		{"transient once", []error{syscall.EAGAIN}, false, "", []time.Duration{50 * ms}},
+		{"stale handles", []error{syscall.ESTALE, syscall.ESTALE}, false, "", []time.Duration{50 * ms, 100 * ms}},
+		{"missing after create", []error{syscall.ENOENT}, true, "", []time.Duration{50 * ms}},
+		{"missing", []error{syscall.ENOENT}, false, "no such file", nil},
+		{
+			"retries exhausted",
+			[]error{syscall.EBUSY, syscall.EBUSY, syscall.EBUSY, syscall.EBUSY},
+			false, "device or resource busy",
+			[]time.Duration{50 * ms, 100 * ms, 200 * ms},
+		},
+		{"disk full", []error{syscall.ENOSPC}, false, "cache directory is not usable", nil},
+		{"permission denied", []error{syscall.EACCES}, true, "cache directory is not usable", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := useFakeCacheClock(t)
+			calls := 0
+			err := retryFileOp(tc.expectExists, func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("expected success, got %v", err)
+			} else if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+			if len(clock.slept) != len(tc.wantSleeps) {
+				t.Fatalf("waited %v, want %v", clock.slept, tc.wantSleeps)
+			}
+			for idx, d := range tc.wantSleeps {
+				if clock.slept[idx] != d {
+					t.Errorf("wait %d was %v, want %v", idx, clock.slept[idx], d)
+				}
+			}
+		})
+	}
+}
+
+func TestGetLinePathRetriesTransientStat(t *testing.T) {
+	clock := useFakeCacheClock(t)
+	cache := NewLineImageCache(t.TempDir())
+	const id = "anzeiger_1850_0a1b2c3d"
+	imgPath := filepath.Join(cache.path, id+".png")
+	if err := ioutil.WriteFile(imgPath, testPNG(t, 80, 20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	calls := failingStat(t, imgPath, syscall.EAGAIN)
+	if path := cache.GetLinePath(id); path == "" {
+		t.Fatal("line was reported as not cached after a transient error")
+	}
+	if *calls != 2 || len(clock.slept) != 1 {
+		t.Errorf("expected a single retry, got %d stats and waits %v", *calls, clock.slept)
+	}
+}
+
+func TestGetThumbnailPathRetriesTransientRead(t *testing.T) {
+	clock := useFakeCacheClock(t)
+	previousHeight := ThumbnailHeight
+	ThumbnailHeight = 10
+	defer func() { ThumbnailHeight = previousHeight }()
+	cache := NewLineImageCache(t.TempDir())
+	const id = "anzeiger_1850_4e5f6a7b"
+	imgPath := filepath.Join(cache.path, id+".png")
+	if err := ioutil.WriteFile(imgPath, testPNG(t, 80, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opened := 0
+	previousOpen := openFile
+	openFile = func(name string) (*os.File, error) {
+		if filepath.Base(name) == id+".png" {
+			opened++
+			if opened == 1 {
+				return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ESTALE}
+			}
+		}
+		return previousOpen(name)
+	}
+	defer func() { openFile = previousOpen }()
+
+	thumbPath, err := cache.GetThumbnailPath(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := readPNG(thumbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dy() != 10 {
+		t.Errorf("expected a thumbnail of height 10, got %d", img.Bounds().Dy())
+	}
+	if opened != 2 || len(clock.slept) != 1 {
+		t.Errorf("expected a single retry, got %d opens and waits %v", opened, clock.slept)
+	}
+}
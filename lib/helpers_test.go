@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update rewrites the golden files with the current output, run with
+// go test ./lib -update
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// assertGolden compares output with the golden file at testdata/<name>, or
+// writes the file with -update
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run with -update if the change is intended\n got:\n%s\nwant:\n%s",
+			path, got, want)
+	}
+}
+
+// git runs a git command in dir and fails the test if it does not succeed
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.org",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.org")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newTestStore creates a corpus repository with a bare origin to push to
+// and a fresh line cache. Returns the store and the path of the origin.
+func newTestStore(t *testing.T) (*DocumentStore, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	git(t, t.TempDir(), "init", "--bare", "--initial-branch=master", origin)
+	repoPath := filepath.Join(t.TempDir(), "corpus")
+	git(t, filepath.Dir(repoPath), "clone", "-q", origin, repoPath)
+	git(t, repoPath, "checkout", "-q", "-B", "master")
+	git(t, repoPath, "config", "user.name", "Test")
+	git(t, repoPath, "config", "user.email", "test@example.org")
+	if err := ioutil.WriteFile(filepath.Join(repoPath, "README.md"), []byte("corpus\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repoPath, "add", "README.md")
+	git(t, repoPath, "commit", "-q", "-m", "Initial commit")
+	git(t, repoPath, "push", "-q", "origin", "master")
+	store, err := NewDocumentStore(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestLineCache(t)
+	return store, origin
+}
+
+// useTestLineCache replaces the global line cache with an empty one for
+// the duration of the test
+func useTestLineCache(t *testing.T) {
+	t.Helper()
+	previous := LineCache
+	LineCache = NewLineImageCache(t.TempDir())
+	t.Cleanup(func() { LineCache = previous })
+}
+
+// withoutRateLimit lifts the limit on requests for the duration of a test
+// against a local server
+func withoutRateLimit(t *testing.T) {
+	t.Helper()
+	previous := requestRate
+	SetMaxRequestsPerSecond(0)
+	t.Cleanup(func() { requestRate = previous })
+}
+
+// testPNG encodes a gray image with a dark stripe, so that it passes the
+// blank line check
+func testPNG(t testing.TB, width int, height int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: 230})
+			if y > height/3 && y < 2*height/3 && x%4 != 0 {
+				img.SetGray(x, y, color.Gray{Y: 20})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// cacheTestLine caches an image for a line of a volume, so that storing it
+// does not need to download anything
+func cacheTestLine(t *testing.T, volumeID string, line OCRLine) {
+	t.Helper()
+	path := filepath.Join(LineCache.path, MakeLineIdentifier(volumeID, line)+".png")
+	if err := ioutil.WriteFile(path, testPNG(t, 200, 30), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testLine returns a transcribed line of a volume whose identifier is
+// derived from its image URL like the scraper does
+func testLine(volumeID string, idx int, transcription string) OCRLine {
+	url := "https://iiif.example.org/" + volumeID + "/line" + string(rune('a'+idx)) + ".png"
+	return OCRLine{
+		Identifier:    LineDigest.Digest([]byte(url)),
+		ImageURL:      url,
+		Transcription: transcription,
+		OCRText:       transcription,
+	}
+}
+
+// withGlobals sets package configuration for the duration of a test and
+// restores it afterwards
+func withGlobals(t *testing.T, set func()) {
+	t.Helper()
+	saved := []interface{}{
+		LineDigest, Offline, CommitBatching, PullRequests, Committer,
+		Consensus, Validation, ReadmePath, ReadmeContributors, JSONIndent,
+		CommitTrailers, NormalizeWhitespace,
+	}
+	set()
+	t.Cleanup(func() {
+		LineDigest = saved[0].(DigestOptions)
+		Offline = saved[1].(bool)
+		CommitBatching = saved[2].(BatchOptions)
+		PullRequests = saved[3].(PullRequestOptions)
+		Committer = saved[4].(Identity)
+		Consensus = saved[5].(ConsensusOptions)
+		Validation = saved[6].(*Validator)
+		ReadmePath = saved[7].(string)
+		ReadmeContributors = saved[8].(string)
+		JSONIndent = saved[9].(string)
+		CommitTrailers = saved[10].(TrailerOptions)
+		NormalizeWhitespace = saved[11].(bool)
+	})
+}
+
+// useVolumeCache replaces the global volume cache with an empty one whose
+// entries expire after maxAge for the duration of the test
+func useVolumeCache(t *testing.T, maxAge time.Duration) *VolumeCache {
+	t.Helper()
+	previous := VolumeLines
+	VolumeLines = NewVolumeCache(t.TempDir(), maxAge)
+	t.Cleanup(func() { VolumeLines = previous })
+	return VolumeLines
+}
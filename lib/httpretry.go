@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// MaxFetchAttempts is the maximum number of attempts for a request to
+// Archive.org that fails with a server error or a transient network error
+var MaxFetchAttempts = 4
+
+// FetchRetryDelay is the wait before the first retry of a request to
+// Archive.org, it doubles with every further retry
+var FetchRetryDelay = time.Second
+
+// DefaultHTTPTimeout is the default maximum time for a request to
+// Archive.org, including reading the response body
+const DefaultHTTPTimeout = 30 * time.Second
+
+// UserAgent identifies archiscribe in all outbound requests, as requested by
+// Archive.org for automated clients
+var UserAgent = DefaultUserAgent("v0")
+
+// DefaultUserAgent returns the user agent for a version of archiscribe
+func DefaultUserAgent(version string) string {
+	return "archiscribe/" + version + " (+https://archiscribe.jbaiter.de)"
+}
+
+// userAgentTransport sets the user agent on requests that do not have one
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" && UserAgent != "" {
+		// A RoundTripper must not modify the request it was given
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Time after which idle connections are closed
+const idleConnTimeout = 90 * time.Second
+
+// pooledTransport keeps connections open between requests, enough for all
+// concurrent requests to the same host. The default transport only keeps two
+// per host, so most requests to Archive.org paid for a new TCP and TLS
+// handshake.
+var pooledTransport = newPooledTransport(DefaultMaxConcurrentRequests)
+
+func newPooledTransport(maxConnsPerHost int) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = maxConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	return transport
+}
+
+// httpTransport is shared by all outbound requests
+var httpTransport = &userAgentTransport{base: pooledTransport}
+
+// httpClient is used for all requests to Archive.org
+var httpClient = &http.Client{Timeout: DefaultHTTPTimeout, Transport: httpTransport}
+
+// SetHTTPTimeout sets the maximum time for a request to Archive.org,
+// including reading the response body. Zero disables the timeout.
+func SetHTTPTimeout(d time.Duration) {
+	httpClient.Timeout = d
+}
+
+// DefaultMaxConcurrentRequests is the default maximum number of requests to
+// Archive.org that are in flight at the same time
+const DefaultMaxConcurrentRequests = 8
+
+// requestSlots limits the requests to Archive.org in flight, a slot is held
+// until the response body is closed
+var requestSlots = make(chan struct{}, DefaultMaxConcurrentRequests)
+
+// SetMaxConcurrentRequests sets the maximum number of requests to
+// Archive.org in flight at the same time, shared by all fetches. Zero
+// disables the limit.
+func SetMaxConcurrentRequests(n int) {
+	if n > 0 {
+		requestSlots = make(chan struct{}, n)
+		pooledTransport.MaxIdleConnsPerHost = n
+	} else {
+		requestSlots = nil
+	}
+}
+
+// Maximum number of bytes read from a discarded response body so that its
+// connection can be reused
+const maxDrainBytes = 64 << 10
+
+// drainAndClose reads the rest of a response body that is not needed, up to
+// a limit, and closes it. Connections are only reused once their response
+// body was read to the end.
+func drainAndClose(body io.ReadCloser) error {
+	io.CopyN(ioutil.Discard, body, maxDrainBytes)
+	return body.Close()
+}
+
+// DefaultMaxRequestsPerSecond is the default rate of requests to Archive.org,
+// including retries
+const DefaultMaxRequestsPerSecond = 5
+
+// requestRate limits the rate of requests to Archive.org across all fetches
+var requestRate = rate.NewLimiter(rate.Limit(DefaultMaxRequestsPerSecond), DefaultMaxRequestsPerSecond)
+
+// SetMaxRequestsPerSecond sets the rate of requests to Archive.org, shared
+// by all fetches. Short bursts of up to one second's worth of requests are
+// allowed. Zero disables the limit.
+func SetMaxRequestsPerSecond(perSecond float64) {
+	if perSecond <= 0 {
+		requestRate = nil
+		return
+	}
+	burst := int(perSecond)
+	if burst < 1 {
+		burst = 1
+	}
+	requestRate = rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// Minimum time between the log messages about delayed requests
+const rateLogInterval = time.Minute
+
+var lastRateLog struct {
+	sync.Mutex
+	at time.Time
+	// Requests delayed since the last message
+	numDelayed int
+}
+
+// awaitRate blocks until the rate limit allows another request. Delays are
+// logged at most once per rateLogInterval.
+func awaitRate(ctx context.Context) error {
+	limiter := requestRate
+	if limiter == nil {
+		return nil
+	}
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+	lastRateLog.Lock()
+	lastRateLog.numDelayed++
+	if time.Since(lastRateLog.at) >= rateLogInterval {
+		log.Warn().
+			Dur("delay", delay).
+			Int("numDelayed", lastRateLog.numDelayed).
+			Float64("maxRequestsPerSecond", float64(limiter.Limit())).
+			Msg("Requests to Archive.org are delayed by the rate limit")
+		lastRateLog.at = time.Now()
+		lastRateLog.numDelayed = 0
+	}
+	lastRateLog.Unlock()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
+
+// slotBody releases its request slot when the response body is closed
+type slotBody struct {
+	io.ReadCloser
+	once  sync.Once
+	slots chan struct{}
+}
+
+func (b *slotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { <-b.slots })
+	return err
+}
+
+// doLimited sends a request once the rate limit allows it and a slot is free
+func doLimited(req *http.Request) (*http.Response, error) {
+	if err := awaitRate(req.Context()); err != nil {
+		return nil, err
+	}
+	slots := requestSlots
+	if slots == nil {
+		return httpClient.Do(req)
+	}
+	select {
+	case slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		<-slots
+		return nil, err
+	}
+	resp.Body = &slotBody{ReadCloser: resp.Body, slots: slots}
+	return resp, nil
+}
+
+// isTransientNetError checks for network errors that are worth retrying
+func isTransientNetError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// httpGet issues a GET request that is aborted when the context is
+// cancelled and retried with backoff on server errors and transient network
+// errors. Other responses, including 404, are returned as they are. The
+// response body must always be closed to give up the request slot.
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	return httpGetRetry(ctx, url, nil)
+}
+
+// httpGetRetry is like httpGet, but calls onRetry before every retry
+func httpGetRetry(ctx context.Context, url string, onRetry func(retry int, err error)) (*http.Response, error) {
+	return httpDoRetry(ctx, "GET", url, onRetry)
+}
+
+// httpHead is like httpGet, but issues a HEAD request
+func httpHead(ctx context.Context, url string) (*http.Response, error) {
+	return httpDoRetry(ctx, "HEAD", url, nil)
+}
+
+// httpDoRetry issues a request with the given method, see httpGet
+func httpDoRetry(ctx context.Context, method string, url string, onRetry func(retry int, err error)) (*http.Response, error) {
+	if Offline {
+		return nil, ErrOffline
+	}
+	delay := FetchRetryDelay
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		resp, err := doLimited(req.WithContext(ctx))
+		if err != nil {
+			observeArchiveRequest(started, 0)
+		} else {
+			observeArchiveRequest(started, resp.StatusCode)
+		}
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		} else if err == nil {
+			err = &StatusError{URL: url, StatusCode: resp.StatusCode}
+			if attempt >= MaxFetchAttempts {
+				return resp, nil
+			}
+			drainAndClose(resp.Body)
+		} else if attempt >= MaxFetchAttempts || !isTransientNetError(err) {
+			return nil, err
+		}
+		log.Warn().
+			Err(err).
+			Str("url", url).
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Msg("Request to Archive.org failed, retrying")
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers requests with the given statuses in turn and the last
+// one for all further requests. A status of zero drops the connection.
+type flakyServer struct {
+	sync.Mutex
+	statuses []int
+	arrivals []time.Time
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	status := s.statuses[len(s.statuses)-1]
+	if len(s.arrivals) < len(s.statuses) {
+		status = s.statuses[len(s.arrivals)]
+	}
+	s.arrivals = append(s.arrivals, time.Now())
+	s.Unlock()
+	if status == 0 {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// requests returns the arrival times of all requests so far
+func (s *flakyServer) requests() []time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return append([]time.Time(nil), s.arrivals...)
+}
+
+func TestHTTPGetRetriesWithBackoff(t *testing.T) {
+	const baseDelay = 20 * time.Millisecond
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int
+		// Expected number of requests, the retries wait twice as long as
+		// the previous one
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"fails twice then succeeds", []int{503, 503, 200}, 200, 3, false},
+		{"connection reset", []int{0, 200}, 200, 2, false},
+		{"not found is not retried", []int{404}, 404, 1, false},
+		{"client errors are not retried", []int{403, 200}, 403, 1, false},
+		{"gives up after the maximum attempts", []int{500}, 500, 4, false},
+		{"connection resets until giving up", []int{0}, 0, 4, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withoutRateLimit(t)
+			previousAttempts, previousDelay := MaxFetchAttempts, FetchRetryDelay
+			MaxFetchAttempts, FetchRetryDelay = 4, baseDelay
+			defer func() { MaxFetchAttempts, FetchRetryDelay = previousAttempts, previousDelay }()
+			flaky := &flakyServer{statuses: tc.statuses}
+			server := httptest.NewServer(flaky)
+			defer server.Close()
+
+			var retries []int
+			resp, err := httpGetRetry(context.Background(), server.URL+"/download/item", func(retry int, err error) {
+				retries = append(retries, retry)
+			})
+			if tc.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("expected an error, got status %d", resp.StatusCode)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				resp.Body.Close()
+				if resp.StatusCode != tc.want {
+					t.Errorf("got status %d, want %d", resp.StatusCode, tc.want)
+				}
+			}
+			arrivals := flaky.requests()
+			if len(arrivals) != tc.wantAttempts {
+				t.Fatalf("sent %d requests, want %d", len(arrivals), tc.wantAttempts)
+			}
+			if len(retries) != tc.wantAttempts-1 {
+				t.Errorf("reported retries %v, want %d", retries, tc.wantAttempts-1)
+			}
+			delay := baseDelay
+			for idx := 1; idx < len(arrivals); idx++ {
+				if waited := arrivals[idx].Sub(arrivals[idx-1]); waited < delay {
+					t.Errorf("retry %d was sent after %v, want at least %v", idx, waited, delay)
+				}
+				delay *= 2
+			}
+		})
+	}
+}
+
+func TestHTTPGetStopsRetryingWhenCancelled(t *testing.T) {
+	withoutRateLimit(t)
+	previousDelay := FetchRetryDelay
+	FetchRetryDelay = time.Hour
+	defer func() { FetchRetryDelay = previousDelay }()
+	flaky := &flakyServer{statuses: []int{503}}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := httpGetRetry(ctx, server.URL+"/download/item", func(retry int, err error) { cancel() })
+	if err != context.Canceled {
+		t.Errorf("expected the cancellation, got %v", err)
+	}
+	if n := len(flaky.requests()); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Glyphs used for hyphenating words at line ends in 19th century prints
+const hyphenGlyphs = "-=⸗¬‐"
+
+// AnnotateHyphenation enables recording the joined form of words that are
+// hyphenated across line ends
+var AnnotateHyphenation = false
+
+// hyphenatedPrefix returns the word fragment before a trailing hyphen, if
+// the text ends with a hyphenated word
+func hyphenatedPrefix(text string) (string, bool) {
+	text = strings.TrimRightFunc(text, unicode.IsSpace)
+	hyphen, size := utf8.DecodeLastRuneInString(text)
+	if size == 0 || !strings.ContainsRune(hyphenGlyphs, hyphen) {
+		return "", false
+	}
+	text = text[:len(text)-size]
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasSuffix(text, fields[len(fields)-1]) {
+		return "", false
+	}
+	prefix := fields[len(fields)-1]
+	// A dash after a number or punctuation is not a hyphenation
+	last, _ := utf8.DecodeLastRuneInString(prefix)
+	if !unicode.IsLetter(last) {
+		return "", false
+	}
+	return prefix, true
+}
+
+// annotateHyphenation records the joined form of words hyphenated across
+// line ends on the line with the first part of the word. Only lines that are
+// directly followed by a transcribed line can be joined. The transcriptions
+// themselves are left as they are.
+func annotateHyphenation(lines []OCRLine) {
+	byImage := make(map[string]int, len(lines))
+	for idx, line := range lines {
+		byImage[line.ImageURL] = idx
+	}
+	for idx, line := range lines {
+		lines[idx].HyphenatedWord = ""
+		prefix, ok := hyphenatedPrefix(line.Transcription)
+		if !ok || line.NextImageURL == "" {
+			continue
+		}
+		nextIdx, ok := byImage[line.NextImageURL]
+		if !ok {
+			continue
+		}
+		nextWords := strings.Fields(lines[nextIdx].Transcription)
+		if len(nextWords) == 0 {
+			continue
+		}
+		suffix := strings.TrimRightFunc(nextWords[0], func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if suffix == "" {
+			continue
+		}
+		lines[idx].HyphenatedWord = prefix + suffix
+	}
+}
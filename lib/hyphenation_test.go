@@ -0,0 +1,67 @@
+package lib
+
+import "testing"
+
+// hyphenatedLines returns two consecutive lines of a volume
+func hyphenatedLines(volumeID string, first string, second string) []OCRLine {
+	lines := []OCRLine{
+		regionLine(volumeID, 11, 150, 200, first),
+		regionLine(volumeID, 11, 150, 260, second),
+	}
+	lines[0].NextImageURL = lines[1].ImageURL
+	lines[1].PreviousImageURL = lines[0].ImageURL
+	return lines
+}
+
+func TestAnnotateHyphenation(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  string
+		second string
+		want   string
+	}{
+		{"hyphen", "Die alten Wör-", "ter der Sprache", "Wörter"},
+		{"double hyphen", "Die alten Wör=", "ter der Sprache", "Wörter"},
+		{"double oblique hyphen", "Die alten Wör⸗", "ter der Sprache", "Wörter"},
+		{"not negated", "Die alten Wör¬", "ter der Sprache", "Wörter"},
+		{"trailing space", "Die alten Wör- ", "ter der Sprache", "Wörter"},
+		{"punctuation after the suffix", "in der Stadt Ber-", "lin, im Jahre", "Berlin"},
+		{"no hyphen", "Die alten Wörter", "der Sprache", ""},
+		{"dash after a number", "Seite 12 -", "15 des Buches", ""},
+		{"empty next line", "Die alten Wör-", "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lines := hyphenatedLines("chronik_1872", tc.first, tc.second)
+			annotateHyphenation(lines)
+			if lines[0].HyphenatedWord != tc.want {
+				t.Errorf("joined word is %q, want %q", lines[0].HyphenatedWord, tc.want)
+			}
+			if lines[1].HyphenatedWord != "" {
+				t.Errorf("second line was annotated with %q", lines[1].HyphenatedWord)
+			}
+			if lines[0].Transcription != tc.first || lines[1].Transcription != tc.second {
+				t.Errorf("transcriptions were changed to %q and %q", lines[0].Transcription, lines[1].Transcription)
+			}
+		})
+	}
+}
+
+func TestHyphenationIsRecordedOnSubmit(t *testing.T) {
+	previous := AnnotateHyphenation
+	AnnotateHyphenation = true
+	defer func() { AnnotateHyphenation = previous }()
+	const ident = "chronik_1873"
+	store := NewMemoryStore()
+	doc := Document{Identifier: ident, Year: 1873, Lines: hyphenatedLines(ident, "Die alten Wör-", "ter der Sprache")}
+	if _, err := Submit(store, doc, "Test", "test@example.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.LoadVolume(ident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Lines[0].HyphenatedWord != "Wörter" || stored.Lines[0].Transcription != "Die alten Wör-" {
+		t.Errorf("expected the joined word next to the line-faithful text, got %+v", stored.Lines[0])
+	}
+}
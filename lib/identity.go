@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Identity is the name and email git records for an author or committer
+type Identity struct {
+	Name  string
+	Email string
+}
+
+// IsZero checks if no identity is configured
+func (i Identity) IsZero() bool {
+	return i.Name == "" && i.Email == ""
+}
+
+// Validate checks that the name is set and the email is well-formed
+func (i Identity) Validate() error {
+	if i.IsZero() {
+		return nil
+	}
+	if sanitizeIdentityName(i.Name) == "" {
+		return fmt.Errorf("identity needs a name")
+	}
+	if !ValidEmail(i.Email) {
+		return fmt.Errorf("invalid email '%s'", i.Email)
+	}
+	return nil
+}
+
+// Committer is the identity that commits to the corpus repository, the git
+// configuration of the repository is used if it is zero. It is also the
+// author of commits without a submitting author, like reviews and backfills.
+var Committer Identity
+
+// ValidEmail checks if an email is a plain address, without a display name
+func ValidEmail(email string) bool {
+	if email == "" || strings.ContainsAny(email, "<> \t\r\n") {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// sanitizeIdentityName removes the characters git does not accept in names
+func sanitizeIdentityName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '<', '>', '\n', '\r':
+			return -1
+		}
+		return r
+	}, name)
+	return strings.TrimSpace(name)
+}
+
+// commitAuthor determines the author of a commit. Submissions are
+// attributed to the transcribing author, an invalid email is dropped
+// instead of failing the commit. Without an author the commit is attributed
+// to the committer.
+func commitAuthor(author string, email string) Identity {
+	name := sanitizeIdentityName(author)
+	if name == "" {
+		return Committer
+	}
+	if email != "" && !ValidEmail(email) {
+		log.Warn().Str("author", name).Str("email", email).
+			Msg("Invalid author email, committing without it")
+		email = ""
+	}
+	return Identity{Name: name, Email: email}
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// IdentifierExportVersion is the schema version of exported identifier
+// caches, it has to be increased on incompatible changes
+const IdentifierExportVersion = 1
+
+// IdentifierExport is the portable form of an identifier cache, shared
+// between deployments to avoid scraping Archive.org again
+type IdentifierExport struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Mediatypes that were queried when the identifiers were scraped
+	MediaTypes []string `json:"mediaTypes"`
+	// Full search query the identifiers were scraped with, empty if unknown
+	Query string `json:"query,omitempty"`
+	// Version of the script heuristic the entries were classified with
+	ScriptHeuristicVersion int                            `json:"scriptHeuristicVersion"`
+	Entries                map[int][]IdentifierCacheEntry `json:"entries"`
+}
+
+// OpenIdentifierCache loads the identifier cache from the cache directory
+// without scraping Archive.org if it does not exist yet
+func OpenIdentifierCache(cacheDir string) (*IdentifierCache, error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, err
+	}
+	idCacheFile := filepath.Join(cacheDir, "identifiers.json")
+	if _, err := os.Stat(idCacheFile); os.IsNotExist(err) {
+		return NewIdentifierCache(idCacheFile), nil
+	}
+	return LoadIdentifierCache(idCacheFile), nil
+}
+
+// Export writes the cache with its query metadata to a file
+func (c *IdentifierCache) Export(path string) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	export := IdentifierExport{
+		Version:                IdentifierExportVersion,
+		Created:                time.Now().UTC(),
+		MediaTypes:             MediaTypes,
+		Query:                  c.query,
+		ScriptHeuristicVersion: ScriptHeuristicVersion,
+		Entries:                c.entries,
+	}
+	exportJSON, err := marshalJSON(export)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, exportJSON)
+}
+
+// Import reads an exported cache and either replaces the entries of this
+// cache with it or merges it, keeping existing entries for identifiers in
+// both. Exports scraped with a different query than the cache cannot be
+// merged. Returns the number of added entries.
+func (c *IdentifierCache) Import(path string, replace bool) (int, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var export IdentifierExport
+	if err := json.Unmarshal(raw, &export); err != nil {
+		return 0, fmt.Errorf("invalid identifier export: %v", err)
+	}
+	if export.Version != IdentifierExportVersion {
+		return 0, fmt.Errorf(
+			"unsupported identifier export version %d, expected %d",
+			export.Version, IdentifierExportVersion)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !replace && export.Query != "" && c.query != "" && export.Query != c.query {
+		return 0, fmt.Errorf(
+			"identifier export was scraped with the query %q, the cache with %q",
+			export.Query, c.query)
+	}
+	if replace || c.entries == nil {
+		c.entries = make(map[int][]IdentifierCacheEntry)
+		c.query = export.Query
+		c.lastRefreshed = time.Time{}
+	} else if c.query == "" {
+		c.query = export.Query
+	}
+	known := make(map[string]bool)
+	for _, entries := range c.entries {
+		for _, entry := range entries {
+			known[entry.Identifier] = true
+		}
+	}
+	numAdded := 0
+	for year, entries := range export.Entries {
+		for _, entry := range entries {
+			if known[entry.Identifier] {
+				continue
+			}
+			known[entry.Identifier] = true
+			c.entries[year] = append(c.entries[year], entry)
+			numAdded++
+		}
+	}
+	c.write()
+	return numAdded, nil
+}
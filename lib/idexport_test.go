@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exportedCache returns a cache as scraped by another deployment
+func exportedCache(t *testing.T) *IdentifierCache {
+	t.Helper()
+	cache := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+	cache.query = DefaultIdentifierQuery.String()
+	cache.Add("chronik_1860", 120, 1860, "texts")
+	cache.Add("predigten_1860", 300, 1860, "texts")
+	cache.Add("kalender_1871", 90, 1871, "texts")
+	cache.SetScript(1860, "chronik_1860", ScriptFraktur)
+	return cache
+}
+
+func TestIdentifierCacheExportRoundTrip(t *testing.T) {
+	source := exportedCache(t)
+	exportPath := filepath.Join(t.TempDir(), "export.json")
+	if err := source.Export(exportPath); err != nil {
+		t.Fatal(err)
+	}
+	target := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+	numAdded, err := target.Import(exportPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numAdded != 3 {
+		t.Errorf("imported %d entries, want 3", numAdded)
+	}
+	// The imported cache is written to disk like a scraped one
+	for _, cache := range []*IdentifierCache{target, LoadIdentifierCache(target.path)} {
+		if !reflect.DeepEqual(cache.entries, source.entries) {
+			t.Errorf("imported entries %+v, want %+v", cache.entries, source.entries)
+		}
+		if cache.query != source.query {
+			t.Errorf("imported query %q, want %q", cache.query, source.query)
+		}
+	}
+}
+
+func TestIdentifierCacheImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace bool
+		// Query of the local cache
+		query     string
+		want      []string
+		wantAdded int
+		wantErr   string
+	}{
+		{"merge", false, DefaultIdentifierQuery.String(),
+			[]string{"chronik_1860", "kalender_1871", "lokal_1860", "predigten_1860"}, 2, ""},
+		{"replace", true, DefaultIdentifierQuery.String(),
+			[]string{"chronik_1860", "kalender_1871", "predigten_1860"}, 3, ""},
+		{"replace cache of another query", true, "language:(French)",
+			[]string{"chronik_1860", "kalender_1871", "predigten_1860"}, 3, ""},
+		{"merge with another query", false, "language:(French)", nil, 0, "scraped with the query"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			exportPath := filepath.Join(t.TempDir(), "export.json")
+			if err := exportedCache(t).Export(exportPath); err != nil {
+				t.Fatal(err)
+			}
+			local := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+			local.query = tc.query
+			local.Add("lokal_1860", 64, 1860, "texts")
+			// Entries of identifiers in both are kept as they are locally
+			local.Add("chronik_1860", 118, 1860, "texts")
+
+			numAdded, err := local.Import(exportPath, tc.replace)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error about %q, got %v", tc.wantErr, err)
+				}
+				if got := cachedIdentifiers(local); strings.Join(got, ",") != "chronik_1860,lokal_1860" {
+					t.Errorf("failed import changed the cache to %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numAdded != tc.wantAdded {
+				t.Errorf("imported %d entries, want %d", numAdded, tc.wantAdded)
+			}
+			if got := cachedIdentifiers(local); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("cache has %v, want %v", got, tc.want)
+			}
+			wantPages := 120
+			if !tc.replace {
+				wantPages = 118
+			}
+			for _, entry := range local.entries[1860] {
+				if entry.Identifier == "chronik_1860" && entry.NumPages != wantPages {
+					t.Errorf("chronik_1860 has %d pages, want %d", entry.NumPages, wantPages)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentifierCacheImportChecksVersion(t *testing.T) {
+	exportPath := filepath.Join(t.TempDir(), "export.json")
+	raw := `{"version": 2, "entries": {"1860": [{"id": "chronik_1860", "numPages": 120}]}}`
+	if err := ioutil.WriteFile(exportPath, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+	if _, err := cache.Import(exportPath, true); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("expected an error about the version, got %v", err)
+	}
+	if got := cachedIdentifiers(cache); len(got) != 0 {
+		t.Errorf("import of an unsupported version added %v", got)
+	}
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Versions of the IIIF Image API
+const (
+	IIIFImageV2 = 2
+	IIIFImageV3 = 3
+)
+
+// IIIFImageOptions configures the image requests for line crops and page
+// images
+type IIIFImageOptions struct {
+	// Base URL of the image service, the image identifier is appended
+	BaseURL string
+	// Version of the Image API that the service speaks
+	Version int
+}
+
+// IIIFImage is the image service used for all line and page images
+var IIIFImage = IIIFImageOptions{
+	BaseURL: "https://iiif.archivelab.org/iiif/",
+	Version: IIIFImageV2,
+}
+
+// Validate checks that the Image API version is supported
+func (o IIIFImageOptions) Validate() error {
+	if o.Version != IIIFImageV2 && o.Version != IIIFImageV3 {
+		return fmt.Errorf("unsupported IIIF Image API version %d, must be 2 or 3", o.Version)
+	}
+	return nil
+}
+
+// imageURL returns the base URL of the image of a page, without a request
+func (o IIIFImageOptions) imageURL(ident string, pageNo int) string {
+	return joinURL(o.BaseURL, ident+"$"+strconv.Itoa(pageNo))
+}
+
+// request builds an image request from its region, size, rotation, quality
+// and format segments
+func (o IIIFImageOptions) request(ident string, pageNo int, region string, format string) string {
+	// Version 3 dropped "full" as the size for the unscaled image
+	size := "full"
+	if o.Version >= IIIFImageV3 {
+		size = "max"
+	}
+	return fmt.Sprintf("%s/%s/%s/0/default.%s", o.imageURL(ident, pageNo), region, size, format)
+}
+
+// RegionURL returns the URL of a region of a page, at its full size
+func (o IIIFImageOptions) RegionURL(ident string, pageNo int, x, y, width, height int, format string) string {
+	region := fmt.Sprintf("%d,%d,%d,%d", x, y, width, height)
+	return o.request(ident, pageNo, region, format)
+}
+
+// PageURL returns the URL of a full page image
+func (o IIIFImageOptions) PageURL(ident string, pageNo int, format string) string {
+	return o.request(ident, pageNo, "full", format)
+}
+
+// InfoURL returns the URL of the image information of a page
+func (o IIIFImageOptions) InfoURL(ident string, pageNo int) string {
+	return o.imageURL(ident, pageNo) + "/info.json"
+}
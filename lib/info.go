@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrVolumeNotFound is returned when Archive.org has no item for an identifier
+var ErrVolumeNotFound = errors.New("volume not found")
+
+// VolumeInfo contains the metadata of a volume shown before transcribing it
+type VolumeInfo struct {
+	Identifier   string `json:"id"`
+	Title        string `json:"title"`
+	Date         string `json:"date,omitempty"`
+	Year         int    `json:"year,omitempty"`
+	Publisher    string `json:"publisher,omitempty"`
+	NumPages     int    `json:"numPages,omitempty"`
+	ThumbnailURL string `json:"thumbnail"`
+	Manifest     string `json:"manifest"`
+}
+
+// Volume infos that were already fetched, metadata of published items
+// rarely changes
+var volumeInfos = struct {
+	sync.Mutex
+	infos map[string]*VolumeInfo
+}{infos: make(map[string]*VolumeInfo)}
+
+// GetVolumeInfo fetches the metadata of a volume from Archive.org, or
+// returns it from the cache if it was fetched before
+func GetVolumeInfo(ident string) (*VolumeInfo, error) {
+	volumeInfos.Lock()
+	info, ok := volumeInfos.infos[ident]
+	volumeInfos.Unlock()
+	if ok {
+		return info, nil
+	}
+	meta, err := GetMetadata(ident)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := meta.Map(); err != nil {
+		// The metadata API responds with an empty object for unknown items
+		return nil, ErrVolumeNotFound
+	}
+	info = &VolumeInfo{
+		Identifier:   ident,
+		Title:        meta.Get("title").MustString(),
+		Date:         meta.Get("date").MustString(),
+		Publisher:    meta.Get("publisher").MustString(),
+		NumPages:     parseImageCount(meta),
+		ThumbnailURL: fmt.Sprintf("https://archive.org/services/img/%s", ident),
+		Manifest:     ManifestURL(ident),
+	}
+	if year := getYear(meta); year > 0 {
+		info.Year = year
+	}
+	volumeInfos.Lock()
+	volumeInfos.infos[ident] = info
+	volumeInfos.Unlock()
+	return info, nil
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetVolumeInfo(t *testing.T) {
+	manifests := map[string]string{
+		"gartenlaube_1858": `{"metadata": {"title": "Die Gartenlaube", "date": "1858",
+			"year": "1858", "publisher": "Ernst Keil", "imagecount": "842"}}`,
+		"hausbuch_1870": `{"metadata": {"title": "Hausbuch", "year": ["unbekannt", "1870"],
+			"imagecount": 120}}`,
+		"fragment_undated": `{"metadata": {"title": "Fragment"}}`,
+	}
+	var requests int64
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		manifest, ok := manifests[r.URL.Path[len("/metadata/"):]]
+		if !ok {
+			// Archive.org responds to unknown items with an empty object
+			manifest = "{}"
+		}
+		fmt.Fprint(w, manifest)
+	}))
+	defer server.Close()
+	routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+	previous := volumeInfos.infos
+	volumeInfos.infos = make(map[string]*VolumeInfo)
+	defer func() { volumeInfos.infos = previous }()
+
+	tests := []struct {
+		ident string
+		want  *VolumeInfo
+	}{
+		{"gartenlaube_1858", &VolumeInfo{
+			Title: "Die Gartenlaube", Date: "1858", Year: 1858, Publisher: "Ernst Keil", NumPages: 842,
+		}},
+		{"hausbuch_1870", &VolumeInfo{Title: "Hausbuch", Year: 1870, NumPages: 120}},
+		{"fragment_undated", &VolumeInfo{Title: "Fragment"}},
+		{"unknown_1850", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.ident, func(t *testing.T) {
+			atomic.StoreInt64(&requests, 0)
+			info, err := GetVolumeInfo(tc.ident)
+			if tc.want == nil {
+				if err != ErrVolumeNotFound {
+					t.Errorf("expected ErrVolumeNotFound, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := *tc.want
+			want.Identifier = tc.ident
+			want.ThumbnailURL = "https://archive.org/services/img/" + tc.ident
+			want.Manifest = ManifestURL(tc.ident)
+			if *info != want {
+				t.Errorf("got %+v, want %+v", *info, want)
+			}
+			if _, err := GetVolumeInfo(tc.ident); err != nil {
+				t.Fatal(err)
+			}
+			if n := atomic.LoadInt64(&requests); n != 1 {
+				t.Errorf("fetched the metadata %d times, want once", n)
+			}
+		})
+	}
+}
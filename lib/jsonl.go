@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+)
+
+// LineRecord is a transcribed line in the JSON Lines export
+type LineRecord struct {
+	// Identifier of the volume
+	Identifier string `json:"identifier"`
+	Year       int    `json:"year"`
+	LineID     string `json:"lineId"`
+	// Identifier of the line across volumes, as used by the line cache
+	LineIdentifier string `json:"lineIdentifier"`
+	ImageURL       string `json:"imageUrl"`
+	// Path of the line image in the repository
+	ImagePath     string `json:"imagePath"`
+	Transcription string `json:"transcription"`
+	Provenance    string `json:"provenance,omitempty"`
+}
+
+// ExportJSONL writes every transcribed line of the corpus to w as JSON
+// Lines, one LineRecord per row. Documents are read one at a time, so the
+// corpus never has to fit into memory. Returns the number of written lines.
+func (s *DocumentStore) ExportJSONL(w io.Writer) (int, error) {
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	numLines := 0
+	err := s.forEachDocument(func(doc *Document) error {
+		for _, line := range doc.Lines {
+			if line.Transcription == "" {
+				continue
+			}
+			name := fmt.Sprintf("%s_%s", doc.Identifier, line.Identifier)
+			record := LineRecord{
+				Identifier:     doc.Identifier,
+				Year:           doc.Year,
+				LineID:         line.Identifier,
+				LineIdentifier: MakeLineIdentifier(doc.Identifier, line),
+				ImageURL:       line.ImageURL,
+				ImagePath: filepath.Join(
+					s.basePath, "transcriptions", strconv.Itoa(doc.Year), name+".png"),
+				Transcription: line.Transcription,
+				Provenance:    line.Provenance,
+			}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+			numLines++
+		}
+		return nil
+	})
+	if err != nil {
+		return numLines, err
+	}
+	return numLines, buf.Flush()
+}
+
+// ExportJSONL writes every transcribed line of the repository at repoPath
+// to w, see DocumentStore.ExportJSONL
+func ExportJSONL(repoPath string, w io.Writer) (int, error) {
+	store, err := NewDocumentStore(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	return store.ExportJSONL(w)
+}
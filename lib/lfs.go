@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LFSLineImages stores the line images committed with transcriptions in Git
+// LFS instead of the repository itself, which keeps clones small
+var LFSLineImages = false
+
+// lfsAttributes routes the line images through the Git LFS filter
+const lfsAttributes = "transcriptions/**/*.png filter=lfs diff=lfs merge=lfs -text"
+
+// SetupLFS installs the Git LFS filters in the repository and makes sure
+// line images are tracked by it
+func (s *DocumentStore) SetupLFS() error {
+	if err := s.repo.LFSInstall(); err != nil {
+		return err
+	}
+	return s.trackLineImagesInLFS()
+}
+
+// trackLineImagesInLFS adds the line images to the .gitattributes of the
+// repository and stages it, unless they are tracked already. Images that
+// were committed before are not migrated.
+func (s *DocumentStore) trackLineImagesInLFS() error {
+	attrPath := filepath.Join(s.basePath, ".gitattributes")
+	raw, err := ioutil.ReadFile(attrPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if strings.TrimSpace(line) == lfsAttributes {
+			return nil
+		}
+	}
+	attrs := string(raw)
+	if attrs != "" && !strings.HasSuffix(attrs, "\n") {
+		attrs += "\n"
+	}
+	attrs += lfsAttributes + "\n"
+	if err := WriteFileAtomic(attrPath, []byte(attrs)); err != nil {
+		return err
+	}
+	return s.repo.Add(attrPath)
+}
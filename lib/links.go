@@ -0,0 +1,29 @@
+package lib
+
+import "strings"
+
+// IIIFBaseURL is the base of the IIIF manifest and viewer links to a volume
+var IIIFBaseURL = "https://iiif.archivelab.org/iiif/"
+
+// ArchiveDetailsBaseURL is the base of the links to the details page of a
+// volume
+var ArchiveDetailsBaseURL = "https://archive.org/details/"
+
+func joinURL(base string, ident string) string {
+	return strings.TrimSuffix(base, "/") + "/" + ident
+}
+
+// ManifestURL returns the link to the IIIF manifest of a volume
+func ManifestURL(ident string) string {
+	return joinURL(IIIFBaseURL, ident) + "/manifest.json"
+}
+
+// MiradorURL returns the link to a volume in the IIIF viewer
+func MiradorURL(ident string) string {
+	return joinURL(IIIFBaseURL, ident)
+}
+
+// DetailsURL returns the link to the details page of a volume
+func DetailsURL(ident string) string {
+	return joinURL(ArchiveDetailsBaseURL, ident)
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrLockTimeout is returned when a volume or repository lock could not be
+// acquired in time
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// VolumeLockTimeout is the maximum time to wait for a volume or repository
+// lock
+var VolumeLockTimeout = 30 * time.Second
+
+// Name of the lock file for the whole repository in the lock directory.
+// Archive.org identifiers never start with a dot, so it cannot clash with a
+// volume lock.
+const repoLockName = ".repository.lock"
+
+// Interval for polling a held lock
+const lockPollInterval = 50 * time.Millisecond
+
+// FileLock is an advisory lock on a file that is held across processes.
+// Locks are tied to the open file, so they also exclude goroutines of the
+// same process that acquired the lock separately.
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile acquires an exclusive lock on the file at path, creating it if
+// needed. Gives up with ErrLockTimeout once the timeout has passed.
+func LockFile(path string, timeout time.Duration) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return &FileLock{file: file}, nil
+		} else if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			file.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
+
+// lockVolume acquires the lock for a volume in the given lock directory
+func lockVolume(lockDir string, ident string) (*FileLock, error) {
+	return LockFile(filepath.Join(lockDir, ident+".lock"), VolumeLockTimeout)
+}
+
+// lockRepoFile acquires the lock for the whole repository in the given lock
+// directory
+func lockRepoFile(lockDir string) (*FileLock, error) {
+	return LockFile(filepath.Join(lockDir, repoLockName), VolumeLockTimeout)
+}
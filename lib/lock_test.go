@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVolumeLockExcludesOtherHolders(t *testing.T) {
+	lockDir := t.TempDir()
+	const numHolders = 2
+	const rounds = 10
+	var holding, overlaps int32
+	var wg sync.WaitGroup
+	for h := 0; h < numHolders; h++ {
+		wg.Add(1)
+		// Every goroutine opens the lock file itself, like a separate
+		// process would
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				lock, err := lockVolume(lockDir, "tageblatt_1870")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if atomic.AddInt32(&holding, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&holding, -1)
+				if err := lock.Unlock(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if overlaps > 0 {
+		t.Errorf("the lock was held by both goroutines %d times", overlaps)
+	}
+}
+
+func TestVolumeLockTimeout(t *testing.T) {
+	lockDir := t.TempDir()
+	held, err := lockVolume(lockDir, "tageblatt_1871")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Unlock()
+	tests := []struct {
+		name    string
+		ident   string
+		wantErr error
+	}{
+		{"same volume", "tageblatt_1871", ErrLockTimeout},
+		{"other volume", "tageblatt_1872", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			started := time.Now()
+			lock, err := LockFile(lockDir+"/"+tc.ident+".lock", 100*time.Millisecond)
+			if err != tc.wantErr {
+				t.Fatalf("got %v, want %v", err, tc.wantErr)
+			}
+			if err == nil {
+				lock.Unlock()
+			} else if waited := time.Since(started); waited < 100*time.Millisecond {
+				t.Errorf("gave up after %v, before the timeout", waited)
+			}
+		})
+	}
+}
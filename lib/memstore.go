@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps volumes in memory, for deployments without a repository
+// and for exercising the submit pipeline. Every saved volume gets a history
+// entry like a commit would create.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	volumes map[string]Document
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{volumes: make(map[string]Document)}
+}
+
+// SaveVolume stores the transcribed lines of a document with their review
+// flags, lines without a transcription are dropped
+func (s *MemoryStore) SaveVolume(doc Document, reviewFlags map[string][]string, author string, email string, comment string) (*Document, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var previous *Document
+	if prev, ok := s.volumes[doc.Identifier]; ok {
+		previous = &prev
+		if doc.Script == "" {
+			doc.Script = prev.Script
+		}
+	}
+	lines := make([]OCRLine, 0, len(doc.Lines))
+	for _, line := range doc.Lines {
+		if line.Transcription == "" {
+			continue
+		}
+		line.Flags = keepReviewFlags(line, previous, reviewFlags[line.Identifier])
+		line.Session = keepSession(line, previous)
+		lines = append(lines, line)
+	}
+	sortLines(lines)
+	if AnnotateHyphenation {
+		annotateHyphenation(lines)
+	}
+	doc.Lines = lines
+	doc.NumLines = 0
+	metaJSON, err := metadataJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	subject := fmt.Sprintf(
+		"Transcribed %d lines from %s (%d)", len(doc.Lines), doc.Identifier, doc.Year)
+	doc.History = nil
+	if previous != nil {
+		subject = fmt.Sprintf("Reviewed %s (%d)", doc.Identifier, doc.Year)
+		doc.History = previous.History
+	}
+	entry := LogEntry{
+		Date:    commitTime(),
+		Commit:  Sha1Digest(metaJSON),
+		Subject: subject,
+		Body:    comment,
+	}
+	entry.Author.Name = author
+	entry.Author.Email = email
+	doc.History = append([]LogEntry{entry}, doc.History...)
+	s.volumes[doc.Identifier] = doc
+	saved, err := copyDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	saved.Commit = entry.Commit
+	return saved, nil
+}
+
+// LoadVolume returns a copy of a stored document with its history
+func (s *MemoryStore) LoadVolume(ident string) (*Document, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	doc, ok := s.volumes[ident]
+	if !ok {
+		return nil, ErrDocumentNotFound
+	}
+	return copyDocument(doc)
+}
+
+// ListVolumes returns all documents without their lines, ordered by
+// identifier
+func (s *MemoryStore) ListVolumes() ([]*Document, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	documents := make([]*Document, 0, len(s.volumes))
+	for _, doc := range s.volumes {
+		listed := doc
+		listed.NumLines = len(doc.Lines)
+		listed.Lines = nil
+		documents = append(documents, &listed)
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].Identifier < documents[j].Identifier
+	})
+	return documents, nil
+}
+
+// Commit does nothing, saved volumes are recorded right away
+func (s *MemoryStore) Commit(message string, author string, email string) error {
+	return nil
+}
+
+// copyDocument deep-copies a document, so that callers cannot modify the
+// stored one
+func copyDocument(doc Document) (*Document, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var out Document
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
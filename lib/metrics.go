@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	archiveRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "archiscribe_archive_requests_total",
+		Help: "Requests to Archive.org by status class, including retries",
+	}, []string{"status"})
+	archiveRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "archiscribe_archive_request_duration_seconds",
+		Help:    "Time until the response headers of a request to Archive.org arrived",
+		Buckets: prometheus.DefBuckets,
+	})
+	ocrBytesDownloaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "archiscribe_ocr_bytes_downloaded_total",
+		Help: "Compressed bytes of ABBYY OCR read from Archive.org",
+	})
+	lineCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "archiscribe_line_cache_lookups_total",
+		Help: "Lookups of line images in the line image cache by result",
+	}, []string{"result"})
+	gitCommits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "archiscribe_git_commits_total",
+		Help: "Commits to the corpus repository by result",
+	}, []string{"result"})
+	quarantinedCacheFiles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "archiscribe_quarantined_cache_files_total",
+		Help: "Corrupt volume cache files moved to the quarantine directory",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		archiveRequests, archiveRequestDuration, ocrBytesDownloaded,
+		lineCacheLookups, gitCommits, quarantinedCacheFiles)
+}
+
+// observeArchiveRequest records a request to Archive.org, statusCode is zero
+// if the request failed without a response
+func observeArchiveRequest(started time.Time, statusCode int) {
+	status := "error"
+	if statusCode > 0 {
+		status = strconv.Itoa(statusCode/100) + "xx"
+	}
+	archiveRequests.WithLabelValues(status).Inc()
+	archiveRequestDuration.Observe(time.Since(started).Seconds())
+}
+
+// observeResult records the result of an operation in a counter labeled by
+// result
+func observeResult(counter *prometheus.CounterVec, err error) {
+	if err != nil {
+		counter.WithLabelValues("failure").Inc()
+	} else {
+		counter.WithLabelValues("success").Inc()
+	}
+}
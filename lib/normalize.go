@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeWhitespace trims submitted transcriptions and collapses runs of
+// spaces inside them to a single space
+var NormalizeWhitespace = true
+
+// NormalizeTranscription brings a transcription into NFC, so that e.g. ä is
+// always stored precomposed, no matter how the browser submitted it.
+// Compatibility characters like the long s are kept as they are.
+func NormalizeTranscription(text string) string {
+	text = norm.NFC.String(text)
+	if !NormalizeWhitespace {
+		return text
+	}
+	text = strings.TrimSpace(text)
+	if !strings.Contains(text, "  ") {
+		return text
+	}
+	var buf strings.Builder
+	buf.Grow(len(text))
+	prevSpace := false
+	for _, r := range text {
+		if r == ' ' && prevSpace {
+			continue
+		}
+		prevSpace = r == ' '
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// normalizeLines normalizes the transcriptions of submitted lines in place
+func normalizeLines(lines []OCRLine) {
+	for idx := range lines {
+		lines[idx].Transcription = NormalizeTranscription(lines[idx].Transcription)
+	}
+}
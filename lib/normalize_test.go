@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestNormalizeTranscription(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		// Want with whitespace normalization turned off
+		strict string
+	}{
+		{"precomposed umlauts", "Mädchen für Öl", "Mädchen für Öl", "Mädchen für Öl"},
+		{"decomposed umlauts", "Ma\u0308dchen fu\u0308r O\u0308l", "Mädchen für Öl", "Mädchen für Öl"},
+		{"decomposed acute accent", "Cafe\u0301 in Bru\u0308nn", "Café in Brünn", "Café in Brünn"},
+		{"long s", "Geſchichte des Landes", "Geſchichte des Landes", "Geſchichte des Landes"},
+		{"long s next to a decomposed umlaut", "verſo\u0308hnt", "verſöhnt", "verſöhnt"},
+		// There is no precomposed long s with a diaeresis
+		{"combining mark on the long s", "ſ\u0308o", "ſ\u0308o", "ſ\u0308o"},
+		{"surrounding whitespace", "  Erſte Zeile\t", "Erſte Zeile", "  Erſte Zeile\t"},
+		{"runs of spaces", "Das   iſt  gut", "Das iſt gut", "Das   iſt  gut"},
+		{"empty", "   ", "", "   "},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withGlobals(t, func() { NormalizeWhitespace = true })
+			if got := NormalizeTranscription(tc.text); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			NormalizeWhitespace = false
+			if got := NormalizeTranscription(tc.text); got != tc.strict {
+				t.Errorf("got %q with whitespace kept, want %q", got, tc.strict)
+			}
+		})
+	}
+}
+
+func TestSubmitNormalizesTranscriptions(t *testing.T) {
+	withGlobals(t, func() { NormalizeWhitespace = true })
+	const ident = "fibel_1855"
+	store := NewMemoryStore()
+	// The OCR text is precomposed, the transcription was submitted
+	// decomposed
+	machine := testLine(ident, 1, "Der Ba\u0308r iſt groß")
+	machine.OCRText = "Der Bär iſt groß"
+	machine.Provenance = ProvenanceMachine
+	doc := Document{Identifier: ident, Year: 1855, Lines: []OCRLine{
+		testLine(ident, 0, " Die  Ga\u0308nſe "),
+		machine,
+	}}
+	if _, err := Submit(store, doc, "Test", "test@example.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.LoadVolume(ident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		doc.Lines[0].Identifier: "Die Gänſe",
+		machine.Identifier:      "Der Bär iſt groß",
+	}
+	for _, line := range loaded.Lines {
+		if line.Transcription != want[line.Identifier] {
+			t.Errorf("line %s is %q, want %q", line.Identifier, line.Transcription, want[line.Identifier])
+		}
+		if line.Identifier == machine.Identifier && line.Provenance != ProvenanceMachine {
+			t.Errorf("machine line lost its provenance after normalization")
+		}
+	}
+}
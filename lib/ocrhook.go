@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	// Register decoders for reading the size of page images
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	simplejson "github.com/bitly/go-simplejson"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNoOCR is returned when Archive.org has no OCR for an item and no
+// fallback OCR is configured
+var ErrNoOCR = errors.New("no OCR available")
+
+// OCRHook runs an external OCR engine on page images of items that lack
+// OCR on Archive.org.
+//
+// The hook receives the page image on stdin (for a command) or as the
+// request body of a POST (for a URL) and has to respond with JSON like
+// {"width": 2000, "height": 3000, "lines": [{"l": 0, "t": 0, "r": 100,
+// "b": 20, "text": "..."}]}. Width and height are optional and read from
+// the image if missing, line coordinates are in pixels of the full image.
+type OCRHook struct {
+	// Command that is run for every page, split at whitespace
+	Command string
+	// URL that page images are posted to, used if no command is set
+	URL string
+	// Maximum time for recognizing a single page
+	Timeout time.Duration
+}
+
+// DefaultOCRHookTimeout is the default maximum time for recognizing a page
+const DefaultOCRHookTimeout = 2 * time.Minute
+
+// FallbackOCR is the hook used for items without Archive.org OCR, disabled
+// if neither command nor URL is set
+var FallbackOCR = OCRHook{Timeout: DefaultOCRHookTimeout}
+
+// HookLine is a single line recognized by an OCR hook
+type HookLine struct {
+	Left       int      `json:"l"`
+	Top        int      `json:"t"`
+	Right      int      `json:"r"`
+	Bottom     int      `json:"b"`
+	Text       string   `json:"text"`
+	Confidence *float64 `json:"confidence,omitempty"`
+}
+
+// HookResult is the response of an OCR hook for a single page
+type HookResult struct {
+	Width  int        `json:"width"`
+	Height int        `json:"height"`
+	Lines  []HookLine `json:"lines"`
+}
+
+// Enabled returns whether a command or URL is configured for the hook
+func (h OCRHook) Enabled() bool {
+	return h.Command != "" || h.URL != ""
+}
+
+// Recognize runs the hook on a page image
+func (h OCRHook) Recognize(img []byte) (*HookResult, error) {
+	return h.recognize(context.Background(), img)
+}
+
+func (h OCRHook) recognize(ctx context.Context, img []byte) (*HookResult, error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultOCRHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var raw []byte
+	var err error
+	if h.Command != "" {
+		raw, err = h.runCommand(ctx, img)
+	} else {
+		raw, err = h.post(ctx, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result HookResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("invalid response from OCR hook: %v", err)
+	}
+	if result.Width <= 0 || result.Height <= 0 {
+		conf, _, err := image.DecodeConfig(bytes.NewReader(img))
+		if err != nil {
+			return nil, fmt.Errorf("could not determine page size: %v", err)
+		}
+		result.Width = conf.Width
+		result.Height = conf.Height
+	}
+	return &result, nil
+}
+
+func (h OCRHook) runCommand(ctx context.Context, img []byte) ([]byte, error) {
+	args := strings.Fields(h.Command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(img)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("OCR hook failed: %v: %s", err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// hookClient is used for requests to the OCR hook, without a timeout since
+// recognizing a page can take long
+var hookClient = &http.Client{Transport: httpTransport}
+
+func (h OCRHook) post(ctx context.Context, img []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "image/jpeg")
+	resp, err := hookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 200 {
+		return nil, &StatusError{URL: h.URL, StatusCode: resp.StatusCode}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fetchPageImage downloads the full image of a page from the IIIF server
+func fetchPageImage(ctx context.Context, ident string, pageNo int) ([]byte, error) {
+	imgURL := IIIFImage.PageURL(ident, pageNo, "jpg")
+	resp, err := httpGet(ctx, imgURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 200 {
+		return nil, &StatusError{URL: imgURL, StatusCode: resp.StatusCode}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// recognizePage runs the fallback OCR on a single page of an item
+func recognizePage(ctx context.Context, ident string, pageNo int) (*HookResult, error) {
+	img, err := fetchPageImage(ctx, ident, pageNo)
+	if err != nil {
+		return nil, err
+	}
+	return FallbackOCR.recognize(ctx, img)
+}
+
+// getPageCount returns the number of page images of an item
+func getPageCount(ident string) (int, error) {
+	meta, err := GetMetadata(ident)
+	if err != nil {
+		return 0, err
+	}
+	count := parseImageCount(meta)
+	if count <= 0 {
+		return 0, fmt.Errorf("no image count in metadata for %s", ident)
+	}
+	return count, nil
+}
+
+// parseImageCount reads the number of page images from item metadata,
+// returns zero if it is missing
+func parseImageCount(meta *simplejson.Json) int {
+	count, err := meta.Get("imagecount").Int()
+	if err != nil {
+		// The metadata API usually returns the count as a string
+		var countStr string
+		if countStr, err = meta.Get("imagecount").String(); err == nil {
+			fmt.Sscanf(countStr, "%d", &count)
+		}
+	}
+	return count
+}
+
+// fetchLinesWithHook recognizes all pages of an item with the fallback OCR
+// and sends the lines in the same form as parsed from ABBYY OCR
+func fetchLinesWithHook(ctx context.Context, ident string, minLineWidth int, progressChan chan ProgressMessage, linesChan chan []OCRLine) {
+	logger := log.With().Str("archiveId", ident).Logger()
+	logger.Info().Msg("No ABBYY OCR available, running fallback OCR")
+	numPages, err := getPageCount(ident)
+	if err != nil {
+		sendFetchError(ctx, ident, progressChan, ProgressMessage{Error: err, Step: "ocr"})
+		return
+	}
+	startPage := getStartPageNumber(ctx, ident)
+	lines := make([]OCRLine, 0)
+	for pageIdx := 0; pageIdx < numPages; pageIdx++ {
+		pageNo := startPage + pageIdx
+		sent := sendProgress(ctx, progressChan, ProgressMessage{
+			Step:       "ocr",
+			Progress:   float64(pageIdx) / float64(numPages),
+			PageNumber: pageNo,
+		})
+		if !sent {
+			return
+		}
+		if pageNo <= 10 { // Same as for ABBYY OCR
+			continue
+		}
+		result, err := recognizePage(ctx, ident, pageNo)
+		if err != nil {
+			sendFetchError(ctx, ident, progressChan, ProgressMessage{Error: err, Step: "ocr", PageNumber: pageNo})
+			return
+		}
+		page := ocrPage{ident, pageNo, result.Width, result.Height}
+		for _, hl := range result.Lines {
+			var added bool
+			lines, added = page.appendLine(
+				lines, hl.Left, hl.Top, hl.Right, hl.Bottom, minLineWidth)
+			if !added {
+				continue
+			}
+			lines[len(lines)-1].OCRText = strings.TrimSpace(hl.Text)
+			if hl.Confidence != nil {
+				lines[len(lines)-1].OCRConfidence = *hl.Confidence
+			}
+		}
+	}
+	logger.Info().Int("numLines", len(lines)).Msg("Finished fallback OCR")
+	Blocked.RecordSuccess(ident)
+	cacheVolumeLines(ident, lines)
+	warnIdentifierCollisions(ident, lines)
+	addContextLines(lines)
+	sendLines(ctx, progressChan, linesChan, lines)
+}
+
+// hookText runs the fallback OCR on a sample of pages from the body of an
+// item and returns a scanner over the recognized words
+func hookText(ident string) (*bufio.Scanner, error) {
+	numPages, err := getPageCount(ident)
+	if err != nil {
+		return nil, err
+	}
+	var text bytes.Buffer
+	startPage := GetStartPageNumber(ident)
+	// Skip the front matter like for line extraction, but stay inside the
+	// volume for very short items
+	for pageNo := startPage + 11; pageNo < startPage+numPages && pageNo < startPage+14; pageNo++ {
+		result, err := recognizePage(context.Background(), ident, pageNo)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range result.Lines {
+			text.WriteString(line.Text)
+			text.WriteByte('\n')
+		}
+	}
+	scanner := bufio.NewScanner(&text)
+	scanner.Split(bufio.ScanWords)
+	return scanner, nil
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// noOCRServer serves an item with page images but without ABBYY OCR
+func noOCRServer(t *testing.T, ident string, numPages int, page []byte) *httptest.Server {
+	t.Helper()
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/" + ident + "/files":
+			fmt.Fprintf(w, `{"result": [{"name": "%s.pdf"}]}`, ident)
+		case "/metadata/" + ident:
+			fmt.Fprintf(w, `{"metadata": {"identifier": %q, "imagecount": "%d"}}`, ident, numPages)
+		case "/download/" + ident + "/" + ident + "_abbyy.gz":
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(page)
+		}
+	}))
+}
+
+func TestFallbackOCR(t *testing.T) {
+	page, err := ioutil.ReadFile("testdata/page.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Answers like the fake engine in testdata/ocrhook.sh
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		img, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || string(img) != string(page) {
+			http.Error(w, "expected the page image", http.StatusBadRequest)
+			return
+		}
+		confidence := 0.6
+		json.NewEncoder(w).Encode(HookResult{Lines: []HookLine{
+			{Left: 40, Top: 100, Right: 360, Bottom: 130, Text: "Erste Zeile ", Confidence: &confidence},
+			{Left: 40, Top: 160, Right: 360, Bottom: 190, Text: "Zweite Zeile"},
+		}})
+	}))
+	defer hookServer.Close()
+	tests := []struct {
+		name string
+		hook OCRHook
+		// Expected error, lines are expected if nil
+		wantErr error
+	}{
+		{"command", OCRHook{Command: "sh testdata/ocrhook.sh"}, nil},
+		{"URL", OCRHook{URL: hookServer.URL}, nil},
+		{"no hook", OCRHook{}, ErrNoOCR},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := FallbackOCR
+			FallbackOCR = tc.hook
+			defer func() { FallbackOCR = previous }()
+			const ident = "handschrift_1869"
+			server := noOCRServer(t, ident, 13, page)
+			defer server.Close()
+			routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+			// The hook server is local as well
+			hookClient.Transport = http.DefaultTransport
+			defer func() { hookClient.Transport = httpTransport }()
+
+			lines, err := fetchAllLinesUncached(ident)
+			if tc.wantErr != nil {
+				if err != tc.wantErr {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Two lines on each page after the front matter, pages 11 and 12
+			if len(lines) != 4 {
+				t.Fatalf("expected 4 lines, got %d", len(lines))
+			}
+			for idx, line := range lines {
+				wantText := []string{"Erste Zeile", "Zweite Zeile"}[idx%2]
+				if line.OCRText != wantText {
+					t.Errorf("line %d has text %q, want %q", idx, line.OCRText, wantText)
+				}
+				if line.Page == nil || line.Page.Number != 11+idx/2 || line.Page.Width != 400 || line.Page.Height != 600 {
+					t.Errorf("line %d is on page %+v, want page %d read from the image", idx, line.Page, 11+idx/2)
+				}
+			}
+			if lines[0].OCRConfidence != 0.6 || lines[1].OCRConfidence != 0 {
+				t.Errorf("unexpected confidences %v and %v", lines[0].OCRConfidence, lines[1].OCRConfidence)
+			}
+		})
+	}
+}
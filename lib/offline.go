@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Offline disables all requests to Archive.org and pulling from or pushing
+// to the origin of the repository. Only volumes whose lines are in the
+// volume cache can be served, with the line images that are cached.
+var Offline bool
+
+// ErrOffline is returned instead of requesting Archive.org in offline mode
+var ErrOffline = errors.New("offline mode, Archive.org is not reachable")
+
+// Has checks if the lines of a volume are cached and not stale
+func (c *VolumeCache) Has(ident string) bool {
+	finfo, err := os.Stat(c.volumePath(ident))
+	if err != nil {
+		return false
+	}
+	return c.maxAge <= 0 || time.Since(finfo.ModTime()) <= c.maxAge
+}
@@ -0,0 +1,210 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PageXMLNamespace is the namespace of the PAGE schema version the export
+// conforms to
+const PageXMLNamespace = "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15"
+
+type pageXMLDocument struct {
+	XMLName  xml.Name        `xml:"PcGts"`
+	Xmlns    string          `xml:"xmlns,attr"`
+	Metadata pageXMLMetadata `xml:"Metadata"`
+	Page     pageXMLPage     `xml:"Page"`
+}
+
+type pageXMLMetadata struct {
+	Creator    string `xml:"Creator"`
+	Created    string `xml:"Created"`
+	LastChange string `xml:"LastChange"`
+}
+
+type pageXMLPage struct {
+	ImageFilename string            `xml:"imageFilename,attr"`
+	ImageWidth    int               `xml:"imageWidth,attr"`
+	ImageHeight   int               `xml:"imageHeight,attr"`
+	Region        pageXMLTextRegion `xml:"TextRegion"`
+}
+
+type pageXMLTextRegion struct {
+	ID     string            `xml:"id,attr"`
+	Coords *pageXMLCoords    `xml:"Coords,omitempty"`
+	Lines  []pageXMLTextLine `xml:"TextLine"`
+}
+
+type pageXMLTextLine struct {
+	ID        string           `xml:"id,attr"`
+	Coords    *pageXMLCoords   `xml:"Coords,omitempty"`
+	TextEquiv pageXMLTextEquiv `xml:"TextEquiv"`
+}
+
+type pageXMLCoords struct {
+	Points string `xml:"points,attr"`
+}
+
+type pageXMLTextEquiv struct {
+	Unicode string `xml:"Unicode"`
+}
+
+// newPageXMLCoords returns the polygon of a bounding box, nil if the box is
+// unknown
+func newPageXMLCoords(box *BBox) *pageXMLCoords {
+	if box == nil {
+		return nil
+	}
+	return &pageXMLCoords{Points: fmt.Sprintf(
+		"%d,%d %d,%d %d,%d %d,%d",
+		box.Left, box.Top, box.Right, box.Top,
+		box.Right, box.Bottom, box.Left, box.Bottom)}
+}
+
+// linePageNumber determines the page of a line from its geometry or, for
+// lines stored before the geometry was recorded, from its image URL
+func linePageNumber(line OCRLine) (int, bool) {
+	if line.Page != nil {
+		return line.Page.Number, true
+	}
+	if region, ok := parseRegion(line.ImageURL); ok {
+		return region.Page, true
+	}
+	return 0, false
+}
+
+// transcribedPage holds the transcribed lines of a page in reading order
+type transcribedPage struct {
+	Number int
+	// Size of the page, zero if none of its lines has recorded geometry
+	Width  int
+	Height int
+	// Smallest box containing the boxes of all lines, nil if none has one
+	BBox  *BBox
+	Lines []OCRLine
+}
+
+// transcribedPages groups the transcribed lines of a document by their
+// page, in page order. Lines whose page cannot be determined are skipped.
+func transcribedPages(doc *Document) []*transcribedPage {
+	pages := make(map[int]*transcribedPage)
+	for _, line := range doc.Lines {
+		if line.Transcription == "" {
+			continue
+		}
+		pageNo, ok := linePageNumber(line)
+		if !ok {
+			continue
+		}
+		page, ok := pages[pageNo]
+		if !ok {
+			page = &transcribedPage{Number: pageNo}
+			pages[pageNo] = page
+		}
+		if line.Page != nil {
+			page.Width = line.Page.Width
+			page.Height = line.Page.Height
+		}
+		if line.BBox != nil {
+			page.BBox = unionBBox(page.BBox, line.BBox)
+		}
+		page.Lines = append(page.Lines, line)
+	}
+	sorted := make([]*transcribedPage, 0, len(pages))
+	for _, page := range pages {
+		sorted = append(sorted, page)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Number < sorted[j].Number
+	})
+	return sorted
+}
+
+// unionBBox returns the smallest box containing both boxes, a may be nil
+func unionBBox(a *BBox, b *BBox) *BBox {
+	if a == nil {
+		union := *b
+		return &union
+	}
+	union := *a
+	if b.Left < union.Left {
+		union.Left = b.Left
+	}
+	if b.Top < union.Top {
+		union.Top = b.Top
+	}
+	if b.Right > union.Right {
+		union.Right = b.Right
+	}
+	if b.Bottom > union.Bottom {
+		union.Bottom = b.Bottom
+	}
+	return &union
+}
+
+// ExportPageXML writes the transcribed lines of a document to one PAGE XML
+// file per page, named <identifier>_<page>.xml. Lines without a bounding box
+// are written without coordinates, lines whose page cannot be determined are
+// skipped. Returns the number of written pages.
+func ExportPageXML(doc *Document, outDir string) (int, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return 0, err
+	}
+	pages := transcribedPages(doc)
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, page := range pages {
+		region := pageXMLTextRegion{
+			ID:     fmt.Sprintf("region_%04d", page.Number),
+			Coords: newPageXMLCoords(page.BBox),
+		}
+		for _, line := range page.Lines {
+			region.Lines = append(region.Lines, pageXMLTextLine{
+				ID:        "line_" + line.Identifier,
+				Coords:    newPageXMLCoords(line.BBox),
+				TextEquiv: pageXMLTextEquiv{Unicode: line.Transcription},
+			})
+		}
+		pageDoc := pageXMLDocument{
+			Xmlns: PageXMLNamespace,
+			Metadata: pageXMLMetadata{
+				Creator:    "archiscribe",
+				Created:    now,
+				LastChange: now,
+			},
+			Page: pageXMLPage{
+				ImageFilename: IIIFImage.PageURL(doc.Identifier, page.Number, "jpg"),
+				ImageWidth:    page.Width,
+				ImageHeight:   page.Height,
+				Region:        region,
+			},
+		}
+		raw, err := xml.MarshalIndent(pageDoc, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		name := fmt.Sprintf("%s_%04d.xml", doc.Identifier, page.Number)
+		data := append([]byte(xml.Header), raw...)
+		if err := WriteFileAtomic(filepath.Join(outDir, name), append(data, '\n')); err != nil {
+			return 0, err
+		}
+	}
+	return len(pages), nil
+}
+
+// ExportPageXML writes the transcriptions of a volume in the repository as
+// PAGE XML, see ExportPageXML
+func (s *DocumentStore) ExportPageXML(ident string, outDir string) (int, error) {
+	metaPath := s.metaPath(ident)
+	if metaPath == "" {
+		return 0, ErrDocumentNotFound
+	}
+	doc, err := s.readDocument(metaPath)
+	if err != nil {
+		return 0, err
+	}
+	return ExportPageXML(doc, outDir)
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// parsePageXMLCoords returns the bounding box of the polygon written by
+// newPageXMLCoords
+func parsePageXMLCoords(t *testing.T, coords *pageXMLCoords) *BBox {
+	t.Helper()
+	if coords == nil {
+		return nil
+	}
+	var box, corners BBox
+	_, err := fmt.Sscanf(coords.Points, "%d,%d %d,%d %d,%d %d,%d",
+		&box.Left, &box.Top, &box.Right, &corners.Top,
+		&corners.Right, &box.Bottom, &corners.Left, &corners.Bottom)
+	if err != nil {
+		t.Fatalf("invalid points %q: %v", coords.Points, err)
+	}
+	if corners != (BBox{box.Left, box.Top, box.Right, box.Bottom}) {
+		t.Errorf("points %q are not a rectangle", coords.Points)
+	}
+	return &box
+}
+
+func TestExportPageXMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		ident string
+		// Expected files, in page order
+		files []string
+		// Expected page size, zero without geometry
+		width  int
+		height int
+	}{
+		{"bote_1848", []string{"bote_1848_0011.xml", "bote_1848_0012.xml"}, 2000, 3000},
+		{"kalender_1873", []string{"kalender_1873_0020.xml"}, 0, 0},
+	}
+	store := &DocumentStore{basePath: fixtureCorpus}
+	for _, tc := range tests {
+		t.Run(tc.ident, func(t *testing.T) {
+			doc, err := store.readDocument(store.metaPath(tc.ident))
+			if err != nil {
+				t.Fatal(err)
+			}
+			outDir := t.TempDir()
+			numPages, err := store.ExportPageXML(tc.ident, outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numPages != len(tc.files) {
+				t.Errorf("exported %d pages, want %d", numPages, len(tc.files))
+			}
+			matches, _ := filepath.Glob(filepath.Join(outDir, "*.xml"))
+			if len(matches) != len(tc.files) {
+				t.Fatalf("wrote %v, want %v", matches, tc.files)
+			}
+
+			// Read the lines back in the order they were written
+			var parsed []OCRLine
+			for _, name := range tc.files {
+				raw, err := ioutil.ReadFile(filepath.Join(outDir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var page pageXMLDocument
+				if err := xml.Unmarshal(raw, &page); err != nil {
+					t.Fatalf("%s is not well-formed: %v", name, err)
+				}
+				if page.Xmlns != PageXMLNamespace {
+					t.Errorf("%s has namespace %q", name, page.Xmlns)
+				}
+				if page.Page.ImageWidth != tc.width || page.Page.ImageHeight != tc.height {
+					t.Errorf("%s has size %dx%d, want %dx%d", name,
+						page.Page.ImageWidth, page.Page.ImageHeight, tc.width, tc.height)
+				}
+				var pageNo int
+				fmt.Sscanf(name, tc.ident+"_%04d.xml", &pageNo)
+				if page.Page.ImageFilename != IIIFImage.PageURL(tc.ident, pageNo, "jpg") {
+					t.Errorf("%s refers to image %s", name, page.Page.ImageFilename)
+				}
+				for _, line := range page.Page.Region.Lines {
+					parsed = append(parsed, OCRLine{
+						Identifier:    line.ID[len("line_"):],
+						Transcription: line.TextEquiv.Unicode,
+						BBox:          parsePageXMLCoords(t, line.Coords),
+					})
+				}
+			}
+
+			if len(parsed) != len(doc.Lines) {
+				t.Fatalf("read back %d lines, want %d", len(parsed), len(doc.Lines))
+			}
+			for _, page := range transcribedPages(doc) {
+				for _, want := range page.Lines {
+					got := parsed[0]
+					parsed = parsed[1:]
+					if got.Identifier != want.Identifier || got.Transcription != want.Transcription {
+						t.Errorf("read back line %s %q, want %s %q",
+							got.Identifier, got.Transcription, want.Identifier, want.Transcription)
+					}
+					if (got.BBox == nil) != (want.BBox == nil) || (got.BBox != nil && *got.BBox != *want.BBox) {
+						t.Errorf("line %s has box %+v, want %+v", want.Identifier, got.BBox, want.BBox)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExportPageXMLUnknownVolume(t *testing.T) {
+	store := &DocumentStore{basePath: fixtureCorpus}
+	if _, err := store.ExportPageXML("missing_1850", t.TempDir()); err != ErrDocumentNotFound {
+		t.Errorf("expected ErrDocumentNotFound, got %v", err)
+	}
+}
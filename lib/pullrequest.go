@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PullRequestOptions configures opening pull requests on GitHub instead of
+// pushing to the base branch of the corpus repository, for repositories
+// whose base branch is protected
+type PullRequestOptions struct {
+	// Slug of the repository, owner/name, pull requests are disabled if it
+	// is empty
+	Repository string
+	// Token for the GitHub API, it must never be logged
+	Token string
+	// Branch the pull requests are opened against
+	Base   string
+	APIURL string
+}
+
+// PullRequests configures opening a pull request for every commit to the
+// corpus repository
+var PullRequests = PullRequestOptions{
+	Base:   "master",
+	APIURL: "https://api.github.com",
+}
+
+var repoSlugPat = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+var branchUnsafePat = regexp.MustCompile(`[^\w.-]+`)
+
+// Enabled checks if pull requests are opened
+func (o PullRequestOptions) Enabled() bool {
+	return o.Repository != ""
+}
+
+// Validate checks that the repository is a slug and a token is set
+func (o PullRequestOptions) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	if !repoSlugPat.MatchString(o.Repository) {
+		return fmt.Errorf("pull request repository must be owner/name, not '%s'", o.Repository)
+	}
+	if o.Token == "" {
+		return fmt.Errorf("pull requests need a GitHub token")
+	}
+	if o.Base == "" {
+		return fmt.Errorf("pull requests need a base branch")
+	}
+	return nil
+}
+
+// githubClient is used for requests to the GitHub API
+var githubClient = &http.Client{Transport: httpTransport, Timeout: 30 * time.Second}
+
+// pullRequestBranch derives the name of the branch for a commit from its
+// topic, usually the identifier of the volume, and the short commit hash
+func pullRequestBranch(topic string, commit string) string {
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	topic = strings.Trim(branchUnsafePat.ReplaceAllString(topic, "-"), "-.")
+	return fmt.Sprintf("archiscribe/%s-%s", topic, commit)
+}
+
+// open opens a pull request for a pushed branch, with the subject of the
+// commit message as its title and the rest as its description. Returns the
+// URL of the pull request.
+func (o PullRequestOptions) open(branch string, message string) (string, error) {
+	title, body := message, ""
+	if idx := strings.Index(message, "\n"); idx >= 0 {
+		title, body = message[:idx], strings.TrimSpace(message[idx+1:])
+	}
+	payload, err := json.Marshal(map[string]string{
+		"title": title,
+		"body":  body,
+		"head":  branch,
+		"base":  o.Base,
+	})
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s/repos/%s/pulls", strings.TrimRight(o.APIURL, "/"), o.Repository)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+o.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := githubClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(raw, &created)
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf(
+			"could not open pull request for %s: %s (%d)", branch, created.Message,
+			resp.StatusCode)
+	}
+	return created.HTMLURL, nil
+}
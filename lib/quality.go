@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CropQualityOptions configures the check that rejects line crops that are
+// almost blank, usually margins or scanning artifacts detected as lines
+type CropQualityOptions struct {
+	// Minimum spread between the darkest and lightest pixels of a crop, as
+	// a ratio of the full luminance range, disabled if zero
+	MinContrast float64
+	// Maximum ratio of pixels close to the background luminance, disabled
+	// if zero
+	MaxBackgroundRatio float64
+}
+
+// CropQuality is the global configuration for checking line crops
+var CropQuality = CropQualityOptions{
+	MinContrast:        0.15,
+	MaxBackgroundRatio: 0.98,
+}
+
+// CropMeasure holds the statistics of a line crop's luminance histogram
+type CropMeasure struct {
+	Contrast        float64 `json:"contrast"`
+	BackgroundRatio float64 `json:"backgroundRatio"`
+}
+
+// Luminance distance from the most common value for a pixel to count as
+// background
+const backgroundTolerance = 24
+
+// MeasureCrop computes the contrast and background ratio of a line image.
+// The contrast ignores the darkest and lightest percent of the pixels, so
+// that single specks of dust don't count.
+func MeasureCrop(img image.Image) CropMeasure {
+	var hist [256]int
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			hist[gray.Y]++
+		}
+	}
+	numPixels := bounds.Dx() * bounds.Dy()
+	if numPixels == 0 {
+		return CropMeasure{BackgroundRatio: 1}
+	}
+	percentile := func(ratio float64) int {
+		limit := int(float64(numPixels) * ratio)
+		sum := 0
+		for val, count := range hist {
+			sum += count
+			if sum > limit {
+				return val
+			}
+		}
+		return 255
+	}
+	mode := 0
+	for val, count := range hist {
+		if count > hist[mode] {
+			mode = val
+		}
+	}
+	numBackground := 0
+	for val := maxInt(0, mode-backgroundTolerance); val <= minInt(255, mode+backgroundTolerance); val++ {
+		numBackground += hist[val]
+	}
+	return CropMeasure{
+		Contrast:        float64(percentile(0.99)-percentile(0.01)) / 255,
+		BackgroundRatio: float64(numBackground) / float64(numPixels),
+	}
+}
+
+// Passes checks if a measured crop is good enough to be transcribed
+func (o CropQualityOptions) Passes(m CropMeasure) bool {
+	if o.MinContrast > 0 && m.Contrast < o.MinContrast {
+		return false
+	}
+	if o.MaxBackgroundRatio > 0 && m.BackgroundRatio > o.MaxBackgroundRatio {
+		return false
+	}
+	return true
+}
+
+// Enabled returns whether any threshold is set
+func (o CropQualityOptions) Enabled() bool {
+	return o.MinContrast > 0 || o.MaxBackgroundRatio > 0
+}
+
+// cropMeasures holds the measures of all line crops cached since the start
+var cropMeasures = struct {
+	sync.RWMutex
+	byLine map[string]CropMeasure
+}{byLine: make(map[string]CropMeasure)}
+
+// measureCachedCrop measures a freshly cached line image and remembers the
+// result for the line
+func measureCachedCrop(id string, imgPath string) {
+	img, err := readPNG(imgPath)
+	if err != nil {
+		log.Warn().Err(err).Str("lineId", id).Msg("Could not measure line crop")
+		return
+	}
+	measure := MeasureCrop(img)
+	cropMeasures.Lock()
+	cropMeasures.byLine[id] = measure
+	cropMeasures.Unlock()
+	if !CropQuality.Passes(measure) {
+		log.Debug().
+			Str("lineId", id).
+			Float64("contrast", measure.Contrast).
+			Float64("backgroundRatio", measure.BackgroundRatio).
+			Msg("Line crop is nearly blank")
+	}
+}
+
+// IsBlankCrop checks if the crop of a line was measured and failed the
+// quality check. Lines whose image was not cached yet are never blank.
+func IsBlankCrop(id string) bool {
+	cropMeasures.RLock()
+	measure, found := cropMeasures.byLine[id]
+	cropMeasures.RUnlock()
+	return found && !CropQuality.Passes(measure)
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// grayCrop returns a line crop with the luminance of every pixel set by fn
+func grayCrop(width int, height int, fn func(x, y int) uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: fn(x, y)})
+		}
+	}
+	return img
+}
+
+var cropFixtures = map[string]image.Image{
+	// Printed text, dark strokes across the middle of the line
+	"text": grayCrop(400, 60, func(x, y int) uint8 {
+		if y > 20 && y < 40 && x%5 < 3 {
+			return 25
+		}
+		return 225
+	}),
+	// Paper with a few specks of dust
+	"blank": grayCrop(400, 60, func(x, y int) uint8 {
+		if (x*31+y*17)%997 == 0 {
+			return 10
+		}
+		return 232
+	}),
+	// Bleed-through from the other side of the page, faint and low in
+	// contrast
+	"bleed-through": grayCrop(400, 60, func(x, y int) uint8 {
+		if y > 20 && y < 40 && x%5 < 3 {
+			return 200
+		}
+		return 225
+	}),
+	// A margin with a single short mark
+	"margin": grayCrop(400, 60, func(x, y int) uint8 {
+		if x < 12 && y > 25 && y < 35 {
+			return 30
+		}
+		return 228
+	}),
+}
+
+func TestCropQualityDefaults(t *testing.T) {
+	tests := []struct {
+		fixture string
+		passes  bool
+	}{
+		{"text", true},
+		{"blank", false},
+		{"bleed-through", false},
+		{"margin", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.fixture, func(t *testing.T) {
+			measure := MeasureCrop(cropFixtures[tc.fixture])
+			if got := CropQuality.Passes(measure); got != tc.passes {
+				t.Errorf("crop with contrast %.2f and background ratio %.3f passes: %v, want %v",
+					measure.Contrast, measure.BackgroundRatio, got, tc.passes)
+			}
+		})
+	}
+	if measure := MeasureCrop(image.NewGray(image.Rect(0, 0, 0, 0))); CropQuality.Passes(measure) {
+		t.Errorf("empty crop passes the check")
+	}
+	if disabled := (CropQualityOptions{}); disabled.Enabled() || !disabled.Passes(MeasureCrop(cropFixtures["blank"])) {
+		t.Errorf("check without thresholds rejects crops")
+	}
+}
+
+func TestCachedCropsAreMeasured(t *testing.T) {
+	useTestLineCache(t)
+	withoutRateLimit(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		img, ok := cropFixtures[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".png")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+	}))
+	defer server.Close()
+
+	const ident = "marktblatt_1857"
+	var lines []OCRLine
+	for _, name := range []string{"text", "blank"} {
+		lines = append(lines, OCRLine{Identifier: name + "_1857", ImageURL: server.URL + "/" + name + ".png"})
+	}
+	for _, line := range lines {
+		if IsBlankCrop(MakeLineIdentifier(ident, line)) {
+			t.Fatalf("line %s is blank before its crop was cached", line.Identifier)
+		}
+	}
+	LineCache.CacheLines(lines, ident, 1857)
+	for _, line := range lines {
+		want := line.Identifier == "blank_1857"
+		if got := IsBlankCrop(MakeLineIdentifier(ident, line)); got != want {
+			t.Errorf("line %s is blank: %v, want %v", line.Identifier, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Minimum overlap of an old and a new crop for them to be considered the
+// same line
+const minRecropOverlap = 0.5
+
+// RecroppedLine is a stored line whose image crop was changed
+type RecroppedLine struct {
+	Identifier  string `json:"id"`
+	OldImageURL string `json:"oldImageUrl"`
+	NewImageURL string `json:"newImageUrl"`
+}
+
+// Recrop computes the crops of all lines of a stored volume again from the
+// original OCR geometry and replaces the line images in the repository.
+// Line identifiers and transcriptions are kept as they are. Returns the
+// lines whose crops changed.
+func (s *DocumentStore) Recrop(ident string) ([]RecroppedLine, error) {
+	logger := log.With().Str("identifier", ident).Logger()
+	metaPath := s.metaPath(ident)
+	if metaPath == "" {
+		return nil, ErrDocumentNotFound
+	}
+	ocrLines, err := fetchAllLinesUncached(ident)
+	if err != nil {
+		return nil, err
+	}
+
+	unlockRepo, err := s.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlockRepo()
+	lock, err := lockVolume(s.lockDir, ident)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	if err := s.syncRepo(logger); err != nil {
+		return nil, err
+	}
+	doc, err := s.readDocument(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	changed := make([]RecroppedLine, 0)
+	for idx, line := range doc.Lines {
+		match, ok := matchCrop(line, ocrLines)
+		if !ok {
+			logger.Warn().
+				Str("lineId", line.Identifier).
+				Msg("Could not match line to the OCR, keeping its crop")
+			continue
+		}
+		if match.ImageURL == line.ImageURL {
+			continue
+		}
+		imgPath := filepath.Join(
+			s.basePath, "transcriptions", strconv.Itoa(doc.Year),
+			fmt.Sprintf("%s_%s.png", doc.Identifier, line.Identifier))
+		if err := downloadFile(match.ImageURL, imgPath); err != nil {
+			return nil, err
+		}
+		if err := s.repo.Add(imgPath); err != nil {
+			return nil, err
+		}
+		changed = append(changed, RecroppedLine{
+			Identifier:  line.Identifier,
+			OldImageURL: line.ImageURL,
+			NewImageURL: match.ImageURL,
+		})
+		doc.Lines[idx].ImageURL = match.ImageURL
+		doc.Lines[idx].PreviousImageURL = match.PreviousImageURL
+		doc.Lines[idx].NextImageURL = match.NextImageURL
+	}
+	if len(changed) == 0 {
+		logger.Info().Msg("No crops changed")
+		return changed, nil
+	}
+	if err := s.writeMetadata(*doc); err != nil {
+		return nil, err
+	}
+	commitMessage := fmt.Sprintf(
+		"Recropped %d lines of %s (%d)", len(changed), doc.Identifier, doc.Year)
+	if _, _, err := s.commitAndPush(doc.Identifier, commitMessage, "", "", logger); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// matchCrop finds the freshly parsed line that overlaps the most with the
+// crop of a stored line
+func matchCrop(line OCRLine, candidates []OCRLine) (OCRLine, bool) {
+	region, ok := parseRegion(line.ImageURL)
+	if !ok {
+		return OCRLine{}, false
+	}
+	bestIdx := -1
+	bestOverlap := 0.
+	for idx, candidate := range candidates {
+		other, ok := parseRegion(candidate.ImageURL)
+		if !ok || other.Page != region.Page {
+			continue
+		}
+		if overlap := overlapRatio(region, other); overlap > bestOverlap {
+			bestIdx = idx
+			bestOverlap = overlap
+		}
+	}
+	if bestIdx < 0 || bestOverlap < minRecropOverlap {
+		return OCRLine{}, false
+	}
+	return candidates[bestIdx], true
+}
+
+// overlapRatio returns the area of the intersection of two regions relative
+// to the area of the smaller one
+func overlapRatio(a Region, b Region) float64 {
+	width := minInt(a.X+a.Width, b.X+b.Width) - maxInt(a.X, b.X)
+	height := minInt(a.Y+a.Height, b.Y+b.Height) - maxInt(a.Y, b.Y)
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	smaller := minInt(a.Width*a.Height, b.Width*b.Height)
+	if smaller <= 0 {
+		return 0
+	}
+	return float64(width*height) / float64(smaller)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// downloadFile stores the response for a URL at the given path
+func downloadFile(url string, path string) error {
+	resp, err := httpGet(context.Background(), url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 200 {
+		return &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return writeAtomic(path, func(w io.Writer) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// shiftCrop moves the crop of a line down, like a bug in the region math
+// would have
+func shiftCrop(t *testing.T, line OCRLine, dy int) OCRLine {
+	t.Helper()
+	r, ok := parseRegion(line.ImageURL)
+	if !ok {
+		t.Fatalf("no region in %s", line.ImageURL)
+	}
+	old := fmt.Sprintf("$%d/%d,%d,%d,%d/", r.Page, r.X, r.Y, r.Width, r.Height)
+	shifted := fmt.Sprintf("$%d/%d,%d,%d,%d/", r.Page, r.X, r.Y+dy, r.Width, r.Height)
+	line.ImageURL = strings.Replace(line.ImageURL, old, shifted, 1)
+	return line
+}
+
+func TestRecrop(t *testing.T) {
+	tests := []struct {
+		name string
+		// Vertical offset of the stored crops
+		shift       int
+		wantChanged int
+	}{
+		{"shifted crops", 8, 2},
+		{"current crops", 0, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			const ident = "stadtanzeiger_1876"
+			server := archiveServer(t, ident, 12, 2)
+			defer server.Close()
+			routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+			store, _ := newTestStore(t)
+			ocrLines, err := fetchAllLinesUncached(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transcriptions := []string{"Bekanntmachung", "Der Magistrat"}
+			doc := Document{Identifier: ident, Title: "Stadtanzeiger", Year: 1876}
+			for idx, line := range ocrLines {
+				line = shiftCrop(t, line, tc.shift)
+				line.Transcription = transcriptions[idx]
+				cacheTestLine(t, ident, line)
+				doc.Lines = append(doc.Lines, line)
+			}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+			imgPath := func(line OCRLine) string {
+				return filepath.Join(store.basePath, "transcriptions", "1876", ident+"_"+line.Identifier+".png")
+			}
+			before, _ := ioutil.ReadFile(imgPath(doc.Lines[0]))
+			commits := git(t, store.basePath, "rev-list", "--count", "HEAD")
+
+			changed, err := store.Recrop(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changed) != tc.wantChanged {
+				t.Fatalf("recropped %d lines, want %d", len(changed), tc.wantChanged)
+			}
+			for idx, recropped := range changed {
+				if recropped.OldImageURL != doc.Lines[idx].ImageURL || recropped.NewImageURL != ocrLines[idx].ImageURL {
+					t.Errorf("unexpected report %+v", recropped)
+				}
+			}
+			stored := store.Details(ident)
+			for idx, line := range stored.Lines {
+				if line.Identifier != doc.Lines[idx].Identifier || line.Transcription != transcriptions[idx] {
+					t.Errorf("line %d changed to %s %q", idx, line.Identifier, line.Transcription)
+				}
+				if line.ImageURL != ocrLines[idx].ImageURL {
+					t.Errorf("line %d still has the crop %s", idx, line.ImageURL)
+				}
+			}
+			after, _ := ioutil.ReadFile(imgPath(doc.Lines[0]))
+			if regenerated := !bytes.Equal(before, after); regenerated != (tc.wantChanged > 0) {
+				t.Errorf("image regenerated is %v, want %v", regenerated, tc.wantChanged > 0)
+			}
+			recommitted := git(t, store.basePath, "rev-list", "--count", "HEAD") != commits
+			if recommitted != (tc.wantChanged > 0) {
+				t.Errorf("committed is %v, want %v", recommitted, tc.wantChanged > 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrDocumentNotFound is returned when no document exists for an identifier
+var ErrDocumentNotFound = errors.New("document not found")
+
+// ErrLineNotFound is returned when a document has no line with the identifier
+var ErrLineNotFound = errors.New("line not found")
+
+// ErrLineNotFlagged is returned when a line that is not flagged for review
+// is cleared or rejected
+var ErrLineNotFlagged = errors.New("line is not flagged for review")
+
+// FlaggedLine is a committed line that failed soft validation and needs to
+// be reviewed by a maintainer
+type FlaggedLine struct {
+	Document string  `json:"document"`
+	Title    string  `json:"title"`
+	Year     int     `json:"year"`
+	Line     OCRLine `json:"line"`
+}
+
+// Flagged lists all lines that are flagged for review
+func (s *DocumentStore) Flagged() ([]FlaggedLine, error) {
+	flagged := make([]FlaggedLine, 0)
+	err := s.forEachDocument(func(doc *Document) error {
+		for _, line := range doc.Lines {
+			if len(line.Flags) == 0 {
+				continue
+			}
+			flagged = append(flagged, FlaggedLine{
+				Document: doc.Identifier,
+				Title:    doc.Title,
+				Year:     doc.Year,
+				Line:     line,
+			})
+		}
+		return nil
+	})
+	return flagged, err
+}
+
+// ClearFlags accepts a flagged line as it is and removes its review flags
+func (s *DocumentStore) ClearFlags(ident string, lineID string) (*Document, error) {
+	return s.updateReviewedLine(ident, lineID, false)
+}
+
+// RejectLine removes a flagged line from the corpus
+func (s *DocumentStore) RejectLine(ident string, lineID string) (*Document, error) {
+	return s.updateReviewedLine(ident, lineID, true)
+}
+
+func (s *DocumentStore) updateReviewedLine(ident string, lineID string, reject bool) (*Document, error) {
+	logger := log.With().Str("identifier", ident).Str("lineId", lineID).Logger()
+	unlockRepo, err := s.lockRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer unlockRepo()
+	lock, err := lockVolume(s.lockDir, ident)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	if err := s.syncRepo(logger); err != nil {
+		return nil, err
+	}
+	metaPath := s.metaPath(ident)
+	if metaPath == "" {
+		return nil, ErrDocumentNotFound
+	}
+	doc, err := s.readDocument(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	lineIdx := -1
+	for idx, line := range doc.Lines {
+		if line.Identifier == lineID {
+			lineIdx = idx
+			break
+		}
+	}
+	if lineIdx < 0 {
+		return nil, ErrLineNotFound
+	}
+	if len(doc.Lines[lineIdx].Flags) == 0 {
+		return nil, ErrLineNotFlagged
+	}
+
+	var commitMessage string
+	if reject {
+		doc.Lines = append(doc.Lines[:lineIdx], doc.Lines[lineIdx+1:]...)
+		if err := s.removeLineFiles(doc, lineID); err != nil {
+			return nil, err
+		}
+		commitMessage = fmt.Sprintf(
+			"Rejected line %s from %s (%d)", lineID, doc.Identifier, doc.Year)
+	} else {
+		doc.Lines[lineIdx].Flags = nil
+		commitMessage = fmt.Sprintf(
+			"Cleared review flags for line %s from %s (%d)", lineID,
+			doc.Identifier, doc.Year)
+	}
+	if err := s.writeMetadata(*doc); err != nil {
+		return nil, err
+	}
+	if err := s.writeReadme(logger); err != nil {
+		return nil, err
+	}
+	if _, _, err := s.commitAndPush(doc.Identifier, commitMessage, "", "", logger); err != nil {
+		return nil, err
+	}
+	return s.Details(ident), nil
+}
+
+// removeLineFiles removes the image and transcription of a line from the
+// repository
+func (s *DocumentStore) removeLineFiles(doc *Document, lineID string) error {
+	basePath := filepath.Join(
+		s.basePath, "transcriptions", strconv.Itoa(doc.Year),
+		fmt.Sprintf("%s_%s", doc.Identifier, lineID))
+	if err := s.repo.Remove(basePath + ".png"); err != nil {
+		return err
+	}
+	return s.repo.Remove(basePath + ".txt")
+}
@@ -0,0 +1,69 @@
+package lib
+
+import "testing"
+
+func TestSoftFailingLineIsFlaggedForReview(t *testing.T) {
+	tests := []struct {
+		name   string
+		reject bool
+		// Lines expected in the volume after the review
+		wantLines int
+	}{
+		{"cleared", false, 2},
+		{"rejected", true, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store, _ := newTestStore(t)
+			const ident = "amtsblatt_1855"
+			good := testLine(ident, 0, "Bekanntmachung des Magistrats")
+			short := testLine(ident, 1, "x")
+			for _, line := range []OCRLine{good, short} {
+				cacheTestLine(t, ident, line)
+			}
+			doc := Document{Identifier: ident, Title: "Amtsblatt", Year: 1855, Lines: []OCRLine{good, short}}
+			if _, err := Submit(store, doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatalf("soft-failing line must not reject the submission: %v", err)
+			}
+
+			flagged, err := store.Flagged()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(flagged) != 1 || flagged[0].Line.Identifier != short.Identifier ||
+				flagged[0].Document != ident || flagged[0].Year != 1855 {
+				t.Fatalf("expected the short line in the review list, got %+v", flagged)
+			}
+			if len(flagged[0].Line.Flags) != 1 || flagged[0].Line.Flags[0] != ReasonTooShort {
+				t.Errorf("expected the line to be flagged as too short, got %v", flagged[0].Line.Flags)
+			}
+
+			// Lines that are not flagged can be neither cleared nor rejected
+			if _, err := store.ClearFlags(ident, good.Identifier); err != ErrLineNotFlagged {
+				t.Errorf("expected ErrLineNotFlagged for clearing an unflagged line, got %v", err)
+			}
+			if _, err := store.RejectLine(ident, good.Identifier); err != ErrLineNotFlagged {
+				t.Errorf("expected ErrLineNotFlagged for rejecting an unflagged line, got %v", err)
+			}
+
+			var reviewed *Document
+			if tc.reject {
+				reviewed, err = store.RejectLine(ident, short.Identifier)
+			} else {
+				reviewed, err = store.ClearFlags(ident, short.Identifier)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reviewed.Lines) != tc.wantLines {
+				t.Errorf("expected %d lines after the review, got %d", tc.wantLines, len(reviewed.Lines))
+			}
+			if flagged, _ := store.Flagged(); len(flagged) != 0 {
+				t.Errorf("reviewed line is still listed: %+v", flagged)
+			}
+			if _, err := store.ClearFlags(ident, "missing"); err != ErrLineNotFound {
+				t.Errorf("expected ErrLineNotFound for an unknown line, got %v", err)
+			}
+		})
+	}
+}
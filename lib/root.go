@@ -1,8 +1,9 @@
 package lib
 
 import (
-	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -10,15 +11,15 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
-	"text/template"
 
-	simplejson "github.com/bitly/go-simplejson"
-	"github.com/olekukonko/tablewriter"
 	"github.com/rs/zerolog/log"
 )
 
 var pagePat = regexp.MustCompile(`<page width="(\d+)" height="(\d+)".+?>`)
 var linePat = regexp.MustCompile(`<line .+?l="(\d+)" t="(\d+)" r="(\d+)" b="(\d+)">`)
+var regionPat = regexp.MustCompile(`\$(\d+)/(\d+),(\d+),(\d+),(\d+)/`)
+var ocrTokenPat = regexp.MustCompile(`<line .+?>|<charParams([^>]*)>([^<]*)</charParams>|</line>`)
+var confidencePat = regexp.MustCompile(`charConfidence="(\d+)"`)
 
 const readmeTemplate = `
 # archiscribe-corpus
@@ -31,6 +32,10 @@ prints as possible.
 Currently the corpus contains {{.numLines}} lines from {{.numWorks}} works
 published across {{.numYears}} years. Detailed statistics are available below.
 
+## Statistics: Totals
+
+{{.totalsTable}}
+
 ## Statistics: Decades
 
 {{.decadeTable}}
@@ -42,7 +47,11 @@ published across {{.numYears}} years. Detailed statistics are available below.
 ## Statistics: Works
 
 {{.worksTable}}
-`
+{{if .contributorsTable}}
+## Statistics: Contributors
+
+{{.contributorsTable}}
+{{end}}`
 
 // IDCache is the global cache for suitable identifiers
 var IDCache *IdentifierCache
@@ -50,13 +59,106 @@ var IDCache *IdentifierCache
 // LineCache is the global cache for line images
 var LineCache *LineImageCache
 
+// VolumeLines is the global cache for parsed lines of volumes
+var VolumeLines *VolumeCache
+
+// CacheDir is the directory that holds all cached data
+var CacheDir string
+
 // OCRLine contains information about an OCR line
 type OCRLine struct {
-	Identifier       string `json:"id"`
-	ImageURL         string `json:"line"`
-	PreviousImageURL string `json:"previous,omitempty"`
-	NextImageURL     string `json:"next,omitempty"`
-	Transcription    string `json:"transcription,omitempty"`
+	Identifier       string    `json:"id"`
+	ImageURL         string    `json:"line"`
+	PreviousImageURL string    `json:"previous,omitempty"`
+	NextImageURL     string    `json:"next,omitempty"`
+	Transcription    string    `json:"transcription,omitempty"`
+	OCRText          string    `json:"ocrText,omitempty"`
+	OCRConfidence    float64   `json:"ocrConfidence,omitempty"`
+	Flags            []string  `json:"flags,omitempty"`
+	Duplicates       []OCRLine `json:"duplicates,omitempty"`
+	// Joined form of a word hyphenated at the end of this line
+	HyphenatedWord string `json:"hyphenatedWord,omitempty"`
+	// Origin of the transcription, empty for human transcriptions
+	Provenance string `json:"provenance,omitempty"`
+	// Opaque identifier of the session that submitted the transcription,
+	// only shown to admins
+	Session string `json:"session,omitempty"`
+	// Images of the lines before and after this one in page order, as many
+	// as configured with ContextLines. The direct neighbours are also in
+	// PreviousImageURL and NextImageURL.
+	PreviousImageURLs []string `json:"previousLines,omitempty"`
+	NextImageURLs     []string `json:"nextLines,omitempty"`
+	// Where the client loads the line image from if it cannot load the
+	// IIIF image, e.g. the local cache in offline mode
+	ImageSrc string `json:"src,omitempty"`
+	// Page of the line and its bounding box as reported by the OCR, unset
+	// for lines fetched before they were recorded
+	Page *PageGeometry `json:"page,omitempty"`
+	BBox *BBox         `json:"bbox,omitempty"`
+}
+
+// PageGeometry is the number of a page in the volume and its size in pixels
+type PageGeometry struct {
+	Number int `json:"number"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// BBox is the bounding box of a line on its page in pixels, without the
+// padding of the line image
+type BBox struct {
+	Left   int `json:"l"`
+	Top    int `json:"t"`
+	Right  int `json:"r"`
+	Bottom int `json:"b"`
+}
+
+// ProvenanceMachine marks transcriptions that were accepted from OCR with a
+// high confidence instead of being transcribed by a human
+const ProvenanceMachine = "machine-verified"
+
+// Region is the area of a page occupied by a line
+type Region struct {
+	Page   int
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// parseRegion determines the page and region of a line from its IIIF URL
+func parseRegion(imageURL string) (Region, bool) {
+	match := regionPat.FindStringSubmatch(imageURL)
+	if match == nil {
+		return Region{}, false
+	}
+	var vals [5]int
+	for idx := range vals {
+		vals[idx], _ = strconv.Atoi(match[idx+1])
+	}
+	return Region{
+		Page: vals[0], X: vals[1], Y: vals[2], Width: vals[3], Height: vals[4],
+	}, true
+}
+
+// sortLines orders lines by page and then by their vertical and horizontal
+// position on the page. Lines without a known region go last, in their
+// original order.
+func sortLines(lines []OCRLine) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		ri, okI := parseRegion(lines[i].ImageURL)
+		rj, okJ := parseRegion(lines[j].ImageURL)
+		if !okI || !okJ {
+			return okI && !okJ
+		}
+		if ri.Page != rj.Page {
+			return ri.Page < rj.Page
+		}
+		if ri.Y != rj.Y {
+			return ri.Y < rj.Y
+		}
+		return ri.X < rj.X
+	})
 }
 
 // TaskDefinition encodes a finished transcription along with author information
@@ -71,7 +173,11 @@ type TaskDefinition struct {
 // SubmitResult holds the result of a submission
 type SubmitResult struct {
 	Document Document
-	Error    error
+	// Commit the submission was recorded in, shared by batched submissions
+	Commit string
+	// URL of the pull request the commit was opened as, see PullRequests
+	PullRequest string
+	Error       error
 }
 
 // ProgressReader wraps another reader and exposes progress information
@@ -95,111 +201,24 @@ func (r *ProgressReader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
-func createReadme(repoPath string) string {
-	metaFiles, _ := filepath.Glob(fmt.Sprintf("%s/transcriptions/*/*.json", repoPath))
-	// TODO: Handle error
-	sort.Strings(metaFiles)
-	numLinesTotal := 0
-	yearCount := map[int]int{}
-	decadeCount := map[int]int{}
-	metaRows := [][]string{}
-	for _, metaPath := range metaFiles {
-		fp, err := os.Open(metaPath)
-		if err != nil {
-			log.Error().
-				Err(err).
-				Str("metaPath", metaPath).
-				Msg("Could not read meta file")
-			continue
+// ResolveCacheDir determines the absolute path of the cache directory. An
+// explicitly passed directory takes precedence over the ARCHISCRIBE_CACHE
+// environment variable, ./cache is used if neither is set.
+func ResolveCacheDir(cacheDir string) string {
+	if cacheDir == "" {
+		var isSet bool
+		if cacheDir, isSet = os.LookupEnv("ARCHISCRIBE_CACHE"); !isSet {
+			cacheDir = "./cache"
 		}
-		meta, err := simplejson.NewFromReader(fp)
-		if err != nil {
-			log.Error().
-				Err(err).
-				Str("metaPath", metaPath).
-				Msg("Could not parse meta file")
-			continue
-		}
-		numLines := len(meta.Get("lines").MustMap())
-		numLinesTotal += numLines
-		year, _ := strconv.Atoi(meta.Get("year").MustString())
-		decade := (year / 10) * 10
-		yearCount[year] += numLines
-		decadeCount[decade] += numLines
-		ident := meta.Get("identifier").MustString()
-		archiveLink := fmt.Sprintf(
-			"[%s](http://archive.org/details/%s)", ident, ident)
-		manifestLink := fmt.Sprintf(
-			"[Manifest](https://iiif.archivelab.org/iiif/%s/manifest.json)", ident)
-		miradorLink := fmt.Sprintf(
-			"[Mirador](https://iiif.archivelab.org/iiif/%s)", ident)
-		metaRows = append(metaRows, []string{
-			meta.Get("title").MustString(), meta.Get("date").MustString(),
-			archiveLink, fmt.Sprintf("%s/%s", manifestLink, miradorLink)})
-	}
-
-	var yearsTable bytes.Buffer
-	var years []int
-	for k := range yearCount {
-		years = append(years, k)
-	}
-	sort.Ints(years)
-	t := tablewriter.NewWriter(&yearsTable)
-	t.SetAutoFormatHeaders(false)
-	t.SetHeader([]string{"Year", "# lines"})
-	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
-	t.SetCenterSeparator("|")
-	for _, year := range years {
-		t.Append([]string{strconv.Itoa(year), strconv.Itoa(yearCount[year])})
-	}
-	t.Render()
-
-	var decadesTable bytes.Buffer
-	var decades []int
-	for k := range decadeCount {
-		decades = append(decades, k)
 	}
-	sort.Ints(decades)
-	t = tablewriter.NewWriter(&decadesTable)
-	t.SetAutoFormatHeaders(false)
-	t.SetHeader([]string{"Decade", "# lines"})
-	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
-	t.SetCenterSeparator("|")
-	for _, decade := range decades {
-		t.Append([]string{strconv.Itoa(decade), strconv.Itoa(decadeCount[decade])})
-	}
-	t.Render()
-
-	var metaTable bytes.Buffer
-	t = tablewriter.NewWriter(&metaTable)
-	t.SetAutoFormatHeaders(false)
-	t.SetAutoWrapText(false)
-	t.SetHeader([]string{"Title", "Date", "Archive.org", "IIIF"})
-	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
-	t.SetCenterSeparator("|")
-	t.AppendBulk(metaRows)
-	t.Render()
-
-	var out bytes.Buffer
-	tmpl := template.Must(template.New("README.md").Parse(readmeTemplate))
-	tmpl.Execute(&out, map[string]string{
-		"numLines":    strconv.Itoa(numLinesTotal),
-		"numWorks":    strconv.Itoa(len(metaFiles)),
-		"numYears":    strconv.Itoa(len(years)),
-		"decadeTable": decadesTable.String(),
-		"yearTable":   yearsTable.String(),
-		"worksTable":  metaTable.String(),
-	})
-	return out.String()
+	cacheDir, _ = filepath.Abs(cacheDir)
+	return cacheDir
 }
 
-// InitCache initializes global identifier cache
-func InitCache() {
-	cacheDir, isSet := os.LookupEnv("ARCHISCRIBE_CACHE")
-	if !isSet {
-		cacheDir = "./cache"
-	}
+// InitCache initializes global identifier cache in the given directory
+func InitCache(cacheDir string) {
 	cacheDir, _ = filepath.Abs(cacheDir)
+	log.Info().Str("cacheDir", cacheDir).Msg("Using cache directory")
 	dirStat, err := os.Stat(cacheDir)
 	if os.IsNotExist(err) {
 		os.MkdirAll(cacheDir, 0755)
@@ -213,29 +232,76 @@ func InitCache() {
 			Str("cacheDir", cacheDir).
 			Msg("Could not set up cache directory")
 	}
+	CacheDir = cacheDir
 	LineCache = NewLineImageCache(cacheDir)
+	VolumeLines = NewVolumeCache(cacheDir, VolumeCacheMaxAge)
 	idCacheFile := filepath.Join(cacheDir, "identifiers.json")
-	if _, err := os.Stat(idCacheFile); err != nil {
+	if _, err := os.Stat(idCacheFile); err != nil && Offline {
+		log.Warn().Msg("No identifiers are cached, there are no volumes to serve offline")
+		IDCache = NewIdentifierCache(idCacheFile)
+	} else if err != nil {
 		fmt.Println("Caching identifiers...")
-		cache, err := CacheIdentifiers(idCacheFile)
+		cache, err := CacheIdentifiers(idCacheFile, IdentifierSearch)
 		if err != nil {
 			panic(err)
 		}
 		IDCache = cache
 	} else {
 		IDCache = LoadIdentifierCache(idCacheFile)
+		if query := IDCache.Query(); query != "" && query != IdentifierSearch.String() {
+			log.Warn().
+				Str("cachedQuery", query).
+				Str("query", IdentifierSearch.String()).
+				Msg("Identifier cache was built from a different query, remove it to scrape again")
+		}
+		if numPruned := IDCache.Prune(); numPruned > 0 {
+			log.Info().
+				Int("numPruned", numPruned).
+				Strs("mediaTypes", MediaTypes).
+				Msg("Pruned identifiers with excluded mediatypes")
+		}
 	}
 }
 
+// JSONIndent is the indentation of JSON files written to disk, an empty
+// string produces compact single-line output
+var JSONIndent = "  "
+
+// marshalJSON serializes a value for storage on disk. Struct fields are
+// written in declaration order and map keys are sorted, so the output is
+// deterministic and produces line-oriented diffs when indented.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var out []byte
+	var err error
+	if JSONIndent == "" {
+		out, err = json.Marshal(v)
+	} else {
+		out, err = json.MarshalIndent(v, "", JSONIndent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
 // Sha1Digest generates the SHA1 digest for the given data
 func Sha1Digest(inp []byte) string {
+	return fmt.Sprintf("%x", sha1Sum(inp))[:8]
+}
+
+func sha1Sum(inp []byte) []byte {
 	hash := sha1.New()
 	hash.Write(inp)
-	return fmt.Sprintf("%x", hash.Sum(nil))[:8]
+	return hash.Sum(nil)
+}
+
+// Sha256Digest generates the full SHA-256 digest for the given data
+func Sha256Digest(inp []byte) string {
+	hash := sha256.Sum256(inp)
+	return fmt.Sprintf("%x", hash[:])
 }
 
 // MakeLineIdentifier returns the unique identifier for a line
 func MakeLineIdentifier(volumeID string, line OCRLine) string {
-	shaHash := Sha1Digest([]byte(line.ImageURL))
-	return fmt.Sprintf("%s_%s", volumeID, shaHash)
+	return fmt.Sprintf("%s_%s", volumeID, LineDigest.Digest([]byte(line.ImageURL)))
 }
@@ -3,11 +3,22 @@ package lib
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	simplejson "github.com/bitly/go-simplejson"
 	"github.com/rs/zerolog/log"
@@ -31,26 +42,171 @@ type ProgressMessage struct {
 	PageNumber int     `json:"pageNumber,omitempty"`
 	LineNumber int     `json:"lineNumber,omitempty"`
 	Error      error   `json:"error,omitempty"`
+	// The item was restricted or removed from Archive.org
+	Unavailable bool `json:"unavailable,omitempty"`
+	// Number of the retry of a failed request that is about to be made
+	Retry int `json:"retry,omitempty"`
 }
 
-func grabNext(totalOnly bool, count int, cursor string) (*Result, error) {
+// StatusError is returned when Archive.org responds with an unexpected status
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Status %d while getting %s", e.StatusCode, e.URL)
+}
+
+// Unavailable checks if the status indicates that the item was restricted
+// (403) or removed (410). Requests for these should not be retried.
+func (e *StatusError) Unavailable() bool {
+	return e.StatusCode == http.StatusForbidden || e.StatusCode == http.StatusGone
+}
+
+// IsUnavailable checks if an error was caused by an item that is no longer
+// available on Archive.org
+func IsUnavailable(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Unavailable()
+}
+
+// Number of 403 responses received for every identifier
+var forbiddenCounts = struct {
+	sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+// ShouldBlacklist decides whether an identifier should no longer be used
+// after a request for it failed. Removed items (410) are blacklisted right
+// away, restricted items (403) once a second response confirmed it, since
+// Archive.org occasionally returns 403 for items that are being processed.
+func ShouldBlacklist(ident string, err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch statusErr.StatusCode {
+	case http.StatusGone:
+		return true
+	case http.StatusForbidden:
+		forbiddenCounts.Lock()
+		defer forbiddenCounts.Unlock()
+		forbiddenCounts.counts[ident]++
+		return forbiddenCounts.counts[ident] >= 2
+	}
+	return false
+}
+
+// MediaTypes is the allowlist of Archive.org mediatypes that are considered
+// when caching identifiers
+var MediaTypes = []string{"texts"}
+
+// IdentifierQuery selects the Archive.org items whose identifiers are cached
+type IdentifierQuery struct {
+	// Archive.org search query, combined with the allowed mediatypes
+	Query string
+	// Collection the items have to be in, any collection if empty
+	Collection string
+}
+
+// DefaultIdentifierQuery selects German prints from the 19th century and
+// the first decades of the 20th
+var DefaultIdentifierQuery = IdentifierQuery{
+	Query: "language:(German) AND date:[1800-01-01 TO 1941-01-01]",
+}
+
+// IdentifierSearch is the query used for caching identifiers
+var IdentifierSearch = DefaultIdentifierQuery
+
+// String returns the full search query, as sent to Archive.org
+func (q IdentifierQuery) String() string {
+	query := fmt.Sprintf("mediatype:(%s) AND (%s)", strings.Join(MediaTypes, " OR "), q.Query)
+	if q.Collection != "" {
+		query += fmt.Sprintf(" AND collection:(%s)", q.Collection)
+	}
+	return query
+}
+
+func isAllowedMediaType(mediaType string) bool {
+	for _, allowed := range MediaTypes {
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Modes for the padding of line crops
+const (
+	PaddingFixed        = "fixed"
+	PaddingProportional = "proportional"
+)
+
+// Padding configures the margin that is added around line crops
+type Padding struct {
+	Mode string
+	// Margin in pixels, for fixed padding
+	Pixels int
+	// Margin as a fraction of the line height, for proportional padding
+	Fraction float64
+}
+
+// CropPadding is the padding applied to all line crops
+var CropPadding = Padding{Mode: PaddingFixed, Pixels: 0, Fraction: 0.15}
+
+// apply adds the margin to a line region, clamped to the page bounds
+func (p Padding) apply(x, y, width, height, pageWidth, pageHeight int) (int, int, int, int) {
+	margin := p.Pixels
+	if p.Mode == PaddingProportional {
+		margin = int(math.Round(p.Fraction * float64(height)))
+	}
+	if margin <= 0 {
+		return x, y, width, height
+	}
+	lrx := x + width + margin
+	lry := y + height + margin
+	x -= margin
+	y -= margin
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	if pageWidth > 0 && lrx > pageWidth {
+		lrx = pageWidth
+	}
+	if pageHeight > 0 && lry > pageHeight {
+		lry = pageHeight
+	}
+	return x, y, lrx - x, lry - y
+}
+
+// grabNext fetches the next page of the identifier scrape for a query. If
+// since is set, only identifiers added to Archive.org on or after that day
+// are included.
+func grabNext(query string, totalOnly bool, count int, cursor string, since time.Time) (*Result, error) {
 	params := url.Values{}
-	params.Set("q", "mediatype:(texts) AND language:(German) AND "+
-		"date:[1800-01-01 TO 1941-01-01]")
-	params.Set("fields", "identifier,imagecount,year")
+	if !since.IsZero() {
+		query += fmt.Sprintf(" AND addeddate:[%s TO null]", since.UTC().Format("2006-01-02"))
+	}
+	params.Set("q", query)
+	params.Set("fields", "identifier,imagecount,year,mediatype")
 	if totalOnly {
 		params.Set("total_only", "true")
 	} else if cursor != "" {
 		params.Set("cursor", cursor)
 	}
 	searchURL := "https://archive.org/services/search/v1/scrape?" + params.Encode()
-	resp, err := http.Get(searchURL)
+	resp, err := httpGet(context.Background(), searchURL)
 	if err != nil {
 		return nil, err
-	} else if resp.StatusCode > 200 {
-		return nil, fmt.Errorf("Status %d while getting %s", resp.StatusCode, searchURL)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode > 200 {
+		return nil, &StatusError{URL: searchURL, StatusCode: resp.StatusCode}
+	}
 	json, err := simplejson.NewFromReader(resp.Body)
 	if err != nil {
 		return nil, err
@@ -80,51 +236,214 @@ func getYear(doc *simplejson.Json) int {
 }
 
 // CacheIdentifiers scrapes the Archive.org API and caches information about
-// relevant identifiers and their number of pages
-func CacheIdentifiers(path string) (*IdentifierCache, error) {
+// the identifiers matching the query and their number of pages
+func CacheIdentifiers(path string, query IdentifierQuery) (*IdentifierCache, error) {
 	cache := NewIdentifierCache(path)
-	res, err := grabNext(true, -1, "")
+	cache.lastRefreshed = time.Now()
+	cache.query = query.String()
+	res, err := grabNext(cache.query, true, -1, "", time.Time{})
 	if err != nil {
 		return nil, err
 	}
 	numTotal := res.total
 
+	processedCount := 0
+	var cursor string
+	checkpointPath := path + ".checkpoint"
+	if checkpoint, ok := loadScrapeCheckpoint(checkpointPath, cache.query); ok {
+		log.Info().
+			Int("numProcessed", checkpoint.NumProcessed).
+			Int("numTotal", numTotal).
+			Msg("Resuming identifier scrape from checkpoint")
+		cache.entries = checkpoint.Entries
+		cursor = checkpoint.Cursor
+		processedCount = checkpoint.NumProcessed
+	}
 	progressBar := pb.New(numTotal)
 	progressBar.SetWidth(80)
 	progressBar.Start()
-	processedCount := 0
-	var cursor string
+	progressBar.Add(processedCount)
 	for processedCount < numTotal {
-		res, err := grabNext(false, 10000, cursor)
+		res, err := grabNext(cache.query, false, 10000, cursor, time.Time{})
 		if err != nil {
 			return nil, err
 		}
-		for i := 0; i < res.count; i++ {
-			itm := res.items.GetIndex(i)
-			year := getYear(itm)
-			numPages, err := itm.Get("imagecount").Int()
-			if err != nil || numPages < 50 {
-				continue
-			}
-			cache.Add(itm.Get("identifier").MustString(), numPages, year)
-		}
+		forEachSuitableItem(res, cache.Add)
 		cursor = res.cursor
 		processedCount += res.count
 		progressBar.Add(res.count)
+		checkpoint := scrapeCheckpoint{
+			Query:        cache.query,
+			Cursor:       cursor,
+			NumProcessed: processedCount,
+			Entries:      cache.entries,
+		}
+		if err := checkpoint.write(checkpointPath); err != nil {
+			log.Warn().Err(err).Msg("Could not write identifier scrape checkpoint")
+		}
+		if cursor == "" {
+			// No more results, even if the total changed in the meantime
+			break
+		}
 	}
 	cache.Write()
 	progressBar.Finish()
+	os.Remove(checkpointPath)
 	return cache, nil
 }
 
+// forEachSuitableItem calls fn for every item of a scrape result with
+// enough pages and an allowed mediatype
+func forEachSuitableItem(res *Result, fn func(ident string, numPages int, year int, mediaType string)) {
+	for i := 0; i < res.count; i++ {
+		itm := res.items.GetIndex(i)
+		year := getYear(itm)
+		numPages, err := itm.Get("imagecount").Int()
+		if err != nil || numPages < 50 {
+			continue
+		}
+		mediaType := itm.Get("mediatype").MustString()
+		if !isAllowedMediaType(mediaType) {
+			continue
+		}
+		fn(itm.Get("identifier").MustString(), numPages, year, mediaType)
+	}
+}
+
+// Overlap of incremental refreshes with the previous one, since Archive.org
+// only matches the day an identifier was added
+const refreshOverlap = 24 * time.Hour
+
+// Refresh queries Archive.org for identifiers matching the query that were
+// added since the last refresh and adds those that are not cached yet,
+// keeping all existing entries. Identifiers for which skip returns true,
+// e.g. because they were already transcribed, are not added. Caches without
+// a refresh time are compared against a full scrape. Fails if the cache was
+// built from a different query. Returns the number of added identifiers.
+func (c *IdentifierCache) Refresh(query IdentifierQuery, skip func(ident string) bool) (int, error) {
+	started := time.Now()
+	queryString := query.String()
+	if cached := c.Query(); cached != "" && cached != queryString {
+		return 0, fmt.Errorf("identifier cache was built from the query %q, not %q", cached, queryString)
+	}
+	var since time.Time
+	if last := c.LastRefreshed(); !last.IsZero() {
+		since = last.Add(-refreshOverlap)
+	}
+	found := make(map[int][]IdentifierCacheEntry)
+	cursor := ""
+	for {
+		res, err := grabNext(queryString, false, 10000, cursor, since)
+		if err != nil {
+			return 0, err
+		}
+		forEachSuitableItem(res, func(ident string, numPages int, year int, mediaType string) {
+			found[year] = append(found[year], IdentifierCacheEntry{
+				Identifier: ident,
+				NumPages:   numPages,
+				MediaType:  mediaType})
+		})
+		if cursor = res.cursor; cursor == "" {
+			break
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	known := make(map[string]bool)
+	for _, entries := range c.entries {
+		for _, entry := range entries {
+			known[entry.Identifier] = true
+		}
+	}
+	numAdded := 0
+	for year, entries := range found {
+		for _, entry := range entries {
+			if known[entry.Identifier] || (skip != nil && skip(entry.Identifier)) {
+				continue
+			}
+			known[entry.Identifier] = true
+			c.entries[year] = append(c.entries[year], entry)
+			numAdded++
+		}
+	}
+	c.lastRefreshed = started
+	c.query = queryString
+	c.write()
+	return numAdded, nil
+}
+
+// RefreshIdentifierCache refreshes the global identifier cache, skipping
+// volumes that were already transcribed into the store. The store may be
+// nil.
+func RefreshIdentifierCache(store *DocumentStore) (int, error) {
+	transcribed := make(map[string]bool)
+	if store != nil {
+		idents, err := store.Identifiers()
+		if err != nil {
+			return 0, err
+		}
+		for _, ident := range idents {
+			transcribed[ident] = true
+		}
+	}
+	return IDCache.Refresh(IdentifierSearch, func(ident string) bool {
+		return transcribed[ident]
+	})
+}
+
+// scrapeCheckpoint holds the progress of an interrupted identifier scrape
+type scrapeCheckpoint struct {
+	Query        string                         `json:"query"`
+	Cursor       string                         `json:"cursor"`
+	NumProcessed int                            `json:"numProcessed"`
+	Entries      map[int][]IdentifierCacheEntry `json:"entries"`
+}
+
+// loadScrapeCheckpoint loads the checkpoint of a scrape for the query.
+// Checkpoints of scrapes for other queries are ignored.
+func loadScrapeCheckpoint(path string, query string) (*scrapeCheckpoint, bool) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var checkpoint scrapeCheckpoint
+	if err := json.Unmarshal(raw, &checkpoint); err != nil || checkpoint.Cursor == "" {
+		log.Warn().Err(err).Str("path", path).Msg("Ignoring unusable scrape checkpoint")
+		return nil, false
+	}
+	if checkpoint.Query != "" && checkpoint.Query != query {
+		log.Warn().
+			Str("path", path).
+			Str("query", checkpoint.Query).
+			Msg("Ignoring scrape checkpoint for a different query")
+		return nil, false
+	}
+	if checkpoint.Entries == nil {
+		checkpoint.Entries = map[int][]IdentifierCacheEntry{}
+	}
+	return &checkpoint, true
+}
+
+// write stores the checkpoint atomically, so an interruption while writing
+// leaves the previous checkpoint intact
+func (c scrapeCheckpoint) write(path string) error {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, raw)
+}
+
 // GetMetadata fetches metadata for identifier from Archive.org
 func GetMetadata(ident string) (*simplejson.Json, error) {
 	metaURL := "https://archive.org/metadata/" + ident
-	resp, err := http.Get(metaURL)
+	resp, err := httpGet(context.Background(), metaURL)
 	if err != nil {
 		return nil, err
-	} else if resp.StatusCode > 200 {
-		return nil, fmt.Errorf("Status %d while getting %s", resp.StatusCode, metaURL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 200 {
+		return nil, &StatusError{URL: metaURL, StatusCode: resp.StatusCode}
 	}
 	json, err := simplejson.NewFromReader(resp.Body)
 	if err != nil {
@@ -133,146 +452,508 @@ func GetMetadata(ident string) (*simplejson.Json, error) {
 	return json.Get("metadata"), nil
 }
 
+// Scripts that identifiers can be classified as
+const (
+	ScriptFraktur = "fraktur"
+	ScriptAntiqua = "antiqua"
+	// Matches volumes in any script
+	ScriptAny = "any"
+)
+
+// ScriptHeuristicVersion is the version of the script classification
+// heuristic. Bump this when changing the heuristic, identifiers classified
+// with an older version are then classified again.
+const ScriptHeuristicVersion = 2
+
+// FrakturThreshold is the minimum Fraktur confidence for classifying an
+// identifier as set in Fraktur
+var FrakturThreshold = 0.5
+
+// Number of "ist" words, correct or misread, that are needed for a Fraktur
+// confidence. Sampling stops after maxScriptSamples of them.
+const (
+	minScriptSamples = 6
+	maxScriptSamples = 200
+)
+
+// ClassifyScript determines the script a given identifier is set in
+func ClassifyScript(ident string) (string, error) {
+	isFrak, err := IsFraktur(ident)
+	if err != nil {
+		return "", err
+	}
+	if isFrak {
+		return ScriptFraktur, nil
+	}
+	return ScriptAntiqua, nil
+}
+
 // IsFraktur uses heuristics to determine wheter a given identifier is
-// set in a Fraktur typeface
+// set in a Fraktur typeface, with a confidence of at least FrakturThreshold
 func IsFraktur(ident string) (bool, error) {
+	conf, err := FrakturConfidence(ident)
+	if err != nil {
+		return false, err
+	}
+	return conf >= FrakturThreshold, nil
+}
+
+// FrakturConfidence estimates how likely an identifier is set in a Fraktur
+// typeface, from 0 to 1. Archive.org's OCR misreads the long s of Fraktur as
+// an f, so the confidence is the ratio of "ist" that were read as "ift".
+// Identifiers with too few occurrences to tell have a confidence of zero.
+func FrakturConfidence(ident string) (float64, error) {
 	ocrURL := fmt.Sprintf("https://archive.org/download/%s/%s_djvu.txt",
 		ident, ident)
-	resp, err := http.Get(ocrURL)
+	resp, err := httpGet(context.Background(), ocrURL)
+	var scanner *bufio.Scanner
 	if err != nil {
-		return false, err
+		return 0, err
+	} else if resp.StatusCode == http.StatusNotFound && FallbackOCR.Enabled() {
+		resp.Body.Close()
+		if scanner, err = hookText(ident); err != nil {
+			return 0, err
+		}
 	} else if resp.StatusCode > 200 {
-		return false, fmt.Errorf("Status %d while getting %s", resp.StatusCode, ocrURL)
+		resp.Body.Close()
+		return 0, &StatusError{URL: ocrURL, StatusCode: resp.StatusCode}
+	} else {
+		defer resp.Body.Close()
+		scanner = bufio.NewScanner(resp.Body)
+		scanner.Split(bufio.ScanWords)
 	}
-	defer resp.Body.Close()
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Split(bufio.ScanWords)
+	return frakturScore(scanner), nil
+}
+
+// frakturScore computes the Fraktur confidence from the words of a text
+func frakturScore(scanner *bufio.Scanner) float64 {
 	numIft := 0
-	var curToken string
-	for scanner.Scan() && numIft <= 5 {
-		curToken = scanner.Text()
-		if curToken == "ift" {
+	numIst := 0
+	for scanner.Scan() && numIft+numIst < maxScriptSamples {
+		switch scanner.Text() {
+		case "ift", "iſt":
 			numIft++
+		case "ist":
+			numIst++
 		}
 	}
-	return numIft > 5, nil
+	if numIft+numIst < minScriptSamples {
+		return 0
+	}
+	return float64(numIft) / float64(numIft+numIst)
 }
 
 // GetStartPageNumber determines whether an identifier's first page has
 // index 0 or 1
 func GetStartPageNumber(ident string) int {
-	infoURL := fmt.Sprintf("https://iiif.archivelab.org/iiif/%s$0/info.json",
-		ident)
-	resp, err := http.Get(infoURL)
+	return getStartPageNumber(context.Background(), ident)
+}
+
+func getStartPageNumber(ctx context.Context, ident string) int {
+	infoURL := IIIFImage.InfoURL(ident, 0)
+	resp, err := httpGet(ctx, infoURL)
 	if err != nil {
 		return 0
-	} else if resp.StatusCode > 200 {
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 200 {
 		return 1
 	}
 	return 0
 }
 
-func fetchLinesWorker(ident string, minLineWidth int, progressChan chan ProgressMessage, linesChan chan []OCRLine) {
-	log.Info().
-		Str("archiveId", ident).
-		Msg("Getting ABBY OCR")
-	boxURL := fmt.Sprintf("https://archive.org/download/%s/%s_abbyy.gz",
-		ident, ident)
-	resp, err := http.Get(boxURL)
+// sendProgress sends a progress message, returns false if the context was
+// cancelled before it was received
+func sendProgress(ctx context.Context, progressChan chan ProgressMessage, msg ProgressMessage) bool {
+	select {
+	case progressChan <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendLines sends the fetched lines and closes both channels, unless the
+// context was cancelled before the lines were received
+func sendLines(ctx context.Context, progressChan chan ProgressMessage, linesChan chan []OCRLine, lines []OCRLine) {
+	select {
+	case linesChan <- lines:
+		close(linesChan)
+		close(progressChan)
+	case <-ctx.Done():
+	}
+}
+
+// ocrPage describes the page of a volume that OCR lines are read from
+type ocrPage struct {
+	ident  string
+	number int
+	width  int
+	height int
+}
+
+// appendLine creates a line from the bounding box of an OCR line on the page
+// and links it with the previous line. Lines that are too narrow or are
+// likely part of the page footer are skipped.
+func (p ocrPage) appendLine(lines []OCRLine, x, y, lrx, lry, minLineWidth int) ([]OCRLine, bool) {
+	width := lrx - x
+	height := lry - y
+	relX := float64(x) / float64(p.width)
+	relY := float64(y) / float64(p.height)
+	if width < minLineWidth || (relX > 0.65 && relY > 0.90) {
+		return lines, false
+	}
+	left, top := x, y
+	x, y, width, height = CropPadding.apply(x, y, width, height, p.width, p.height)
+	iiifURL := IIIFImage.RegionURL(p.ident, p.number, x, y, width, height, "png")
+	l := OCRLine{
+		Identifier: LineDigest.Digest([]byte(iiifURL)),
+		ImageURL:   iiifURL,
+		Page:       &PageGeometry{Number: p.number, Width: p.width, Height: p.height},
+		BBox:       &BBox{Left: left, Top: top, Right: lrx, Bottom: lry},
+	}
+	if len(lines) > 0 {
+		lines[len(lines)-1].NextImageURL = iiifURL
+		l.PreviousImageURL = lines[len(lines)-1].ImageURL
+	}
+	return append(lines, l), true
+}
+
+// abbyyParser extracts lines from the ABBYY OCR files of a volume. Page
+// numbers continue across files, so volumes whose OCR is split into several
+// parts are numbered like a single file.
+type abbyyParser struct {
+	ctx          context.Context
+	ident        string
+	minLineWidth int
+	progressChan chan ProgressMessage
+	lines        []OCRLine
+	// Sum and number of character confidences for every line
+	confSums   []int
+	confCounts []int
+	pageNo     int
+	numLines   int
+}
+
+func newAbbyyParser(ctx context.Context, ident string, minLineWidth int, progressChan chan ProgressMessage) *abbyyParser {
+	return &abbyyParser{
+		ctx:          ctx,
+		ident:        ident,
+		minLineWidth: minLineWidth,
+		progressChan: progressChan,
+		lines:        make([]OCRLine, 0),
+		pageNo:       getStartPageNumber(ctx, ident) - 1,
+	}
+}
+
+// parse reads the lines from a single gzipped ABBYY file, which is the
+// part with index partIdx of numParts
+func (p *abbyyParser) parse(body io.Reader, numBytesTotal int64, partIdx int, numParts int) error {
+	progReader := NewProgressReader(body)
+	defer func() {
+		ocrBytesDownloaded.Add(float64(progReader.BytesRead))
+	}()
+	gzReader, err := gzip.NewReader(progReader)
 	if err != nil {
-		progressChan <- ProgressMessage{Error: err, Step: "fetch"}
-		return
-	} else if resp.StatusCode > 200 {
-		progressChan <- ProgressMessage{
-			Error: fmt.Errorf("Status %d while getting %s", resp.StatusCode, boxURL),
-			Step:  "fetch"}
-		return
+		return err
 	}
-	numBytesTotal := resp.ContentLength
-	log.Info().
-		Str("archiveId", ident).
-		Int64("numBytes", numBytesTotal).
-		Msg("Parsing lines from ABBYY OCR")
-	progReader := NewProgressReader(resp.Body)
-	gzReader, _ := gzip.NewReader(progReader)
-	defer resp.Body.Close()
 	defer gzReader.Close()
 	lineScanner := bufio.NewScanner(gzReader)
 	lineScanner.Split(bufio.ScanLines)
 	buf := make([]byte, 64*1024)
 	lineScanner.Buffer(buf, 16*1024*1024)
-	lines := make([]OCRLine, 0)
-	numLines := 0
-	currentPageNo := GetStartPageNumber(ident) - 1
 	pageWidth := -1
 	pageHeight := -1
 	progPercent := 0
+	// Index of the line whose characters are currently read
+	textIdx := -1
 	for lineScanner.Scan() {
-		numLines++
+		p.numLines++
 		line := lineScanner.Text()
 		if strings.Contains(line, "<page") {
 			match := pagePat.FindStringSubmatch(line)
 			pageWidth, _ = strconv.Atoi(match[1])
 			pageHeight, _ = strconv.Atoi(match[2])
-			currentPageNo++
+			p.pageNo++
 		}
-		if !strings.Contains(line, "<line") {
-			continue
-		}
-		prct := int(100. * float64(progReader.BytesRead) / float64(numBytesTotal))
-		if prct > progPercent {
-			progPercent = prct
-			progressChan <- ProgressMessage{
-				Step:       "fetch",
-				Progress:   float64(progReader.BytesRead) / float64(numBytesTotal),
-				BytesTotal: numBytesTotal,
-				BytesRead:  progReader.BytesRead,
-				PageNumber: currentPageNo,
-				LineNumber: numLines,
-				Error:      nil,
+		if strings.Contains(line, "<line") {
+			partProgress := float64(progReader.BytesRead) / float64(numBytesTotal)
+			prct := int(100. * partProgress)
+			if prct > progPercent {
+				progPercent = prct
+				sent := sendProgress(p.ctx, p.progressChan, ProgressMessage{
+					Step:       "fetch",
+					Progress:   (float64(partIdx) + partProgress) / float64(numParts),
+					BytesTotal: numBytesTotal,
+					BytesRead:  progReader.BytesRead,
+					PageNumber: p.pageNo,
+					LineNumber: p.numLines,
+					Error:      nil,
+				})
+				if !sent {
+					return p.ctx.Err()
+				}
 			}
 		}
-		if currentPageNo <= 10 { // TODO: Should be dynamic or from constant
+		if p.pageNo <= 10 { // TODO: Should be dynamic or from constant
 			continue
 		}
-		matches := linePat.FindAllStringSubmatch(line, -1)
-		for _, match := range matches {
+		for _, token := range ocrTokenPat.FindAllStringSubmatch(line, -1) {
+			if strings.HasPrefix(token[0], "</line") {
+				textIdx = -1
+				continue
+			} else if strings.HasPrefix(token[0], "<charParams") {
+				if textIdx >= 0 {
+					p.lines[textIdx].OCRText += html.UnescapeString(token[2])
+					if conf := confidencePat.FindStringSubmatch(token[1]); conf != nil {
+						val, _ := strconv.Atoi(conf[1])
+						p.confSums[textIdx] += val
+						p.confCounts[textIdx]++
+					}
+				}
+				continue
+			}
+			textIdx = -1
+			match := linePat.FindStringSubmatch(token[0])
+			if match == nil {
+				continue
+			}
 			x, _ := strconv.Atoi(match[1])
 			y, _ := strconv.Atoi(match[2])
 			lrx, _ := strconv.Atoi(match[3])
 			lry, _ := strconv.Atoi(match[4])
-			width := lrx - x
-			height := lry - y
-			relX := float64(x) / float64(pageWidth)
-			relY := float64(y) / float64(pageHeight)
-			if width < minLineWidth || (relX > 0.65 && relY > 0.90) {
+			page := ocrPage{p.ident, p.pageNo, pageWidth, pageHeight}
+			var added bool
+			if p.lines, added = page.appendLine(p.lines, x, y, lrx, lry, p.minLineWidth); !added {
 				continue
 			}
-			iiifURL := fmt.Sprintf(
-				"https://iiif.archivelab.org/iiif/%s$%d/%d,%d,%d,%d/full/0/default.png",
-				ident, currentPageNo, x, y, width, height)
-			if len(lines) > 0 {
-				lines[len(lines)-1].NextImageURL = iiifURL
-			}
-			l := OCRLine{
-				Identifier: Sha1Digest([]byte(iiifURL)),
-				ImageURL:   iiifURL,
+			p.confSums = append(p.confSums, 0)
+			p.confCounts = append(p.confCounts, 0)
+			textIdx = len(p.lines) - 1
+		}
+	}
+	return lineScanner.Err()
+}
+
+// finish returns all parsed lines with their OCR text and confidence
+func (p *abbyyParser) finish() []OCRLine {
+	for idx := range p.lines {
+		p.lines[idx].OCRText = strings.TrimSpace(p.lines[idx].OCRText)
+		if p.confCounts[idx] > 0 {
+			// ABBYY reports confidences from 0 to 100
+			p.lines[idx].OCRConfidence = float64(p.confSums[idx]) / float64(p.confCounts[idx]) / 100.
+		}
+	}
+	return p.lines
+}
+
+// getOCRParts returns the names of all ABBYY OCR files of an item in page
+// order. Most items only have <ident>_abbyy.gz, but multi-part works can
+// have one file per part.
+func getOCRParts(ctx context.Context, ident string) []string {
+	defaultParts := []string{ident + "_abbyy.gz"}
+	filesURL := fmt.Sprintf("https://archive.org/metadata/%s/files", ident)
+	resp, err := httpGet(ctx, filesURL)
+	if err != nil {
+		return defaultParts
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 200 {
+		return defaultParts
+	}
+	json, err := simplejson.NewFromReader(resp.Body)
+	if err != nil {
+		return defaultParts
+	}
+	parts := make([]string, 0)
+	for _, file := range json.Get("result").MustArray() {
+		fileMap, ok := file.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, _ := fileMap["name"].(string); strings.HasSuffix(name, "_abbyy.gz") {
+			parts = append(parts, name)
+		}
+	}
+	if len(parts) == 0 {
+		return defaultParts
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return naturalLess(parts[i], parts[j])
+	})
+	return parts
+}
+
+// naturalLess compares strings with embedded numbers by their numeric value,
+// so that part_2 sorts before part_10
+func naturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		aNum, aRest := splitNumber(a)
+		bNum, bRest := splitNumber(b)
+		if aNum >= 0 && bNum >= 0 {
+			if aNum != bNum {
+				return aNum < bNum
 			}
-			if len(lines) > 0 {
-				l.PreviousImageURL = lines[len(lines)-1].ImageURL
+			a, b = aRest, bRest
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// splitNumber parses a leading number, returning -1 if there is none
+func splitNumber(s string) (int, string) {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return -1, s
+	}
+	num, _ := strconv.Atoi(s[:end])
+	return num, s[end:]
+}
+
+// fetchLinesWorker parses the ABBYY OCR of an item and sends the lines. It
+// returns without closing the channels once the context is cancelled.
+func fetchLinesWorker(ctx context.Context, ident string, minLineWidth int, progressChan chan ProgressMessage, linesChan chan []OCRLine) {
+	parts := getOCRParts(ctx, ident)
+	parser := newAbbyyParser(ctx, ident, minLineWidth, progressChan)
+	for partIdx, part := range parts {
+		log.Debug().
+			Str("archiveId", ident).
+			Str("file", part).
+			Msg("Getting ABBY OCR")
+		boxURL := fmt.Sprintf("https://archive.org/download/%s/%s", ident, part)
+		resp, err := httpGetRetry(ctx, boxURL, func(retry int, err error) {
+			sendProgress(ctx, progressChan, ProgressMessage{
+				Step:     "fetch",
+				Progress: float64(partIdx) / float64(len(parts)),
+				Retry:    retry,
+			})
+		})
+		if err != nil {
+			sendFetchError(ctx, ident, progressChan, ProgressMessage{Error: err, Step: "fetch"})
+			return
+		} else if resp.StatusCode == http.StatusNotFound && len(parts) == 1 {
+			resp.Body.Close()
+			if FallbackOCR.Enabled() {
+				fetchLinesWithHook(ctx, ident, minLineWidth, progressChan, linesChan)
+				return
 			}
-			lines = append(lines, l)
+			sendFetchError(ctx, ident, progressChan, ProgressMessage{Error: ErrNoOCR, Step: "fetch"})
+			return
+		} else if resp.StatusCode > 200 {
+			resp.Body.Close()
+			err := &StatusError{URL: boxURL, StatusCode: resp.StatusCode}
+			sendFetchError(ctx, ident, progressChan, ProgressMessage{
+				Error:       err,
+				Step:        "fetch",
+				Unavailable: err.Unavailable()})
+			return
+		}
+		log.Debug().
+			Str("archiveId", ident).
+			Int64("numBytes", resp.ContentLength).
+			Int("part", partIdx+1).
+			Int("numParts", len(parts)).
+			Msg("Parsing lines from ABBYY OCR")
+		err = parser.parse(resp.Body, resp.ContentLength, partIdx, len(parts))
+		resp.Body.Close()
+		if err != nil {
+			sendFetchError(ctx, ident, progressChan, ProgressMessage{Error: err, Step: "fetch"})
+			return
 		}
 	}
-	linesChan <- lines
-	close(linesChan)
-	close(progressChan)
+	lines := parser.finish()
+	Blocked.RecordSuccess(ident)
+	cacheVolumeLines(ident, lines)
+	warnIdentifierCollisions(ident, lines)
+	addContextLines(lines)
+	sendLines(ctx, progressChan, linesChan, lines)
+}
+
+// cacheVolumeLines stores freshly parsed lines in the volume cache, if set up
+func cacheVolumeLines(ident string, lines []OCRLine) {
+	if VolumeLines == nil {
+		return
+	}
+	if err := VolumeLines.Put(ident, lines); err != nil {
+		log.Error().
+			Err(err).
+			Str("archiveId", ident).
+			Msg("Could not cache lines")
+	}
 }
 
 // FetchLines fetches OCR lines for a given Archive.org identifier
 func FetchLines(ident string) (chan ProgressMessage, chan []OCRLine) {
+	return FetchLinesContext(context.Background(), ident)
+}
+
+// FetchLinesContext is like FetchLines, but stops fetching when the context
+// is cancelled. The channels are not closed in that case.
+func FetchLinesContext(ctx context.Context, ident string) (chan ProgressMessage, chan []OCRLine) {
 	progressChan := make(chan ProgressMessage)
 	lineChan := make(chan []OCRLine)
-	go fetchLinesWorker(ident, 200, progressChan, lineChan)
+	if VolumeLines != nil {
+		if lines, ok := VolumeLines.Get(ident); ok {
+			log.Info().
+				Str("archiveId", ident).
+				Int("numLines", len(lines)).
+				Msg("Using cached lines")
+			addContextLines(lines)
+			go sendLines(ctx, progressChan, lineChan, lines)
+			return progressChan, lineChan
+		}
+	}
+	go fetchLinesWorker(ctx, ident, 200, progressChan, lineChan)
 	return progressChan, lineChan
 }
+
+// FetchAllLines fetches the OCR lines for a given Archive.org identifier and
+// waits until all of them have been parsed
+func FetchAllLines(ident string) ([]OCRLine, error) {
+	return FetchAllLinesContext(context.Background(), ident)
+}
+
+// FetchAllLinesContext is like FetchAllLines, but returns the context's error
+// when it is cancelled before all lines have been parsed
+func FetchAllLinesContext(ctx context.Context, ident string) ([]OCRLine, error) {
+	progressChan, lineChan := FetchLinesContext(ctx, ident)
+	return collectLines(ctx, ident, progressChan, lineChan)
+}
+
+// fetchAllLinesUncached is like FetchAllLines, but always parses the OCR
+// instead of using the volume cache
+func fetchAllLinesUncached(ident string) ([]OCRLine, error) {
+	progressChan := make(chan ProgressMessage)
+	lineChan := make(chan []OCRLine)
+	ctx := context.Background()
+	go fetchLinesWorker(ctx, ident, 200, progressChan, lineChan)
+	return collectLines(ctx, ident, progressChan, lineChan)
+}
+
+func collectLines(ctx context.Context, ident string, progressChan chan ProgressMessage, lineChan chan []OCRLine) ([]OCRLine, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case progMsg, ok := <-progressChan:
+			if !ok {
+				progressChan = nil
+			} else if progMsg.Error != nil {
+				return nil, progMsg.Error
+			}
+		case lines, ok := <-lineChan:
+			if !ok {
+				return nil, fmt.Errorf("no lines received for %s", ident)
+			}
+			return lines, nil
+		}
+	}
+}
@@ -0,0 +1,667 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// abbyyFixture returns a gzipped ABBYY file with the given number of pages
+// and lines per page. Lines on the first ten pages are skipped by the
+// parser, like front matter.
+func abbyyFixture(t testing.TB, numPages int, linesPerPage int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	fmt.Fprintln(gz, `<document>`)
+	for page := 0; page < numPages; page++ {
+		fmt.Fprintln(gz, `<page width="2000" height="3000" resolution="300">`)
+		for line := 0; line < linesPerPage; line++ {
+			top := 200 + line*60
+			fmt.Fprintf(gz, `<line baseline="%d" l="150" t="%d" r="1700" b="%d">`, top+40, top, top+50)
+			fmt.Fprintf(gz, `<charParams charConfidence="90">Z</charParams>`)
+			fmt.Fprintf(gz, `<charParams charConfidence="80">eile %d</charParams>`, line)
+			fmt.Fprintln(gz, `</line>`)
+		}
+		fmt.Fprintln(gz, `</page>`)
+	}
+	fmt.Fprintln(gz, `</document>`)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// archiveServer serves the files list and OCR of a volume in two parts like
+// Archive.org, with all pages in the first part. Every other request is
+// answered with a line image.
+func archiveServer(t testing.TB, ident string, numPages int, linesPerPage int) *httptest.Server {
+	t.Helper()
+	return partsServer(t, ident, linesPerPage, numPages, 0)
+}
+
+// partsServer is like archiveServer, with the OCR split into parts with the
+// given numbers of pages. The files list names the parts in reverse order.
+func partsServer(t testing.TB, ident string, linesPerPage int, partPages ...int) *httptest.Server {
+	t.Helper()
+	parts := make(map[string][]byte, len(partPages))
+	var files []string
+	for idx, numPages := range partPages {
+		name := fmt.Sprintf("%s_%d_abbyy.gz", ident, idx+1)
+		parts["/download/"+ident+"/"+name] = abbyyFixture(t, numPages, linesPerPage)
+		files = append([]string{fmt.Sprintf(`{"name": %q}`, name)}, files...)
+	}
+	image := testPNG(t, 1550, 50)
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if part, ok := parts[r.URL.Path]; ok {
+			w.Write(part)
+			return
+		}
+		switch r.URL.Path {
+		case "/metadata/" + ident + "/files":
+			fmt.Fprintf(w, `{"result": [%s]}`, strings.Join(files, ", "))
+		default:
+			if strings.HasSuffix(r.URL.Path, "/info.json") {
+				fmt.Fprint(w, `{}`)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(image)
+		}
+	}))
+}
+
+// routeToServer sends all outbound requests to the test server with the
+// given transport for the duration of the test and returns the counter of
+// opened connections
+func routeToServer(t testing.TB, server *httptest.Server, transport *http.Transport) *int64 {
+	t.Helper()
+	var dials int64
+	dialer := &net.Dialer{}
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		atomic.AddInt64(&dials, 1)
+		return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	// The server's certificate is not valid for the hosts of Archive.org
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	previousBase, previousRate := httpTransport.base, requestRate
+	httpTransport.base = transport
+	SetMaxRequestsPerSecond(0)
+	t.Cleanup(func() {
+		transport.CloseIdleConnections()
+		httpTransport.base, requestRate = previousBase, previousRate
+	})
+	return &dials
+}
+
+func TestFetchVolume(t *testing.T) {
+	const ident = "wochenblatt_1848"
+	server := archiveServer(t, ident, 14, 5)
+	defer server.Close()
+	routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+
+	lines, err := fetchAllLinesUncached(ident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages are numbered from zero, the first eleven are skipped
+	if len(lines) != 3*5 {
+		t.Fatalf("expected 15 lines, got %d", len(lines))
+	}
+	if lines[0].OCRText != "Zeile 0" || lines[0].OCRConfidence != 0.85 {
+		t.Errorf("unexpected first line %+v", lines[0])
+	}
+	if lines[0].Page == nil || lines[0].Page.Number != 11 {
+		t.Errorf("expected the first line on page 11, got %+v", lines[0].Page)
+	}
+}
+
+func TestFetchVolumeFromParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		partPages []int
+		// Expected number of lines on every page after the front matter
+		wantPages []int
+	}{
+		{"single part", []int{13}, []int{11, 12}},
+		{"page break between parts", []int{12, 2}, []int{11, 12, 13}},
+		{"front matter in the first part", []int{8, 5}, []int{11, 12}},
+		{"ten parts", []int{3, 3, 3, 3, 1, 1, 1, 1, 1, 1}, []int{11, 12, 13, 14, 15, 16, 17}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			const ident = "konversationslexikon_1877"
+			server := partsServer(t, ident, 2, tc.partPages...)
+			defer server.Close()
+			routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+
+			lines, err := fetchAllLinesUncached(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != 2*len(tc.wantPages) {
+				t.Fatalf("expected %d lines, got %d", 2*len(tc.wantPages), len(lines))
+			}
+			for idx, line := range lines {
+				want := tc.wantPages[idx/2]
+				if line.Page == nil || line.Page.Number != want {
+					t.Errorf("line %d is on page %+v, want %d", idx, line.Page, want)
+				}
+				if region, ok := parseRegion(line.ImageURL); !ok || region.Page != want {
+					t.Errorf("line %d is cropped from %s, want page %d", idx, line.ImageURL, want)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFetchVolume fetches the OCR of a volume and caches all of its
+// line images over HTTPS, once with the pooled transport and once with the
+// default one that only keeps two idle connections per host
+func BenchmarkFetchVolume(b *testing.B) {
+	const ident = "wochenblatt_1849"
+	server := archiveServer(b, ident, 30, 20)
+	defer server.Close()
+	transports := []struct {
+		name      string
+		transport func() *http.Transport
+	}{
+		{"pooled", func() *http.Transport { return newPooledTransport(DefaultMaxConcurrentRequests) }},
+		{"default", func() *http.Transport { return http.DefaultTransport.(*http.Transport).Clone() }},
+	}
+	for _, tc := range transports {
+		b.Run(tc.name, func(b *testing.B) {
+			transport := tc.transport()
+			dials := routeToServer(b, server, transport)
+			cache := NewLineImageCache(b.TempDir())
+			maxDownloads := MaxImageDownloads
+			MaxImageDownloads = DefaultMaxConcurrentRequests
+			defer func() { MaxImageDownloads = maxDownloads }()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				lines, err := fetchAllLinesUncached(ident)
+				if err != nil {
+					b.Fatal(err)
+				}
+				cache.CacheLines(lines, ident, MinYear)
+				b.StopTimer()
+				if err := cache.PurgeLines(ident); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(dials))/float64(b.N), "conns/op")
+		})
+	}
+}
+
+// searchItem is an item of a stubbed Archive.org search
+type searchItem struct {
+	Identifier string `json:"identifier"`
+	Year       string `json:"year"`
+	ImageCount int    `json:"imagecount"`
+	MediaType  string `json:"mediatype"`
+}
+
+// searchStub answers identifier scrapes with its items, pageSize at a time
+type searchStub struct {
+	sync.Mutex
+	items    []searchItem
+	pageSize int
+	// Page that is answered with an error, none if negative
+	failPage int
+	// Queries of all scrape requests
+	queries []string
+	// Cursors of all requests for result pages
+	cursors []string
+}
+
+func (s *searchStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	params := r.URL.Query()
+	s.queries = append(s.queries, params.Get("q"))
+	if params.Get("total_only") == "true" {
+		fmt.Fprintf(w, `{"total": %d}`, len(s.items))
+		return
+	}
+	s.cursors = append(s.cursors, params.Get("cursor"))
+	start, _ := strconv.Atoi(params.Get("cursor"))
+	if s.failPage >= 0 && start/s.pageSize == s.failPage {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	end := start + s.pageSize
+	if end > len(s.items) {
+		end = len(s.items)
+	}
+	page := map[string]interface{}{"items": s.items[start:end], "count": end - start, "total": len(s.items)}
+	if end < len(s.items) {
+		page["cursor"] = strconv.Itoa(end)
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// cachedIdentifiers returns the sorted identifiers of a cache
+func cachedIdentifiers(cache *IdentifierCache) []string {
+	var idents []string
+	for _, entries := range cache.entries {
+		for _, entry := range entries {
+			idents = append(idents, entry.Identifier)
+		}
+	}
+	sort.Strings(idents)
+	return idents
+}
+
+func TestCacheIdentifiersFiltersMediaTypes(t *testing.T) {
+	tests := []struct {
+		name       string
+		mediaTypes []string
+		want       []string
+	}{
+		{"texts only", []string{"texts"}, []string{"chronik_1860", "predigten_1861"}},
+		{"texts and audio", []string{"texts", "audio"}, []string{"chronik_1860", "lieder_1862", "predigten_1861"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := MediaTypes
+			MediaTypes = tc.mediaTypes
+			defer func() { MediaTypes = previous }()
+			stub := &searchStub{pageSize: 2, failPage: -1, items: []searchItem{
+				{"chronik_1860", "1860", 120, "texts"},
+				{"lieder_1862", "1862", 80, "audio"},
+				{"predigten_1861", "1861", 300, "texts"},
+				{"spiel_1863", "1863", 60, "software"},
+				{"flugblatt_1864", "1864", 2, "texts"},
+			}}
+			server := httptest.NewTLSServer(stub)
+			defer server.Close()
+			routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+
+			cache, err := CacheIdentifiers(filepath.Join(t.TempDir(), "identifiers.json"), DefaultIdentifierQuery)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Items with too few pages are skipped as well
+			if got := cachedIdentifiers(cache); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("cached %v, want %v", got, tc.want)
+			}
+			for _, query := range stub.queries {
+				if !strings.HasPrefix(query, "mediatype:("+strings.Join(tc.mediaTypes, " OR ")+")") {
+					t.Errorf("search was not restricted to the allowed mediatypes: %s", query)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheIdentifiersResumesFromCheckpoint(t *testing.T) {
+	items := []searchItem{
+		{"anzeiger_1850", "1850", 120, "texts"},
+		{"bote_1851", "1851", 120, "texts"},
+		{"chronik_1852", "1852", 120, "texts"},
+		{"dorfzeitung_1853", "1853", 120, "texts"},
+		{"echo_1854", "1854", 120, "texts"},
+		{"fackel_1855", "1855", 120, "texts"},
+		{"grenzbote_1856", "1856", 120, "texts"},
+	}
+	var want []string
+	for _, item := range items {
+		want = append(want, item.Identifier)
+	}
+	tests := []struct {
+		name     string
+		failPage int
+		// Cursor from which the second scrape continues
+		resumeCursor string
+	}{
+		{"interrupted on the second page", 1, "2"},
+		{"interrupted on the third page", 2, "4"},
+		{"interrupted on the last page", 3, "6"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := &searchStub{pageSize: 2, failPage: tc.failPage, items: items}
+			server := httptest.NewTLSServer(stub)
+			defer server.Close()
+			routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+			path := filepath.Join(t.TempDir(), "identifiers.json")
+
+			if _, err := CacheIdentifiers(path, DefaultIdentifierQuery); err == nil {
+				t.Fatal("expected the interrupted scrape to fail")
+			}
+			if _, err := os.Stat(path + ".checkpoint"); err != nil {
+				t.Fatalf("no checkpoint after the interruption: %v", err)
+			}
+			stub.failPage = -1
+			stub.cursors = nil
+			cache, err := CacheIdentifiers(path, DefaultIdentifierQuery)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cachedIdentifiers(cache); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("cached %v, want %v", got, want)
+			}
+			if got := cachedIdentifiers(LoadIdentifierCache(path)); len(got) != len(want) {
+				t.Errorf("wrote %d identifiers, want %d", len(got), len(want))
+			}
+			if len(stub.cursors) == 0 || stub.cursors[0] != tc.resumeCursor {
+				t.Errorf("resumed with cursors %v, want to start at %s", stub.cursors, tc.resumeCursor)
+			}
+			if _, err := os.Stat(path + ".checkpoint"); !os.IsNotExist(err) {
+				t.Errorf("checkpoint was not removed after the scrape: %v", err)
+			}
+		})
+	}
+}
+
+func TestCacheIdentifiersIgnoresCheckpointOfOtherQuery(t *testing.T) {
+	stub := &searchStub{pageSize: 2, failPage: 1, items: []searchItem{
+		{"anzeiger_1850", "1850", 120, "texts"},
+		{"bote_1851", "1851", 120, "texts"},
+		{"chronik_1852", "1852", 120, "texts"},
+	}}
+	server := httptest.NewTLSServer(stub)
+	defer server.Close()
+	routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+	path := filepath.Join(t.TempDir(), "identifiers.json")
+	if _, err := CacheIdentifiers(path, IdentifierQuery{Query: "language:(German)", Collection: "zeitungen"}); err == nil {
+		t.Fatal("expected the interrupted scrape to fail")
+	}
+	stub.failPage = -1
+	stub.cursors = nil
+	if _, err := CacheIdentifiers(path, DefaultIdentifierQuery); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.cursors) == 0 || stub.cursors[0] != "" {
+		t.Errorf("scrape for another query resumed with cursors %v", stub.cursors)
+	}
+}
+
+func TestPruneIdentifiersWithExcludedMediaTypes(t *testing.T) {
+	cache := NewIdentifierCache(filepath.Join(t.TempDir(), "identifiers.json"))
+	cache.Add("chronik_1860", 120, 1860, "texts")
+	cache.Add("lieder_1860", 80, 1860, "audio")
+	// Entries from before mediatypes were recorded were all texts
+	cache.Add("predigten_1860", 300, 1860, "")
+	if pruned := cache.Prune(); pruned != 1 {
+		t.Errorf("pruned %d entries, want 1", pruned)
+	}
+	if got := cachedIdentifiers(LoadIdentifierCache(cache.path)); strings.Join(got, ",") != "chronik_1860,predigten_1860" {
+		t.Errorf("kept %v after pruning", got)
+	}
+}
+
+func TestCropPadding(t *testing.T) {
+	type box struct{ x, y, width, height int }
+	tests := []struct {
+		name    string
+		padding Padding
+		line    box
+		want    box
+	}{
+		{"no padding", Padding{Mode: PaddingFixed}, box{100, 200, 1000, 40}, box{100, 200, 1000, 40}},
+		{"fixed", Padding{Mode: PaddingFixed, Pixels: 10}, box{100, 200, 1000, 40}, box{90, 190, 1020, 60}},
+		{"fixed ignores the height", Padding{Mode: PaddingFixed, Pixels: 10, Fraction: 0.5}, box{100, 200, 1000, 80}, box{90, 190, 1020, 100}},
+		{"proportional small line", Padding{Mode: PaddingProportional, Fraction: 0.15}, box{100, 200, 1000, 40}, box{94, 194, 1012, 52}},
+		{"proportional large line", Padding{Mode: PaddingProportional, Fraction: 0.15}, box{100, 200, 1000, 120}, box{82, 182, 1036, 156}},
+		{"clamped to the top left", Padding{Mode: PaddingProportional, Fraction: 0.5}, box{10, 5, 500, 60}, box{0, 0, 540, 95}},
+		{"clamped to the bottom right", Padding{Mode: PaddingProportional, Fraction: 0.5}, box{1400, 2900, 580, 80}, box{1360, 2860, 640, 140}},
+	}
+	const pageWidth, pageHeight = 2000, 3000
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			x, y, width, height := tc.padding.apply(
+				tc.line.x, tc.line.y, tc.line.width, tc.line.height, pageWidth, pageHeight)
+			if got := (box{x, y, width, height}); got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+			if x < 0 || y < 0 || x+width > pageWidth || y+height > pageHeight {
+				t.Errorf("crop %+v is outside of the page", box{x, y, width, height})
+			}
+		})
+	}
+}
+
+func TestUnavailableItems(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		// Whether the error reports an unavailable item
+		unavailable bool
+		// Number of failed fetches after which the item is blacklisted,
+		// never if zero
+		blacklistAfter int
+		// Requests expected for every URL of a fetch
+		wantRequests int
+	}{
+		{"removed", http.StatusGone, true, 1, 1},
+		{"restricted", http.StatusForbidden, true, 2, 1},
+		{"server error", http.StatusInternalServerError, false, 0, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previousAttempts, previousDelay := MaxFetchAttempts, FetchRetryDelay
+			MaxFetchAttempts, FetchRetryDelay = 2, time.Millisecond
+			defer func() { MaxFetchAttempts, FetchRetryDelay = previousAttempts, previousDelay }()
+			var mutex sync.Mutex
+			requests := make(map[string]int)
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				requests[r.URL.Path]++
+				mutex.Unlock()
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+			routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+			ident := "flugschrift_" + strconv.Itoa(tc.status)
+
+			for attempt := 1; attempt <= 3; attempt++ {
+				mutex.Lock()
+				requests = make(map[string]int)
+				mutex.Unlock()
+				_, err := fetchAllLinesUncached(ident)
+				if err == nil {
+					t.Fatal("expected the fetch to fail")
+				}
+				if IsUnavailable(err) != tc.unavailable {
+					t.Errorf("IsUnavailable is %v for %v", !tc.unavailable, err)
+				}
+				mutex.Lock()
+				for path, got := range requests {
+					if got != tc.wantRequests {
+						t.Errorf("fetch sent %d requests for %s, want %d", got, path, tc.wantRequests)
+					}
+				}
+				mutex.Unlock()
+				wantBlacklist := tc.blacklistAfter > 0 && attempt >= tc.blacklistAfter
+				if got := ShouldBlacklist(ident, err); got != wantBlacklist {
+					t.Errorf("attempt %d: blacklisting is %v, want %v", attempt, got, wantBlacklist)
+				}
+			}
+		})
+	}
+}
+
+// scriptText returns the OCR text of a volume in which "ist" appears
+// numIst times and was misread as "ift" numIft times
+func scriptText(numIft int, numIst int) string {
+	var words []string
+	for i := 0; i < numIft; i++ {
+		words = append(words, "Das", "ift", "gut.")
+	}
+	for i := 0; i < numIst; i++ {
+		words = append(words, "Das", "ist", "gut.")
+	}
+	return strings.Join(words, " ")
+}
+
+func TestFrakturConfidence(t *testing.T) {
+	texts := map[string]string{
+		"gesangbuch_1850": scriptText(40, 2),
+		"lehrbuch_1890":   scriptText(1, 39),
+		"sammelband_1870": scriptText(15, 15),
+		"flugblatt_1848":  scriptText(2, 1),
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		text, ok := texts[parts[len(parts)-2]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, text)
+	}))
+	defer server.Close()
+	routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+
+	tests := []struct {
+		name       string
+		ident      string
+		confidence float64
+		script     string
+	}{
+		{"clearly Fraktur", "gesangbuch_1850", 40.0 / 42, ScriptFraktur},
+		{"clearly Antiqua", "lehrbuch_1890", 1.0 / 40, ScriptAntiqua},
+		{"mixed", "sammelband_1870", 0.5, ScriptFraktur},
+		{"too few samples", "flugblatt_1848", 0, ScriptAntiqua},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conf, err := FrakturConfidence(tc.ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(conf-tc.confidence) > 1e-9 {
+				t.Errorf("got confidence %.3f, want %.3f", conf, tc.confidence)
+			}
+			script, err := ClassifyScript(tc.ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if script != tc.script {
+				t.Errorf("classified as %s, want %s", script, tc.script)
+			}
+		})
+	}
+
+	t.Run("threshold", func(t *testing.T) {
+		previous := FrakturThreshold
+		FrakturThreshold = 0.6
+		defer func() { FrakturThreshold = previous }()
+		if isFrak, err := IsFraktur("sammelband_1870"); err != nil || isFrak {
+			t.Errorf("mixed volume is Fraktur above the threshold: %v, %v", isFrak, err)
+		}
+	})
+	t.Run("missing text", func(t *testing.T) {
+		_, err := FrakturConfidence("verschollen_1860")
+		if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusNotFound {
+			t.Errorf("expected a 404 status error, got %v", err)
+		}
+	})
+}
+
+func TestParseLineGeometry(t *testing.T) {
+	previous := CropPadding
+	CropPadding = Padding{Mode: PaddingFixed, Pixels: 20}
+	defer func() { CropPadding = previous }()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	fmt.Fprintln(gz, `<document>`)
+	for page := 0; page <= 10; page++ {
+		fmt.Fprintln(gz, `<page width="2000" height="3000" resolution="300"></page>`)
+	}
+	fmt.Fprint(gz, `<page width="2480" height="3508" resolution="400">
+<line baseline="340" l="212" t="301" r="2210" b="352"><formatting lang="GermanStandard"><charParams charConfidence="91">Erstes</charParams></formatting></line>
+<line baseline="412" l="230" t="371" r="1984" b="420"><charParams charConfidence="87">Zweites</charParams></line>
+</page>
+<page width="1800" height="2700" resolution="300">
+<line baseline="1540" l="97" t="1498" r="1650" b="1551"><charParams charConfidence="64">Drittes</charParams></line>
+<line baseline="1640" l="900" t="1600" r="950" b="1650"><charParams charConfidence="50">9</charParams></line>
+</page>
+</document>
+`)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Number pages from 0 without asking the IIIF server for the first page
+	parser := &abbyyParser{
+		ctx:          context.Background(),
+		ident:        "kalender_1862",
+		minLineWidth: 100,
+		progressChan: make(chan ProgressMessage, 101),
+		pageNo:       -1,
+	}
+	if err := parser.parse(&buf, int64(buf.Len()), 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	lines := parser.finish()
+
+	tests := []struct {
+		text string
+		page PageGeometry
+		bbox BBox
+	}{
+		{"Erstes", PageGeometry{11, 2480, 3508}, BBox{212, 301, 2210, 352}},
+		{"Zweites", PageGeometry{11, 2480, 3508}, BBox{230, 371, 1984, 420}},
+		{"Drittes", PageGeometry{12, 1800, 2700}, BBox{97, 1498, 1650, 1551}},
+	}
+	if len(lines) != len(tests) {
+		t.Fatalf("parsed %d lines, want %d: %+v", len(lines), len(tests), lines)
+	}
+	for idx, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			line := lines[idx]
+			if line.OCRText != tc.text {
+				t.Errorf("line %d has text %q, want %q", idx, line.OCRText, tc.text)
+			}
+			if line.Page == nil || *line.Page != tc.page {
+				t.Errorf("got page %+v, want %+v", line.Page, tc.page)
+			}
+			if line.BBox == nil || *line.BBox != tc.bbox {
+				t.Errorf("got bounding box %+v, want %+v", line.BBox, tc.bbox)
+			}
+			// The image is cropped with padding, the bounding box is not
+			crop := fmt.Sprintf("$%d/%d,%d,", tc.page.Number, tc.bbox.Left-20, tc.bbox.Top-20)
+			if !strings.Contains(line.ImageURL, crop) {
+				t.Errorf("image %s is not cropped at %s", line.ImageURL, crop)
+			}
+		})
+	}
+
+	t.Run("encoding", func(t *testing.T) {
+		encoded, err := json.Marshal(lines[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `"page":{"number":11,"width":2480,"height":3508},"bbox":{"l":212,"t":301,"r":2210,"b":352}`
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("encoded %s, want it to contain %s", encoded, want)
+		}
+		encoded, err = json.Marshal(OCRLine{Identifier: "abc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(encoded), `"page"`) || strings.Contains(string(encoded), `"bbox"`) {
+			t.Errorf("lines without geometry encode it: %s", encoded)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SessionLine is a committed line that was submitted in a given session
+type SessionLine struct {
+	Document string  `json:"document"`
+	Title    string  `json:"title"`
+	Year     int     `json:"year"`
+	Line     OCRLine `json:"line"`
+}
+
+// SessionLines lists all lines that were submitted in a session
+func (s *DocumentStore) SessionLines(session string) ([]SessionLine, error) {
+	lines := make([]SessionLine, 0)
+	if session == "" {
+		return lines, nil
+	}
+	err := s.forEachDocument(func(doc *Document) error {
+		for _, line := range doc.Lines {
+			if line.Session != session {
+				continue
+			}
+			lines = append(lines, SessionLine{
+				Document: doc.Identifier,
+				Title:    doc.Title,
+				Year:     doc.Year,
+				Line:     line,
+			})
+		}
+		return nil
+	})
+	return lines, err
+}
+
+// RetractSession removes all lines submitted in a session from the corpus,
+// with a commit for every affected volume. Returns the number of removed
+// lines.
+func (s *DocumentStore) RetractSession(session string) (int, error) {
+	lines, err := s.SessionLines(session)
+	if err != nil {
+		return 0, err
+	}
+	idents := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		if !seen[line.Document] {
+			seen[line.Document] = true
+			idents = append(idents, line.Document)
+		}
+	}
+	numRetracted := 0
+	for _, ident := range idents {
+		numRemoved, err := s.retractSessionLines(ident, session)
+		numRetracted += numRemoved
+		if err != nil {
+			return numRetracted, err
+		}
+	}
+	return numRetracted, nil
+}
+
+func (s *DocumentStore) retractSessionLines(ident string, session string) (int, error) {
+	logger := log.With().Str("identifier", ident).Str("session", session).Logger()
+	unlockRepo, err := s.lockRepo()
+	if err != nil {
+		return 0, err
+	}
+	defer unlockRepo()
+	lock, err := lockVolume(s.lockDir, ident)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+	if err := s.syncRepo(logger); err != nil {
+		return 0, err
+	}
+	metaPath := s.metaPath(ident)
+	if metaPath == "" {
+		return 0, ErrDocumentNotFound
+	}
+	doc, err := s.readDocument(metaPath)
+	if err != nil {
+		return 0, err
+	}
+	kept := make([]OCRLine, 0, len(doc.Lines))
+	for _, line := range doc.Lines {
+		if line.Session != session {
+			kept = append(kept, line)
+			continue
+		}
+		if err := s.removeLineFiles(doc, line.Identifier); err != nil {
+			return 0, err
+		}
+	}
+	numRemoved := len(doc.Lines) - len(kept)
+	if numRemoved == 0 {
+		return 0, nil
+	}
+	doc.Lines = kept
+	if err := s.writeMetadata(*doc); err != nil {
+		return 0, err
+	}
+	if err := s.writeReadme(logger); err != nil {
+		return 0, err
+	}
+	commitMessage := fmt.Sprintf(
+		"Retracted %d lines of session %s from %s (%d)", numRemoved, session,
+		doc.Identifier, doc.Year)
+	if _, _, err := s.commitAndPush(doc.Identifier, commitMessage, "", "", logger); err != nil {
+		return 0, err
+	}
+	logger.Info().Int("numRemoved", numRemoved).Msg("Retracted session lines")
+	return numRemoved, nil
+}
+
+// StripSessions removes the session identifiers from a document's lines,
+// before it is shown to other users
+func StripSessions(doc *Document) {
+	for idx := range doc.Lines {
+		doc.Lines[idx].Session = ""
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sessionLine returns a line of a volume transcribed in a session
+func sessionLine(volumeID string, y int, transcription string, session string) OCRLine {
+	line := regionLine(volumeID, 11, 150, y, transcription)
+	line.Session = session
+	return line
+}
+
+func TestRetractSession(t *testing.T) {
+	tests := []struct {
+		session string
+		// Transcriptions left in the corpus after the retraction
+		wantKept    []string
+		wantCommits int
+	}{
+		{"s1", []string{"Zweite Spalte", "Marktpreise"}, 2},
+		{"s2", []string{"Erste Spalte", "Dritte Spalte", "Getreidepreise"}, 2},
+		{"unknown", []string{"Dritte Spalte", "Erste Spalte", "Getreidepreise", "Marktpreise", "Zweite Spalte"}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.session, func(t *testing.T) {
+			store, _ := newTestStore(t)
+			save := func(doc Document) {
+				t.Helper()
+				for _, line := range doc.Lines {
+					cacheTestLine(t, doc.Identifier, line)
+				}
+				if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			save(Document{Identifier: "anzeiger_1850", Title: "Anzeiger", Year: 1850, Lines: []OCRLine{
+				sessionLine("anzeiger_1850", 100, "Erste Spalte", "s1"),
+				sessionLine("anzeiger_1850", 160, "Dritte Spalte", "s1"),
+			}})
+			save(Document{Identifier: "handelsblatt_1851", Title: "Handelsblatt", Year: 1851, Lines: []OCRLine{
+				sessionLine("handelsblatt_1851", 100, "Getreidepreise", "s1"),
+			}})
+			// The second session resubmits the unchanged line, which stays
+			// attributed to the first session, and adds another one
+			save(Document{Identifier: "handelsblatt_1851", Title: "Handelsblatt", Year: 1851, Lines: []OCRLine{
+				sessionLine("handelsblatt_1851", 100, "Getreidepreise", "s2"),
+				sessionLine("handelsblatt_1851", 160, "Marktpreise", "s2"),
+			}})
+			save(Document{Identifier: "anzeiger_1850", Title: "Anzeiger", Year: 1850, Lines: []OCRLine{
+				sessionLine("anzeiger_1850", 100, "Erste Spalte", "s2"),
+				sessionLine("anzeiger_1850", 160, "Dritte Spalte", "s2"),
+				sessionLine("anzeiger_1850", 220, "Zweite Spalte", "s2"),
+			}})
+
+			listed, err := store.SessionLines(tc.session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			numAll := len(corpusTexts(t, store))
+			if want := numAll - len(tc.wantKept); len(listed) != want {
+				t.Errorf("listed %d lines of the session, want %d", len(listed), want)
+			}
+			for _, line := range listed {
+				if line.Line.Session != tc.session {
+					t.Errorf("listed line %q of session %q", line.Line.Transcription, line.Line.Session)
+				}
+			}
+			commitsBefore := git(t, store.basePath, "rev-list", "--count", "HEAD")
+
+			numRetracted, err := store.RetractSession(tc.session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numRetracted != len(listed) {
+				t.Errorf("retracted %d lines, want %d", numRetracted, len(listed))
+			}
+			got := corpusTexts(t, store)
+			sort.Strings(tc.wantKept)
+			if strings.Join(got, ",") != strings.Join(tc.wantKept, ",") {
+				t.Errorf("kept %v, want %v", got, tc.wantKept)
+			}
+			numTexts := len(storedLineFiles(t, store, "1850", ".txt")) + len(storedLineFiles(t, store, "1851", ".txt"))
+			if numTexts != len(tc.wantKept) {
+				t.Errorf("%d transcription files are left, want %d", numTexts, len(tc.wantKept))
+			}
+			before, _ := strconv.Atoi(commitsBefore)
+			after, _ := strconv.Atoi(git(t, store.basePath, "rev-list", "--count", "HEAD"))
+			if after-before != tc.wantCommits {
+				t.Errorf("retraction made %d commits, want %d", after-before, tc.wantCommits)
+			}
+			if remaining, _ := store.SessionLines(tc.session); len(remaining) != 0 {
+				t.Errorf("lines of the session are left: %+v", remaining)
+			}
+		})
+	}
+}
+
+// corpusTexts returns the sorted transcriptions of all lines in the store
+func corpusTexts(t *testing.T, store *DocumentStore) []string {
+	t.Helper()
+	var texts []string
+	err := store.forEachDocument(func(doc *Document) error {
+		for _, line := range doc.Lines {
+			texts = append(texts, line.Transcription)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(texts)
+	return texts
+}
+
+func TestStripSessions(t *testing.T) {
+	doc := Document{Identifier: "anzeiger_1850", Lines: []OCRLine{
+		sessionLine("anzeiger_1850", 100, "Erste Spalte", "s1"),
+		sessionLine("anzeiger_1850", 160, "Zweite Spalte", ""),
+	}}
+	StripSessions(&doc)
+	for _, line := range doc.Lines {
+		if line.Session != "" {
+			t.Errorf("line %q still has session %q", line.Transcription, line.Session)
+		}
+	}
+}
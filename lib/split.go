@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SplitNames are the names of the splits, in the order of their ratios
+var SplitNames = []string{"train", "val", "test"}
+
+// SplitOptions control how the corpus is partitioned
+type SplitOptions struct {
+	// Relative sizes of the train, validation and test splits
+	Ratios []float64
+	// Different seeds produce different splits of the same corpus
+	Seed int64
+	// Keep all lines of a volume in the same split, so that models are not
+	// evaluated on volumes they were trained on
+	ByVolume bool
+}
+
+// Validate checks that there is a valid ratio for every split
+func (o SplitOptions) Validate() error {
+	if len(o.Ratios) != len(SplitNames) {
+		return fmt.Errorf(
+			"expected %d ratios for the train, val and test splits, got %d",
+			len(SplitNames), len(o.Ratios))
+	}
+	var total float64
+	for _, ratio := range o.Ratios {
+		if ratio < 0 {
+			return fmt.Errorf("split ratios must not be negative")
+		}
+		total += ratio
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one split ratio must be positive")
+	}
+	return nil
+}
+
+// SplitReport is the number of lines written to each split
+type SplitReport struct {
+	NumLines map[string]int `json:"numLines"`
+	// Number of volumes that have lines in each split
+	NumVolumes map[string]int `json:"numVolumes"`
+}
+
+// splitUnit is a set of lines that is assigned to a split as a whole
+type splitUnit struct {
+	key     string
+	volume  string
+	lineIDs []string
+	rank    uint64
+}
+
+// splitRank orders units pseudo-randomly. It only depends on the seed and
+// the unit, so a unit keeps its rank if the rest of the corpus changes.
+func splitRank(seed int64, key string) uint64 {
+	hash := sha256.New()
+	binary.Write(hash, binary.LittleEndian, seed)
+	hash.Write([]byte(key))
+	return binary.BigEndian.Uint64(hash.Sum(nil))
+}
+
+// Split partitions the transcribed lines of the corpus into train,
+// validation and test splits and writes a manifest for each to outDir,
+// <split>.txt with one line name per row. Line names are the same as the
+// file names written by Export, <identifier>_<line>. The splits only
+// depend on the corpus and the options, so they can be reproduced.
+func (s *DocumentStore) Split(outDir string, opts SplitOptions) (*SplitReport, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	var units []*splitUnit
+	err := s.forEachDocument(func(doc *Document) error {
+		var volumeUnit *splitUnit
+		if opts.ByVolume {
+			volumeUnit = &splitUnit{key: doc.Identifier, volume: doc.Identifier}
+		}
+		for _, line := range doc.Lines {
+			if line.Transcription == "" {
+				continue
+			}
+			lineID := fmt.Sprintf("%s_%s", doc.Identifier, line.Identifier)
+			if volumeUnit != nil {
+				volumeUnit.lineIDs = append(volumeUnit.lineIDs, lineID)
+				continue
+			}
+			units = append(units, &splitUnit{
+				key: lineID, volume: doc.Identifier, lineIDs: []string{lineID}})
+		}
+		if volumeUnit != nil && len(volumeUnit.lineIDs) > 0 {
+			units = append(units, volumeUnit)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, unit := range units {
+		unit.rank = splitRank(opts.Seed, unit.key)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		if units[i].rank != units[j].rank {
+			return units[i].rank < units[j].rank
+		}
+		return units[i].key < units[j].key
+	})
+
+	var numLines int
+	for _, unit := range units {
+		numLines += len(unit.lineIDs)
+	}
+	var total float64
+	for _, ratio := range opts.Ratios {
+		total += ratio
+	}
+	report := &SplitReport{
+		NumLines:   make(map[string]int),
+		NumVolumes: make(map[string]int),
+	}
+	// Units are assigned in rank order until a split has its share of the
+	// lines, the last split takes the remaining ones
+	var cumulative float64
+	unitIdx, assigned := 0, 0
+	for splitIdx, name := range SplitNames {
+		cumulative += opts.Ratios[splitIdx]
+		target := int(float64(numLines)*cumulative/total + 0.5)
+		if splitIdx == len(SplitNames)-1 {
+			target = numLines
+		}
+		var lineIDs []string
+		volumes := make(map[string]bool)
+		for ; unitIdx < len(units) && assigned < target; unitIdx++ {
+			unit := units[unitIdx]
+			lineIDs = append(lineIDs, unit.lineIDs...)
+			assigned += len(unit.lineIDs)
+			report.NumLines[name] += len(unit.lineIDs)
+			volumes[unit.volume] = true
+		}
+		report.NumVolumes[name] = len(volumes)
+		sort.Strings(lineIDs)
+		var manifest bytes.Buffer
+		for _, lineID := range lineIDs {
+			manifest.WriteString(lineID + "\n")
+		}
+		manifestPath := filepath.Join(outDir, name+".txt")
+		if err := WriteFileAtomic(manifestPath, manifest.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,302 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// StatsFileName is the name of the corpus statistics written next to the
+// README
+const StatsFileName = "stats.json"
+
+// Orders of the works table in the README
+const (
+	SortByDate  = "date"
+	SortByTitle = "title"
+	SortByLines = "lines"
+)
+
+// ReadmeSort is the order of the works table in the README, by year,
+// alphabetically by title or by descending number of lines
+var ReadmeSort = SortByDate
+
+// Ways of listing contributors in the README
+const (
+	ContributorsOmit      = "omit"
+	ContributorsAnonymous = "anonymous"
+	ContributorsNamed     = "named"
+)
+
+// ReadmeContributors controls the contributors table of the README and the
+// statistics. It is omitted by default, anonymous contributors are numbered
+// instead of showing their names.
+var ReadmeContributors = ContributorsOmit
+
+// ContributorStats holds the number of lines a contributor transcribed or
+// corrected and the number of works they contributed to
+type ContributorStats struct {
+	Author   string `json:"author"`
+	NumLines int    `json:"numLines"`
+	NumWorks int    `json:"numWorks"`
+}
+
+// WorkStats holds the number of transcribed lines of a single work
+type WorkStats struct {
+	Identifier string `json:"id"`
+	Title      string `json:"title"`
+	Year       int    `json:"year"`
+	NumLines   int    `json:"numLines"`
+}
+
+// CorpusStats holds the number of transcribed lines in the corpus, in total
+// and per year, decade and work, and the number of characters and tokens in
+// their transcriptions
+type CorpusStats struct {
+	NumLines         int         `json:"numLines"`
+	NumWorks         int         `json:"numWorks"`
+	NumCharacters    int         `json:"numCharacters"`
+	NumTokens        int         `json:"numTokens"`
+	Years            map[int]int `json:"years"`
+	Decades          map[int]int `json:"decades"`
+	DecadeCharacters map[int]int `json:"decadeCharacters"`
+	Works            []WorkStats `json:"works"`
+	// Only set if contributors are listed, see ReadmeContributors
+	Contributors []ContributorStats `json:"contributors,omitempty"`
+}
+
+// ComputeStats counts the transcribed lines in the working copy at repoPath.
+// The repository history is only consulted for the contributors.
+func ComputeStats(repoPath string) (CorpusStats, error) {
+	s := &DocumentStore{basePath: repoPath}
+	if ReadmeContributors != ContributorsOmit {
+		repo, err := GitOpen(repoPath)
+		if err != nil {
+			return CorpusStats{}, err
+		}
+		s.repo = repo
+	}
+	return s.stats()
+}
+
+func (s *DocumentStore) stats() (CorpusStats, error) {
+	stats := CorpusStats{
+		Years:            make(map[int]int),
+		Decades:          make(map[int]int),
+		DecadeCharacters: make(map[int]int),
+		Works:            []WorkStats{},
+	}
+	err := s.forEachDocument(func(doc *Document) error {
+		numLines := len(doc.Lines)
+		decade := (doc.Year / 10) * 10
+		stats.NumLines += numLines
+		stats.NumWorks++
+		stats.Years[doc.Year] += numLines
+		stats.Decades[decade] += numLines
+		for _, line := range doc.Lines {
+			// Count runes, not bytes, umlauts and long s are multi-byte
+			numChars := utf8.RuneCountInString(line.Transcription)
+			stats.NumCharacters += numChars
+			stats.DecadeCharacters[decade] += numChars
+			stats.NumTokens += len(strings.Fields(line.Transcription))
+		}
+		stats.Works = append(stats.Works, WorkStats{
+			Identifier: doc.Identifier,
+			Title:      doc.Title,
+			Year:       doc.Year,
+			NumLines:   numLines,
+		})
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	sort.SliceStable(stats.Works, func(i, j int) bool {
+		return stats.Works[i].Year < stats.Works[j].Year
+	})
+	if ReadmeContributors != ContributorsOmit {
+		if stats.Contributors, err = s.contributorStats(); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// contributorStats aggregates the submission commits per author, most
+// lines first. Authors are identified by their email, or their name if they
+// have none, and shown with the name of their latest commit.
+func (s *DocumentStore) contributorStats() ([]ContributorStats, error) {
+	entries, err := s.repo.Log("transcriptions")
+	if err != nil {
+		return nil, err
+	}
+	contributors := make(map[string]*ContributorStats)
+	works := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		contribs := entry.contributions()
+		if len(contribs) == 0 {
+			continue
+		}
+		key := strings.ToLower(entry.Author.Email)
+		if key == "" {
+			key = entry.Author.Name
+		}
+		contributor, found := contributors[key]
+		if !found {
+			// The log is ordered by date, newest first
+			contributor = &ContributorStats{Author: entry.Author.Name}
+			contributors[key] = contributor
+			works[key] = make(map[string]bool)
+		}
+		for _, contrib := range contribs {
+			contributor.NumLines += contrib.numLines
+			works[key][contrib.ident] = true
+		}
+	}
+	sorted := make([]ContributorStats, 0, len(contributors))
+	for key, contributor := range contributors {
+		contributor.NumWorks = len(works[key])
+		sorted = append(sorted, *contributor)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].NumLines != sorted[j].NumLines {
+			return sorted[i].NumLines > sorted[j].NumLines
+		}
+		return sorted[i].Author < sorted[j].Author
+	})
+	if ReadmeContributors == ContributorsAnonymous {
+		for idx := range sorted {
+			sorted[idx].Author = fmt.Sprintf("Contributor %d", idx+1)
+		}
+	}
+	return sorted, nil
+}
+
+// CreateReadme renders the README of the corpus from the transcriptions in
+// the working copy at repoPath, without consulting the repository history
+func CreateReadme(repoPath string) (string, error) {
+	stats, err := ComputeStats(repoPath)
+	if err != nil {
+		return "", err
+	}
+	return RenderReadme(stats), nil
+}
+
+// RenderReadme renders the README of the corpus from its statistics
+func RenderReadme(stats CorpusStats) string {
+	var totalsTable bytes.Buffer
+	t := tablewriter.NewWriter(&totalsTable)
+	t.SetAutoFormatHeaders(false)
+	t.SetHeader([]string{"", "Total"})
+	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	t.SetCenterSeparator("|")
+	t.Append([]string{"Lines", strconv.Itoa(stats.NumLines)})
+	t.Append([]string{"Characters", strconv.Itoa(stats.NumCharacters)})
+	t.Append([]string{"Tokens", strconv.Itoa(stats.NumTokens)})
+	t.Render()
+
+	var yearsTable bytes.Buffer
+	t = tablewriter.NewWriter(&yearsTable)
+	t.SetAutoFormatHeaders(false)
+	t.SetHeader([]string{"Year", "# lines"})
+	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	t.SetCenterSeparator("|")
+	for _, year := range sortedKeys(stats.Years) {
+		t.Append([]string{strconv.Itoa(year), strconv.Itoa(stats.Years[year])})
+	}
+	t.Render()
+
+	var decadesTable bytes.Buffer
+	t = tablewriter.NewWriter(&decadesTable)
+	t.SetAutoFormatHeaders(false)
+	t.SetHeader([]string{"Decade", "# lines", "# characters"})
+	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	t.SetCenterSeparator("|")
+	for _, decade := range sortedKeys(stats.Decades) {
+		t.Append([]string{
+			strconv.Itoa(decade), strconv.Itoa(stats.Decades[decade]),
+			strconv.Itoa(stats.DecadeCharacters[decade])})
+	}
+	t.Render()
+
+	var metaTable bytes.Buffer
+	t = tablewriter.NewWriter(&metaTable)
+	t.SetAutoFormatHeaders(false)
+	t.SetAutoWrapText(false)
+	t.SetHeader([]string{"Title", "Date", "# lines", "Archive.org", "IIIF"})
+	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	t.SetCenterSeparator("|")
+	for _, work := range sortWorks(stats.Works, ReadmeSort) {
+		archiveLink := fmt.Sprintf(
+			"[%s](%s)", work.Identifier, DetailsURL(work.Identifier))
+		manifestLink := fmt.Sprintf(
+			"[Manifest](%s)", ManifestURL(work.Identifier))
+		miradorLink := fmt.Sprintf(
+			"[Mirador](%s)", MiradorURL(work.Identifier))
+		t.Append([]string{
+			work.Title, strconv.Itoa(work.Year), strconv.Itoa(work.NumLines),
+			archiveLink, fmt.Sprintf("%s/%s", manifestLink, miradorLink)})
+	}
+	t.Render()
+
+	var contributorsTable bytes.Buffer
+	if len(stats.Contributors) > 0 {
+		t = tablewriter.NewWriter(&contributorsTable)
+		t.SetAutoFormatHeaders(false)
+		t.SetHeader([]string{"Contributor", "# lines", "# works"})
+		t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+		t.SetCenterSeparator("|")
+		for _, contributor := range stats.Contributors {
+			t.Append([]string{
+				contributor.Author, strconv.Itoa(contributor.NumLines),
+				strconv.Itoa(contributor.NumWorks)})
+		}
+		t.Render()
+	}
+
+	var out bytes.Buffer
+	tmpl := template.Must(template.New("README.md").Parse(readmeTemplate))
+	tmpl.Execute(&out, map[string]string{
+		"numLines":          strconv.Itoa(stats.NumLines),
+		"numWorks":          strconv.Itoa(stats.NumWorks),
+		"numYears":          strconv.Itoa(len(stats.Years)),
+		"totalsTable":       totalsTable.String(),
+		"decadeTable":       decadesTable.String(),
+		"yearTable":         yearsTable.String(),
+		"worksTable":        metaTable.String(),
+		"contributorsTable": contributorsTable.String(),
+	})
+	return out.String()
+}
+
+// sortWorks returns a copy of the works in the given order, works that
+// compare equal keep their order by year
+func sortWorks(works []WorkStats, order string) []WorkStats {
+	sorted := append([]WorkStats(nil), works...)
+	switch order {
+	case SortByTitle:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Title < sorted[j].Title
+		})
+	case SortByLines:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].NumLines > sorted[j].NumLines
+		})
+	}
+	return sorted
+}
+
+func sortedKeys(counts map[int]int) []int {
+	keys := make([]int, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Corpus of two works in the format of the repository, one of them with
+// lines from before line geometry was recorded
+var fixtureCorpus = filepath.Join("testdata", "corpus")
+
+func TestCreateReadmeGolden(t *testing.T) {
+	tests := []struct {
+		sort   string
+		golden string
+	}{
+		{SortByDate, "readme_date.golden.md"},
+		{SortByTitle, "readme_title.golden.md"},
+		{SortByLines, "readme_lines.golden.md"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.sort, func(t *testing.T) {
+			previous := ReadmeSort
+			ReadmeSort = tc.sort
+			defer func() { ReadmeSort = previous }()
+			withGlobals(t, func() { ReadmeContributors = ContributorsOmit })
+			readme, err := CreateReadme(fixtureCorpus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, tc.golden, []byte(readme))
+		})
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	withGlobals(t, func() { ReadmeContributors = ContributorsOmit })
+	stats, err := ComputeStats(fixtureCorpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.NumWorks != 2 || stats.NumLines != 5 {
+		t.Errorf("expected 2 works with 5 lines, got %d with %d", stats.NumWorks, stats.NumLines)
+	}
+	if stats.Years[1848] != 3 || stats.Decades[1870] != 2 {
+		t.Errorf("unexpected lines per year %v and decade %v", stats.Years, stats.Decades)
+	}
+	if stats.NumTokens != 18 {
+		t.Errorf("expected 18 tokens, got %d", stats.NumTokens)
+	}
+}
+
+func TestStatsJSONGolden(t *testing.T) {
+	withGlobals(t, func() { ReadmeContributors = ContributorsOmit })
+	stats, err := ComputeStats(fixtureCorpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statsJSON, err := marshalJSON(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "stats.golden.json", statsJSON)
+
+	works := make(map[string]WorkStats)
+	for _, work := range stats.Works {
+		works[work.Identifier] = work
+	}
+	if bote := works["bote_1848"]; bote.Year != 1848 || bote.NumLines != 3 {
+		t.Errorf("unexpected stats for bote_1848: %+v", bote)
+	}
+	if kalender := works["kalender_1873"]; kalender.Year != 1873 || kalender.NumLines != 2 {
+		t.Errorf("unexpected stats for kalender_1873: %+v", kalender)
+	}
+}
+
+var markdownLinkPat = regexp.MustCompile(`\]\(([^)]+)\)`)
+
+func TestReadmeLinks(t *testing.T) {
+	tests := []struct {
+		name        string
+		iiifBase    string
+		detailsBase string
+		wantIIIF    string
+		wantDetails string
+	}{
+		{"default", IIIFBaseURL, ArchiveDetailsBaseURL,
+			"https://iiif.archivelab.org/iiif/", "https://archive.org/details/"},
+		{"without trailing slashes", "https://iiif.example.org/iiif", "https://archive.example.org/details",
+			"https://iiif.example.org/iiif/", "https://archive.example.org/details/"},
+		{"with trailing slashes", "https://iiif.example.org/iiif/", "https://archive.example.org/details/",
+			"https://iiif.example.org/iiif/", "https://archive.example.org/details/"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previousIIIF, previousDetails := IIIFBaseURL, ArchiveDetailsBaseURL
+			IIIFBaseURL, ArchiveDetailsBaseURL = tc.iiifBase, tc.detailsBase
+			defer func() { IIIFBaseURL, ArchiveDetailsBaseURL = previousIIIF, previousDetails }()
+			withGlobals(t, func() { ReadmeContributors = ContributorsOmit })
+			readme, err := CreateReadme(fixtureCorpus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			links := markdownLinkPat.FindAllStringSubmatch(readme, -1)
+			// Details, manifest and viewer for each of the two works
+			if len(links) != 6 {
+				t.Fatalf("expected 6 links, got %d", len(links))
+			}
+			for _, match := range links {
+				link := match[1]
+				if !strings.HasPrefix(link, "https://") {
+					t.Errorf("link %s does not start with https://", link)
+				}
+				if !strings.HasPrefix(link, tc.wantIIIF) && !strings.HasPrefix(link, tc.wantDetails) {
+					t.Errorf("link %s is not derived from the configured bases", link)
+				}
+				if strings.Contains(strings.TrimPrefix(link, "https://"), "//") {
+					t.Errorf("link %s has an empty path segment", link)
+				}
+			}
+			for _, want := range []string{
+				tc.wantDetails + "bote_1848",
+				tc.wantIIIF + "bote_1848/manifest.json",
+				tc.wantIIIF + "bote_1848)",
+			} {
+				if !strings.Contains(readme, want) {
+					t.Errorf("README does not contain %s", want)
+				}
+			}
+		})
+	}
+}
+
+func TestContributorStats(t *testing.T) {
+	store, _ := newTestStore(t)
+	submissions := []struct {
+		name, email string
+		ident       string
+		year        int
+		numLines    int
+	}{
+		{"Anna", "anna@example.org", "kurier_1850", 1850, 2},
+		{"Bernd", "bernd@example.org", "chronik_1855", 1855, 4},
+		// The same contributor under a new name
+		{"Anna Kraus", "Anna@Example.org", "bote_1861", 1861, 1},
+	}
+	for idx, sub := range submissions {
+		doc := Document{Identifier: sub.ident, Title: "Test", Year: sub.year}
+		for lineIdx := 0; lineIdx < sub.numLines; lineIdx++ {
+			line := regionLine(sub.ident, 11, 150, 100+60*lineIdx, fmt.Sprintf("Zeile %d von %s", lineIdx, sub.name))
+			cacheTestLine(t, sub.ident, line)
+			doc.Lines = append(doc.Lines, line)
+		}
+		if _, err := store.Save(doc, sub.name, sub.email, ""); err != nil {
+			t.Fatalf("submission %d: %v", idx, err)
+		}
+	}
+
+	tests := []struct {
+		mode string
+		want []ContributorStats
+		// Text that must not appear in the README
+		hidden []string
+	}{
+		{ContributorsOmit, nil, []string{"Statistics: Contributors", "Anna", "Bernd"}},
+		{ContributorsAnonymous, []ContributorStats{
+			{Author: "Contributor 1", NumLines: 4, NumWorks: 1},
+			{Author: "Contributor 2", NumLines: 3, NumWorks: 2},
+		}, []string{"Anna", "Bernd", "example.org"}},
+		{ContributorsNamed, []ContributorStats{
+			{Author: "Bernd", NumLines: 4, NumWorks: 1},
+			{Author: "Anna Kraus", NumLines: 3, NumWorks: 2},
+		}, []string{"example.org"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			withGlobals(t, func() { ReadmeContributors = tc.mode })
+			stats, err := ComputeStats(store.basePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stats.Contributors) != len(tc.want) {
+				t.Fatalf("got contributors %+v, want %+v", stats.Contributors, tc.want)
+			}
+			for idx, want := range tc.want {
+				if stats.Contributors[idx] != want {
+					t.Errorf("contributor %d is %+v, want %+v", idx, stats.Contributors[idx], want)
+				}
+			}
+			readme := RenderReadme(stats)
+			for _, want := range tc.want {
+				row := fmt.Sprintf("| %s", want.Author)
+				if !strings.Contains(readme, row) {
+					t.Errorf("README has no row for %s:\n%s", want.Author, readme)
+				}
+			}
+			for _, hidden := range tc.hidden {
+				if strings.Contains(readme, hidden) {
+					t.Errorf("README contains %q:\n%s", hidden, readme)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+// Vertical gap between stitched line images in pixels
+const stitchGap = 4
+
+// ConsecutiveLines returns up to count lines that follow each other on the
+// same page, starting with the line with the given identifier. The lines
+// have to be in reading order, as returned by FetchLines.
+func ConsecutiveLines(lines []OCRLine, startID string, count int) []OCRLine {
+	startIdx := -1
+	for idx, line := range lines {
+		if line.Identifier == startID {
+			startIdx = idx
+			break
+		}
+	}
+	if startIdx < 0 {
+		return nil
+	}
+	run := []OCRLine{lines[startIdx]}
+	startRegion, _ := parseRegion(lines[startIdx].ImageURL)
+	for idx := startIdx + 1; idx < len(lines) && len(run) < count; idx++ {
+		region, ok := parseRegion(lines[idx].ImageURL)
+		if !ok || region.Page != startRegion.Page {
+			break
+		}
+		if run[len(run)-1].NextImageURL != lines[idx].ImageURL {
+			break
+		}
+		run = append(run, lines[idx])
+	}
+	return run
+}
+
+// Stitch combines the images of the given lines of a volume into a single
+// image, from top to bottom. Narrower lines are left-aligned and padded
+// with white. Images that are not cached yet are fetched. Returns the
+// vertical offset of every line in the stitched image.
+func (c *LineImageCache) Stitch(ident string, lines []OCRLine) (image.Image, []int, error) {
+	images := make([]image.Image, 0, len(lines))
+	width := 0
+	height := 0
+	for _, line := range lines {
+		cacheID := MakeLineIdentifier(ident, line)
+		imgPath := c.GetLinePath(cacheID)
+		if imgPath == "" {
+			path, err := c.CacheLine(line.ImageURL, cacheID)
+			if err != nil {
+				return nil, nil, err
+			}
+			imgPath = path
+		}
+		img, err := readPNG(imgPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, img)
+		if img.Bounds().Dx() > width {
+			width = img.Bounds().Dx()
+		}
+		height += img.Bounds().Dy()
+	}
+	if len(images) > 1 {
+		height += (len(images) - 1) * stitchGap
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	offsets := make([]int, len(images))
+	y := 0
+	for idx, img := range images {
+		offsets[idx] = y
+		bounds := img.Bounds()
+		target := image.Rect(0, y, bounds.Dx(), y+bounds.Dy())
+		draw.Draw(out, target, img, bounds.Min, draw.Over)
+		y += bounds.Dy() + stitchGap
+	}
+	return out, offsets, nil
+}
+
+// readPNG decodes a cached image
+func readPNG(path string) (image.Image, error) {
+	var img image.Image
+	err := retryFileOp(true, func() error {
+		in, err := openFile(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		img, err = png.Decode(in)
+		return err
+	})
+	return img, err
+}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// paragraphLines returns lines of a volume in reading order, linked to the
+// next line on the same page
+func paragraphLines(ident string, pages ...int) []OCRLine {
+	lines := make([]OCRLine, 0, len(pages))
+	for idx, page := range pages {
+		lines = append(lines, regionLine(ident, page, 150, 100+60*idx, ""))
+	}
+	for idx := 0; idx+1 < len(lines); idx++ {
+		if pages[idx] == pages[idx+1] {
+			lines[idx].NextImageURL = lines[idx+1].ImageURL
+		}
+	}
+	return lines
+}
+
+func TestConsecutiveLines(t *testing.T) {
+	const ident = "hausfreund_1869"
+	lines := paragraphLines(ident, 11, 11, 11, 11, 12, 12)
+	// The fourth line does not follow the third on the page, e.g. because a
+	// blank line in between was dropped
+	lines[2].NextImageURL = ""
+	tests := []struct {
+		name  string
+		start int
+		count int
+		want  []int
+	}{
+		{"within the page", 0, 2, []int{0, 1}},
+		{"up to a gap in the reading order", 0, 5, []int{0, 1, 2}},
+		{"single line", 3, 1, []int{3}},
+		{"up to the end of the page", 3, 5, []int{3}},
+		{"next page", 4, 5, []int{4, 5}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			run := ConsecutiveLines(lines, lines[tc.start].Identifier, tc.count)
+			if len(run) != len(tc.want) {
+				t.Fatalf("got %d lines, want %d", len(run), len(tc.want))
+			}
+			for idx, want := range tc.want {
+				if run[idx].Identifier != lines[want].Identifier {
+					t.Errorf("line %d of the run is %s, want line %d", idx, run[idx].ImageURL, want)
+				}
+			}
+		})
+	}
+	if run := ConsecutiveLines(lines, "missing", 3); run != nil {
+		t.Errorf("expected no lines for an unknown start, got %d", len(run))
+	}
+}
+
+func TestStitch(t *testing.T) {
+	useTestLineCache(t)
+	const ident = "hausfreund_1869"
+	lines := paragraphLines(ident, 11, 11, 11)
+	sizes := []image.Point{{300, 40}, {200, 30}, {250, 50}}
+	shades := []uint8{40, 90, 140}
+	for idx, line := range lines {
+		img := image.NewGray(image.Rect(0, 0, sizes[idx].X, sizes[idx].Y))
+		for y := 0; y < sizes[idx].Y; y++ {
+			for x := 0; x < sizes[idx].X; x++ {
+				img.SetGray(x, y, color.Gray{Y: shades[idx]})
+			}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(LineCache.path, MakeLineIdentifier(ident, line)+".png")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	img, offsets, err := LineCache.Stitch(ident, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOffsets := []int{0, 40 + stitchGap, 40 + 30 + 2*stitchGap}
+	if len(offsets) != len(wantOffsets) {
+		t.Fatalf("got offsets %v, want %v", offsets, wantOffsets)
+	}
+	for idx, want := range wantOffsets {
+		if offsets[idx] != want {
+			t.Errorf("line %d is at offset %d, want %d", idx, offsets[idx], want)
+		}
+	}
+	if got, want := img.Bounds().Size(), (image.Point{300, 120 + 2*stitchGap}); got != want {
+		t.Errorf("stitched image has size %v, want %v", got, want)
+	}
+
+	white := color.Gray{Y: 255}
+	tests := []struct {
+		name string
+		x, y int
+		want color.Gray
+	}{
+		{"first line", 0, 0, color.Gray{Y: shades[0]}},
+		{"end of the first line", 299, 39, color.Gray{Y: shades[0]}},
+		{"gap after the first line", 10, 40, white},
+		{"second line", 0, wantOffsets[1], color.Gray{Y: shades[1]}},
+		{"end of the second line", 199, wantOffsets[1] + 29, color.Gray{Y: shades[1]}},
+		{"padding of the second line", 200, wantOffsets[1], white},
+		{"third line", 0, wantOffsets[2], color.Gray{Y: shades[2]}},
+		{"padding of the third line", 299, wantOffsets[2] + 49, white},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := color.GrayModel.Convert(img.At(tc.x, tc.y)).(color.Gray); got != tc.want {
+				t.Errorf("pixel at %d,%d is %v, want %v", tc.x, tc.y, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStitchFetchesMissingImages(t *testing.T) {
+	useTestLineCache(t)
+	withoutRateLimit(t)
+	const ident = "hausfreund_1869"
+	server := archiveServer(t, ident, 0, 0)
+	defer server.Close()
+	routeToServer(t, server, newPooledTransport(DefaultMaxConcurrentRequests))
+
+	lines := paragraphLines(ident, 11, 11)
+	img, offsets, err := LineCache.Stitch(ident, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 2 || img.Bounds().Dy() != 2*50+stitchGap {
+		t.Errorf("unexpected stitched image of size %v with offsets %v", img.Bounds().Size(), offsets)
+	}
+	for _, line := range lines {
+		if LineCache.GetLinePath(MakeLineIdentifier(ident, line)) == "" {
+			t.Errorf("line %s was not cached", line.ImageURL)
+		}
+	}
+}
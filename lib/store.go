@@ -12,9 +12,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
 
-	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -22,6 +22,13 @@ import (
 type DocumentStore struct {
 	basePath string
 	repo     *GitRepo
+	// Directory for the repository and volume locks that coordinate commits
+	// between processes sharing the repository
+	lockDir string
+	// Serializes the changes to the working copy, see lockRepo
+	repoMutex sync.Mutex
+	// Submissions waiting to be committed together, see CommitBatching
+	batch commitBatch
 }
 
 // Document holds all information about a transcription document
@@ -34,9 +41,54 @@ type Document struct {
 	History    []LogEntry `json:"history,omitempty"`
 	NumLines   int        `json:"numLines,omitempty"`
 	Reviewed   bool       `json:"reviewed"`
+	// Script the volume is set in, empty for volumes from before scripts
+	// were recorded, which are all Fraktur
+	Script string `json:"script,omitempty"`
+	// Commit and pull request the last submission was recorded in, only set
+	// in the response to it. Batched submissions share their commit.
+	Commit      string `json:"commit,omitempty"`
+	PullRequest string `json:"pullRequest,omitempty"`
 }
 
-var lineNamePat = regexp.MustCompile(`(.+?)_([a-z0-9]{8})`)
+// Line identifiers are hex digests of any length, see LineDigest
+var lineDigestPat = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// UnmarshalJSON decodes a document. Older metadata files store the lines as
+// a map from identifiers to lines, these are read in page order.
+func (d *Document) UnmarshalJSON(data []byte) error {
+	type plainDocument Document
+	var raw struct {
+		plainDocument
+		Lines json.RawMessage `json:"lines,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*d = Document(raw.plainDocument)
+	lines := bytes.TrimSpace(raw.Lines)
+	if len(lines) == 0 || bytes.Equal(lines, []byte("null")) {
+		return nil
+	}
+	if lines[0] != '{' {
+		return json.Unmarshal(lines, &d.Lines)
+	}
+	var lineMap map[string]OCRLine
+	if err := json.Unmarshal(lines, &lineMap); err != nil {
+		return err
+	}
+	d.Lines = make([]OCRLine, 0, len(lineMap))
+	for ident, line := range lineMap {
+		if line.Identifier == "" {
+			line.Identifier = ident
+		}
+		d.Lines = append(d.Lines, line)
+	}
+	sort.Slice(d.Lines, func(i, j int) bool {
+		return d.Lines[i].Identifier < d.Lines[j].Identifier
+	})
+	sortLines(d.Lines)
+	return nil
+}
 
 // NewDocumentStore creates a new document store
 func NewDocumentStore(path string) (*DocumentStore, error) {
@@ -47,92 +99,196 @@ func NewDocumentStore(path string) (*DocumentStore, error) {
 	return &DocumentStore{
 		basePath: path,
 		repo:     repo,
+		lockDir:  filepath.Join(path, ".git", "archiscribe-locks"),
 	}, nil
 }
 
 // Details retrieves a single Document by its identifier
 func (s *DocumentStore) Details(ident string) *Document {
-	var doc Document
-	transPath := filepath.Join(s.basePath, "transcriptions")
-	globPath := filepath.Join(transPath, "*", ident+".json")
-	metaPaths, err := filepath.Glob(globPath)
-	if err != nil {
+	doc, err := s.LoadVolume(ident)
+	if err == ErrDocumentNotFound {
+		return nil
+	} else if err != nil {
 		panic(err)
 	}
-	if len(metaPaths) == 0 {
-		return nil
+	return doc
+}
+
+// LoadVolume reads a single document with its history
+func (s *DocumentStore) LoadVolume(ident string) (*Document, error) {
+	metaPath := s.metaPath(ident)
+	if metaPath == "" {
+		return nil, ErrDocumentNotFound
 	}
-	metaPath := metaPaths[0]
-	raw, err := ioutil.ReadFile(metaPath)
+	doc, err := s.readDocument(metaPath)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	if err := json.Unmarshal(raw, &doc); err != nil {
-		panic(err)
+	transLog, err := s.history(metaPath)
+	if err != nil {
+		return nil, err
 	}
-	for idx, line := range doc.Lines {
-		textPath := strings.Replace(metaPath, ".json", "_"+line.Identifier+".txt", -1)
-		text, err := ioutil.ReadFile(textPath)
+	doc.History = transLog
+	return doc, nil
+}
+
+// ListVolumes returns all documents with their history, but without their
+// lines
+func (s *DocumentStore) ListVolumes() ([]*Document, error) {
+	documents := make([]*Document, 0)
+	err := s.forEachDocumentPath(func(metaPath string, doc *Document) error {
+		if doc.Identifier == "" {
+			return nil
+		}
+		transLog, err := s.history(metaPath)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		doc.Lines[idx].Transcription = strings.TrimSpace(string(text))
+		doc.History = transLog
+		doc.NumLines = len(doc.Lines)
+		doc.Lines = doc.Lines[:0]
+		documents = append(documents, doc)
+		return nil
+	})
+	return documents, err
+}
+
+// Commit commits and pushes the changes that are staged in the repository,
+// like the ones of a save that was interrupted before its commit. Saved
+// volumes are committed by SaveVolume already.
+func (s *DocumentStore) Commit(message string, author string, email string) error {
+	unlockRepo, err := s.lockRepo()
+	if err != nil {
+		return err
+	}
+	defer unlockRepo()
+	staged, err := s.repo.Diff(true)
+	if err != nil {
+		return err
 	}
+	if len(staged) == 0 {
+		return nil
+	}
+	_, _, err = s.commitAndPush("corpus", message, author, email, log.Logger)
+	return err
+}
+
+// history returns the git log for a document
+func (s *DocumentStore) history(metaPath string) ([]LogEntry, error) {
 	transFiles, err := filepath.Glob(strings.Replace(metaPath, ".json", ".*", -1))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	transPaths := make([]string, 0, len(transFiles))
 	for _, tf := range transFiles {
 		tp, _ := filepath.Rel(s.basePath, tf)
 		transPaths = append(transPaths, tp)
 	}
-	transLog, err := s.repo.Log(transPaths...)
+	return s.repo.Log(transPaths...)
+}
+
+func (s *DocumentStore) metaPath(ident string) string {
+	transPath := filepath.Join(s.basePath, "transcriptions")
+	globPath := filepath.Join(transPath, "*", ident+".json")
+	metaPaths, err := filepath.Glob(globPath)
 	if err != nil {
 		panic(err)
 	}
-	doc.History = transLog
-	return &doc
+	if len(metaPaths) == 0 {
+		return ""
+	}
+	return metaPaths[0]
 }
 
-// List all documents
-func (s *DocumentStore) List() []*Document {
+// readDocument loads a document and its transcriptions from disk, without
+// consulting the repository history
+func (s *DocumentStore) readDocument(metaPath string) (*Document, error) {
+	var doc Document
+	raw, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for idx, line := range doc.Lines {
+		textPath := strings.Replace(metaPath, ".json", "_"+line.Identifier+".txt", -1)
+		text, err := ioutil.ReadFile(textPath)
+		if err != nil {
+			return nil, err
+		}
+		doc.Lines[idx].Transcription = strings.TrimSpace(string(text))
+	}
+	return &doc, nil
+}
+
+// forEachDocument calls fn for every document in the working copy, without
+// reading the history. Unreadable documents are logged and skipped, an error
+// returned by fn stops the iteration.
+func (s *DocumentStore) forEachDocument(fn func(doc *Document) error) error {
+	return s.forEachDocumentPath(func(metaPath string, doc *Document) error {
+		return fn(doc)
+	})
+}
+
+// forEachDocumentPath is like forEachDocument, but also passes the path of
+// the metadata of every document
+func (s *DocumentStore) forEachDocumentPath(fn func(metaPath string, doc *Document) error) error {
 	transPath := filepath.Join(s.basePath, "transcriptions")
 	metaPaths, err := filepath.Glob(filepath.Join(transPath, "*", "*.json"))
-	documents := make([]*Document, 0, len(metaPaths))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	for _, metaPath := range metaPaths {
-		doc := s.Details(strings.Replace(filepath.Base(metaPath), ".json", "", -1))
-		doc.NumLines = len(doc.Lines)
-		doc.Lines = doc.Lines[:0]
-		if doc.Identifier != "" {
-			documents = append(documents, doc)
+		doc, err := s.readDocument(metaPath)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("metaPath", metaPath).
+				Msg("Could not read document")
+			continue
+		}
+		if err := fn(metaPath, doc); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// LinesPerYear counts the transcribed lines in the store for every year
+func (s *DocumentStore) LinesPerYear() (map[int]int, error) {
+	counts := make(map[int]int)
+	err := s.forEachDocument(func(doc *Document) error {
+		counts[doc.Year] += len(doc.Lines)
+		return nil
+	})
+	return counts, err
+}
+
+// List all documents, see ListVolumes. Documents that could not be listed
+// are logged and left out.
+func (s *DocumentStore) List() []*Document {
+	documents, err := s.ListVolumes()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not list all documents")
+	}
 	return documents
 }
 
+// removeDeletedLines removes the stored lines of a volume that are no
+// longer part of it. Files are matched by their full name, so that lines of
+// volumes whose identifier starts with the same prefix are left alone.
 func (s *DocumentStore) removeDeletedLines(doc Document) {
 	basePath := filepath.Join(s.basePath, "transcriptions", strconv.Itoa(doc.Year))
-	globPat := basePath + "/" + doc.Identifier + "*.png"
-	lpaths, _ := filepath.Glob(globPat)
+	prefix := doc.Identifier + "_"
+	kept := make(map[string]bool, len(doc.Lines))
+	for _, line := range doc.Lines {
+		kept[prefix+line.Identifier] = true
+	}
+	lpaths, _ := filepath.Glob(filepath.Join(basePath, prefix+"*.png"))
 	for _, lpath := range lpaths {
 		baseName := strings.TrimSuffix(filepath.Base(lpath), filepath.Ext(lpath))
-		match := lineNamePat.FindStringSubmatch(baseName)
-		if len(match) == 0 {
-			continue
-		}
-		ident := match[2]
-		found := false
-		for _, line := range doc.Lines {
-			if line.Identifier == ident {
-				found = true
-				break
-			}
-		}
-		if !found {
+		if isVolumeLine(baseName, doc.Identifier) && !kept[baseName] {
 			if err := s.repo.Remove(lpath); err != nil {
 				panic(err)
 			}
@@ -145,15 +301,98 @@ func (s *DocumentStore) removeDeletedLines(doc Document) {
 
 // Save a document
 func (s *DocumentStore) Save(doc Document, author string, email string, comment string) (*Document, error) {
+	return Submit(s, doc, author, email, comment)
+}
+
+// SaveVolume writes the line data and metadata of a validated document to
+// the repository and commits it. With CommitBatching, the commit is shared
+// with other submissions and SaveVolume returns once it was made.
+func (s *DocumentStore) SaveVolume(doc Document, reviewFlags map[string][]string, author string, email string, comment string) (*Document, error) {
+	if CommitBatching.Enabled() {
+		result := s.submitBatched(&batchedSubmission{
+			doc:         doc,
+			reviewFlags: reviewFlags,
+			author:      author,
+			email:       email,
+			comment:     comment,
+		})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.Commit == "" && len(result.Document.History) > 0 {
+			result.Commit = result.Document.History[0].Commit
+		}
+		result.Document.Commit = result.Commit
+		result.Document.PullRequest = result.PullRequest
+		return &result.Document, nil
+	}
 	logger := log.With().Str("identifier", doc.Identifier).Logger()
-	logger.Info().Msg("Cleaning up repository")
-	if err := s.repo.CleanUp(); err != nil {
+	unlockRepo, err := s.lockRepo()
+	if err != nil {
 		return nil, err
 	}
-	logger.Info().Msg("Pulling from origin")
-	if err := s.repo.Pull("origin", "master", true); err != nil {
+	defer unlockRepo()
+	lock, err := lockVolume(s.lockDir, doc.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	if err := s.syncRepo(logger); err != nil {
+		return nil, err
+	}
+	staged, err := s.stageVolume(doc, reviewFlags, author, email, comment, nil, logger)
+	if err != nil {
 		return nil, err
 	}
+	var commit, pullRequest string
+	if staged != nil {
+		if commit, pullRequest, err = s.commitAndPush(doc.Identifier, staged.message(), author, email, logger); err != nil {
+			return nil, err
+		}
+	}
+	stored := s.Details(doc.Identifier)
+	if stored != nil {
+		if commit == "" && len(stored.History) > 0 {
+			// Nothing changed, the submission is recorded in the last commit
+			commit = stored.History[0].Commit
+		}
+		stored.Commit = commit
+		stored.PullRequest = pullRequest
+	}
+	return stored, nil
+}
+
+// stagedVolume is a submission whose changes are staged, but not committed
+type stagedVolume struct {
+	ident string
+	// First line of the commit message, parsed by parseContribution
+	subject  string
+	comment  string
+	trailers string
+}
+
+// message returns the commit message for the volume on its own
+func (v *stagedVolume) message() string {
+	message := v.subject
+	if v.comment != "" {
+		message += ("\n" + v.comment)
+	}
+	if v.trailers != "" {
+		message += "\n\n" + v.trailers
+	}
+	return message
+}
+
+// stageVolume writes and stages the line data and metadata of a validated
+// document. Changes that were staged before, passed as alreadyStaged, are
+// not attributed to the document. Returns nil if an update did not change
+// anything.
+func (s *DocumentStore) stageVolume(doc Document, reviewFlags map[string][]string, author string, email string, comment string, alreadyStaged map[string]FileStatus, logger zerolog.Logger) (*stagedVolume, error) {
+	if LFSLineImages {
+		if err := s.trackLineImagesInLFS(); err != nil {
+			return nil, err
+		}
+	}
 
 	yearPath := filepath.Join(
 		s.basePath, "transcriptions", strconv.Itoa(doc.Year))
@@ -163,8 +402,17 @@ func (s *DocumentStore) Save(doc Document, author string, email string, comment
 	doc.History = doc.History[:0]
 	metaPath := filepath.Join(yearPath, doc.Identifier+".json")
 	isUpdate := false
+	var previous *Document
 	if _, err := os.Stat(metaPath); !os.IsNotExist(err) {
 		isUpdate = true
+		prev, err := s.readDocument(metaPath)
+		if err != nil {
+			return nil, err
+		}
+		previous = prev
+		if doc.Script == "" {
+			doc.Script = previous.Script
+		}
 	}
 
 	ident := doc.Identifier
@@ -179,8 +427,8 @@ func (s *DocumentStore) Save(doc Document, author string, email string, comment
 		if err != nil {
 			return nil, err
 		}
-		// We don't store the transcriptions in the JSON
-		doc.Lines[idx].Transcription = ""
+		doc.Lines[idx].Flags = keepReviewFlags(line, previous, reviewFlags[line.Identifier])
+		doc.Lines[idx].Session = keepSession(line, previous)
 	}
 	logger.Info().Int("numRemoved", len(toRemove)).Msg("Removed empty lines")
 	filtered := make([]OCRLine, 0, len(doc.Lines)-len(toRemove))
@@ -190,6 +438,10 @@ func (s *DocumentStore) Save(doc Document, author string, email string, comment
 		}
 	}
 	doc.Lines = filtered
+	sortLines(doc.Lines)
+	if AnnotateHyphenation {
+		annotateHyphenation(doc.Lines)
+	}
 	doc.NumLines = 0
 	if err := LineCache.PurgeLines(ident); err != nil {
 		return nil, err
@@ -198,35 +450,27 @@ func (s *DocumentStore) Save(doc Document, author string, email string, comment
 		s.removeDeletedLines(doc)
 	}
 
-	// Write metadata
 	logger.Info().Msg("Writing metadata")
-	metaOut, _ := os.Create(metaPath)
-	enc := json.NewEncoder(metaOut)
-	enc.SetIndent("", "  ")
-	enc.Encode(doc)
-	metaOut.Close()
-	if err := s.repo.Add(metaPath); err != nil {
+	if err := s.writeMetadata(doc); err != nil {
 		return nil, err
 	}
-
-	logger.Info().Msg("Creating README")
-	readme := s.createReadme()
-	readmePath := filepath.Join(s.basePath, "README.md")
-	readmeOut, _ := os.Create(readmePath)
-	readmeOut.WriteString(readme)
-	readmeOut.Close()
-	if err := s.repo.Add(readmePath); err != nil {
+	if err := s.writeReadme(logger); err != nil {
 		return nil, err
 	}
-	var commitMessage string
+	var subject string
 	if isUpdate {
-		commitMessage = fmt.Sprintf("Reviewed %s (%d)", doc.Identifier, doc.Year)
+		subject = fmt.Sprintf("Reviewed %s (%d)", doc.Identifier, doc.Year)
 		changes, err := s.repo.Diff(true)
 		if err != nil {
 			return nil, err
 		}
+		for fname, change := range alreadyStaged {
+			if changes[fname] == change {
+				delete(changes, fname)
+			}
+		}
 		if len(changes) == 0 {
-			return s.Details(doc.Identifier), nil
+			return nil, nil
 		}
 		numModified := 0
 		numDeleted := 0
@@ -241,29 +485,229 @@ func (s *DocumentStore) Save(doc Document, author string, email string, comment
 			}
 		}
 		if numModified > 0 {
-			commitMessage += fmt.Sprintf(", corrected %d", numModified)
+			subject += fmt.Sprintf(", corrected %d", numModified)
 		}
 		if numDeleted > 0 {
-			commitMessage += fmt.Sprintf(", deleted %d", numDeleted)
+			subject += fmt.Sprintf(", deleted %d", numDeleted)
 		}
 		if numModified > 0 || numDeleted > 0 {
-			commitMessage += " lines"
+			subject += " lines"
 		}
 	} else {
-		commitMessage = fmt.Sprintf(
+		subject = fmt.Sprintf(
 			"Transcribed %d lines from %s (%d)", len(doc.Lines), doc.Identifier,
 			doc.Year)
 	}
-	if comment != "" {
-		commitMessage += ("\n" + comment)
+	if len(reviewFlags) > 0 {
+		subject += fmt.Sprintf(", %d flagged for review", len(reviewFlags))
+	}
+	var contributors []LogEntry
+	if isUpdate && CommitTrailers.CoAuthors {
+		history, err := s.history(metaPath)
+		if err != nil {
+			return nil, err
+		}
+		contributors = history
 	}
-	if _, err := s.repo.Commit(commitMessage, author, email); err != nil {
+	return &stagedVolume{
+		ident:    doc.Identifier,
+		subject:  subject,
+		comment:  comment,
+		trailers: CommitTrailers.build(author, email, contributors),
+	}, nil
+}
+
+// keepReviewFlags determines the review flags for a saved line. Lines that
+// did not change since the last save keep their previous flags, so lines
+// that were already cleared by a maintainer are not flagged again.
+func keepReviewFlags(line OCRLine, previous *Document, flags []string) []string {
+	if previous == nil {
+		return flags
+	}
+	for _, prevLine := range previous.Lines {
+		if prevLine.Identifier == line.Identifier && prevLine.Transcription == line.Transcription {
+			return prevLine.Flags
+		}
+	}
+	return flags
+}
+
+// keepSession determines the session recorded for a saved line. Lines that
+// did not change since the last save stay attributed to the session that
+// transcribed them.
+func keepSession(line OCRLine, previous *Document) string {
+	if previous == nil {
+		return line.Session
+	}
+	for _, prevLine := range previous.Lines {
+		if prevLine.Identifier == line.Identifier && prevLine.Transcription == line.Transcription {
+			return prevLine.Session
+		}
+	}
+	return line.Session
+}
+
+// lockRepo serializes the changes to the repository. The working copy, its
+// index and the pushes are shared by all volumes, so syncing, staging and
+// committing must not overlap even for different volumes. Goroutines are
+// excluded by a mutex and other processes sharing the repository by a lock
+// file. It is acquired before any volume lock.
+func (s *DocumentStore) lockRepo() (unlock func(), err error) {
+	s.repoMutex.Lock()
+	lock, err := lockRepoFile(s.lockDir)
+	if err != nil {
+		s.repoMutex.Unlock()
 		return nil, err
 	}
-	logger.Info().Msg("Committed")
-	s.repo.Push("origin", "master")
-	logger.Info().Msg("Pushed")
-	return s.Details(doc.Identifier), nil
+	return func() {
+		lock.Unlock()
+		s.repoMutex.Unlock()
+	}, nil
+}
+
+// syncRepo discards residual modifications and pulls from origin
+func (s *DocumentStore) syncRepo(logger zerolog.Logger) error {
+	logger.Info().Msg("Cleaning up repository")
+	if err := s.repo.CleanUp(); err != nil {
+		return err
+	}
+	if Offline {
+		return nil
+	}
+	if PullRequests.Enabled() {
+		// Commits only reach the base branch through pull requests, the
+		// ones of earlier submissions are dropped locally
+		logger.Info().Str("base", PullRequests.Base).Msg("Resetting to origin")
+		return s.repo.ResetToRemote("origin", PullRequests.Base)
+	}
+	logger.Info().Msg("Pulling from origin")
+	if err := s.repo.Pull("origin", "master", true); err != nil {
+		return err
+	}
+	// Push commits that failed to be pushed before, so that a retried
+	// submission finds its changes committed instead of committing them again
+	ahead, err := s.repo.CommitsAhead("origin", "master")
+	if err != nil {
+		return err
+	}
+	if ahead == 0 {
+		return nil
+	}
+	logger.Info().Int("numCommits", ahead).Msg("Pushing earlier commits")
+	return s.repo.Push("origin", "master")
+}
+
+// writeMetadata writes and stages the metadata for a document, without its
+// transcriptions and history
+func (s *DocumentStore) writeMetadata(doc Document) error {
+	metaPath := filepath.Join(
+		s.basePath, "transcriptions", strconv.Itoa(doc.Year), doc.Identifier+".json")
+	metaJSON, err := metadataJSON(doc)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(metaPath, metaJSON); err != nil {
+		return err
+	}
+	return s.repo.Add(metaPath)
+}
+
+// metadataJSON serializes a document as it is stored on disk, without its
+// transcriptions and history
+func metadataJSON(doc Document) ([]byte, error) {
+	doc.History = nil
+	doc.Commit = ""
+	doc.PullRequest = ""
+	lines := make([]OCRLine, len(doc.Lines))
+	for idx, line := range doc.Lines {
+		// We don't store the transcriptions in the JSON
+		line.Transcription = ""
+		// Only the direct neighbours are kept, the wider context can be
+		// derived from them
+		line.PreviousImageURLs = nil
+		line.NextImageURLs = nil
+		line.ImageSrc = ""
+		lines[idx] = line
+	}
+	doc.Lines = lines
+	return marshalJSON(doc)
+}
+
+// ReadmePath is where the README of the corpus is written, relative to the
+// repository root unless it is absolute. It is only committed if it lies
+// inside the repository.
+var ReadmePath = "README.md"
+
+func (s *DocumentStore) writeReadme(logger zerolog.Logger) error {
+	readmePath := ReadmePath
+	if !filepath.IsAbs(readmePath) {
+		readmePath = filepath.Join(s.basePath, readmePath)
+	}
+	logger.Info().Str("path", readmePath).Msg("Creating README")
+	stats, err := s.stats()
+	if err != nil {
+		return err
+	}
+	statsJSON, err := marshalJSON(stats)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(readmePath), 0755); err != nil {
+		return err
+	}
+	statsPath := filepath.Join(filepath.Dir(readmePath), StatsFileName)
+	if err := s.writeCorpusFile(readmePath, []byte(RenderReadme(stats))); err != nil {
+		return err
+	}
+	return s.writeCorpusFile(statsPath, statsJSON)
+}
+
+// writeCorpusFile atomically replaces a file and stages it if it lies inside
+// the repository
+func (s *DocumentStore) writeCorpusFile(path string, data []byte) error {
+	if err := WriteFileAtomic(path, data); err != nil {
+		return err
+	}
+	if relPath, err := filepath.Rel(s.basePath, path); err != nil || strings.HasPrefix(relPath, "..") {
+		return nil
+	}
+	return s.repo.Add(path)
+}
+
+// commitAndPush commits the staged changes and pushes them. With
+// PullRequests, the commit is pushed to a new branch named after the topic
+// and a pull request for it is opened, its URL is returned.
+func (s *DocumentStore) commitAndPush(topic string, message string, author string, email string, logger zerolog.Logger) (commit string, pullRequest string, err error) {
+	commit, err = s.repo.Commit(message, commitAuthor(author, email), Committer, commitTime())
+	observeResult(gitCommits, err)
+	if err != nil {
+		return "", "", err
+	}
+	logger.Info().Str("commit", commit).Msg("Committed")
+	if Offline {
+		logger.Info().Msg("Offline, not pushing")
+		return commit, "", nil
+	}
+	if !PullRequests.Enabled() {
+		// The commit stays in the working copy if pushing fails, it is
+		// pushed with the next submission or retry, see syncRepo
+		if err := s.repo.Push("origin", "master"); err != nil {
+			return commit, "", err
+		}
+		logger.Info().Msg("Pushed")
+		return commit, "", nil
+	}
+	branch := pullRequestBranch(topic, commit)
+	if err := s.repo.Push("origin", "HEAD:refs/heads/"+branch); err != nil {
+		return commit, "", err
+	}
+	logger.Info().Str("branch", branch).Msg("Pushed")
+	pullRequest, err = PullRequests.open(branch, message)
+	if err != nil {
+		return commit, "", err
+	}
+	logger.Info().Str("pullRequest", pullRequest).Msg("Opened pull request")
+	return commit, pullRequest, nil
 }
 
 func (s *DocumentStore) writeLineData(doc Document, line OCRLine) error {
@@ -279,26 +723,30 @@ func (s *DocumentStore) writeLineData(doc Document, line OCRLine) error {
 			log.Warn().
 				Str("lineId", line.Identifier).
 				Msg("Line image was not cached, fetching it.")
-			path, err := LineCache.CacheLine(line.ImageURL, line.Identifier)
+			path, err := LineCache.CacheLine(line.ImageURL, cacheID)
 			if err != nil {
 				return err
 			}
 			cachedPath = path
 		}
 
-		// Move line image from cache into repository
-		in, err := os.Open(cachedPath)
-		if err != nil {
+		// Copy line image from cache into repository. The cached file may be
+		// shared with other lines, it is removed when the volume's lines are
+		// purged from the cache.
+		var in *os.File
+		err := retryFileOp(true, func() (err error) {
+			in, err = openFile(cachedPath)
 			return err
-		}
-		out, err := os.Create(imgPath)
+		})
 		if err != nil {
 			return err
 		}
-		io.Copy(out, in)
+		err = writeAtomic(imgPath, func(w io.Writer) error {
+			_, err := io.Copy(w, in)
+			return err
+		})
 		in.Close()
-		out.Close()
-		if err := os.Remove(cachedPath); err != nil {
+		if err != nil {
 			return err
 		}
 		if err := s.repo.Add(imgPath); err != nil {
@@ -308,95 +756,8 @@ func (s *DocumentStore) writeLineData(doc Document, line OCRLine) error {
 
 	// Write transcription
 	transPath := basePath + ".txt"
-	transOut, err := os.Create(transPath)
-	if err != nil {
+	if err := WriteFileAtomic(transPath, []byte(line.Transcription+"\n")); err != nil {
 		return err
 	}
-	if _, err = transOut.WriteString(line.Transcription + "\n"); err != nil {
-		return err
-	}
-	transOut.Close()
 	return s.repo.Add(transPath)
 }
-
-func (s *DocumentStore) createReadme() string {
-	documents := s.List()
-	sort.Slice(documents, func(i, j int) bool {
-		return documents[i].Year < documents[j].Year
-	})
-
-	numLinesTotal := 0
-	yearCount := map[int]int{}
-	decadeCount := map[int]int{}
-	metaRows := [][]string{}
-	for _, doc := range documents {
-		numLinesTotal += doc.NumLines
-		decade := (doc.Year / 10) * 10
-		yearCount[doc.Year] += doc.NumLines
-		decadeCount[decade] += doc.NumLines
-		archiveLink := fmt.Sprintf(
-			"[%s](http://archive.org/details/%s)", doc.Identifier, doc.Identifier)
-		manifestLink := fmt.Sprintf(
-			"[Manifest](https://iiif.archivelab.org/iiif/%s/manifest.json)",
-			doc.Identifier)
-		miradorLink := fmt.Sprintf(
-			"[Mirador](https://iiif.archivelab.org/iiif/%s)", doc.Identifier)
-		metaRows = append(metaRows, []string{
-			doc.Title, strconv.Itoa(doc.Year),
-			archiveLink, fmt.Sprintf("%s/%s", manifestLink, miradorLink)})
-	}
-
-	var yearsTable bytes.Buffer
-	var years []int
-	for k := range yearCount {
-		years = append(years, k)
-	}
-	sort.Ints(years)
-	t := tablewriter.NewWriter(&yearsTable)
-	t.SetAutoFormatHeaders(false)
-	t.SetHeader([]string{"Year", "# lines"})
-	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
-	t.SetCenterSeparator("|")
-	for _, year := range years {
-		t.Append([]string{strconv.Itoa(year), strconv.Itoa(yearCount[year])})
-	}
-	t.Render()
-
-	var decadesTable bytes.Buffer
-	var decades []int
-	for k := range decadeCount {
-		decades = append(decades, k)
-	}
-	sort.Ints(decades)
-	t = tablewriter.NewWriter(&decadesTable)
-	t.SetAutoFormatHeaders(false)
-	t.SetHeader([]string{"Decade", "# lines"})
-	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
-	t.SetCenterSeparator("|")
-	for _, decade := range decades {
-		t.Append([]string{strconv.Itoa(decade), strconv.Itoa(decadeCount[decade])})
-	}
-	t.Render()
-
-	var metaTable bytes.Buffer
-	t = tablewriter.NewWriter(&metaTable)
-	t.SetAutoFormatHeaders(false)
-	t.SetAutoWrapText(false)
-	t.SetHeader([]string{"Title", "Date", "Archive.org", "IIIF"})
-	t.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
-	t.SetCenterSeparator("|")
-	t.AppendBulk(metaRows)
-	t.Render()
-
-	var out bytes.Buffer
-	tmpl := template.Must(template.New("README.md").Parse(readmeTemplate))
-	tmpl.Execute(&out, map[string]string{
-		"numLines":    strconv.Itoa(numLinesTotal),
-		"numWorks":    strconv.Itoa(len(documents)),
-		"numYears":    strconv.Itoa(len(years)),
-		"decadeTable": decadesTable.String(),
-		"yearTable":   yearsTable.String(),
-		"worksTable":  metaTable.String(),
-	})
-	return out.String()
-}
@@ -0,0 +1,596 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storedLineFiles returns the names of the line files of a year in the
+// working tree
+func storedLineFiles(t *testing.T, store *DocumentStore, year string, ext string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(store.basePath, "transcriptions", year, "*"+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestUpdateKeepsLinesWithConfiguredDigest(t *testing.T) {
+	tests := []struct {
+		name   string
+		digest DigestOptions
+	}{
+		{"default", DigestOptions{Algorithm: DigestSHA1, Length: 8}},
+		{"long sha1", DigestOptions{Algorithm: DigestSHA1, Length: 12}},
+		{"full sha256", DigestOptions{Algorithm: DigestSHA256}},
+		{"short sha256", DigestOptions{Algorithm: DigestSHA256, Length: 6}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withGlobals(t, func() { LineDigest = tc.digest })
+			store, _ := newTestStore(t)
+			const ident = "zeitung_1850"
+			lines := []OCRLine{
+				testLine(ident, 0, "Die Zeitung erscheint täglich"),
+				testLine(ident, 1, "mit Ausnahme der Sonntage"),
+				testLine(ident, 2, "Preis vierteljährlich zwei Thaler"),
+			}
+			for _, line := range lines {
+				cacheTestLine(t, ident, line)
+			}
+			// Lines of another volume whose identifier shares the prefix
+			// must survive updates of the first one
+			const other = "zeitung_1850_beilage"
+			otherLine := testLine(other, 0, "Beilage zur Zeitung")
+			cacheTestLine(t, other, otherLine)
+			docs := []Document{
+				{Identifier: ident, Year: 1850, Lines: append([]OCRLine(nil), lines...)},
+				{Identifier: other, Year: 1850, Lines: []OCRLine{otherLine}},
+			}
+			for _, doc := range docs {
+				if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+					t.Fatalf("saving %s: %v", doc.Identifier, err)
+				}
+			}
+			before := storedLineFiles(t, store, "1850", ".png")
+			if len(before) != 4 {
+				t.Fatalf("expected 4 stored line images, got %v", before)
+			}
+
+			// Correct one line and drop the last one
+			update := Document{Identifier: ident, Year: 1850, Lines: []OCRLine{
+				lines[0],
+				testLine(ident, 1, "mit Ausnahme der Sonn- und Festtage"),
+			}}
+			if _, err := store.Save(update, "Test", "test@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				ident + "_" + lines[0].Identifier + ".png",
+				ident + "_" + lines[1].Identifier + ".png",
+				other + "_" + otherLine.Identifier + ".png",
+			}
+			sort.Strings(want)
+			got := storedLineFiles(t, store, "1850", ".png")
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("stored images after update:\n got %v\nwant %v", got, want)
+			}
+			txtPath := filepath.Join(
+				store.basePath, "transcriptions", "1850", ident+"_"+lines[1].Identifier+".txt")
+			raw, err := ioutil.ReadFile(txtPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != "mit Ausnahme der Sonn- und Festtage\n" {
+				t.Errorf("corrected transcription not stored, got %q", raw)
+			}
+		})
+	}
+}
+
+func TestFailedPushIsRetriedWithoutNewCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		batching BatchOptions
+	}{
+		{"single", BatchOptions{}},
+		{"batched", BatchOptions{Window: 10 * time.Millisecond}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withGlobals(t, func() { CommitBatching = tc.batching })
+			store, origin := newTestStore(t)
+			const ident = "zeitung_1851"
+			line := testLine(ident, 0, "Amtliche Bekanntmachungen")
+			cacheTestLine(t, ident, line)
+			doc := Document{Identifier: ident, Year: 1851, Lines: []OCRLine{line}}
+
+			// Let the origin reject the push of the first attempt
+			hook := filepath.Join(origin, "hooks", "pre-receive")
+			if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err == nil {
+				t.Fatal("expected the failed push to be reported")
+			}
+			localHead := git(t, store.basePath, "rev-parse", "HEAD")
+			if count := git(t, store.basePath, "rev-list", "--count", "HEAD"); count != "2" {
+				t.Fatalf("expected the submission to be committed locally, got %s commits", count)
+			}
+
+			if err := os.Remove(hook); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatalf("retry failed: %v", err)
+			}
+			if head := git(t, store.basePath, "rev-parse", "HEAD"); head != localHead {
+				t.Errorf("retry committed again, HEAD moved from %s to %s", localHead, head)
+			}
+			if pushed := git(t, origin, "rev-parse", "master"); pushed != localHead {
+				t.Errorf("origin is at %s, expected the earlier commit %s", pushed, localHead)
+			}
+			if count := git(t, origin, "rev-list", "--count", "master"); count != "2" {
+				t.Errorf("expected the initial and one submission commit on origin, got %s", count)
+			}
+		})
+	}
+}
+
+func TestSaveReturnsCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		batching BatchOptions
+	}{
+		{"single", BatchOptions{}},
+		{"batched", BatchOptions{Window: 10 * time.Millisecond}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withGlobals(t, func() { CommitBatching = tc.batching })
+			store, _ := newTestStore(t)
+			const ident = "zeitung_1852"
+			line := testLine(ident, 0, "Inserate werden angenommen")
+			cacheTestLine(t, ident, line)
+			doc := Document{Identifier: ident, Year: 1852, Lines: []OCRLine{line}}
+			saved, err := store.Save(doc, "Test", "test@example.org", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			head := git(t, store.basePath, "rev-parse", "HEAD")
+			// Commits are abbreviated like in the history
+			if saved.Commit == "" || !strings.HasPrefix(head, saved.Commit) {
+				t.Errorf("returned commit %q, want HEAD %s", saved.Commit, head)
+			}
+
+			// Submitting the same transcriptions again changes nothing
+			saved, err = store.Save(doc, "Test", "test@example.org", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Commit == "" || !strings.HasPrefix(head, saved.Commit) {
+				t.Errorf("unchanged submission returned commit %q, want the last one %s", saved.Commit, head)
+			}
+			if stored := store.Details(ident); stored.Commit != "" {
+				t.Errorf("commit %q leaked into the stored metadata", stored.Commit)
+			}
+		})
+	}
+}
+
+func TestListVolumesSkipsUnreadableDocuments(t *testing.T) {
+	store, _ := newTestStore(t)
+	const ident = "zeitung_1853"
+	line := testLine(ident, 0, "Anzeigen und Bekanntmachungen")
+	cacheTestLine(t, ident, line)
+	doc := Document{Identifier: ident, Year: 1853, Lines: []OCRLine{line}}
+	if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	// A document whose transcriptions are missing, like one that is being
+	// removed while the volumes are listed
+	broken := Document{Identifier: "zeitung_1854", Year: 1853, Lines: []OCRLine{testLine("zeitung_1854", 0, "")}}
+	raw, _ := json.Marshal(broken)
+	brokenPath := filepath.Join(store.basePath, "transcriptions", "1853", "zeitung_1854.json")
+	if err := ioutil.WriteFile(brokenPath, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := store.ListVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Identifier != ident {
+		t.Fatalf("expected only %s to be listed, got %+v", ident, volumes)
+	}
+	if volumes[0].NumLines != 1 || len(volumes[0].Lines) != 0 || len(volumes[0].History) != 1 {
+		t.Errorf("expected the listed volume with its history, but without lines, got %+v", volumes[0])
+	}
+}
+
+func TestConcurrentSavesOfDifferentVolumes(t *testing.T) {
+	store, origin := newTestStore(t)
+	const numVolumes = 4
+	docs := make([]Document, numVolumes)
+	for idx := range docs {
+		ident := fmt.Sprintf("zeitung_%d", 1860+idx)
+		line := testLine(ident, 0, "Verlag und Druck der Expedition")
+		cacheTestLine(t, ident, line)
+		docs[idx] = Document{Identifier: ident, Year: 1860 + idx, Lines: []OCRLine{line}}
+	}
+	var wg sync.WaitGroup
+	for _, doc := range docs {
+		wg.Add(1)
+		go func(doc Document) {
+			defer wg.Done()
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Errorf("saving %s: %v", doc.Identifier, err)
+			}
+		}(doc)
+	}
+	wg.Wait()
+	if count := git(t, origin, "rev-list", "--count", "master"); count != strconv.Itoa(numVolumes+1) {
+		t.Errorf("expected the initial and one commit per volume on origin, got %s", count)
+	}
+	files := git(t, store.basePath, "ls-tree", "-r", "--name-only", "HEAD")
+	for _, doc := range docs {
+		metaPath := fmt.Sprintf("transcriptions/%d/%s.json", doc.Year, doc.Identifier)
+		if !strings.Contains(files, metaPath) {
+			t.Errorf("%s is missing from the committed tree", metaPath)
+		}
+	}
+}
+
+func TestStoresSharingRepositoryCommitInTurn(t *testing.T) {
+	first, origin := newTestStore(t)
+	// A second store on the same working copy stands in for another server
+	// process, it shares nothing with the first but the repository
+	second, err := NewDocumentStore(first.basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numRounds = 3
+	var wg sync.WaitGroup
+	for storeIdx, store := range []*DocumentStore{first, second} {
+		wg.Add(1)
+		go func(storeIdx int, store *DocumentStore) {
+			defer wg.Done()
+			for round := 0; round < numRounds; round++ {
+				ident := fmt.Sprintf("anzeiger_%d_%d", storeIdx, round)
+				line := testLine(ident, 0, "Bekanntmachung des Magistrats")
+				cacheTestLine(t, ident, line)
+				doc := Document{Identifier: ident, Year: 1870, Lines: []OCRLine{line}}
+				if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+					t.Errorf("saving %s: %v", ident, err)
+				}
+			}
+		}(storeIdx, store)
+	}
+	wg.Wait()
+	if count := git(t, origin, "rev-list", "--count", "master"); count != strconv.Itoa(2*numRounds+1) {
+		t.Errorf("expected the initial and one commit per volume on origin, got %s", count)
+	}
+	if status := git(t, first.basePath, "status", "--porcelain"); status != "" {
+		t.Errorf("working copy was left with changes:\n%s", status)
+	}
+}
+
+func TestMetadataIndentation(t *testing.T) {
+	tests := []struct {
+		name   string
+		indent string
+	}{
+		{"two spaces", "  "},
+		{"tabs", "\t"},
+		{"compact", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withGlobals(t, func() { JSONIndent = tc.indent })
+			store, _ := newTestStore(t)
+			const ident = "wochenschrift_1853"
+			line := testLine(ident, 0, "Zur Unterhaltung und Belehrung")
+			cacheTestLine(t, ident, line)
+			doc := Document{Identifier: ident, Title: "Wochenschrift", Year: 1853, Lines: []OCRLine{line}}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := ioutil.ReadFile(store.metaPath(ident))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(string(raw), "}\n") {
+				t.Errorf("expected a trailing newline, got %q", raw)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+			if tc.indent == "" && len(lines) != 1 {
+				t.Errorf("expected compact output on one line, got %d lines", len(lines))
+			} else if tc.indent != "" && (len(lines) < 2 || lines[1] != tc.indent+`"id": "`+ident+`",`) {
+				t.Errorf("expected the identifier first with indentation %q, got:\n%s", tc.indent, raw)
+			}
+			// Keys are written in the same order every time
+			keys := []string{`"id"`, `"title"`, `"year"`, `"manifest"`, `"lines"`, `"reviewed"`}
+			last := -1
+			for _, key := range keys {
+				idx := strings.Index(string(raw), key)
+				if idx <= last {
+					t.Errorf("key %s is out of order in:\n%s", key, raw)
+				}
+				last = idx
+			}
+			again, err := metadataJSON(*store.Details(ident))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(raw) {
+				t.Errorf("re-serializing the stored volume changed it:\n got %s\nwant %s", again, raw)
+			}
+		})
+	}
+}
+
+// regionLine returns a line whose IIIF URL places it on a page
+func regionLine(volumeID string, page int, x int, y int, transcription string) OCRLine {
+	url := fmt.Sprintf(
+		"https://iiif.archivelab.org/iiif/%s$%d/%d,%d,1400,60/full/0/default.png", volumeID, page, x, y)
+	return OCRLine{
+		Identifier:    LineDigest.Digest([]byte(url)),
+		ImageURL:      url,
+		Transcription: transcription,
+		OCRText:       transcription,
+	}
+}
+
+func TestLinesAreStoredInReadingOrder(t *testing.T) {
+	const ident = "volksfreund_1854"
+	ordered := []OCRLine{
+		regionLine(ident, 11, 150, 200, "Erste Spalte oben"),
+		regionLine(ident, 11, 150, 260, "Erste Spalte unten"),
+		regionLine(ident, 11, 900, 260, "Zweite Spalte unten"),
+		regionLine(ident, 12, 150, 100, "Nächste Seite"),
+	}
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"in order", []int{0, 1, 2, 3}},
+		{"reversed", []int{3, 2, 1, 0}},
+		{"shuffled", []int{2, 0, 3, 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store, _ := newTestStore(t)
+			doc := Document{Identifier: ident, Title: "Volksfreund", Year: 1854}
+			for _, idx := range tc.order {
+				cacheTestLine(t, ident, ordered[idx])
+				doc.Lines = append(doc.Lines, ordered[idx])
+			}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := ioutil.ReadFile(store.metaPath(ident))
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := -1
+			for _, line := range ordered {
+				idx := strings.Index(string(raw), line.Identifier)
+				if idx <= last {
+					t.Errorf("line %q is not written in reading order", line.OCRText)
+				}
+				last = idx
+			}
+			stored := store.Details(ident)
+			if len(stored.Lines) != len(ordered) {
+				t.Fatalf("expected %d lines, got %d", len(ordered), len(stored.Lines))
+			}
+			for idx, line := range stored.Lines {
+				if line.Identifier != ordered[idx].Identifier || line.Transcription != ordered[idx].Transcription {
+					t.Errorf("line %d re-read as %q, want %q", idx, line.Transcription, ordered[idx].Transcription)
+				}
+			}
+		})
+	}
+}
+
+func TestReadLegacyLineMap(t *testing.T) {
+	const ident = "volksfreund_1854"
+	first := regionLine(ident, 11, 150, 200, "")
+	second := regionLine(ident, 11, 150, 260, "")
+	third := regionLine(ident, 12, 150, 100, "")
+	// Lines used to be stored in a map by their identifier, without the
+	// identifier in the line itself
+	raw := fmt.Sprintf(`{"id": %q, "year": 1854, "lines": {%q: {"line": %q}, %q: {"line": %q}, %q: {"line": %q}}}`,
+		ident, third.Identifier, third.ImageURL, first.Identifier, first.ImageURL, second.Identifier, second.ImageURL)
+	var doc Document
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := []OCRLine{first, second, third}
+	if len(doc.Lines) != len(want) {
+		t.Fatalf("expected %d lines, got %+v", len(want), doc.Lines)
+	}
+	for idx, line := range doc.Lines {
+		if line.Identifier != want[idx].Identifier || line.ImageURL != want[idx].ImageURL {
+			t.Errorf("line %d is %+v, want %s", idx, line, want[idx].ImageURL)
+		}
+	}
+}
+
+func TestBatchCommitTrailers(t *testing.T) {
+	withGlobals(t, func() {
+		CommitBatching = BatchOptions{Window: 100 * time.Millisecond}
+		CommitTrailers = TrailerOptions{CoAuthors: true, SignOff: true}
+	})
+	store, _ := newTestStore(t)
+	contributors := []struct {
+		ident, name, email string
+	}{
+		{"kreisblatt_1867", "Anna", "anna@example.org"},
+		{"kreisblatt_1868", "Bert", "bert@example.org"},
+	}
+	var docs []Document
+	for _, contributor := range contributors {
+		line := testLine(contributor.ident, 0, "Amtlicher Theil")
+		cacheTestLine(t, contributor.ident, line)
+		doc := Document{Identifier: contributor.ident, Year: 1867, Lines: []OCRLine{line}}
+		if _, err := store.Save(doc, contributor.name, contributor.email, ""); err != nil {
+			t.Fatal(err)
+		}
+		// The reviewer corrects the line of every volume in one batch
+		doc.Lines = []OCRLine{testLine(contributor.ident, 0, "Amtlicher Theil.")}
+		docs = append(docs, doc)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(docs))
+	for _, doc := range docs {
+		wg.Add(1)
+		go func(doc Document) {
+			defer wg.Done()
+			_, err := store.Save(doc, "Clara", "clara@example.org", "")
+			errs <- err
+		}(doc)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := git(t, store.basePath, "rev-list", "--count", "HEAD"); count != "4" {
+		t.Fatalf("expected both corrections in one commit, got %s commits", count)
+	}
+	// Parsed by git, like GitHub does
+	trailers := git(t, store.basePath, "log", "-1", "--format=%(trailers:only,unfold)")
+	want := []string{
+		"Co-authored-by: Anna <anna@example.org>",
+		"Co-authored-by: Bert <bert@example.org>",
+		"Signed-off-by: Clara <clara@example.org>",
+	}
+	got := strings.Split(trailers, "\n")
+	sort.Strings(got)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commit has trailers\n%s\nwant\n%s", trailers, strings.Join(want, "\n"))
+	}
+}
+
+func TestCommitTimestamp(t *testing.T) {
+	clockTime := time.Date(1999, 12, 31, 23, 59, 0, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name string
+		// Value of SOURCE_DATE_EPOCH, unset if empty
+		epoch string
+		want  time.Time
+	}{
+		{"fixed clock", "", clockTime},
+		{"source date epoch", "1000000000", time.Unix(1000000000, 0)},
+		{"invalid source date epoch", "yesterday", clockTime},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", tc.epoch)
+			if tc.epoch == "" {
+				os.Unsetenv("SOURCE_DATE_EPOCH")
+			}
+			previous := CommitClock
+			CommitClock = &fakeClock{now: clockTime}
+			defer func() { CommitClock = previous }()
+
+			store, _ := newTestStore(t)
+			const ident = "tageblatt_1848"
+			line := regionLine(ident, 11, 150, 200, "Berlin, 18. März")
+			cacheTestLine(t, ident, line)
+			doc := Document{Identifier: ident, Title: "Tageblatt", Year: 1848, Lines: []OCRLine{line}}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+			want := fmt.Sprintf("%d %d", tc.want.Unix(), tc.want.Unix())
+			if got := git(t, store.basePath, "log", "-1", "--format=%at %ct"); got != want {
+				t.Errorf("commit has author and committer time %s, want %s", got, want)
+			}
+			if tc.want == clockTime {
+				// The commit keeps the time zone of the clock
+				if got := git(t, store.basePath, "log", "-1", "--format=%ai"); got != "1999-12-31 23:59:00 +0100" {
+					t.Errorf("commit has author date %s", got)
+				}
+			}
+		})
+	}
+}
+
+func TestReadmePath(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "staging", "README.md")
+	tests := []struct {
+		name       string
+		readmePath string
+		// Path of the README relative to the repository, empty if it is
+		// outside of it
+		wantInRepo string
+	}{
+		{"default", "README.md", "README.md"},
+		{"docs folder", "docs/README.md", "docs/README.md"},
+		{"outside of the repository", outside, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withGlobals(t, func() { ReadmePath = tc.readmePath })
+			store, _ := newTestStore(t)
+			const ident = "hausfreund_1860"
+			line := regionLine(ident, 11, 150, 200, "Vermischte Nachrichten")
+			cacheTestLine(t, ident, line)
+			doc := Document{Identifier: ident, Title: "Hausfreund", Year: 1860, Lines: []OCRLine{line}}
+			if _, err := store.Save(doc, "Test", "test@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			path := tc.readmePath
+			if tc.wantInRepo != "" {
+				path = filepath.Join(store.basePath, tc.wantInRepo)
+			}
+			readme, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("README was not written to %s: %v", path, err)
+			}
+			if !strings.Contains(string(readme), "Hausfreund") {
+				t.Errorf("README at %s does not list the volume:\n%s", path, readme)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), StatsFileName)); err != nil {
+				t.Errorf("statistics were not written next to the README: %v", err)
+			}
+			if tc.readmePath != "README.md" {
+				// The initial README of the repository is left alone
+				if raw, _ := ioutil.ReadFile(filepath.Join(store.basePath, "README.md")); string(raw) != "corpus\n" {
+					t.Errorf("default README was overwritten:\n%s", raw)
+				}
+			}
+			committed := strings.Split(git(t, store.basePath, "show", "--name-only", "--format=", "HEAD"), "\n")
+			found := false
+			for _, name := range committed {
+				found = found || name == tc.wantInRepo
+			}
+			if tc.wantInRepo != "" && !found {
+				t.Errorf("README is not part of the commit %v", committed)
+			}
+			if status := git(t, store.basePath, "status", "--porcelain"); status != "" {
+				t.Errorf("uncommitted changes in the repository:\n%s", status)
+			}
+		})
+	}
+}
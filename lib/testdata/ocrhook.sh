@@ -0,0 +1,11 @@
+#!/bin/sh
+# Fake OCR engine for the tests: recognizes the two lines of page.png,
+# without reporting the page size
+head -c 8 | grep -q PNG || { echo "not a PNG image" >&2; exit 1; }
+cat > /dev/null
+cat <<'JSON'
+{"lines": [
+  {"l": 40, "t": 100, "r": 360, "b": 130, "text": "Erste Zeile ", "confidence": 0.6},
+  {"l": 40, "t": 160, "r": 360, "b": 190, "text": "Zweite Zeile"}
+]}
+JSON
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TrailerOptions configures the trailers that are appended to commit messages
+type TrailerOptions struct {
+	// Add a Co-authored-by trailer for every previous contributor to a volume
+	CoAuthors bool
+	// Add a Signed-off-by trailer for the submitting author
+	SignOff bool
+}
+
+// CommitTrailers are the trailers added to commits of submissions
+var CommitTrailers TrailerOptions
+
+// build generates the trailers for a commit by the given author. The
+// contributors are the authors of previous commits to the volume, entries
+// without an email or by the author themselves are skipped, since GitHub can
+// only attribute co-authors by their email.
+func (o TrailerOptions) build(author string, email string, contributors []LogEntry) string {
+	var trailers []string
+	if o.CoAuthors {
+		seen := map[string]bool{strings.ToLower(email): true}
+		for _, entry := range contributors {
+			key := strings.ToLower(entry.Author.Email)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			trailers = append(trailers, fmt.Sprintf(
+				"Co-authored-by: %s <%s>", entry.Author.Name, entry.Author.Email))
+		}
+	}
+	if o.SignOff && author != "" && email != "" {
+		trailers = append(trailers, fmt.Sprintf("Signed-off-by: %s <%s>", author, email))
+	}
+	return strings.Join(trailers, "\n")
+}
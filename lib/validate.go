@@ -0,0 +1,186 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ValidationLevel encodes how severe a validation problem with a line is
+type ValidationLevel string
+
+// Validation levels, from least to most severe
+const (
+	ValidationOK   ValidationLevel = "ok"
+	ValidationSoft ValidationLevel = "soft"
+	ValidationHard ValidationLevel = "hard"
+)
+
+// Reasons reported for failed validations, these are also used as the
+// review flags that are stored with soft-failing lines
+const (
+	ReasonInvalidEncoding  = "invalid-encoding"
+	ReasonControlCharacter = "control-character"
+	ReasonTooShort         = "too-short"
+	ReasonTooLong          = "too-long"
+	ReasonUnusualCharacter = "unusual-character"
+	ReasonImplausibleWords = "implausible-words"
+	ReasonDisallowedChars  = "disallowed-character"
+)
+
+// Minimum number of considered words for the plausibility check, shorter
+// lines give too many false positives
+const minPlausibilityWords = 2
+
+// Punctuation and symbols that are common in 19th century prints and
+// should not cause a line to be flagged
+const usualPunctuation = ".,:;!?-–—=⸗¬'\"„“”‚‘’»«()[]/&*§†+%"
+
+// LineValidation holds the validation result for a single line
+type LineValidation struct {
+	Identifier string          `json:"id"`
+	Level      ValidationLevel `json:"level"`
+	Reasons    []string        `json:"reasons,omitempty"`
+	// Characters outside of the allowed character set, for highlighting
+	Disallowed []DisallowedCharacter `json:"disallowed,omitempty"`
+}
+
+// ValidationError is returned when a document contains lines that failed
+// hard validation and thus cannot be committed
+type ValidationError struct {
+	Lines []LineValidation
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d lines failed validation", len(e.Lines))
+}
+
+// Validator checks transcriptions before they are committed. Hard failures
+// reject the submission, soft failures are committed but flagged for review.
+type Validator struct {
+	// Flag lines for review instead of committing them silently
+	SoftValidation bool
+	// Lines with fewer characters than this are flagged
+	MinLength int
+	// Lines with more characters than this are flagged
+	MaxLength int
+	// Dictionary for the plausibility check, disabled if nil
+	Dictionary *Dictionary
+	// Lines with a higher ratio of unknown words are flagged
+	MaxUnknownRatio float64
+	// Allowed characters by script, ScriptAny applies to volumes in scripts
+	// without their own set. Disabled if empty.
+	CharacterSets map[string]*CharacterSet
+	// Reject lines with disallowed characters instead of flagging them
+	RejectDisallowed bool
+}
+
+// Validation is the global validator used for submissions
+var Validation = &Validator{
+	SoftValidation:  true,
+	MinLength:       2,
+	MaxLength:       120,
+	MaxUnknownRatio: 0.5,
+}
+
+// ValidateLine checks a single transcription
+func (v *Validator) ValidateLine(line OCRLine) LineValidation {
+	return v.validateLine(line, v.characterSet(ScriptAny))
+}
+
+// characterSet returns the allowed characters for volumes in a script, nil
+// if they are not checked
+func (v *Validator) characterSet(script string) *CharacterSet {
+	if script == "" {
+		script = ScriptFraktur
+	}
+	if set, ok := v.CharacterSets[script]; ok {
+		return set
+	}
+	return v.CharacterSets[ScriptAny]
+}
+
+func (v *Validator) validateLine(line OCRLine, allowed *CharacterSet) LineValidation {
+	result := LineValidation{Identifier: line.Identifier, Level: ValidationOK}
+	text := line.Transcription
+	if !utf8.ValidString(text) {
+		result.Level = ValidationHard
+		result.Reasons = append(result.Reasons, ReasonInvalidEncoding)
+		return result
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			result.Level = ValidationHard
+			result.Reasons = append(result.Reasons, ReasonControlCharacter)
+			return result
+		}
+	}
+	if allowed != nil {
+		result.Disallowed = ValidateTranscription(text, allowed)
+	}
+	if len(result.Disallowed) > 0 && v.RejectDisallowed {
+		result.Level = ValidationHard
+		result.Reasons = append(result.Reasons, ReasonDisallowedChars)
+		return result
+	}
+	if !v.SoftValidation {
+		result.Disallowed = nil
+		return result
+	}
+	if len(result.Disallowed) > 0 {
+		result.Reasons = append(result.Reasons, ReasonDisallowedChars)
+	}
+	length := utf8.RuneCountInString(strings.TrimSpace(text))
+	if v.MinLength > 0 && length < v.MinLength {
+		result.Reasons = append(result.Reasons, ReasonTooShort)
+	} else if v.MaxLength > 0 && length > v.MaxLength {
+		result.Reasons = append(result.Reasons, ReasonTooLong)
+	}
+	for _, r := range text {
+		if !isUsualRune(r) {
+			result.Reasons = append(result.Reasons, ReasonUnusualCharacter)
+			break
+		}
+	}
+	if v.Dictionary != nil {
+		ratio, numWords := v.Dictionary.UnknownRatio(text)
+		if numWords >= minPlausibilityWords && ratio > v.MaxUnknownRatio {
+			result.Reasons = append(result.Reasons, ReasonImplausibleWords)
+		}
+	}
+	if len(result.Reasons) > 0 {
+		result.Level = ValidationSoft
+	}
+	return result
+}
+
+// ValidateDocument checks all transcribed lines of a document, lines
+// without a transcription are skipped. The transcriptions are normalized
+// first, like they are on submission.
+func (v *Validator) ValidateDocument(doc Document) []LineValidation {
+	results := make([]LineValidation, 0, len(doc.Lines))
+	allowed := v.characterSet(doc.Script)
+	for _, line := range doc.Lines {
+		line.Transcription = NormalizeTranscription(line.Transcription)
+		if line.Transcription == "" {
+			continue
+		}
+		results = append(results, v.validateLine(line, allowed))
+	}
+	return results
+}
+
+func isUsualRune(r rune) bool {
+	if unicode.IsSpace(r) || unicode.IsDigit(r) {
+		return true
+	}
+	if unicode.IsLetter(r) {
+		return unicode.Is(unicode.Latin, r)
+	}
+	if unicode.Is(unicode.Mn, r) {
+		// Combining marks, e.g. the superscript e used for old umlauts
+		return true
+	}
+	return strings.ContainsRune(usualPunctuation, r)
+}
@@ -2,11 +2,12 @@ package lib
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,7 +15,6 @@ import (
 )
 
 var commitPat = regexp.MustCompile(`^\[(.+) ([0-9a-f]+)\] (.+)\n`)
-var logEscapePat = regexp.MustCompile(`\s*\"(.*?)\"\s*[,}:]`)
 
 // LogEntry encodes a git log entry
 type LogEntry struct {
@@ -38,9 +38,13 @@ const (
 	StatusDeleted  FileStatus = 'D'
 )
 
-// GitRepo represents a Git repository
+// GitRepo represents a Git repository. Every operation runs its own git
+// process, so a repository can be used from several goroutines, but the
+// caller has to keep operations that change the working copy from
+// overlapping.
 type GitRepo struct {
-	cmd *exec.Cmd
+	gitPath string
+	dir     string
 }
 
 // GitOpen a repository
@@ -51,36 +55,35 @@ func GitOpen(path string) (*GitRepo, error) {
 	if err != nil {
 		return nil, err
 	}
-	cmd := exec.Command(gitPath)
-	cmd.Dir = path
 	return &GitRepo{
-		cmd: cmd,
+		gitPath: gitPath,
+		dir:     path,
 	}, nil
 }
 
-func (r *GitRepo) run() (stdout string, stderr string, err error) {
+// command prepares a git invocation in the repository
+func (r *GitRepo) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(r.gitPath, args...)
+	cmd.Dir = r.dir
+	return cmd
+}
+
+func runGit(cmd *exec.Cmd) (stdout string, stderr string, err error) {
 	var stdoutBuf bytes.Buffer
 	var stderrBuf bytes.Buffer
-	r.cmd.Stdout = &stdoutBuf
-	r.cmd.Stderr = &stderrBuf
-	err = r.cmd.Run()
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+	err = cmd.Run()
 	return stdoutBuf.String(), stderrBuf.String(), err
 }
 
-func (r *GitRepo) resetCmd() {
-	dir := r.cmd.Dir
-	r.cmd = exec.Command(r.cmd.Path)
-	r.cmd.Dir = dir
-}
-
 // Pull from remote and optionally rebase
 func (r *GitRepo) Pull(remote string, branch string, rebase bool) error {
-	defer r.resetCmd()
-	r.cmd.Args = append(r.cmd.Args, "pull", remote, branch)
+	cmd := r.command("pull", remote, branch)
 	if rebase {
-		r.cmd.Args = append(r.cmd.Args, "--rebase")
+		cmd.Args = append(cmd.Args, "--rebase")
 	}
-	stdout, stderr, err := r.run()
+	stdout, stderr, err := runGit(cmd)
 	if err != nil {
 		return fmt.Errorf("%q\n%q", stdout, stderr)
 	}
@@ -89,12 +92,12 @@ func (r *GitRepo) Pull(remote string, branch string, rebase bool) error {
 
 func (r *GitRepo) adjustPath(path string) (string, error) {
 	if strings.HasPrefix(path, "/") {
-		newPath, errr := filepath.Rel(r.cmd.Dir, path)
+		newPath, errr := filepath.Rel(r.dir, path)
 		if errr != nil {
 			return "", errr
 		} else if strings.HasPrefix(newPath, "../") {
 			return "", fmt.Errorf(
-				"Path must be relative to repository root (%s)", r.cmd.Dir)
+				"Path must be relative to repository root (%s)", r.dir)
 		}
 		return newPath, nil
 	}
@@ -103,13 +106,21 @@ func (r *GitRepo) adjustPath(path string) (string, error) {
 
 // Add stages a new file
 func (r *GitRepo) Add(path string) error {
-	defer r.resetCmd()
 	p, err := r.adjustPath(path)
 	if err != nil {
 		return err
 	}
-	r.cmd.Args = append(r.cmd.Args, "add", p)
-	stdout, stderr, err := r.run()
+	stdout, stderr, err := runGit(r.command("add", p))
+	if err != nil {
+		return fmt.Errorf("%+v\n%q\n%q", err, stdout, stderr)
+	}
+	return nil
+}
+
+// LFSInstall sets up the Git LFS filters for the repository, it fails if
+// Git LFS is not installed
+func (r *GitRepo) LFSInstall() error {
+	stdout, stderr, err := runGit(r.command("lfs", "install", "--local"))
 	if err != nil {
 		return fmt.Errorf("%+v\n%q\n%q", err, stdout, stderr)
 	}
@@ -118,29 +129,39 @@ func (r *GitRepo) Add(path string) error {
 
 // Remove removes a file
 func (r *GitRepo) Remove(path string) error {
-	defer r.resetCmd()
 	p, err := r.adjustPath(path)
 	if err != nil {
 		return err
 	}
-	r.cmd.Args = append(r.cmd.Args, "rm", "-rf", p)
-	stdout, stderr, err := r.run()
+	stdout, stderr, err := runGit(r.command("rm", "-rf", p))
 	if err != nil {
 		return fmt.Errorf("%+v\n%q\n%q", err, stdout, stderr)
 	}
 	return nil
 }
 
-// Commit the staged changes
-func (r *GitRepo) Commit(message string, author string, email string) (string, error) {
-	defer r.resetCmd()
-	r.cmd.Args = append(
-		r.cmd.Args, "commit", "-m", message)
-	if author != "" {
-		r.cmd.Args = append(
-			r.cmd.Args, "--author", fmt.Sprintf("%s <%s>", author, email))
+// Commit the staged changes. The author and committer date is set to date,
+// unless it is zero. The git configuration provides the committer, and the
+// author if it has no name.
+func (r *GitRepo) Commit(message string, author Identity, committer Identity, date time.Time) (string, error) {
+	cmd := r.command("commit", "-m", message)
+	if author.Name != "" {
+		cmd.Args = append(
+			cmd.Args, "--author", fmt.Sprintf("%s <%s>", author.Name, author.Email))
+	}
+	var env []string
+	if !committer.IsZero() {
+		env = append(env,
+			"GIT_COMMITTER_NAME="+committer.Name, "GIT_COMMITTER_EMAIL="+committer.Email)
+	}
+	if !date.IsZero() {
+		gitDate := fmt.Sprintf("@%d %s", date.Unix(), date.Format("-0700"))
+		env = append(env, "GIT_AUTHOR_DATE="+gitDate, "GIT_COMMITTER_DATE="+gitDate)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
 	}
-	stdout, stderr, err := r.run()
+	stdout, stderr, err := runGit(cmd)
 	if err != nil {
 		return "", fmt.Errorf("%+v, %q\n%q", err, stdout, stderr)
 	}
@@ -148,46 +169,55 @@ func (r *GitRepo) Commit(message string, author string, email string) (string, e
 	return commitSha, nil
 }
 
+// ResetToRemote fetches a branch and resets the working copy to it,
+// discarding local commits
+func (r *GitRepo) ResetToRemote(remote string, branch string) error {
+	if stdout, stderr, err := runGit(r.command("fetch", remote, branch)); err != nil {
+		return fmt.Errorf("%q\n%q", stdout, stderr)
+	}
+	if stdout, stderr, err := runGit(r.command("reset", "--hard", "FETCH_HEAD")); err != nil {
+		return fmt.Errorf("%q\n%q", stdout, stderr)
+	}
+	return nil
+}
+
 // Push changes to remote
 func (r *GitRepo) Push(remote string, branch string) error {
-	defer r.resetCmd()
-	r.cmd.Args = append(r.cmd.Args, "push", remote, branch)
-	stdout, stderr, err := r.run()
+	stdout, stderr, err := runGit(r.command("push", remote, branch))
 	if err != nil {
 		return fmt.Errorf("%q\n%q", stdout, stderr)
 	}
 	return nil
 }
 
+// CommitsAhead counts the local commits that are not on the remote branch
+func (r *GitRepo) CommitsAhead(remote string, branch string) (int, error) {
+	stdout, stderr, err := runGit(r.command("rev-list", "--count", remote+"/"+branch+"..HEAD"))
+	if err != nil {
+		return 0, fmt.Errorf("%q\n%q", stdout, stderr)
+	}
+	return strconv.Atoi(strings.TrimSpace(stdout))
+}
+
 // CleanUp residual modifications
 func (r *GitRepo) CleanUp() error {
-	r.cmd.Args = append(r.cmd.Args, "reset")
-	if stdout, stderr, err := r.run(); err != nil {
-		return fmt.Errorf("%q\n%q", stdout, stderr)
-	}
-	r.resetCmd()
-	r.cmd.Args = append(r.cmd.Args, "checkout", "--", ".")
-	if stdout, stderr, err := r.run(); err != nil {
-		return fmt.Errorf("%q\n%q", stdout, stderr)
-	}
-	r.resetCmd()
-	r.cmd.Args = append(r.cmd.Args, "clean", "-fd")
-	if stdout, stderr, err := r.run(); err != nil {
-		return fmt.Errorf("%q\n%q", stdout, stderr)
+	for _, args := range [][]string{
+		{"reset"}, {"checkout", "--", "."}, {"clean", "-fd"},
+	} {
+		if stdout, stderr, err := runGit(r.command(args...)); err != nil {
+			return fmt.Errorf("%q\n%q", stdout, stderr)
+		}
 	}
-	r.resetCmd()
 	return nil
 }
 
 // Diff lists modified files
 func (r *GitRepo) Diff(cached bool) (map[string]FileStatus, error) {
-	defer r.resetCmd()
-	r.cmd.Args = append(
-		r.cmd.Args, "diff", "--name-status")
+	cmd := r.command("diff", "--name-status")
 	if cached {
-		r.cmd.Args = append(r.cmd.Args, "--cached")
+		cmd.Args = append(cmd.Args, "--cached")
 	}
-	stdout, stderr, err := r.run()
+	stdout, stderr, err := runGit(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("%q\n%q", stdout, stderr)
 	}
@@ -208,35 +238,42 @@ func (r *GitRepo) Diff(cached bool) (map[string]FileStatus, error) {
 
 // Log returns the git log of a given file
 func (r *GitRepo) Log(fpaths ...string) ([]LogEntry, error) {
-	defer r.resetCmd()
-	r.cmd.Args = append(
-		r.cmd.Args, "log", `--pretty=format:{"commit":"%H","subject":"%s","body":"%b","author": {"name":"%aN","email":"%aE"},"date":"%aI"}`)
+	// Fields are separated by unit separators and entries by record
+	// separators, which cannot occur in commit messages unlike quotes and
+	// newlines
+	cmd := r.command(
+		"log", "--pretty=format:%H%x1f%s%x1f%b%x1f%aN%x1f%aE%x1f%aI%x1e")
 	if len(fpaths) > 0 {
-		r.cmd.Args = append(r.cmd.Args, fpaths...)
+		cmd.Args = append(cmd.Args, fpaths...)
 	}
-	stdout, stderr, err := r.run()
+	stdout, stderr, err := runGit(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("%q\n%q", stdout, stderr)
 	}
-	// Escape double quotes inside of values
-	cleanedLog := logEscapePat.ReplaceAllStringFunc(stdout, func(match string) string {
-		start := strings.Index(match, `"`)
-		end := strings.LastIndex(match, `"`)
-		escaped := strings.Replace(string(match[start+1:end]), `"`, `\"`, -1)
-		escaped = strings.Replace(escaped, `\n`, `\\n`, -1)
-		return match[:start+1] + escaped + match[end:]
-	})
-	logJsons := strings.Split(cleanedLog, "\n")
-	logEntries := make([]LogEntry, 0, len(logJsons))
-	for _, entryJSON := range logJsons {
-		if entryJSON == "" {
+	records := strings.Split(stdout, "\x1e")
+	logEntries := make([]LogEntry, 0, len(records))
+	for _, record := range records {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
 			continue
 		}
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != 6 {
+			log.Error().
+				Str("logEntry", record).
+				Msg("Failed to parse Git log entry")
+			return nil, fmt.Errorf("malformed git log entry %q", record)
+		}
 		var entry LogEntry
-		if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+		entry.Commit = fields[0]
+		entry.Subject = fields[1]
+		entry.Body = strings.TrimSpace(fields[2])
+		entry.Author.Name = fields[3]
+		entry.Author.Email = fields[4]
+		if entry.Date, err = time.Parse(time.RFC3339, fields[5]); err != nil {
 			log.Error().
 				Err(err).
-				Str("logEntry", entryJSON).
+				Str("logEntry", record).
 				Msg("Failed to parse Git log entry")
 			return nil, err
 		}
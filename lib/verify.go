@@ -0,0 +1,240 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Problem is an integrity problem found in a file of the corpus
+type Problem struct {
+	Path       string `json:"path"`
+	Identifier string `json:"identifier,omitempty"`
+	LineID     string `json:"lineId,omitempty"`
+	Message    string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.LineID != "" {
+		return fmt.Sprintf("%s: line %s: %s", p.Path, p.LineID, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// requiredMetadataFields are the fields every metadata file of a volume has
+// to have, with the JSON type of their value
+var requiredMetadataFields = []struct {
+	name     string
+	kind     byte
+	nonEmpty bool
+}{
+	{"id", '"', true},
+	{"year", '0', false},
+	{"title", '"', false},
+	{"lines", '[', true},
+}
+
+// VerifyCorpus checks the metadata files in the transcriptions of a working
+// copy for missing or malformed fields and for missing transcriptions and
+// line images. If checkImages is set, the Archive.org URLs of the line
+// images are also checked to still resolve. Returns the problems sorted by
+// path.
+func VerifyCorpus(ctx context.Context, repoPath string, checkImages bool) ([]Problem, error) {
+	metaPaths, err := filepath.Glob(filepath.Join(repoPath, "transcriptions", "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	var urls []imageCheck
+	for _, metaPath := range metaPaths {
+		relPath, _ := filepath.Rel(repoPath, metaPath)
+		docProblems, doc := verifyDocument(metaPath, relPath)
+		problems = append(problems, docProblems...)
+		if doc == nil || !checkImages {
+			continue
+		}
+		for _, line := range doc.Lines {
+			if line.ImageURL != "" {
+				urls = append(urls, imageCheck{relPath, doc.Identifier, line.Identifier, line.ImageURL})
+			}
+		}
+	}
+	if checkImages {
+		problems = append(problems, checkImageURLs(ctx, urls)...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Path < problems[j].Path
+	})
+	return problems, nil
+}
+
+// verifyDocument checks a single metadata file and the files of its lines.
+// The document is nil if it could not be decoded.
+func verifyDocument(metaPath string, relPath string) ([]Problem, *Document) {
+	var problems []Problem
+	report := func(lineID string, format string, args ...interface{}) {
+		problems = append(problems, Problem{
+			Path:    relPath,
+			LineID:  lineID,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	raw, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		report("", "could not read file: %v", err)
+		return problems, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		report("", "malformed JSON: %v", err)
+		return problems, nil
+	}
+	for _, field := range requiredMetadataFields {
+		value, ok := fields[field.name]
+		if !ok {
+			report("", "missing field %q", field.name)
+			continue
+		}
+		if !hasJSONKind(value, field.kind) {
+			report("", "field %q has the wrong type", field.name)
+		} else if field.nonEmpty && isEmptyJSON(value) {
+			report("", "field %q is empty", field.name)
+		}
+	}
+	var doc Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		report("", "could not decode document: %v", err)
+		return problems, nil
+	}
+	if ident := strings.TrimSuffix(filepath.Base(metaPath), ".json"); doc.Identifier != ident {
+		report("", "identifier %q does not match the file name", doc.Identifier)
+	}
+	if dir := filepath.Base(filepath.Dir(metaPath)); strconv.Itoa(doc.Year) != dir {
+		report("", "year %d does not match the directory %s", doc.Year, dir)
+	}
+	seen := make(map[string]bool, len(doc.Lines))
+	for _, line := range doc.Lines {
+		if line.Identifier == "" {
+			report("", "line without an identifier")
+			continue
+		}
+		if seen[line.Identifier] {
+			report(line.Identifier, "duplicate line identifier")
+		}
+		seen[line.Identifier] = true
+		if line.ImageURL == "" {
+			report(line.Identifier, "missing image URL")
+		}
+		basePath := strings.TrimSuffix(metaPath, ".json") + "_" + line.Identifier
+		if _, err := os.Stat(basePath + ".txt"); err != nil {
+			report(line.Identifier, "missing transcription file")
+		}
+		if _, err := os.Stat(basePath + ".png"); err != nil {
+			report(line.Identifier, "missing line image")
+		}
+	}
+	for idx := range problems {
+		problems[idx].Identifier = doc.Identifier
+	}
+	return problems, &doc
+}
+
+// hasJSONKind checks the type of a JSON value by its first character, '0'
+// stands for numbers. Arrays also match objects, since older metadata files
+// store the lines as a map.
+func hasJSONKind(value json.RawMessage, kind byte) bool {
+	trimmed := strings.TrimSpace(string(value))
+	if trimmed == "" {
+		return false
+	}
+	switch first := trimmed[0]; kind {
+	case '0':
+		return first == '-' || (first >= '0' && first <= '9')
+	case '[':
+		return first == '[' || first == '{'
+	default:
+		return first == kind
+	}
+}
+
+// isEmptyJSON checks for empty strings, arrays and objects
+func isEmptyJSON(value json.RawMessage) bool {
+	switch strings.Join(strings.Fields(string(value)), "") {
+	case `""`, "[]", "{}":
+		return true
+	}
+	return false
+}
+
+// imageCheck is a line image URL to be checked
+type imageCheck struct {
+	path   string
+	ident  string
+	lineID string
+	url    string
+}
+
+// checkImageURLs checks that the line image URLs still resolve, several at
+// a time
+func checkImageURLs(ctx context.Context, checks []imageCheck) []Problem {
+	var problems []Problem
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan imageCheck)
+	for i := 0; i < DefaultMaxConcurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range queue {
+				if err := checkImageURL(ctx, check.url); err != nil {
+					mutex.Lock()
+					problems = append(problems, Problem{
+						Path:       check.path,
+						Identifier: check.ident,
+						LineID:     check.lineID,
+						Message:    fmt.Sprintf("image URL does not resolve: %v", err),
+					})
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, check := range checks {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- check
+	}
+	close(queue)
+	wg.Wait()
+	return problems
+}
+
+// checkImageURL checks that an image URL can be fetched, with a GET for
+// servers that do not support HEAD requests
+func checkImageURL(ctx context.Context, url string) error {
+	resp, err := httpHead(ctx, url)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		resp, err = httpGet(ctx, url)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
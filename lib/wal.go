@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SubmissionLog is an append-only write-ahead log of accepted submissions.
+// Every submission is durably logged before it is committed, so that it can
+// be replayed if the process dies before the commit went through.
+type SubmissionLog struct {
+	path    string
+	mutex   sync.Mutex
+	file    *os.File
+	nextID  int64
+	pending map[int64]TaskDefinition
+}
+
+// PendingSubmission is a logged submission that has not been committed yet
+type PendingSubmission struct {
+	ID   int64
+	Task TaskDefinition
+}
+
+type walRecord struct {
+	ID   int64           `json:"id"`
+	Task *TaskDefinition `json:"task,omitempty"`
+	Done bool            `json:"done,omitempty"`
+}
+
+// OpenSubmissionLog opens the log at the given path and reads all entries
+// that were not marked as done
+func OpenSubmissionLog(path string) (*SubmissionLog, error) {
+	wal := &SubmissionLog{
+		path:    path,
+		nextID:  1,
+		pending: make(map[int64]TaskDefinition),
+	}
+	if in, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var record walRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				// Most likely a partial write from a crash, the submission was
+				// never acknowledged to the user in that case
+				log.Warn().Err(err).Str("path", path).Msg("Skipping unreadable WAL entry")
+				continue
+			}
+			if record.ID >= wal.nextID {
+				wal.nextID = record.ID + 1
+			}
+			if record.Done {
+				delete(wal.pending, record.ID)
+			} else if record.Task != nil {
+				wal.pending[record.ID] = *record.Task
+			}
+		}
+		in.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	wal.file = file
+	return wal, nil
+}
+
+func (l *SubmissionLog) write(record walRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(raw, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Append durably logs a submission and returns its id in the log
+func (l *SubmissionLog) Append(task TaskDefinition) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	id := l.nextID
+	if err := l.write(walRecord{ID: id, Task: &task}); err != nil {
+		return 0, err
+	}
+	l.nextID++
+	l.pending[id] = task
+	return id, nil
+}
+
+// MarkDone marks a submission as committed. Once no submissions are
+// pending anymore, the log is truncated.
+func (l *SubmissionLog) MarkDone(id int64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.pending, id)
+	if len(l.pending) == 0 {
+		return l.file.Truncate(0)
+	}
+	return l.write(walRecord{ID: id, Done: true})
+}
+
+// Pending returns all submissions that were not committed yet, in the order
+// they were logged
+func (l *SubmissionLog) Pending() []PendingSubmission {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	pending := make([]PendingSubmission, 0, len(l.pending))
+	for id, task := range l.pending {
+		pending = append(pending, PendingSubmission{ID: id, Task: task})
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].ID < pending[j].ID
+	})
+	return pending
+}
+
+// NumPending returns the number of submissions that were not committed yet
+func (l *SubmissionLog) NumPending() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.pending)
+}
+
+// Close the log file
+func (l *SubmissionLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
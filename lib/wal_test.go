@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubmissionLogReopen(t *testing.T) {
+	tests := []struct {
+		name string
+		// Submissions to log, and which of them are marked as done before
+		// the log is reopened
+		idents []string
+		done   map[int]bool
+		// Identifiers expected to be pending after reopening
+		want []string
+	}{
+		{"nothing logged", nil, nil, nil},
+		{"all pending", []string{"a", "b"}, nil, []string{"a", "b"}},
+		{"some done", []string{"a", "b", "c"}, map[int]bool{1: true}, []string{"a", "c"}},
+		{"all done", []string{"a", "b"}, map[int]bool{0: true, 1: true}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "submissions.wal")
+			wal, err := OpenSubmissionLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for _, ident := range tc.idents {
+				id, err := wal.Append(TaskDefinition{Document: Document{Identifier: ident}, Author: "Test"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			for idx := range tc.done {
+				if err := wal.MarkDone(ids[idx]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := wal.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			reopened, err := OpenSubmissionLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			pending := reopened.Pending()
+			if len(pending) != len(tc.want) || reopened.NumPending() != len(tc.want) {
+				t.Fatalf("got %d pending submissions, want %v", len(pending), tc.want)
+			}
+			for idx, sub := range pending {
+				if sub.Task.Document.Identifier != tc.want[idx] || sub.Task.Author != "Test" {
+					t.Errorf("pending submission %d is %+v, want %s", idx, sub.Task, tc.want[idx])
+				}
+			}
+			// New submissions must not reuse the ids of pending ones
+			id, err := reopened.Append(TaskDefinition{Document: Document{Identifier: "new"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, sub := range pending {
+				if sub.ID == id {
+					t.Errorf("new submission got the id %d of a pending one", id)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmissionLogTruncatesWhenDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.wal")
+	wal, err := OpenSubmissionLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	first, _ := wal.Append(TaskDefinition{Document: Document{Identifier: "a"}})
+	second, _ := wal.Append(TaskDefinition{Document: Document{Identifier: "b"}})
+	if err := wal.MarkDone(first); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() == 0 {
+		t.Fatal("log was truncated while a submission is pending")
+	}
+	if err := wal.MarkDone(second); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("log has %d bytes after all submissions were done", info.Size())
+	}
+}
+
+func TestSubmissionLogSkipsPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.wal")
+	wal, err := OpenSubmissionLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.Append(TaskDefinition{Document: Document{Identifier: "a"}})
+	wal.Close()
+	out, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.WriteString(`{"id":2,"task":{"document":`)
+	out.Close()
+
+	reopened, err := OpenSubmissionLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if pending := reopened.Pending(); len(pending) != 1 || pending[0].Task.Document.Identifier != "a" {
+		t.Errorf("expected only the complete entry to be pending, got %+v", pending)
+	}
+}
@@ -0,0 +1,35 @@
+package lib
+
+import "fmt"
+
+// Range of publication years whose volumes are cached and served
+var (
+	MinYear = 1800
+	MaxYear = 1899
+)
+
+// YearRangeError is returned for years outside of the served range
+type YearRangeError struct {
+	Year int
+}
+
+func (e *YearRangeError) Error() string {
+	return fmt.Sprintf("year %d is outside of the served range %d-%d", e.Year, MinYear, MaxYear)
+}
+
+// CheckYear returns a *YearRangeError if a year is outside of the served
+// range
+func CheckYear(year int) error {
+	if year < MinYear || year > MaxYear {
+		return &YearRangeError{Year: year}
+	}
+	return nil
+}
+
+// ValidateYearRange checks that the bounds of a year range are in order
+func ValidateYearRange(minYear int, maxYear int) error {
+	if minYear > maxYear {
+		return fmt.Errorf("minYear %d is after maxYear %d", minYear, maxYear)
+	}
+	return nil
+}
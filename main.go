@@ -2,41 +2,382 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"archiscribe/cmd"
 	"archiscribe/lib"
 	"archiscribe/web"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if command := cmd.Lookup(os.Args[1]); command != nil {
+			log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+			if err := command.Run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		cmd.PrintCommands()
+	}
 	var logPath = flag.String("log", "", "Set path to logging file")
 	var isDebug = flag.Bool("debug", false, "Enable debug mode")
+	var listenPort = flag.Int("port", 8080, "Port to listen on, 443 with TLS or 8083 in debug mode unless set explicitly, an explicitly set port takes precedence over both")
 	var repoPath = flag.String("repoPath", "", "Set repository path")
+	var cacheDir = flag.String("cacheDir", "", "Set cache directory, overrides the ARCHISCRIBE_CACHE environment variable, ./cache if neither is set")
+	var adminToken = flag.String("adminToken", "", "Bearer token for the admin API, disabled if empty")
+	var softValidation = flag.Bool("softValidation", true, "Flag unusual transcriptions for review")
+	var minLineLength = flag.Int("minLineLength", lib.Validation.MinLength, "Flag transcriptions shorter than this for review")
+	var maxLineLength = flag.Int("maxLineLength", lib.Validation.MaxLength, "Flag transcriptions longer than this for review")
+	var compactJSON = flag.Bool("compactJSON", false, "Write compact instead of indented JSON files")
+	var maxSubmitBytes = flag.Int64("maxSubmitBytes", web.DefaultMaxSubmitBytes, "Maximum size of a submitted document in bytes")
+	var submitTimeout = flag.Duration("submitTimeout", web.DefaultSubmitTimeout, "Maximum time for receiving a submitted document")
+	var mergeDuplicates = flag.Bool("mergeDuplicates", false, "Transcribe lines with identical OCR text only once per volume")
+	var mediaTypes = flag.String("mediaTypes", strings.Join(lib.MediaTypes, ","), "Comma-separated list of Archive.org mediatypes to include")
+	var cropPadding = flag.Int("cropPadding", lib.CropPadding.Pixels, "Margin in pixels around line crops, with fixed padding")
+	var cropPaddingFraction = flag.Float64("cropPaddingFraction", lib.CropPadding.Fraction, "Margin around line crops as a fraction of the line height, with proportional padding")
+	var cropPaddingMode = flag.String("cropPaddingMode", lib.CropPadding.Mode, "Padding of line crops, 'fixed' or 'proportional'")
+	var dictPath = flag.String("dictionary", "", "Wordlist for flagging implausible transcriptions, disabled if empty")
+	var maxUnknownRatio = flag.Float64("maxUnknownRatio", lib.Validation.MaxUnknownRatio, "Flag transcriptions with a higher ratio of unknown words for review")
+	var lfsLineImages = flag.Bool("lfsLineImages", false, "Commit line images to Git LFS instead of the repository, requires git-lfs")
+	var coAuthors = flag.Bool("coAuthors", false, "Credit previous contributors of a volume with Co-authored-by trailers")
+	var signOff = flag.Bool("signOff", false, "Add a Signed-off-by trailer for the submitting author")
+	var committerName = flag.String("committerName", "", "Name of the identity that commits submissions, and authors commits without a submitting author, the repository's git configuration if empty")
+	var committerEmail = flag.String("committerEmail", "", "Email of the identity that commits submissions")
+	var pullRequestRepo = flag.String("pullRequestRepo", "", "GitHub repository, owner/name, to open a pull request on for every commit instead of pushing to the base branch")
+	var pullRequestBase = flag.String("pullRequestBase", lib.PullRequests.Base, "Branch pull requests are opened against")
+	var githubAPIURL = flag.String("githubAPIURL", lib.PullRequests.APIURL, "Base URL of the GitHub API")
+	var githubToken = flag.String("githubToken", "", "Token for opening pull requests, overrides the ARCHISCRIBE_GITHUB_TOKEN environment variable")
+	var commitBatchWindow = flag.Duration("commitBatchWindow", 0, "Commit submissions together that arrive within this long of the first one, e.g. 60s, 0 to commit every submission on its own")
+	var commitBatchSize = flag.Int("commitBatchSize", 0, "Commit a batch of submissions as soon as it holds this many, 0 for no limit")
+	var lineSelection = flag.String("lineSelection", web.SelectRandom, "How lines are picked, 'random' or 'difficult' to prefer low OCR confidence")
+	var ocrHookCommand = flag.String("ocrHookCommand", "", "Command for recognizing page images of items without Archive.org OCR")
+	var ocrHookURL = flag.String("ocrHookURL", "", "URL that page images of items without Archive.org OCR are posted to for recognition")
+	var ocrHookTimeout = flag.Duration("ocrHookTimeout", lib.DefaultOCRHookTimeout, "Maximum time for recognizing a single page with the OCR hook")
+	var volumeCacheMaxAge = flag.Duration("volumeCacheMaxAge", lib.VolumeCacheMaxAge, "Age after which cached lines of a volume are fetched again, 0 to never expire")
+	var annotateHyphenation = flag.Bool("annotateHyphenation", false, "Record the joined form of words hyphenated across line ends")
+	var maxImageDownloads = flag.Int("maxImageDownloads", lib.MaxImageDownloads, "Maximum number of concurrent line image downloads per volume")
+	var thumbnailHeight = flag.Int("thumbnailHeight", lib.ThumbnailHeight, "Maximum height of line thumbnails in pixels, 0 to disable thumbnails")
+	var minSubmitInterval = flag.Duration("minSubmitInterval", 0, "Minimum time between two submissions of a contributor, 0 for no limit")
+	var trustedTokens = flag.String("trustedTokens", "", "Comma-separated bearer tokens whose submissions are exempt from -minSubmitInterval, like those with the -adminToken")
+	var maxStitchedLines = flag.Int("maxStitchedLines", web.DefaultMaxStitchedLines, "Maximum number of consecutive lines served as a single image, 0 to disable")
+	var autoAcceptConfidence = flag.Float64("autoAcceptConfidence", 0, "Commit lines with at least this OCR confidence (0-1) as machine-verified instead of serving them, 0 to disable")
+	var yearQuota = flag.Int("yearQuota", 0, "Stop serving years with at least this many transcribed lines while other years have fewer, 0 for no quota")
+	var volumeCacheMaxEntries = flag.Int("volumeCacheMaxEntries", 0, "Maximum number of volumes kept in the volume cache across all years, 0 for no limit")
+	var compactInterval = flag.Duration("compactInterval", web.DefaultCompactInterval, "Interval for removing transcribed, expired and unreadable volumes from the cache, 0 to disable")
+	var readmePath = flag.String("readmePath", lib.ReadmePath, "Where the corpus README is written, relative to the repository unless absolute")
+	var maxDisagreement = flag.Float64("maxDisagreement", lib.Consensus.MaxDisagreement, "Flag lines of a resubmitted volume for review if more than this ratio of them differs from the stored transcriptions, 0 to disable")
+	var minOverlap = flag.Int("minOverlap", lib.Consensus.MinOverlap, "Minimum number of lines shared with the stored transcriptions for -maxDisagreement to apply")
+	var minCropContrast = flag.Float64("minCropContrast", lib.CropQuality.MinContrast, "Skip lines whose crop has a lower luminance spread, from 0 to 1, 0 to disable")
+	var maxCropBackground = flag.Float64("maxCropBackground", lib.CropQuality.MaxBackgroundRatio, "Skip lines whose crop has a higher ratio of background pixels, 0 to disable")
+	var recordSessions = flag.Bool("recordSessions", false, "Record an anonymous session identifier with submitted lines for retracting them later")
+	var shutdownTimeout = flag.Duration("shutdownTimeout", web.DefaultShutdownTimeout, "Maximum time for finishing in-flight requests and commits on SIGINT or SIGTERM")
+	var corsOrigins = flag.String("corsOrigins", "", "Comma-separated origins allowed to call the API from other origins, * for any, same-origin only if empty")
+	var corsMethods = flag.String("corsMethods", strings.Join(web.DefaultCORSMethods, ","), "Comma-separated methods allowed in cross-origin API requests")
+	var corsHeaders = flag.String("corsHeaders", strings.Join(web.DefaultCORSHeaders, ","), "Comma-separated headers allowed in cross-origin API requests")
+	var tlsCert = flag.String("tlsCert", "", "PEM certificate chain for serving HTTPS, needs -tlsKey")
+	var tlsKey = flag.String("tlsKey", "", "PEM private key for serving HTTPS, needs -tlsCert")
+	var autocertDomains = flag.String("autocertDomains", "", "Comma-separated domains to serve HTTPS for with certificates from Let's Encrypt, instead of -tlsCert and -tlsKey")
+	var autocertCacheDir = flag.String("autocertCacheDir", "", "Directory for the certificates from Let's Encrypt, autocert in the cache directory if empty")
+	var autocertEmail = flag.String("autocertEmail", "", "Contact email for Let's Encrypt")
+	var httpRedirectPort = flag.Int("httpRedirectPort", 0, "Port for plain HTTP that redirects to HTTPS and answers Let's Encrypt challenges, 80 for most setups, no redirect if 0")
+	var accessLog = flag.Bool("accessLog", true, "Log every request with its status, response size and latency, including request bodies with -debug")
+	var compressLevel = flag.Int("compressLevel", web.DefaultCompressLevel, "Level for compressing responses with gzip or deflate, from -2 (Huffman only) to 9 (best), 0 disables compression")
+	var compressMinSize = flag.Int("compressMinSize", web.DefaultCompressMinSize, "Minimum size of a response in bytes for it to be compressed")
+	var retryInterval = flag.Duration("retryInterval", web.DefaultRetryInterval, "Time before a submission whose commit failed is committed again, doubled after every failed attempt")
+	var submissionStatusTTL = flag.Duration("submissionStatusTTL", web.DefaultSubmissionStatusTTL, "How long the outcome of an asynchronous submission can be polled for")
+	var maxFetchAttempts = flag.Int("maxFetchAttempts", lib.MaxFetchAttempts, "Maximum number of attempts for requests to Archive.org failing with server or network errors")
+	var fetchRetryDelay = flag.Duration("fetchRetryDelay", lib.FetchRetryDelay, "Wait before retrying a failed request to Archive.org, doubled for every further retry")
+	var httpTimeout = flag.Duration("httpTimeout", lib.DefaultHTTPTimeout, "Maximum time for a request to Archive.org including the response body, large OCR files may need more, 0 to disable")
+	var scriptFilter = flag.String("script", lib.ScriptFraktur, "Script of the volumes to transcribe, 'fraktur', 'antiqua' or 'any'")
+	var frakturThreshold = flag.Float64("frakturThreshold", lib.FrakturThreshold, "Minimum Fraktur confidence, from 0 to 1, for classifying a volume as set in Fraktur")
+	var cacheDepth = flag.Int("cacheDepth", web.DefaultPrefetchDepth, "Number of volumes with parsed lines kept ready for every requested year, at least 1")
+	var lineCacheMaxSize = flag.Int64("lineCacheMaxSize", 0, "Maximum total size of cached line images in bytes, least recently used images are evicted first, 0 for no limit")
+	var metrics = flag.Bool("metrics", true, "Serve Prometheus metrics at /metrics")
+	var iiifBaseURL = flag.String("iiifBaseURL", lib.IIIFBaseURL, "Base URL of the IIIF manifest and viewer links to volumes")
+	var archiveBaseURL = flag.String("archiveBaseURL", lib.ArchiveDetailsBaseURL, "Base URL of the links to the details pages of volumes")
+	var readmeSort = flag.String("readmeSort", lib.ReadmeSort, "Order of the works table in the README, 'date', 'title' or 'lines'")
+	var readmeContributors = flag.String("readmeContributors", lib.ReadmeContributors, "List contributors in the README with the number of their lines and works, 'omit', 'anonymous' or 'named'")
+	var prefetchWorkers = flag.Int("prefetchWorkers", 0, "Number of volumes prefetched at the same time across all years, the number of CPUs if 0")
+	var maxConcurrentRequests = flag.Int("maxConcurrentRequests", lib.DefaultMaxConcurrentRequests, "Maximum number of requests to Archive.org in flight at the same time, 0 for no limit")
+	var dryRun = flag.Bool("dryRun", false, "Validate submissions and write them to a temporary directory instead of committing them")
+	var lineDigest = flag.String("lineDigest", lib.LineDigest.Algorithm, "Digest of the image URL that identifies new lines, 'sha1' or 'sha256'")
+	var lineDigestLength = flag.Int("lineDigestLength", lib.LineDigest.Length, "Number of hex digits of the line digest that are kept, 0 for all")
+	var contextLines = flag.Int("contextLines", lib.ContextLines, "Number of neighbouring lines shown as context on each side of a line")
+	var offline = flag.Bool("offline", false, "Never contact Archive.org or the origin of the repository, only serve volumes and line images from the cache")
+	var normalizeWhitespace = flag.Bool("normalizeWhitespace", lib.NormalizeWhitespace, "Trim submitted transcriptions and collapse runs of spaces, Unicode is always normalized to NFC")
+	var allowedChars = flag.String("allowedCharacters", "", "Files with the characters allowed in transcriptions, comma separated, 'script=path' for a single script")
+	var rejectDisallowed = flag.Bool("rejectDisallowed", false, "Reject transcriptions with characters that are not allowed instead of flagging them for review")
+	var refreshInterval = flag.Duration("refreshInterval", 0, "Interval for adding identifiers that were added to Archive.org since the last refresh, 0 to disable")
+	var searchQuery = flag.String("query", lib.DefaultIdentifierQuery.Query, "Archive.org search query for the identifiers to cache, combined with the mediatypes")
+	var collection = flag.String("collection", "", "Only cache identifiers from this Archive.org collection")
+	var blocklistPath = flag.String("blocklist", "", "File with identifiers that are never picked or cached, one per line, reloaded on SIGHUP")
+	var maxFetchFailures = flag.Int("maxFetchFailures", lib.MaxConsecutiveFailures, "Number of failed fetches in a row after which an identifier is skipped until restart, 0 to never skip")
+	var minYear = flag.Int("minYear", lib.MinYear, "Earliest publication year of the volumes that are cached and served")
+	var maxYear = flag.Int("maxYear", lib.MaxYear, "Latest publication year of the volumes that are cached and served")
+	var maxRequestsPerSecond = flag.Float64("maxRequestsPerSecond", lib.DefaultMaxRequestsPerSecond, "Maximum rate of requests to Archive.org across all fetches, 0 for no limit")
+	var userAgent = flag.String("userAgent", lib.UserAgent, "User-Agent header sent with all outbound requests")
+	var iiifImageBaseURL = flag.String("iiifImageBaseURL", lib.IIIFImage.BaseURL, "Base URL of the IIIF image service for line and page images")
+	var iiifImageVersion = flag.Int("iiifImageVersion", lib.IIIFImage.Version, "Version of the IIIF Image API spoken by the image service, 2 or 3")
+	var cacheRetries = flag.Int("cacheRetries", lib.CacheRetries, "Number of retries for cache file operations failing with transient errors")
 	flag.Parse()
-	if *repoPath == "" {
-		panic("repoPath must be set!")
+	if err := checkRepoPath(*repoPath); err != nil {
+		flag.Usage()
+		exitInvalidFlag(err)
+	}
+	lib.IIIFBaseURL = *iiifBaseURL
+	lib.ArchiveDetailsBaseURL = *archiveBaseURL
+	if *compactJSON {
+		lib.JSONIndent = ""
+	}
+	lib.Consensus = lib.ConsensusOptions{MaxDisagreement: *maxDisagreement, MinOverlap: *minOverlap}
+	lib.CommitTrailers = lib.TrailerOptions{CoAuthors: *coAuthors, SignOff: *signOff}
+	lib.Committer = lib.Identity{Name: *committerName, Email: *committerEmail}
+	if err := lib.Committer.Validate(); err != nil {
+		exitInvalidFlag(err)
+	}
+	if *githubToken == "" {
+		*githubToken = os.Getenv("ARCHISCRIBE_GITHUB_TOKEN")
+	}
+	lib.PullRequests = lib.PullRequestOptions{
+		Repository: *pullRequestRepo,
+		Token:      *githubToken,
+		Base:       *pullRequestBase,
+		APIURL:     *githubAPIURL,
+	}
+	if err := lib.PullRequests.Validate(); err != nil {
+		exitInvalidFlag(err)
+	}
+	lib.CommitBatching = lib.BatchOptions{Window: *commitBatchWindow, MaxSize: *commitBatchSize}
+	lib.LFSLineImages = *lfsLineImages
+	lib.MediaTypes = mediaTypeList(*mediaTypes)
+	lib.IdentifierSearch = lib.IdentifierQuery{Query: *searchQuery, Collection: *collection}
+	if *readmeSort != lib.SortByDate && *readmeSort != lib.SortByTitle && *readmeSort != lib.SortByLines {
+		exitInvalidFlag(fmt.Errorf("-readmeSort must be 'date', 'title' or 'lines'"))
+	}
+	lib.ReadmeSort = *readmeSort
+	if *readmeContributors != lib.ContributorsOmit && *readmeContributors != lib.ContributorsAnonymous && *readmeContributors != lib.ContributorsNamed {
+		exitInvalidFlag(fmt.Errorf("-readmeContributors must be 'omit', 'anonymous' or 'named'"))
+	}
+	lib.ReadmeContributors = *readmeContributors
+	lib.LineDigest = lib.DigestOptions{Algorithm: *lineDigest, Length: *lineDigestLength}
+	if err := lib.LineDigest.Validate(); err != nil {
+		exitInvalidFlag(err)
+	}
+	lib.IIIFImage = lib.IIIFImageOptions{BaseURL: *iiifImageBaseURL, Version: *iiifImageVersion}
+	if err := lib.IIIFImage.Validate(); err != nil {
+		exitInvalidFlag(err)
+	}
+	if err := lib.ValidateYearRange(*minYear, *maxYear); err != nil {
+		exitInvalidFlag(err)
+	}
+	lib.MinYear, lib.MaxYear = *minYear, *maxYear
+	if *scriptFilter != lib.ScriptFraktur && *scriptFilter != lib.ScriptAntiqua && *scriptFilter != lib.ScriptAny {
+		exitInvalidFlag(fmt.Errorf("-script must be 'fraktur', 'antiqua' or 'any'"))
+	}
+	if *cacheDepth < 1 {
+		exitInvalidFlag(fmt.Errorf("-cacheDepth must be at least 1"))
+	}
+	if *cropPaddingMode != lib.PaddingFixed && *cropPaddingMode != lib.PaddingProportional {
+		exitInvalidFlag(fmt.Errorf("-cropPaddingMode must be 'fixed' or 'proportional'"))
+	}
+	lib.CropPadding = lib.Padding{
+		Mode:     *cropPaddingMode,
+		Pixels:   *cropPadding,
+		Fraction: *cropPaddingFraction,
+	}
+	lib.FallbackOCR = lib.OCRHook{
+		Command: *ocrHookCommand,
+		URL:     *ocrHookURL,
+		Timeout: *ocrHookTimeout,
+	}
+	lib.VolumeCacheMaxAge = *volumeCacheMaxAge
+	lib.AnnotateHyphenation = *annotateHyphenation
+	lib.MaxImageDownloads = *maxImageDownloads
+	lib.ThumbnailHeight = *thumbnailHeight
+	lib.ReadmePath = *readmePath
+	lib.CacheRetries = *cacheRetries
+	lib.NormalizeWhitespace = *normalizeWhitespace
+	lib.MaxConsecutiveFailures = *maxFetchFailures
+	lib.Blocked = lib.NewBlocklist(*blocklistPath)
+	if err := lib.Blocked.Reload(); err != nil {
+		exitInvalidFlag(err)
+	}
+	lib.Offline = *offline
+	lib.ContextLines = *contextLines
+	lib.SetMaxConcurrentRequests(*maxConcurrentRequests)
+	lib.SetMaxRequestsPerSecond(*maxRequestsPerSecond)
+	lib.UserAgent = *userAgent
+	lib.FrakturThreshold = *frakturThreshold
+	lib.MaxFetchAttempts = *maxFetchAttempts
+	lib.SetHTTPTimeout(*httpTimeout)
+	lib.FetchRetryDelay = *fetchRetryDelay
+	lib.CropQuality = lib.CropQualityOptions{
+		MinContrast:        *minCropContrast,
+		MaxBackgroundRatio: *maxCropBackground,
+	}
+	lib.Validation.SoftValidation = *softValidation
+	lib.Validation.MinLength = *minLineLength
+	lib.Validation.MaxLength = *maxLineLength
+	lib.Validation.MaxUnknownRatio = *maxUnknownRatio
+	if *dictPath != "" {
+		dict, err := lib.LoadDictionary(*dictPath)
+		if err != nil {
+			exitInvalidFlag(err)
+		}
+		lib.Validation.Dictionary = dict
+	}
+	if *allowedChars != "" {
+		sets, err := lib.LoadCharacterSets(*allowedChars)
+		if err != nil {
+			exitInvalidFlag(err)
+		}
+		lib.Validation.CharacterSets = sets
 	}
-	lib.InitCache()
+	lib.Validation.RejectDisallowed = *rejectDisallowed
+	if err := web.ValidateCompressLevel(*compressLevel); err != nil {
+		exitInvalidFlag(err)
+	}
+	tlsOptions := web.TLSOptions{
+		CertFile:         *tlsCert,
+		KeyFile:          *tlsKey,
+		AutocertDomains:  splitList(*autocertDomains),
+		AutocertCacheDir: *autocertCacheDir,
+		AutocertEmail:    *autocertEmail,
+		RedirectPort:     *httpRedirectPort,
+	}
+	if err := tlsOptions.Validate(); err != nil {
+		exitInvalidFlag(err)
+	}
+	// Debug messages (per-part OCR fetches, blank crops) are only
+	// emitted in debug mode
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	if *isDebug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	} else if *logPath == "" {
 		log.Logger = log.Output(os.Stdout)
 	} else {
 		f, err := os.OpenFile(*logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			panic(err)
+			exitInvalidFlag(err)
 		}
 		defer f.Close()
 		log.Logger = log.Output(f)
 	}
-	var port int
-	if *isDebug {
-		port = 8083
-	} else {
-		port = 8080
+	lib.InitCache(lib.ResolveCacheDir(*cacheDir))
+	lib.LineCache.SetMaxSize(*lineCacheMaxSize)
+	port := resolvePort(*listenPort, isFlagSet("port"), *isDebug, tlsOptions.Enabled())
+	web.Serve(port, *repoPath, web.Options{
+		AdminToken:      *adminToken,
+		MaxSubmitBytes:  *maxSubmitBytes,
+		SubmitTimeout:   *submitTimeout,
+		MergeDuplicates: *mergeDuplicates,
+		LineSelection:   *lineSelection,
+
+		MinSubmitInterval: *minSubmitInterval,
+		TrustedTokens:     splitList(*trustedTokens),
+		MaxStitchedLines:  *maxStitchedLines,
+
+		AutoAcceptConfidence:  *autoAcceptConfidence,
+		YearQuota:             *yearQuota,
+		VolumeCacheMaxEntries: *volumeCacheMaxEntries,
+		CompactInterval:       *compactInterval,
+		RefreshInterval:       *refreshInterval,
+		RecordSessions:        *recordSessions,
+		ShutdownTimeout:       *shutdownTimeout,
+		SubmissionStatusTTL:   *submissionStatusTTL,
+		RetryInterval:         *retryInterval,
+		CORSOrigins:           splitList(*corsOrigins),
+		CORSMethods:           splitList(*corsMethods),
+		CORSHeaders:           splitList(*corsHeaders),
+		CompressLevel:         *compressLevel,
+		CompressMinSize:       *compressMinSize,
+		AccessLog:             *accessLog,
+		LogRequestBodies:      *isDebug,
+		TLS:                   tlsOptions,
+		ScriptFilter:          *scriptFilter,
+		PrefetchDepth:         *cacheDepth,
+		PrefetchWorkers:       *prefetchWorkers,
+		DryRun:                *dryRun,
+		Metrics:               *metrics,
+	})
+}
+
+// exitInvalidFlag reports an invalid flag value like the flag package does
+// for values it cannot parse
+func exitInvalidFlag(err error) {
+	fmt.Fprintf(os.Stderr, "flag: %v\n", err)
+	os.Exit(2)
+}
+
+// isFlagSet checks if a flag was passed on the command line, as opposed to
+// having its default value
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// resolvePort returns the port to listen on. Debug mode and TLS have their
+// own default ports, which only apply if the port was not set explicitly.
+func resolvePort(port int, explicit bool, debug bool, tls bool) int {
+	if explicit {
+		return port
+	} else if debug {
+		return 8083
+	} else if tls {
+		return 443
+	}
+	return port
+}
+
+// splitList splits a comma-separated flag value, leaving out empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// mediaTypeList splits the value of -mediaTypes, only texts are included if
+// it lists none
+func mediaTypeList(value string) []string {
+	if types := splitList(value); len(types) > 0 {
+		return types
+	}
+	return []string{"texts"}
+}
+
+// checkRepoPath makes sure the repository path is set and is a directory
+func checkRepoPath(repoPath string) error {
+	if repoPath == "" {
+		return fmt.Errorf("-repoPath must be set")
+	}
+	stat, err := os.Stat(repoPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("repository path %s does not exist", repoPath)
+	} else if err != nil {
+		return fmt.Errorf("could not access repository path %s: %v", repoPath, err)
+	} else if !stat.IsDir() {
+		return fmt.Errorf("repository path %s is not a directory", repoPath)
 	}
-	web.Serve(port, *repoPath)
+	return nil
 }
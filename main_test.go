@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary runs main with the arguments after "--" if this variable
+// is set, so that exiting can be observed from the outside
+const runMainEnv = "ARCHISCRIBE_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		for idx, arg := range os.Args {
+			if arg == "--" {
+				os.Args = append([]string{os.Args[0]}, os.Args[idx+1:]...)
+				break
+			}
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// mainCommand returns a command that runs the program with the given
+// arguments
+func mainCommand(args ...string) *exec.Cmd {
+	command := exec.Command(os.Args[0], append([]string{"-test.run=TestMain", "--"}, args...)...)
+	command.Env = append(os.Environ(), runMainEnv+"=1")
+	return command
+}
+
+// runMain runs the program with the given arguments and returns its exit
+// code and error output
+func runMain(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	command := mainCommand(args...)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	err := command.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), stderr.String()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stderr.String()
+}
+
+func TestInvalidFlags(t *testing.T) {
+	repoPath := t.TempDir()
+	notADir := repoPath + "/README.md"
+	if err := ioutil.WriteFile(notADir, []byte("corpus\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+		// Whether the arguments include the repository path
+		withRepoPath bool
+	}{
+		{"missing repoPath", nil, "-repoPath must be set", true},
+		{"repoPath does not exist", []string{"-repoPath", repoPath + "/missing"}, "does not exist", true},
+		{"repoPath is a file", []string{"-repoPath", notADir}, "is not a directory", true},
+		{"committer without email", []string{"-committerName", "Bot"}, "invalid email", false},
+		{"pull requests without token", []string{"-pullRequestRepo", "owner/corpus"}, "token", false},
+		{"readme sort", []string{"-readmeSort", "random"}, "-readmeSort must be", false},
+		{"readme contributors", []string{"-readmeContributors", "all"}, "-readmeContributors must be", false},
+		{"line digest", []string{"-lineDigest", "md5"}, "md5", false},
+		{"year range", []string{"-minYear", "1900", "-maxYear", "1850"}, "is after maxYear", false},
+		{"script", []string{"-script", "gothic"}, "-script must be", false},
+		{"crop padding", []string{"-cropPaddingMode", "none"}, "-cropPaddingMode must be", false},
+		{"cache depth", []string{"-cacheDepth", "0"}, "-cacheDepth must be at least 1", false},
+		{"tls key without certificate", []string{"-tlsKey", "key.pem"}, "both a certificate and a key", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			if !tc.withRepoPath {
+				// Only the flag under test is invalid
+				args = append([]string{"-repoPath", repoPath, "-offline"}, args...)
+			}
+			code, stderr := runMain(t, args...)
+			if code != 2 {
+				t.Errorf("exited with %d, want 2\n%s", code, stderr)
+			}
+			if !strings.Contains(stderr, "flag: ") || !strings.Contains(stderr, tc.want) {
+				t.Errorf("expected 'flag: ...%s' on stderr, got:\n%s", tc.want, stderr)
+			}
+			if strings.Contains(stderr, "panic:") {
+				t.Errorf("panicked:\n%s", stderr)
+			}
+		})
+	}
+}
+
+func TestMediaTypeList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"texts", []string{"texts"}},
+		{"texts, audio", []string{"texts", "audio"}},
+		{"texts,,audio,", []string{"texts", "audio"}},
+		{"", []string{"texts"}},
+		{" , ", []string{"texts"}},
+	}
+	for _, tc := range tests {
+		if got := mediaTypeList(tc.value); strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("mediaTypeList(%q) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+// serveUntilHealthy runs the server on a fresh repository with the given
+// extra arguments, waits until it is serving and shuts it down with SIGINT.
+// Returns what it logged to stdout.
+func serveUntilHealthy(t *testing.T, args ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repoPath := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repoPath).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	command := mainCommand(append([]string{"-repoPath", repoPath, "-offline", "-cacheDir", t.TempDir(),
+		"-port", fmt.Sprint(port), "-metrics=false"}, args...)...)
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- command.Wait() }()
+
+	healthURL := fmt.Sprintf("http://127.0.0.1:%d/healthz", port)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(healthURL)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		select {
+		case err := <-exited:
+			t.Fatalf("exited with %v before serving:\n%s", err, stderr.String())
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			command.Process.Kill()
+			t.Fatalf("not serving after 10s:\n%s", stderr.String())
+		}
+	}
+	if err := command.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("exited with %v after SIGINT:\n%s", err, stderr.String())
+		}
+	case <-time.After(10 * time.Second):
+		command.Process.Kill()
+		t.Fatalf("did not shut down after SIGINT:\n%s", stderr.String())
+	}
+	if strings.Contains(stderr.String(), "panic:") {
+		t.Errorf("panicked:\n%s", stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestServeWithValidRepoPath(t *testing.T) {
+	serveUntilHealthy(t)
+}
+
+func TestCacheDepthIsLogged(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"default", nil, 3},
+		{"explicit", []string{"-cacheDepth", "5"}, 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout := serveUntilHealthy(t, tc.args...)
+			if want := fmt.Sprintf(`"prefetchDepth":%d`, tc.want); !strings.Contains(stdout, want) {
+				t.Errorf("expected %s in the log, got:\n%s", want, stdout)
+			}
+		})
+	}
+}
+
+func TestResolvePort(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     int
+		explicit bool
+		debug    bool
+		tls      bool
+		want     int
+	}{
+		{"default", 8080, false, false, false, 8080},
+		{"debug", 8080, false, true, false, 8083},
+		{"tls", 8080, false, false, true, 443},
+		{"debug with tls", 8080, false, true, true, 8083},
+		{"explicit", 9000, true, false, false, 9000},
+		{"explicit in debug mode", 9000, true, true, false, 9000},
+		{"explicit with tls", 8443, true, false, true, 8443},
+		{"explicitly the default in debug mode", 8080, true, true, false, 8080},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := resolvePort(tc.port, tc.explicit, tc.debug, tc.tls); got != tc.want {
+				t.Errorf("got port %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
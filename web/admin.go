@@ -0,0 +1,150 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+// requireAdmin only lets requests through that carry the admin token
+func requireAdmin(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if options.AdminToken == "" {
+			writeAPIError(fmt.Errorf("admin API is disabled"), http.StatusForbidden, w)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(options.AdminToken)) != 1 {
+			writeAPIError(fmt.Errorf("invalid admin token"), http.StatusUnauthorized, w)
+			return
+		}
+		handle(w, r, ps)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to serialize response to JSON")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(raw)
+}
+
+func writeStoreError(err error, w http.ResponseWriter) {
+	if err == lib.ErrDocumentNotFound || err == lib.ErrLineNotFound {
+		writeAPIError(err, http.StatusNotFound, w)
+	} else if err == lib.ErrLineNotFlagged {
+		writeAPIError(err, http.StatusConflict, w)
+	} else if err == lib.ErrLockTimeout {
+		writeAPIError(err, http.StatusServiceUnavailable, w)
+	} else {
+		writeAPIError(err, http.StatusInternalServerError, w)
+	}
+}
+
+// ListFlagged returns all lines that are flagged for review
+func ListFlagged(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	flagged, err := store.Flagged()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list flagged lines")
+		writeStoreError(err, w)
+		return
+	}
+	writeJSON(w, flagged)
+}
+
+// ClearFlags accepts a flagged line and removes it from the review list
+func ClearFlags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	doc, err := store.ClearFlags(ps.ByName("ident"), ps.ByName("line"))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("documentId", ps.ByName("ident")).
+			Str("lineId", ps.ByName("line")).
+			Msg("Failed to clear review flags")
+		writeStoreError(err, w)
+		return
+	}
+	writeJSON(w, doc)
+}
+
+// RejectLine removes a flagged line from the corpus
+func RejectLine(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	doc, err := store.RejectLine(ps.ByName("ident"), ps.ByName("line"))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("documentId", ps.ByName("ident")).
+			Str("lineId", ps.ByName("line")).
+			Msg("Failed to reject line")
+		writeStoreError(err, w)
+		return
+	}
+	writeJSON(w, doc)
+}
+
+// ListSessionLines lists all committed lines submitted in a session
+func ListSessionLines(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	lines, err := store.SessionLines(ps.ByName("session"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list session lines")
+		writeStoreError(err, w)
+		return
+	}
+	writeJSON(w, lines)
+}
+
+// RetractSession removes all lines submitted in a session from the corpus
+func RetractSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	numRetracted, err := store.RetractSession(ps.ByName("session"))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("session", ps.ByName("session")).
+			Msg("Failed to retract session")
+		writeStoreError(err, w)
+		return
+	}
+	writeJSON(w, map[string]int{"numRetracted": numRetracted})
+}
+
+type debugState struct {
+	Identifiers        map[int]int             `json:"identifiers"`
+	LineImages         lib.LineImageCacheStats `json:"lineImages"`
+	LineImageDedup     lib.DedupStats          `json:"lineImageDedup"`
+	QuarantinedVolumes int                     `json:"quarantinedVolumes"`
+	BlockedIdentifiers int                     `json:"blockedIdentifiers"`
+	InFlightFetches    map[string]int          `json:"inFlightFetches"`
+	PendingSubmissions int                     `json:"pendingSubmissions"`
+	RetriedSubmissions int                     `json:"retriedSubmissions"`
+}
+
+// DebugState returns a snapshot of the server's in-memory state
+func DebugState(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	state := debugState{
+		Identifiers:        lib.IDCache.Counts(),
+		LineImages:         lib.LineCache.Stats(),
+		LineImageDedup:     lib.LineCache.DedupStats(),
+		QuarantinedVolumes: lib.VolumeLines.NumQuarantined(),
+		BlockedIdentifiers: lib.Blocked.Len(),
+		InFlightFetches:    make(map[string]int),
+		PendingSubmissions: submissionLog.NumPending(),
+		RetriedSubmissions: retries.size(),
+	}
+	inFlight.Lock()
+	for ident, year := range inFlight.fetches {
+		state.InFlightFetches[ident] = year
+	}
+	inFlight.Unlock()
+	writeJSON(w, state)
+}
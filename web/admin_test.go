@@ -0,0 +1,102 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"archiscribe/lib"
+)
+
+func TestDebugState(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		header     string
+		want       int
+	}{
+		{"admin API disabled", "", "Bearer secret", http.StatusForbidden},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer guess", http.StatusUnauthorized},
+		{"admin token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMemoryCorpus(t)
+			useCaches(t)
+			useOptions(t, Options{AdminToken: tc.adminToken})
+			lib.IDCache.Add("anzeiger_1877", 120, 1877, "texts")
+			lib.IDCache.Add("anzeiger_1878", 140, 1878, "texts")
+			lib.IDCache.Add("kalender_1878", 90, 1878, "texts")
+			if _, err := submissionLog.Append(lib.TaskDefinition{Document: testDocument("anzeiger_1877", "Zeile")}); err != nil {
+				t.Fatal(err)
+			}
+			inFlight.Lock()
+			inFlight.fetches["anzeiger_1878"] = 1878
+			inFlight.Unlock()
+			defer func() {
+				inFlight.Lock()
+				delete(inFlight.fetches, "anzeiger_1878")
+				inFlight.Unlock()
+			}()
+
+			req := httptest.NewRequest("GET", "/api/admin/debug/state", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			requireAdmin(DebugState)(rec, req, nil)
+			if rec.Code != tc.want {
+				t.Fatalf("got status %d, want %d", rec.Code, tc.want)
+			}
+			if tc.want != http.StatusOK {
+				return
+			}
+			var state map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+				t.Fatal(err)
+			}
+			fields := []string{
+				"identifiers", "lineImages", "lineImageDedup", "quarantinedVolumes",
+				"blockedIdentifiers", "inFlightFetches", "pendingSubmissions", "retriedSubmissions",
+			}
+			for _, field := range fields {
+				if _, ok := state[field]; !ok {
+					t.Errorf("snapshot has no field %q", field)
+				}
+			}
+			var snapshot debugState
+			if err := json.Unmarshal(rec.Body.Bytes(), &snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if snapshot.Identifiers[1877] != 1 || snapshot.Identifiers[1878] != 2 {
+				t.Errorf("unexpected identifier counts %v", snapshot.Identifiers)
+			}
+			if snapshot.InFlightFetches["anzeiger_1878"] != 1878 || snapshot.PendingSubmissions != 1 {
+				t.Errorf("unexpected snapshot %+v", snapshot)
+			}
+		})
+	}
+}
+
+func TestStoreErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{lib.ErrDocumentNotFound, http.StatusNotFound},
+		{lib.ErrLineNotFound, http.StatusNotFound},
+		{lib.ErrLineNotFlagged, http.StatusConflict},
+		{lib.ErrLockTimeout, http.StatusServiceUnavailable},
+		{fmt.Errorf("push failed"), http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		writeStoreError(tc.err, rec)
+		if rec.Code != tc.want {
+			t.Errorf("%v got status %d, want %d", tc.err, rec.Code, tc.want)
+		}
+	}
+}
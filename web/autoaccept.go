@@ -0,0 +1,83 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	"archiscribe/lib"
+)
+
+// Time after which auto-accepted lines of a task that was never submitted
+// are forgotten
+const autoAcceptedMaxAge = 24 * time.Hour
+
+type acceptedLines struct {
+	lines   []lib.OCRLine
+	created time.Time
+}
+
+// Lines that were accepted from the OCR when a task was handed out, mapped
+// to their volume. They are added to the document once it is submitted.
+var autoAccepted = struct {
+	sync.Mutex
+	volumes map[string]acceptedLines
+}{volumes: make(map[string]acceptedLines)}
+
+// partitionByConfidence splits lines into those that need a human
+// transcription and those whose OCR confidence is high enough to accept
+// their OCR text as it is
+func partitionByConfidence(lines []lib.OCRLine, minConfidence float64) ([]lib.OCRLine, []lib.OCRLine) {
+	human := make([]lib.OCRLine, 0, len(lines))
+	machine := make([]lib.OCRLine, 0)
+	for _, line := range lines {
+		if line.OCRText != "" && line.OCRConfidence >= minConfidence {
+			machine = append(machine, line)
+		} else {
+			human = append(human, line)
+		}
+	}
+	return human, machine
+}
+
+// rememberAccepted stores the auto-accepted lines of a volume until its
+// document is submitted
+func rememberAccepted(ident string, lines []lib.OCRLine) {
+	accepted := make([]lib.OCRLine, len(lines))
+	for idx, line := range lines {
+		line.Transcription = line.OCRText
+		line.Provenance = lib.ProvenanceMachine
+		accepted[idx] = line
+	}
+	autoAccepted.Lock()
+	defer autoAccepted.Unlock()
+	now := time.Now()
+	for other, entry := range autoAccepted.volumes {
+		if now.Sub(entry.created) > autoAcceptedMaxAge {
+			delete(autoAccepted.volumes, other)
+		}
+	}
+	autoAccepted.volumes[ident] = acceptedLines{lines: accepted, created: now}
+}
+
+// takeAccepted returns the auto-accepted lines of a volume that are not part
+// of the submitted lines and forgets them
+func takeAccepted(ident string, submitted []lib.OCRLine) []lib.OCRLine {
+	autoAccepted.Lock()
+	entry, ok := autoAccepted.volumes[ident]
+	delete(autoAccepted.volumes, ident)
+	autoAccepted.Unlock()
+	if !ok {
+		return nil
+	}
+	submittedIDs := make(map[string]bool, len(submitted))
+	for _, line := range submitted {
+		submittedIDs[line.Identifier] = true
+	}
+	lines := make([]lib.OCRLine, 0, len(entry.lines))
+	for _, line := range entry.lines {
+		if !submittedIDs[line.Identifier] {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"archiscribe/lib"
+)
+
+// recordingSink keeps the lines sent by a line producer
+type recordingSink struct {
+	lines []lib.OCRLine
+}
+
+func (s *recordingSink) open() {}
+
+func (s *recordingSink) writeMessage(event string, msg interface{}) {
+	if lines, ok := msg.([]lib.OCRLine); ok && event == "lines" {
+		s.lines = append(s.lines, lines...)
+	}
+}
+
+func TestAutoAcceptConfidentLines(t *testing.T) {
+	const ident = "landbote_1866"
+	texts := []string{"Amtliche Bekanntmachung", "Holzverſteigerung", "im Gemeindewald", "am 3. Mai"}
+	confidences := []float64{0.98, 0.6, 0.95, 0.3}
+	tests := []struct {
+		name          string
+		minConfidence float64
+		// Indices of the lines served to the contributor and of those
+		// accepted from the OCR
+		served   []int
+		accepted []int
+	}{
+		{"disabled", 0, []int{0, 1, 2, 3}, nil},
+		{"high threshold", 0.97, []int{1, 2, 3}, []int{0}},
+		{"low threshold", 0.9, []int{1, 3}, []int{0, 2}},
+		{"everything is uncertain", 0.99, []int{0, 1, 2, 3}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			memory, _ := useMemoryCorpus(t)
+			useCaches(t)
+			useOptions(t, Options{AutoAcceptConfidence: tc.minConfidence})
+			doc := testDocument(ident, texts...)
+			img := testPNG(t, 300, 40)
+			for idx := range doc.Lines {
+				doc.Lines[idx].Transcription = ""
+				doc.Lines[idx].OCRConfidence = confidences[idx]
+				cacheImage(t, lib.MakeLineIdentifier(ident, doc.Lines[idx]), img)
+			}
+			// Only the cached lines are served without a connection, so none
+			// are downloaded in the background
+			previousOffline := lib.Offline
+			lib.Offline = true
+			defer func() { lib.Offline = previousOffline }()
+			sink := &recordingSink{}
+			producer := newLineProducer(context.Background(), sink, 10, 1866)
+			producer.ident = ident
+			producer.handleLines(doc.Lines)
+
+			served := make(map[string]bool)
+			for _, line := range sink.lines {
+				served[line.Identifier] = true
+			}
+			if len(served) != len(tc.served) {
+				t.Errorf("served %d lines, want %d", len(served), len(tc.served))
+			}
+			for _, idx := range tc.served {
+				if !served[doc.Lines[idx].Identifier] {
+					t.Errorf("line %q was not served", texts[idx])
+				}
+			}
+
+			// The contributor transcribes the lines they were served
+			submitted := doc
+			submitted.Lines = nil
+			for _, line := range sink.lines {
+				line.Transcription = line.OCRText + "."
+				submitted.Lines = append(submitted.Lines, line)
+			}
+			body, _ := json.Marshal(lib.TaskDefinition{Document: submitted, Author: "Test", Email: "test@example.org"})
+			rec := httptest.NewRecorder()
+			SubmitDocument(rec, httptest.NewRequest("PUT", "/api/documents", bytes.NewReader(body)), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+			stored, err := memory.LoadVolume(ident)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored.Lines) != len(texts) {
+				t.Fatalf("stored %d lines, want %d", len(stored.Lines), len(texts))
+			}
+			accepted := make(map[string]bool)
+			for _, idx := range tc.accepted {
+				accepted[doc.Lines[idx].Identifier] = true
+			}
+			for _, line := range stored.Lines {
+				if accepted[line.Identifier] {
+					if line.Provenance != lib.ProvenanceMachine || line.Transcription != line.OCRText {
+						t.Errorf("line %q stored as %q with provenance %q, want the OCR text as %q",
+							line.OCRText, line.Transcription, line.Provenance, lib.ProvenanceMachine)
+					}
+				} else if line.Provenance != "" || line.Transcription != line.OCRText+"." {
+					t.Errorf("line %q stored as %q with provenance %q, want the human transcription",
+						line.OCRText, line.Transcription, line.Provenance)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package web
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+// reloadBlocklistOnHangup reloads the blocklist file whenever the process
+// receives SIGHUP
+func reloadBlocklistOnHangup() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := lib.Blocked.Reload(); err != nil {
+			log.Error().
+				Err(err).
+				Str("path", lib.Blocked.Path()).
+				Msg("Could not reload blocklist")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+// isVolumeInUse checks if the cached lines of a volume are about to be served,
+// because it was prefetched or its lines are being sent to a client
+func isVolumeInUse(ident string) bool {
+	if prefetcher.isReserved(ident) {
+		return true
+	}
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	_, ok := inFlight.fetches[ident]
+	return ok
+}
+
+// compactCacheWorker compacts the volume cache periodically
+func compactCacheWorker() {
+	for {
+		numRemoved, err := lib.CompactVolumeCache(store, isVolumeInUse, options.VolumeCacheMaxEntries)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not compact volume cache")
+		} else {
+			log.Info().Int("numRemoved", numRemoved).Msg("Compacted volume cache")
+		}
+		time.Sleep(options.CompactInterval)
+	}
+}
@@ -0,0 +1,221 @@
+package web
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultCompressMinSize is used if no minimum size is configured. The
+// compression overhead outweighs the savings for smaller responses.
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressLevel balances speed and size
+const DefaultCompressLevel = gzip.DefaultCompression
+
+// compressibleTypes are the media types worth compressing. Images and other
+// binary formats are compressed already.
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// ValidateCompressLevel checks that a compression level is supported by
+// compress/flate, which gzip uses as well
+func ValidateCompressLevel(level int) error {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return fmt.Errorf(
+			"compression level must be between %d and %d, not %d",
+			flate.HuffmanOnly, flate.BestCompression, level)
+	}
+	return nil
+}
+
+// negotiateEncoding picks the content encoding for a request, gzip is
+// preferred over deflate. Returns an empty string if the client accepts
+// neither.
+func negotiateEncoding(r *http.Request) string {
+	var deflate bool
+	for _, token := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(token, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) > 1 && strings.Replace(strings.TrimSpace(parts[1]), " ", "", -1) == "q=0" {
+			continue
+		}
+		switch coding {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter compresses a response once it is known to be worth it.
+// The body is buffered until it reaches the minimum size, the handler
+// finishes or flushes it. Streamed event responses are compressed right
+// away and flushed message by message.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.decided {
+		c.ResponseWriter.WriteHeader(status)
+	} else if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.decided {
+		if c.encoder != nil {
+			return c.encoder.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize || c.isStream() {
+		if err := c.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends everything that was written so far to the client
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if err := c.decide(); err != nil {
+			return
+		}
+	}
+	if flusher, ok := c.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the connection, e.g. for
+// read deadlines
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Close writes out the buffered body and finishes the compressed stream
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if err := c.decide(); err != nil {
+			return err
+		}
+	}
+	if c.encoder != nil {
+		return c.encoder.Close()
+	}
+	return nil
+}
+
+func (c *compressWriter) isStream() bool {
+	return strings.HasPrefix(c.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// decide checks if the response is compressed, writes the header and the
+// buffered body
+func (c *compressWriter) decide() error {
+	c.decided = true
+	headers := c.Header()
+	if headers.Get("Content-Type") == "" && len(c.buf) > 0 {
+		// Sniff it like net/http would, so images are recognized
+		headers.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	status := c.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	compress := isCompressible(headers.Get("Content-Type")) &&
+		headers.Get("Content-Encoding") == "" &&
+		headers.Get("Content-Range") == "" &&
+		status != http.StatusNoContent && status != http.StatusNotModified &&
+		status != http.StatusPartialContent
+	if compress {
+		headers.Add("Vary", "Accept-Encoding")
+		compress = len(c.buf) >= c.minSize || c.isStream()
+	}
+	if compress {
+		headers.Set("Content-Encoding", c.encoding)
+		headers.Del("Content-Length")
+		var err error
+		if c.encoding == "gzip" {
+			c.encoder, err = gzip.NewWriterLevel(c.ResponseWriter, options.CompressLevel)
+		} else {
+			c.encoder, err = flate.NewWriter(c.ResponseWriter, options.CompressLevel)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.encoder != nil {
+		_, err = c.encoder.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+	return err
+}
+
+// withCompression compresses responses for clients that accept gzip or
+// deflate, if a compression level is configured. WebSocket upgrades and
+// HEAD requests are passed through.
+func withCompression(h http.Handler) http.Handler {
+	if options.CompressLevel == 0 {
+		return h
+	}
+	minSize := options.CompressMinSize
+	if minSize <= 0 {
+		minSize = DefaultCompressMinSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r)
+		if encoding == "" || r.Method == "HEAD" || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+		h.ServeHTTP(cw, r)
+		// Not deferred, after a panic the recovery middleware responds
+		cw.Close()
+	})
+}
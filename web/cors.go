@@ -0,0 +1,85 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Methods and headers that cross-origin API requests may use if none are
+// configured
+var (
+	DefaultCORSMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	DefaultCORSHeaders = []string{"Content-Type", "Authorization"}
+)
+
+// How long browsers may cache the response to a preflight request
+const corsMaxAge = 10 * time.Minute
+
+// Response headers that scripts on other origins can read, needed for
+// polling asynchronous submissions and for backing off
+var corsExposedHeaders = []string{"Location", "Retry-After"}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header
+// for a request origin, empty if the origin is not allowed
+func allowedOrigin(origin string) string {
+	for _, allowed := range options.CORSOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS answers preflight requests and adds the CORS headers to the
+// responses of cross-origin API requests from the allowed origins. Without
+// any allowed origins, the API stays same-origin only.
+func withCORS(h http.Handler) http.Handler {
+	if len(options.CORSOrigins) == 0 {
+		return h
+	}
+	methods := options.CORSMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	headers := options.CORSHeaders
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := allowedOrigin(origin)
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed == "" {
+			if preflight {
+				// Without the CORS headers, the browser does not send the
+				// actual request
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		h.ServeHTTP(w, r)
+	})
+}
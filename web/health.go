@@ -0,0 +1,109 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+
+	"archiscribe/lib"
+)
+
+// Years that tasks were requested for since the start
+var requestedYears = struct {
+	sync.Mutex
+	years map[int]bool
+}{years: make(map[int]bool)}
+
+// noteRequestedYear remembers a year for the readiness check
+func noteRequestedYear(year int) {
+	requestedYears.Lock()
+	requestedYears.years[year] = true
+	requestedYears.Unlock()
+}
+
+type componentStatus struct {
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+type readiness struct {
+	Ready bool `json:"ready"`
+	// Requested years without any volume left to transcribe
+	EmptyYears []int                      `json:"emptyYears,omitempty"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// Healthz reports that the server is up
+func Healthz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeStatus(w, http.StatusOK, map[string]bool{"alive": true})
+}
+
+// Readyz reports whether the server can hand out tasks. This requires the
+// caches to be set up and at least one volume to be available for every year
+// tasks were requested for.
+func Readyz(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	state := readiness{Ready: true, Components: make(map[string]componentStatus)}
+	set := func(name string, status componentStatus) {
+		state.Components[name] = status
+		state.Ready = state.Ready && status.Ready
+	}
+	if isShuttingDown() {
+		set("server", componentStatus{Message: "shutting down"})
+	} else {
+		set("server", componentStatus{Ready: true})
+	}
+	if lib.LineCache == nil {
+		set("lineCache", componentStatus{Message: "not initialized"})
+	} else {
+		set("lineCache", componentStatus{Ready: true})
+	}
+	if lib.IDCache == nil {
+		set("identifiers", componentStatus{Message: "not initialized"})
+	} else {
+		counts := lib.IDCache.Counts()
+		numIdents := 0
+		for _, count := range counts {
+			numIdents += count
+		}
+		requestedYears.Lock()
+		for year := range requestedYears.years {
+			if counts[year] == 0 && !prefetcher.has(year) {
+				state.EmptyYears = append(state.EmptyYears, year)
+			}
+		}
+		requestedYears.Unlock()
+		sort.Ints(state.EmptyYears)
+		if numIdents == 0 {
+			set("identifiers", componentStatus{Message: "no identifiers cached"})
+		} else if len(state.EmptyYears) > 0 {
+			set("identifiers", componentStatus{Message: "no volumes left for some requested years"})
+		} else {
+			set("identifiers", componentStatus{Ready: true})
+		}
+	}
+	if submissionLog == nil {
+		set("submissionLog", componentStatus{Message: "not opened"})
+	} else {
+		set("submissionLog", componentStatus{Ready: true})
+	}
+	status := http.StatusOK
+	if !state.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeStatus(w, status, state)
+}
+
+// writeStatus writes a JSON response with the given status code
+func writeStatus(w http.ResponseWriter, status int, v interface{}) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(raw)
+}
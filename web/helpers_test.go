@@ -0,0 +1,103 @@
+package web
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"archiscribe/lib"
+)
+
+// useMemoryCorpus replaces the corpus with an empty in-memory store and the
+// submission log with one in a temporary directory for the duration of the
+// test. Returns the store and the path of the log.
+func useMemoryCorpus(t *testing.T) (*lib.MemoryStore, string) {
+	t.Helper()
+	memory := lib.NewMemoryStore()
+	walPath := filepath.Join(t.TempDir(), "submissions.wal")
+	wal, err := lib.OpenSubmissionLog(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousCorpus, previousLog := corpus, submissionLog
+	corpus, submissionLog = memory, wal
+	t.Cleanup(func() {
+		wal.Close()
+		corpus, submissionLog = previousCorpus, previousLog
+	})
+	return memory, walPath
+}
+
+// useOptions sets the server options for the duration of the test
+func useOptions(t *testing.T, opts Options) {
+	t.Helper()
+	previous := options
+	options = opts
+	t.Cleanup(func() { options = previous })
+}
+
+// testDocument returns a volume with transcribed lines
+func testDocument(ident string, transcriptions ...string) lib.Document {
+	doc := lib.Document{Identifier: ident, Title: "Test", Year: 1870}
+	for idx, text := range transcriptions {
+		url := "https://iiif.example.org/" + ident + "/line" + string(rune('a'+idx)) + ".png"
+		doc.Lines = append(doc.Lines, lib.OCRLine{
+			Identifier:    lib.LineDigest.Digest([]byte(url)),
+			ImageURL:      url,
+			Transcription: text,
+			OCRText:       text,
+		})
+	}
+	return doc
+}
+
+// useCaches replaces the identifier, line image and volume caches with
+// empty ones in a temporary directory for the duration of the test. Images
+// are cached from local servers, so the rate limit is lifted meanwhile.
+func useCaches(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	previousIDs, previousImages, previousVolumes := lib.IDCache, lib.LineCache, lib.VolumeLines
+	lib.IDCache = lib.NewIdentifierCache(filepath.Join(dir, "identifiers.json"))
+	lib.LineCache = lib.NewLineImageCache(dir)
+	lib.VolumeLines = lib.NewVolumeCache(dir, 0)
+	lib.SetMaxRequestsPerSecond(0)
+	t.Cleanup(func() {
+		lib.IDCache, lib.LineCache, lib.VolumeLines = previousIDs, previousImages, previousVolumes
+		lib.SetMaxRequestsPerSecond(lib.DefaultMaxRequestsPerSecond)
+	})
+}
+
+// testPNG encodes a gray image of the given size
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(30 + (x*7+y*13)%200)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// cacheImage downloads an image into the line cache from a test server
+func cacheImage(t *testing.T, id string, img []byte) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	}))
+	defer server.Close()
+	if _, err := lib.LineCache.CacheLine(server.URL+"/"+id+".png", id); err != nil {
+		t.Fatal(err)
+	}
+}
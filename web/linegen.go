@@ -2,82 +2,246 @@ package web
 
 import (
 	"archiscribe/lib"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"sort"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 )
 
-func pickVolume(year int) string {
+// Maximum number of failed requests while picking a volume
+const maxPickFailures = 10
+
+// pickVolume picks a random volume from a year that is set in the script
+// selected by the options and removes it from the identifier cache, returns
+// the volume and its script
+func pickVolume(year int) (string, string, error) {
+	for {
+		ident, script, err := findVolume(year)
+		if err != nil {
+			return "", "", err
+		}
+		// The prefetcher may have picked the same volume in the meantime
+		if prefetcher.reserve(ident) {
+			lib.IDCache.Remove(year, ident)
+			prefetcher.release(ident)
+			return ident, script, nil
+		}
+	}
+}
+
+// findVolume is like pickVolume, but keeps the volume in the identifier
+// cache. Volumes reserved by the prefetcher are skipped.
+func findVolume(year int) (string, string, error) {
+	wanted := options.ScriptFilter
+	if wanted == "" {
+		wanted = lib.ScriptFraktur
+	}
+	if lib.Offline {
+		return findCachedVolume(year, wanted)
+	}
+	numFailures := 0
 	for {
-		entry := lib.IDCache.Random(year)
+		entry, ok := lib.IDCache.RandomWhere(year, func(entry lib.IdentifierCacheEntry) bool {
+			matches := wanted == lib.ScriptAny || !entry.IsClassified() || entry.Script == wanted
+			return matches && !prefetcher.isReserved(entry.Identifier)
+		})
+		if !ok {
+			return "", "", fmt.Errorf("no suitable volumes left for %d", year)
+		}
 		candidate := entry.Identifier
-		isFrak, _ := lib.IsFraktur(candidate)
-		if !isFrak {
-			log.Info().Str("identifier", candidate).
-				Msg("Document did not seem to have Fraktur letters")
+		script := entry.Script
+		if !entry.IsClassified() {
+			classified, err := lib.ClassifyScript(candidate)
+			if err != nil {
+				lib.Blocked.RecordFailure(candidate, err)
+				log.Error().Err(err).Str("identifier", candidate).
+					Bool("unavailable", lib.IsUnavailable(err)).
+					Msg("Could not classify script of document")
+				if lib.ShouldBlacklist(candidate, err) {
+					lib.IDCache.Remove(year, candidate)
+				} else if numFailures++; numFailures >= maxPickFailures {
+					return "", "", err
+				}
+				continue
+			}
+			lib.IDCache.SetScript(year, candidate, classified)
+			script = classified
+		}
+		if wanted != lib.ScriptAny && script != wanted {
+			log.Info().Str("identifier", candidate).Str("script", script).
+				Msg("Document is not set in the selected script")
 			continue
 		}
-		return candidate
+		return candidate, script, nil
+	}
+}
+
+// Volumes whose lines are currently being fetched, mapped to their year
+var inFlight = struct {
+	sync.Mutex
+	fetches map[string]int
+}{fetches: make(map[string]int)}
+
+// lineSink delivers the events of a line producer to the client
+type lineSink interface {
+	// open prepares the response, it is called before the first event
+	open()
+	writeMessage(event string, msg interface{})
+}
+
+// sseSink sends the events of a line producer as server-sent events
+type sseSink struct {
+	resp http.ResponseWriter
+}
+
+func newSSESink(resp http.ResponseWriter) (*sseSink, error) {
+	if _, ok := resp.(http.Flusher); !ok {
+		return nil, fmt.Errorf("streaming unsupported")
 	}
+	return &sseSink{resp: resp}, nil
+}
+
+func (s *sseSink) open() {
+	headers := s.resp.Header()
+	headers.Set("Content-Type", "text/event-stream")
+	headers.Set("Cache-Control", "no-cache")
+	headers.Set("Connection", "keep-alive")
+}
+
+func (s *sseSink) writeMessage(event string, msg interface{}) {
+	json, _ := json.Marshal(msg)
+	fmt.Fprintf(s.resp, "event: %s\n", event)
+	fmt.Fprintf(s.resp, "data: %s\n\n", json)
+	s.resp.(http.Flusher).Flush()
 }
 
 type lineProducer struct {
-	resp     http.ResponseWriter
+	// Cancelled when the client goes away, which stops the fetch
+	ctx      context.Context
+	sink     lineSink
 	ident    string
+	script   string
 	year     int
 	taskSize int
 	progChan chan lib.ProgressMessage
 	lineChan chan []lib.OCRLine
 }
 
-func newLineProducer(resp http.ResponseWriter, taskSize int, year int) (*lineProducer, error) {
-	if _, ok := resp.(http.Flusher); !ok {
-		return nil, fmt.Errorf("streaming unsupported")
-	}
-	if _, ok := resp.(http.CloseNotifier); !ok {
-		return nil, fmt.Errorf("close notification unsupported")
-	}
+func newLineProducer(ctx context.Context, sink lineSink, taskSize int, year int) *lineProducer {
 	if taskSize == 0 {
 		taskSize = 50
 	}
-	return &lineProducer{resp: resp, taskSize: taskSize, year: year}, nil
+	return &lineProducer{ctx: ctx, sink: sink, taskSize: taskSize, year: year}
 }
 
-func (p *lineProducer) produceLines() {
-	p.ident = pickVolume(p.year)
-	p.progChan, p.lineChan = lib.FetchLines(p.ident)
-	log.Info().Str("identifier", p.ident).Msg("Fetching lines")
-	headers := p.resp.Header()
-	headers.Set("Content-Type", "text/event-stream")
-	headers.Set("Cache-Control", "no-cache")
-	headers.Set("Connection", "keep-alive")
+func (p *lineProducer) produceLines() error {
+	var ident, script string
+	if vol, ok := prefetcher.take(p.year); ok {
+		ident, script = vol.ident, vol.script
+	} else {
+		var err error
+		if ident, script, err = pickVolume(p.year); err != nil {
+			return err
+		}
+	}
+	p.ident = ident
+	p.script = script
+	inFlight.Lock()
+	inFlight.fetches[ident] = p.year
+	inFlight.Unlock()
+	defer func() {
+		inFlight.Lock()
+		delete(inFlight.fetches, ident)
+		inFlight.Unlock()
+	}()
+	p.progChan, p.lineChan = lib.FetchLinesContext(p.ctx, p.ident)
+	log.Info().Str("identifier", p.ident).Int("year", p.year).Msg("Fetching lines")
+	p.sink.open()
 
-	metadata, _ := lib.GetMetadata(p.ident)
+	var title string
+	if metadata, err := lib.GetMetadata(p.ident); err == nil {
+		title = metadata.Get("title").MustString()
+	}
 	doc := lib.Document{
 		Identifier: p.ident,
-		Title:      metadata.Get("title").MustString(),
+		Title:      title,
 		Year:       p.year,
-		Manifest:   fmt.Sprintf("https://iiif.archivelab.org/iiif/%s/manifest.json", p.ident),
+		Manifest:   lib.ManifestURL(p.ident),
+		Script:     p.script,
 	}
 	p.writeMessage("document", doc)
 	p.streamLines()
+	return nil
 }
 
 func (p *lineProducer) writeMessage(event string, msg interface{}) {
-	json, _ := json.Marshal(msg)
-	fmt.Fprintf(p.resp, "event: %s\n", event)
-	fmt.Fprintf(p.resp, "data: %s\n\n", json)
-	p.resp.(http.Flusher).Flush()
+	p.sink.writeMessage(event, msg)
 }
 
 func (p *lineProducer) handleLines(lines []lib.OCRLine) {
-	lineIdxes := make([]int, 0, p.taskSize)
+	if options.MergeDuplicates {
+		lines = lib.GroupDuplicateLines(lines)
+	}
+	if lib.CropQuality.Enabled() {
+		lines = skipBlankCrops(p.ident, lines)
+	}
+	if lib.Offline {
+		// Only lines whose images were cached before can be shown
+		lines = cachedLines(p.ident, lines)
+	}
+	var accepted []lib.OCRLine
+	if options.AutoAcceptConfidence > 0 {
+		lines, accepted = partitionByConfidence(lines, options.AutoAcceptConfidence)
+		if len(accepted) > p.taskSize {
+			accepted = pickRandomLines(accepted, p.taskSize)
+		}
+	}
+	taskSize := p.taskSize
+	if taskSize > len(lines) {
+		taskSize = len(lines)
+	}
+	var taskLines []lib.OCRLine
+	if options.LineSelection == SelectDifficult {
+		taskLines = pickDifficultLines(lines, taskSize)
+	} else {
+		taskLines = pickRandomLines(lines, taskSize)
+	}
+	if !lib.Offline {
+		// Run in the background, the user does not have to wait for our
+		// caching
+		go lib.LineCache.CacheLines(append(taskLines, accepted...), p.ident, p.year)
+	}
+	if len(accepted) > 0 {
+		rememberAccepted(p.ident, accepted)
+	}
+	p.writeMessage("lines", taskLines)
+}
+
+// skipBlankCrops removes lines whose cached crop failed the quality check,
+// unless that would leave no lines at all
+func skipBlankCrops(ident string, lines []lib.OCRLine) []lib.OCRLine {
+	kept := make([]lib.OCRLine, 0, len(lines))
+	for _, line := range lines {
+		if !lib.IsBlankCrop(lib.MakeLineIdentifier(ident, line)) {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == 0 {
+		return lines
+	}
+	return kept
+}
+
+// pickRandomLines picks random lines and returns them in their original order
+func pickRandomLines(lines []lib.OCRLine, taskSize int) []lib.OCRLine {
+	lineIdxes := make([]int, 0, taskSize)
 	lineIdxesMap := map[int]bool{}
-	for len(lineIdxes) < p.taskSize {
+	for len(lineIdxes) < taskSize {
 		pickIdx := rand.Intn(len(lines))
 		if lineIdxesMap[pickIdx] {
 			continue
@@ -90,15 +254,30 @@ func (p *lineProducer) handleLines(lines []lib.OCRLine) {
 	for _, lineIdx := range lineIdxes {
 		randomLines = append(randomLines, lines[lineIdx])
 	}
-	// Run in the background, the user does not have to wait for our
-	// caching
-	go lib.LineCache.CacheLines(randomLines, p.ident)
-	p.writeMessage("lines", randomLines)
+	return randomLines
+}
+
+// pickDifficultLines picks the lines with the lowest OCR confidence, most
+// difficult first. If there are not enough lines with a confidence, the
+// remaining lines are picked in their original order.
+func pickDifficultLines(lines []lib.OCRLine, taskSize int) []lib.OCRLine {
+	var withConf []lib.OCRLine
+	var withoutConf []lib.OCRLine
+	for _, line := range lines {
+		if line.OCRConfidence > 0 {
+			withConf = append(withConf, line)
+		} else {
+			withoutConf = append(withoutConf, line)
+		}
+	}
+	sort.SliceStable(withConf, func(i, j int) bool {
+		return withConf[i].OCRConfidence < withConf[j].OCRConfidence
+	})
+	picked := append(withConf, withoutConf...)
+	return picked[:taskSize]
 }
 
 func (p *lineProducer) streamLines() {
-	c, _ := p.resp.(http.CloseNotifier)
-	closer := c.CloseNotify()
 	for {
 		select {
 		case progMsg, ok := <-p.progChan:
@@ -107,6 +286,10 @@ func (p *lineProducer) streamLines() {
 				break
 			}
 			p.writeMessage("progress", progMsg)
+			if progMsg.Error != nil {
+				// The fetch was aborted, no lines will follow
+				return
+			}
 		case allLines, ok := <-p.lineChan:
 			if !ok {
 				p.lineChan = nil
@@ -114,10 +297,13 @@ func (p *lineProducer) streamLines() {
 			}
 			log.Info().
 				Str("identifier", p.ident).
+				Int("year", p.year).
 				Int("numLines", p.taskSize).
 				Msg("Picking lines and caching them")
 			p.handleLines(allLines)
-		case <-closer:
+		case <-p.ctx.Done():
+			return
+		case <-shutdownChan:
 			return
 		}
 		if p.progChan == nil && p.lineChan == nil {
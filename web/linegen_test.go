@@ -0,0 +1,49 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"archiscribe/lib"
+)
+
+// confidentLines returns lines named by their OCR text with the given
+// confidences
+func confidentLines(confidences map[string]float64, order ...string) []lib.OCRLine {
+	lines := make([]lib.OCRLine, 0, len(order))
+	for _, text := range order {
+		lines = append(lines, lib.OCRLine{Identifier: text, OCRText: text, OCRConfidence: confidences[text]})
+	}
+	return lines
+}
+
+func lineTexts(lines []lib.OCRLine) string {
+	texts := make([]string, 0, len(lines))
+	for _, line := range lines {
+		texts = append(texts, line.OCRText)
+	}
+	return strings.Join(texts, ",")
+}
+
+func TestPickDifficultLines(t *testing.T) {
+	confidences := map[string]float64{"a": 0.95, "b": 0.4, "c": 0.7, "d": 0.55}
+	tests := []struct {
+		name     string
+		order    []string
+		taskSize int
+		want     string
+	}{
+		{"lowest confidence first", []string{"a", "b", "c", "d"}, 4, "b,d,c,a"},
+		{"task size", []string{"a", "b", "c", "d"}, 2, "b,d"},
+		{"lines without confidence last", []string{"x", "a", "y", "b"}, 4, "b,a,x,y"},
+		{"sequential without any confidence", []string{"x", "y", "z"}, 2, "x,y"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			picked := pickDifficultLines(confidentLines(confidences, tc.order...), tc.taskSize)
+			if got := lineTexts(picked); got != tc.want {
+				t.Errorf("picked %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package web
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"archiscribe/lib"
+)
+
+var submissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "archiscribe_submissions_total",
+	Help: "Submitted transcriptions by year, script and result",
+}, []string{"year", "script", "result"})
+
+func init() {
+	prometheus.MustRegister(submissions)
+}
+
+// countSubmission records the result of a submission
+func countSubmission(doc lib.Document, result string) {
+	script := doc.Script
+	if script == "" {
+		script = lib.ScriptFraktur
+	}
+	submissions.WithLabelValues(strconv.Itoa(doc.Year), script, result).Inc()
+}
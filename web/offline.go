@@ -0,0 +1,60 @@
+package web
+
+import (
+	"errors"
+
+	"archiscribe/lib"
+)
+
+var errNoCachedVolumes = errors.New("server is offline and has no cached volumes left for this year")
+
+// findCachedVolume finds a random volume from a year whose lines are cached.
+// Volumes whose script is not known yet only match ScriptAny, since they
+// cannot be classified offline.
+func findCachedVolume(year int, wanted string) (string, string, error) {
+	entry, ok := lib.IDCache.RandomWhere(year, func(entry lib.IdentifierCacheEntry) bool {
+		if wanted != lib.ScriptAny && entry.Script != wanted {
+			return false
+		}
+		return lib.VolumeLines.Has(entry.Identifier) && !prefetcher.isReserved(entry.Identifier)
+	})
+	if !ok {
+		return "", "", errNoCachedVolumes
+	}
+	return entry.Identifier, entry.Script, nil
+}
+
+// localImageURL returns the URL of a line image in the local cache, or an
+// empty string if it is not cached
+func localImageURL(ident string, imageURL string) string {
+	id := lib.MakeLineIdentifier(ident, lib.OCRLine{ImageURL: imageURL})
+	if lib.LineCache.GetLinePath(id) == "" {
+		return ""
+	}
+	return "/api/images/" + id
+}
+
+// cachedLines keeps the lines whose images are cached and points the client
+// to the cached images, including those of the context lines
+func cachedLines(ident string, lines []lib.OCRLine) []lib.OCRLine {
+	kept := make([]lib.OCRLine, 0, len(lines))
+	for _, line := range lines {
+		if line.ImageSrc = localImageURL(ident, line.ImageURL); line.ImageSrc == "" {
+			continue
+		}
+		line.PreviousImageURLs = localImageURLs(ident, line.PreviousImageURLs)
+		line.NextImageURLs = localImageURLs(ident, line.NextImageURLs)
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+func localImageURLs(ident string, imageURLs []string) []string {
+	local := make([]string, 0, len(imageURLs))
+	for _, imageURL := range imageURLs {
+		if src := localImageURL(ident, imageURL); src != "" {
+			local = append(local, src)
+		}
+	}
+	return local
+}
@@ -0,0 +1,153 @@
+package web
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+// prefetchedVolume is a volume whose lines are already in the volume cache
+type prefetchedVolume struct {
+	ident  string
+	script string
+}
+
+// volumePrefetcher keeps a buffer of volumes with cached lines for every
+// year that tasks were requested for, so that new tasks can be served
+// without waiting for the OCR to be downloaded and parsed
+type volumePrefetcher struct {
+	sync.Mutex
+	ready map[int]chan prefetchedVolume
+	// Number of volumes currently being fetched for every year
+	pending map[int]int
+	// Limits the fetches running at the same time across all years
+	workers chan struct{}
+	// Identifiers of the volumes that are being fetched or ready. They stay
+	// in the identifier cache until they are taken, so that they are not lost
+	// on restarts, and must not be picked for anything else.
+	reserved map[string]bool
+}
+
+var prefetcher = newVolumePrefetcher()
+
+func newVolumePrefetcher() *volumePrefetcher {
+	return &volumePrefetcher{
+		ready:    make(map[int]chan prefetchedVolume),
+		pending:  make(map[int]int),
+		workers:  make(chan struct{}, 1),
+		reserved: make(map[string]bool),
+	}
+}
+
+// setWorkers sets the number of volumes that are fetched at the same time
+func (p *volumePrefetcher) setWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.workers = make(chan struct{}, n)
+}
+
+// take returns a prefetched volume for a year if there is one and starts
+// fetching replacements in the background
+func (p *volumePrefetcher) take(year int) (prefetchedVolume, bool) {
+	if options.PrefetchDepth < 1 || lib.VolumeLines == nil {
+		return prefetchedVolume{}, false
+	}
+	defer p.refill(year)
+	p.Lock()
+	ready, ok := p.ready[year]
+	if !ok {
+		ready = make(chan prefetchedVolume, options.PrefetchDepth)
+		p.ready[year] = ready
+	}
+	p.Unlock()
+	select {
+	case vol := <-ready:
+		lib.IDCache.Remove(year, vol.ident)
+		p.release(vol.ident)
+		return vol, true
+	default:
+		return prefetchedVolume{}, false
+	}
+}
+
+// has checks if a prefetched volume is ready for a year
+func (p *volumePrefetcher) has(year int) bool {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.ready[year]) > 0
+}
+
+// reserve marks a volume as picked, returns false if it already was
+func (p *volumePrefetcher) reserve(ident string) bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.reserved[ident] {
+		return false
+	}
+	p.reserved[ident] = true
+	return true
+}
+
+// isReserved checks if a volume was picked, but not served yet
+func (p *volumePrefetcher) isReserved(ident string) bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.reserved[ident]
+}
+
+// release makes a reserved volume available again, after it was taken or
+// could not be fetched
+func (p *volumePrefetcher) release(ident string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.reserved, ident)
+}
+
+// refill starts fetching volumes until the buffer for the year is full
+func (p *volumePrefetcher) refill(year int) {
+	p.Lock()
+	defer p.Unlock()
+	ready := p.ready[year]
+	numMissing := options.PrefetchDepth - len(ready) - p.pending[year]
+	for i := 0; i < numMissing; i++ {
+		p.pending[year]++
+		go p.fetch(year, ready)
+	}
+}
+
+// fetch picks a volume and caches its lines. The buffer always has room for
+// the volume, since pending fetches count against its capacity, so failed
+// fetches only give up their slot.
+func (p *volumePrefetcher) fetch(year int, ready chan prefetchedVolume) {
+	defer func() {
+		p.Lock()
+		p.pending[year]--
+		p.Unlock()
+	}()
+	p.workers <- struct{}{}
+	defer func() { <-p.workers }()
+	ident, script, err := findVolume(year)
+	if err != nil {
+		log.Error().Err(err).Int("year", year).Msg("Could not pick volume to prefetch")
+		return
+	}
+	if !p.reserve(ident) {
+		// Picked for a request or another fetch in the meantime
+		return
+	}
+	lines, err := lib.FetchAllLines(ident)
+	if err != nil {
+		log.Error().Err(err).Str("identifier", ident).Msg("Could not prefetch volume")
+		p.release(ident)
+		return
+	}
+	log.Info().
+		Str("identifier", ident).
+		Int("year", year).
+		Int("numLines", len(lines)).
+		Msg("Prefetched volume")
+	ready <- prefetchedVolume{ident: ident, script: script}
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"archiscribe/lib"
+)
+
+// usePrefetcher replaces the prefetcher with an empty one for the duration
+// of the test and waits for its fetches to finish afterwards
+func usePrefetcher(t *testing.T, workers int) *volumePrefetcher {
+	t.Helper()
+	previous := prefetcher
+	prefetcher = newVolumePrefetcher()
+	prefetcher.setWorkers(workers)
+	t.Cleanup(func() {
+		waitForPrefetches(t, prefetcher)
+		prefetcher = previous
+	})
+	return prefetcher
+}
+
+// waitForPrefetches waits until no volumes are being fetched
+func waitForPrefetches(t *testing.T, p *volumePrefetcher) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.Lock()
+		numPending := 0
+		for _, pending := range p.pending {
+			numPending += pending
+		}
+		p.Unlock()
+		if numPending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d volumes are still being prefetched", numPending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPrefetchedVolumesStayCachedUntilTaken(t *testing.T) {
+	useCaches(t)
+	useOptions(t, Options{PrefetchDepth: 2, ScriptFilter: lib.ScriptAny})
+	// Only cached volumes are picked without a connection
+	previousOffline := lib.Offline
+	lib.Offline = true
+	t.Cleanup(func() { lib.Offline = previousOffline })
+	p := usePrefetcher(t, 2)
+	const year = 1871
+	volumes := []string{"anzeiger_1871", "kalender_1871", "wochenblatt_1871"}
+	for _, ident := range volumes {
+		lib.IDCache.Add(ident, 20, year, "texts")
+		lines := []lib.OCRLine{{Identifier: "0001", ImageURL: "https://example.org/" + ident + ".png"}}
+		if err := lib.VolumeLines.Put(ident, lines); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := p.take(year); ok {
+		t.Fatal("took a volume before any was prefetched")
+	}
+	waitForPrefetches(t, p)
+	if numReady := len(p.ready[year]); numReady != 2 {
+		t.Fatalf("prefetched %d volumes, want 2", numReady)
+	}
+	// Prefetched volumes are still cached, so they survive a restart
+	if numCached := lib.IDCache.Counts()[year]; numCached != len(volumes) {
+		t.Errorf("%d volumes are cached after prefetching, want %d", numCached, len(volumes))
+	}
+
+	// Requests that are not served from the buffer get the remaining volume
+	picked, _, err := pickVolume(year)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.isReserved(picked) {
+		t.Errorf("picked %s, which was prefetched", picked)
+	}
+	if _, _, err := pickVolume(year); err == nil {
+		t.Errorf("picked a prefetched volume")
+	}
+
+	vol, ok := p.take(year)
+	if !ok {
+		t.Fatal("no prefetched volume was taken")
+	}
+	if vol.ident == picked {
+		t.Errorf("took %s, which was already picked", vol.ident)
+	}
+	if p.isReserved(vol.ident) {
+		t.Errorf("taken volume %s is still reserved", vol.ident)
+	}
+	if numCached := lib.IDCache.Counts()[year]; numCached != 1 {
+		t.Errorf("%d volumes are cached after taking one, want 1", numCached)
+	}
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// progressFrame is a message sent over the progress WebSocket. Progress
+// frames only carry the progress and errors, the document and its lines are
+// sent in their own frames.
+type progressFrame struct {
+	Type       string        `json:"type"`
+	Identifier string        `json:"identifier"`
+	Year       int           `json:"year"`
+	Progress   float64       `json:"progress"`
+	Retry      int           `json:"retry,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Document   *lib.Document `json:"document,omitempty"`
+	Lines      []lib.OCRLine `json:"lines,omitempty"`
+}
+
+// wsSink sends the events of a line producer as WebSocket frames
+type wsSink struct {
+	conn  *websocket.Conn
+	ident string
+	year  int
+}
+
+func (s *wsSink) open() {}
+
+func (s *wsSink) writeMessage(event string, msg interface{}) {
+	frame := progressFrame{Type: event, Identifier: s.ident, Year: s.year}
+	switch msg := msg.(type) {
+	case lib.Document:
+		s.ident = msg.Identifier
+		frame.Identifier = msg.Identifier
+		frame.Document = &msg
+	case lib.ProgressMessage:
+		frame.Progress = msg.Progress
+		frame.Retry = msg.Retry
+		if msg.Error != nil {
+			frame.Error = msg.Error.Error()
+		}
+	case []lib.OCRLine:
+		frame.Progress = 1
+		frame.Lines = msg
+	}
+	s.writeFrame(frame)
+}
+
+func (s *wsSink) writeFrame(frame progressFrame) {
+	if err := s.conn.WriteJSON(frame); err != nil {
+		log.Debug().Err(err).Str("identifier", s.ident).Msg("Could not write progress frame")
+	}
+}
+
+// ProgressSocket produces lines for a year like ProduceLines, but sends the
+// progress of fetching the volume, the document and its lines as JSON frames
+// over a WebSocket. The fetch is cancelled when the client closes the socket.
+func ProgressSocket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	year, ok := requestedYear(w, ps)
+	if !ok {
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already responded with an error
+		log.Warn().Err(err).Msg("Could not upgrade to WebSocket")
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// Reading is needed to notice the client closing the socket
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+	sink := &wsSink{conn: conn, year: year}
+	taskSize, _ := strconv.Atoi(r.URL.Query().Get("taskSize"))
+	if err := newLineProducer(ctx, sink, taskSize, year).produceLines(); err != nil {
+		log.Error().Err(err).Int("year", year).Msg("Failed to produce lines")
+		sink.writeFrame(progressFrame{Type: "error", Year: year, Error: err.Error()})
+	}
+	conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
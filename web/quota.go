@@ -0,0 +1,84 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	"archiscribe/lib"
+
+	"github.com/rs/zerolog/log"
+)
+
+// coverageInterval is how often the transcribed lines of every year are
+// counted again for the quota
+const coverageInterval = 5 * time.Minute
+
+// yearCoverage holds the number of transcribed lines of every year. It is
+// counted in the background, since counting reads the whole corpus.
+var yearCoverage struct {
+	sync.RWMutex
+	// Lines per year, nil until they were counted for the first time
+	counts map[int]int
+}
+
+// countCoverage counts the transcribed lines of every year again
+func countCoverage() {
+	counts, err := store.LinesPerYear()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not determine coverage of years")
+		return
+	}
+	yearCoverage.Lock()
+	yearCoverage.counts = counts
+	yearCoverage.Unlock()
+}
+
+// coverageWorker keeps the coverage of the years up to date for the quota
+func coverageWorker() {
+	for {
+		countCoverage()
+		time.Sleep(coverageInterval)
+	}
+}
+
+// chooseYear returns the requested year unless it has met its quota. In
+// that case the closest year that is below the quota and still has volumes
+// is returned, or the requested year if all years have met the quota.
+func chooseYear(year int) int {
+	yearCoverage.RLock()
+	coverage := yearCoverage.counts
+	yearCoverage.RUnlock()
+	if coverage == nil {
+		// Not counted yet, every year is below the quota
+		return year
+	}
+	if coverage[year] < options.YearQuota {
+		return year
+	}
+	best := -1
+	for candidate, numVolumes := range lib.IDCache.Counts() {
+		if numVolumes == 0 || coverage[candidate] >= options.YearQuota || lib.CheckYear(candidate) != nil {
+			continue
+		}
+		dist, bestDist := absInt(candidate-year), absInt(best-year)
+		if best < 0 || dist < bestDist || (dist == bestDist && candidate < best) {
+			best = candidate
+		}
+	}
+	if best < 0 {
+		return year
+	}
+	log.Info().
+		Int("year", year).
+		Int("numLines", coverage[year]).
+		Int("servedYear", best).
+		Msg("Year met its quota, serving another year")
+	return best
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
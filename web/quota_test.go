@@ -0,0 +1,123 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"archiscribe/lib"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// useDocumentStore replaces the document store with one in a temporary
+// directory that holds a volume with the given number of transcribed lines
+// for every year
+func useDocumentStore(t *testing.T, linesPerYear map[int]int) {
+	t.Helper()
+	dir := t.TempDir()
+	for year, numLines := range linesPerYear {
+		ident := fmt.Sprintf("chronik_%d", year)
+		yearPath := filepath.Join(dir, "transcriptions", strconv.Itoa(year))
+		if err := os.MkdirAll(yearPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		texts := make([]string, numLines)
+		for idx := range texts {
+			texts[idx] = fmt.Sprintf("Zeile %d", idx+1)
+		}
+		doc := testDocument(ident, texts...)
+		doc.Year = year
+		for _, line := range doc.Lines {
+			textPath := filepath.Join(yearPath, ident+"_"+line.Identifier+".txt")
+			if err := ioutil.WriteFile(textPath, []byte(line.Transcription+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		raw, _ := json.Marshal(doc)
+		if err := ioutil.WriteFile(filepath.Join(yearPath, ident+".json"), raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	docStore, err := lib.NewDocumentStore(dir)
+	if err != nil {
+		t.Skip(err)
+	}
+	previous := store
+	store = docStore
+	t.Cleanup(func() { store = previous })
+}
+
+// useCoverage counts the lines per year of the document store and forgets
+// them after the test
+func useCoverage(t *testing.T) {
+	t.Helper()
+	countCoverage()
+	t.Cleanup(func() {
+		yearCoverage.Lock()
+		yearCoverage.counts = nil
+		yearCoverage.Unlock()
+	})
+}
+
+func TestYearQuota(t *testing.T) {
+	tests := []struct {
+		name         string
+		linesPerYear map[int]int
+		// Years with cached volumes
+		cached    []int
+		requested int
+		want      int
+	}{
+		{"under the quota", map[int]int{1850: 3, 1851: 1}, []int{1850, 1851}, 1851, 1851},
+		{"saturated year", map[int]int{1850: 3, 1851: 1}, []int{1850, 1851}, 1850, 1851},
+		{"above the quota", map[int]int{1850: 5}, []int{1850, 1851}, 1850, 1851},
+		{"closest year below the quota", map[int]int{1850: 3, 1853: 3}, []int{1847, 1850, 1852, 1853}, 1850, 1852},
+		{"earlier year on a tie", map[int]int{1850: 3}, []int{1848, 1850, 1852}, 1850, 1848},
+		{"far year with volumes", map[int]int{1850: 3}, []int{1850, 1860}, 1850, 1860},
+		{"all years saturated", map[int]int{1850: 3, 1851: 4}, []int{1850, 1851}, 1850, 1850},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useOptions(t, Options{YearQuota: 3})
+			useCaches(t)
+			useDocumentStore(t, tc.linesPerYear)
+			useCoverage(t)
+			for _, year := range tc.cached {
+				lib.IDCache.Add(fmt.Sprintf("chronik_%d", year), 20, year, "texts")
+			}
+			rec := httptest.NewRecorder()
+			year, ok := requestedYear(rec, httprouter.Params{{Key: "year", Value: strconv.Itoa(tc.requested)}})
+			if !ok {
+				t.Fatalf("request was rejected with %d: %s", rec.Code, rec.Body)
+			}
+			if year != tc.want {
+				t.Errorf("served %d, want %d", year, tc.want)
+			}
+		})
+	}
+}
+
+func TestYearQuotaUsesCountedCoverage(t *testing.T) {
+	useOptions(t, Options{YearQuota: 3})
+	useCaches(t)
+	useDocumentStore(t, map[int]int{1850: 1})
+	useCoverage(t)
+	lib.IDCache.Add("chronik_1850", 20, 1850, "texts")
+	lib.IDCache.Add("chronik_1851", 20, 1851, "texts")
+
+	// Lines committed since the last count only apply once they are counted
+	useDocumentStore(t, map[int]int{1850: 5})
+	if year := chooseYear(1850); year != 1850 {
+		t.Errorf("served %d before counting again, want 1850", year)
+	}
+	countCoverage()
+	if year := chooseYear(1850); year != 1851 {
+		t.Errorf("served %d after counting again, want 1851", year)
+	}
+}
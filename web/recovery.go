@@ -0,0 +1,49 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var errInternal = errors.New("internal server error")
+
+// logPanic logs a recovered panic with the stack trace of the goroutine
+func logPanic(logger zerolog.Logger, rcv interface{}) {
+	logger.Error().
+		Str("panic", fmt.Sprint(rcv)).
+		Str("stack", string(debug.Stack())).
+		Msg("Recovered from panic")
+}
+
+// withRecovery catches panics in handlers, so that a bad request only fails
+// itself instead of taking down the server. The request is answered with a
+// 500 error, the request id in its header leads to the stack trace in the
+// log.
+func withRecovery(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rcv := recover()
+			if rcv == nil {
+				return
+			}
+			if rcv == http.ErrAbortHandler {
+				// Deliberately aborted response, net/http handles it
+				panic(rcv)
+			}
+			id := requestIDFrom(r)
+			logPanic(log.With().
+				Str("requestId", id).
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Logger(), rcv)
+			w.Header().Del("Content-Encoding")
+			writeAPIError(errInternal, http.StatusInternalServerError, w)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
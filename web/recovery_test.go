@@ -0,0 +1,134 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// panickingHandler serves /ok normally, panics on /panic, panics on /partial
+// after writing the start of a response and aborts the response on /abort
+func panickingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var meta map[string]interface{}
+		// Like a missing field of a malformed metadata file
+		_ = meta["year"].(float64)
+	})
+	mux.HandleFunc("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "partial")
+		panic("partial response")
+	})
+	mux.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	return mux
+}
+
+var generatedIDPat = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+func TestRecoveryAnswersWithInternalError(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		header   http.Header
+		compress bool
+		// Expected request id, generated if empty
+		id string
+	}{
+		{"generated request id", "/panic", nil, false, ""},
+		{"request id of the proxy", "/panic", http.Header{requestIDHeader: {"req-1862"}}, false, "req-1862"},
+		{"overlong request id", "/panic", http.Header{requestIDHeader: {strings.Repeat("x", 65)}}, false, ""},
+		// The compressed output is still buffered when the handler panics
+		{"compressed partial response", "/partial", http.Header{"Accept-Encoding": {"gzip"}}, true, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := Options{}
+			if tc.compress {
+				opts.CompressLevel = 6
+			}
+			useOptions(t, opts)
+			var logged bytes.Buffer
+			previousLogger := log.Logger
+			log.Logger = zerolog.New(&logged)
+			t.Cleanup(func() { log.Logger = previousLogger })
+
+			req := httptest.NewRequest("GET", tc.path, nil)
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			withRequestID(withRecovery(withCORS(withCompression(panickingHandler())))).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("got status %d, want 500", rec.Code)
+			}
+			if encoding := rec.Header().Get("Content-Encoding"); encoding != "" {
+				t.Errorf("error is sent with Content-Encoding %s", encoding)
+			}
+			var apiErr struct {
+				Error string `json:"error"`
+				Code  int    `json:"code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil || apiErr.Code != 500 {
+				t.Errorf("expected a JSON error, got %q", rec.Body.String())
+			}
+			id := rec.Header().Get(requestIDHeader)
+			if tc.id != "" && id != tc.id {
+				t.Errorf("got request id %q, want %q", id, tc.id)
+			} else if tc.id == "" && !generatedIDPat.MatchString(id) {
+				t.Errorf("got request id %q, want a generated one", id)
+			}
+			for _, want := range []string{`"requestId":"` + id + `"`, `"path":"` + tc.path + `"`, "recovery_test.go"} {
+				if !strings.Contains(logged.String(), want) {
+					t.Errorf("log does not contain %s:\n%s", want, logged.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRecoveryKeepsServerUp(t *testing.T) {
+	useOptions(t, Options{})
+	previousLogger := log.Logger
+	log.Logger = zerolog.Nop()
+	t.Cleanup(func() { log.Logger = previousLogger })
+	server := httptest.NewServer(withRequestID(withRecovery(panickingHandler())))
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(server.URL + "/panic")
+		if err != nil {
+			t.Fatalf("server went down after a panic: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("got status %d for the panicking handler", resp.StatusCode)
+		}
+		if resp, err := http.Get(server.URL + "/abort"); err == nil {
+			resp.Body.Close()
+			t.Errorf("aborted response was answered with %d", resp.StatusCode)
+		}
+		resp, err = http.Get(server.URL + "/ok")
+		if err != nil {
+			t.Fatalf("server went down after a panic: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("got status %d after a panic in another request", resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package web
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+// refreshIdentifiersWorker adds new identifiers from Archive.org to the
+// identifier cache periodically
+func refreshIdentifiersWorker() {
+	for {
+		time.Sleep(options.RefreshInterval)
+		numAdded, err := lib.RefreshIdentifierCache(store)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not refresh identifier cache")
+		} else {
+			log.Info().Int("numAdded", numAdded).Msg("Refreshed identifier cache")
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Header for correlating a request with the log, taken from proxies that
+// set it and sent back with every response
+const requestIDHeader = "X-Request-Id"
+
+// Maximum number of bytes of a request body that are logged
+const maxLoggedBodyBytes = 4096
+
+// Paths that are polled by monitoring, their requests are only logged if
+// they fail
+var quietPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+type requestIDKey struct{}
+
+// requestID returns the identifier that a client or proxy passed with a
+// request, a random one if there is none
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 64 {
+		return id
+	}
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// requestIDFrom returns the identifier assigned to a request by
+// withRequestID
+func requestIDFrom(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return requestID(r)
+}
+
+// withRequestID assigns every request an identifier, which is stored in its
+// context and sent back in the X-Request-Id header
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// loggingWriter records the status and size of a response. It passes
+// flushes through for event streams and hijacking for WebSockets.
+type loggingWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (l *loggingWriter) WriteHeader(status int) {
+	if l.status == 0 {
+		l.status = status
+	}
+	l.ResponseWriter.WriteHeader(status)
+}
+
+func (l *loggingWriter) Write(p []byte) (int, error) {
+	if l.status == 0 {
+		l.status = http.StatusOK
+	}
+	n, err := l.ResponseWriter.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *loggingWriter) Flush() {
+	if flusher, ok := l.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the connection, e.g. for
+// read deadlines
+func (l *loggingWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+func (l *loggingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := l.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking unsupported")
+	}
+	if l.status == 0 {
+		l.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// bodyCapture keeps the start of a request body as the handler reads it
+type bodyCapture struct {
+	io.ReadCloser
+	buf []byte
+}
+
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remaining := maxLoggedBodyBytes - len(b.buf); remaining > 0 {
+		if n < remaining {
+			remaining = n
+		}
+		b.buf = append(b.buf, p[:remaining]...)
+	}
+	return n, err
+}
+
+// withAccessLog logs every request with its status, response size and
+// latency once it was handled. Request bodies are logged as well with
+// LogRequestBodies.
+func withAccessLog(h http.Handler) http.Handler {
+	if !options.AccessLog {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &loggingWriter{ResponseWriter: w}
+		var body *bodyCapture
+		if options.LogRequestBodies && r.Body != nil {
+			body = &bodyCapture{ReadCloser: r.Body}
+			r.Body = body
+		}
+		h.ServeHTTP(lw, r)
+		status := lw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		event := log.Info()
+		if status >= 500 {
+			event = log.Warn()
+		} else if quietPaths[r.URL.Path] {
+			event = log.Debug()
+		}
+		event = event.
+			Str("requestId", requestIDFrom(r)).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Int("status", status).
+			Int64("size", lw.size).
+			Dur("latency", time.Since(start))
+		if body != nil && len(body.buf) > 0 {
+			event = event.Str("body", string(body.buf))
+		}
+		event.Msg("Handled request")
+	})
+}
@@ -0,0 +1,181 @@
+package web
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+// Upper limit for the time between two attempts at committing a submission
+const maxRetryInterval = time.Hour
+
+// queuedSubmission is a logged submission whose commit failed and that is
+// attempted again
+type queuedSubmission struct {
+	task lib.TaskDefinition
+	// Identifier of the asynchronous submission to report the outcome to,
+	// empty if nobody is polling for it
+	taskID   string
+	attempts int
+	next     time.Time
+}
+
+// retryQueue commits submissions again whose commit failed with an error
+// that may go away, like a push that failed because of the network. The
+// submissions stay in the submission log until they were committed, so they
+// are replayed after a restart as well.
+type retryQueue struct {
+	sync.Mutex
+	clock   lib.Clock
+	entries map[int64]*queuedSubmission
+	wake    chan struct{}
+	// Closed once the worker stopped, so the submission log can be closed
+	stopped chan struct{}
+}
+
+var retries = &retryQueue{
+	clock:   lib.SystemClock,
+	entries: make(map[int64]*queuedSubmission),
+	wake:    make(chan struct{}, 1),
+}
+
+// isRetriable checks if a failed submission is worth committing again.
+// Invalid submissions will never succeed and dry-run submissions are not in
+// the submission log.
+func isRetriable(code int) bool {
+	return code != http.StatusUnprocessableEntity && !options.DryRun
+}
+
+// retryBackoff returns the time to wait after a number of failed attempts,
+// doubling the retry interval for every attempt
+func retryBackoff(attempts int) time.Duration {
+	interval := options.RetryInterval
+	if interval <= 0 {
+		interval = DefaultRetryInterval
+	}
+	for i := 1; i < attempts && interval < maxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxRetryInterval {
+		interval = maxRetryInterval
+	}
+	return interval
+}
+
+// schedule queues a logged submission that failed to commit
+func (q *retryQueue) schedule(walID int64, task lib.TaskDefinition, taskID string) {
+	q.Lock()
+	defer q.Unlock()
+	if _, ok := q.entries[walID]; ok {
+		return
+	}
+	q.entries[walID] = &queuedSubmission{
+		task:     task,
+		taskID:   taskID,
+		attempts: 1,
+		next:     q.clock.Now().Add(retryBackoff(1)),
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due removes the submissions that are due from the queue and returns them
+// with the time until the next one is due, zero if the queue is empty
+func (q *retryQueue) due() (map[int64]*queuedSubmission, time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+	now := q.clock.Now()
+	due := make(map[int64]*queuedSubmission)
+	var wait time.Duration
+	for walID, entry := range q.entries {
+		if !entry.next.After(now) {
+			due[walID] = entry
+			delete(q.entries, walID)
+		} else if remaining := entry.next.Sub(now); wait == 0 || remaining < wait {
+			wait = remaining
+		}
+	}
+	return due, wait
+}
+
+// requeue puts a submission back into the queue after another failed attempt
+func (q *retryQueue) requeue(walID int64, entry *queuedSubmission) {
+	q.Lock()
+	defer q.Unlock()
+	entry.attempts++
+	entry.next = q.clock.Now().Add(retryBackoff(entry.attempts))
+	q.entries[walID] = entry
+}
+
+// size returns the number of queued submissions
+func (q *retryQueue) size() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.entries)
+}
+
+// retry attempts to commit a queued submission once more
+func (q *retryQueue) retry(walID int64, entry *queuedSubmission) {
+	task := entry.task
+	logger := log.With().
+		Str("documentId", task.Document.Identifier).
+		Int("attempt", entry.attempts+1).
+		Logger()
+	logger.Info().Msg("Retrying failed submission")
+	stored, err := lib.Submit(corpus, task.Document, task.Author, task.Email, task.Comment)
+	if _, ok := err.(*lib.ValidationError); err != nil && !ok {
+		next := retryBackoff(entry.attempts + 1)
+		logger.Error().Err(err).Dur("retryIn", next).Msg("Retried submission failed again")
+		asyncSubmissions.retrying(entry.taskID, err, entry.attempts+1)
+		q.requeue(walID, entry)
+		return
+	} else if err != nil {
+		logger.Error().Err(err).Msg("Dropping invalid submission")
+		countSubmission(task.Document, "invalid")
+		asyncSubmissions.finish(entry.taskID, nil, http.StatusUnprocessableEntity, err)
+	} else {
+		logger.Info().Msg("Committed retried submission")
+		countSubmission(task.Document, "committed")
+		lib.StripSessions(stored)
+		asyncSubmissions.finish(entry.taskID, stored, http.StatusOK, nil)
+	}
+	if err := submissionLog.MarkDone(walID); err != nil {
+		logger.Error().Err(err).Msg("Could not mark submission as done")
+	}
+}
+
+// retryWorker commits the queued submissions once they are due, until the
+// server shuts down. Submissions that are still queued then are replayed
+// from the submission log on the next start.
+func retryWorker() {
+	defer close(retries.stopped)
+	for {
+		due, wait := retries.due()
+		for walID, entry := range due {
+			if isShuttingDown() {
+				return
+			}
+			retries.retry(walID, entry)
+		}
+		if len(due) > 0 {
+			// Failed attempts were queued again
+			continue
+		}
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-timer:
+		case <-retries.wake:
+		case <-shutdownChan:
+			return
+		}
+	}
+}
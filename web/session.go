@@ -0,0 +1,59 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"archiscribe/lib"
+)
+
+// Name of the cookie that holds the session identifier
+const sessionCookie = "archiscribe_session"
+
+// Lifetime of the session cookie
+const sessionMaxAge = 30 * 24 * time.Hour
+
+// sessionID returns the session identifier of a request and sets a new one
+// if the request has none. The identifier is random and carries no
+// information about the user.
+func sessionID(w http.ResponseWriter, r *http.Request) string {
+	if id := requestSession(r); id != "" {
+		return id
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(raw)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(sessionMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return id
+}
+
+// requestSession returns the session identifier sent with a request, or an
+// empty string if it has none or a malformed one
+func requestSession(r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && len(cookie.Value) == 32 {
+		if _, err := hex.DecodeString(cookie.Value); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// setSession attributes all submitted lines to a session, replacing any
+// session the client sent
+func setSession(doc *lib.Document, session string) {
+	for idx := range doc.Lines {
+		doc.Lines[idx].Session = session
+	}
+}
@@ -0,0 +1,84 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"archiscribe/lib"
+)
+
+func TestSubmissionsRecordSessions(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  bool
+		cookie  string
+		wantNew bool
+	}{
+		{"disabled", false, "", false},
+		{"new session", true, "", true},
+		{"existing session", true, "0123456789abcdef0123456789abcdef", false},
+		{"malformed session", true, "admin", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			memory, _ := useMemoryCorpus(t)
+			useOptions(t, Options{RecordSessions: tc.record})
+			doc := testDocument("jahrbuch_1868", "Vorwort", "Inhalt")
+			// Sessions sent by the client are never trusted
+			for idx := range doc.Lines {
+				doc.Lines[idx].Session = "forged"
+			}
+			body, _ := json.Marshal(lib.TaskDefinition{Document: doc, Author: "Test", Email: "test@example.org"})
+			req := httptest.NewRequest("PUT", "/api/documents", bytes.NewReader(body))
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tc.cookie})
+			}
+			rec := httptest.NewRecorder()
+			SubmitDocument(rec, req, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+
+			var session string
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == sessionCookie {
+					session = cookie.Value
+					if !cookie.HttpOnly {
+						t.Errorf("session cookie is readable by scripts")
+					}
+				}
+			}
+			if (session != "") != tc.wantNew {
+				t.Fatalf("set session cookie %q, want a new one: %v", session, tc.wantNew)
+			}
+			want := ""
+			if tc.record {
+				want = tc.cookie
+				if tc.wantNew {
+					want = session
+				}
+			}
+			stored, err := memory.LoadVolume("jahrbuch_1868")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range stored.Lines {
+				if line.Session != want {
+					t.Errorf("line %q was stored with session %q, want %q", line.Transcription, line.Session, want)
+				}
+			}
+			var response lib.Document
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range response.Lines {
+				if line.Session != "" {
+					t.Errorf("session %q was sent back to the client", line.Session)
+				}
+			}
+		})
+	}
+}
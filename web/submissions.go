@@ -0,0 +1,221 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+// States of an asynchronous submission
+const (
+	SubmissionPending   = "pending"
+	SubmissionCommitted = "committed"
+	SubmissionFailed    = "failed"
+)
+
+// Maximum number of asynchronous submissions that are tracked at the same
+// time, the oldest finished ones are forgotten first
+const maxTrackedSubmissions = 1000
+
+var errTooManySubmissions = errors.New("too many pending submissions")
+var errSubmissionNotFound = errors.New("unknown or expired submission")
+
+// SubmissionStatus is the state of an asynchronous submission, as reported
+// to the client polling for it
+type SubmissionStatus struct {
+	TaskID     string `json:"taskId"`
+	Identifier string `json:"identifier"`
+	Status     string `json:"status"`
+	// Commit the submission was recorded in, once it was committed
+	Commit      string `json:"commit,omitempty"`
+	PullRequest string `json:"pullRequest,omitempty"`
+	// Error of the failed submission, or of the last failed attempt while
+	// it is retried
+	Error string `json:"error,omitempty"`
+	// Number of failed attempts at committing a submission that is retried
+	Attempts int `json:"attempts,omitempty"`
+	// HTTP status the submission would have failed with synchronously
+	Code      int       `json:"code,omitempty"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// trackedSubmission is an asynchronous submission with the task that is
+// being committed, which is dropped once it finished
+type trackedSubmission struct {
+	status   SubmissionStatus
+	task     *lib.TaskDefinition
+	finished time.Time
+}
+
+// submissionTracker keeps the state of asynchronous submissions until
+// their clients had the time to poll for it
+type submissionTracker struct {
+	sync.Mutex
+	clock   lib.Clock
+	entries map[string]*trackedSubmission
+	// Submissions whose commit is still running, waited for when shutting
+	// down
+	running sync.WaitGroup
+}
+
+var asyncSubmissions = &submissionTracker{
+	clock:   lib.SystemClock,
+	entries: make(map[string]*trackedSubmission),
+}
+
+// submissionTTL returns how long the state of a finished submission is kept
+func submissionTTL() time.Duration {
+	if options.SubmissionStatusTTL > 0 {
+		return options.SubmissionStatusTTL
+	}
+	return DefaultSubmissionStatusTTL
+}
+
+// add starts tracking a submission and returns its task identifier. Fails if
+// the tracker is full of submissions that are still pending.
+func (t *submissionTracker) add(task *lib.TaskDefinition) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(raw)
+	t.Lock()
+	defer t.Unlock()
+	now := t.clock.Now()
+	ttl := submissionTTL()
+	var oldestID string
+	var oldest time.Time
+	for other, entry := range t.entries {
+		if entry.task != nil {
+			continue
+		}
+		if now.Sub(entry.finished) >= ttl {
+			delete(t.entries, other)
+		} else if oldestID == "" || entry.finished.Before(oldest) {
+			oldestID, oldest = other, entry.finished
+		}
+	}
+	if len(t.entries) >= maxTrackedSubmissions {
+		if oldestID == "" {
+			return "", errTooManySubmissions
+		}
+		delete(t.entries, oldestID)
+	}
+	t.entries[id] = &trackedSubmission{
+		status: SubmissionStatus{
+			TaskID:     id,
+			Identifier: task.Document.Identifier,
+			Status:     SubmissionPending,
+			Submitted:  now,
+		},
+		task: task,
+	}
+	return id, nil
+}
+
+// finish records the outcome of a submission
+func (t *submissionTracker) finish(id string, stored *lib.Document, code int, err error) {
+	t.Lock()
+	defer t.Unlock()
+	entry, ok := t.entries[id]
+	if !ok {
+		return
+	}
+	entry.task = nil
+	entry.finished = t.clock.Now()
+	if err != nil {
+		entry.status.Status = SubmissionFailed
+		entry.status.Error = err.Error()
+		entry.status.Code = code
+		return
+	}
+	entry.status.Status = SubmissionCommitted
+	if stored != nil {
+		entry.status.Commit = stored.Commit
+		entry.status.PullRequest = stored.PullRequest
+	}
+}
+
+// retrying records a failed attempt at committing a submission that is
+// retried, it stays pending
+func (t *submissionTracker) retrying(id string, err error, attempts int) {
+	t.Lock()
+	defer t.Unlock()
+	if entry, ok := t.entries[id]; ok {
+		entry.status.Error = err.Error()
+		entry.status.Attempts = attempts
+	}
+}
+
+// get returns the state of a submission, false if it is unknown or expired
+func (t *submissionTracker) get(id string) (SubmissionStatus, bool) {
+	t.Lock()
+	defer t.Unlock()
+	entry, ok := t.entries[id]
+	if !ok {
+		return SubmissionStatus{}, false
+	}
+	if entry.task == nil && t.clock.Now().Sub(entry.finished) >= submissionTTL() {
+		delete(t.entries, id)
+		return SubmissionStatus{}, false
+	}
+	return entry.status, true
+}
+
+// commitAsync commits a logged submission in the background and responds
+// with the task identifier the client can poll for its outcome
+func commitAsync(w http.ResponseWriter, id string, task lib.TaskDefinition, walID int64, throttled bool, contributor string) {
+	asyncSubmissions.running.Add(1)
+	go func() {
+		defer asyncSubmissions.running.Done()
+		defer func() {
+			// The commit outlives the request, so the recovery middleware
+			// does not cover it
+			if rcv := recover(); rcv != nil {
+				logPanic(log.With().
+					Str("taskId", id).
+					Str("documentId", task.Document.Identifier).
+					Logger(), rcv)
+				asyncSubmissions.finish(id, nil, http.StatusInternalServerError, errInternal)
+			}
+		}()
+		stored, code, err := commitSubmission(task, walID, throttled, contributor)
+		if err != nil && isRetriable(code) {
+			asyncSubmissions.retrying(id, err, 1)
+			retries.schedule(walID, task, id)
+			return
+		}
+		asyncSubmissions.finish(id, stored, code, err)
+	}()
+	writeAccepted(w, id)
+}
+
+// writeAccepted responds with the state of a submission that is committed
+// in the background
+func writeAccepted(w http.ResponseWriter, id string) {
+	status, _ := asyncSubmissions.get(id)
+	js, _ := json.MarshalIndent(status, "", "  ")
+	w.Header().Set("Location", "/api/submissions/"+id)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(js)
+}
+
+// GetSubmission reports the state of an asynchronous submission
+func GetSubmission(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status, ok := asyncSubmissions.get(ps.ByName("taskId"))
+	if !ok {
+		writeAPIError(errSubmissionNotFound, http.StatusNotFound, w)
+		return
+	}
+	writeJSON(w, status)
+}
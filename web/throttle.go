@@ -0,0 +1,89 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"archiscribe/lib"
+)
+
+// submitThrottle enforces a minimum interval between the submissions of a
+// contributor
+type submitThrottle struct {
+	sync.Mutex
+	clock lib.Clock
+	last  map[string]time.Time
+}
+
+var throttle = &submitThrottle{
+	clock: lib.SystemClock,
+	last:  make(map[string]time.Time),
+}
+
+// contributorKey identifies the contributor of a submission by their session
+// and their address. Requests without a session are only told apart by their
+// address. The email and name in a submission are not verified, so they do
+// not identify anyone.
+func contributorKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if session := requestSession(r); session != "" {
+		return "session:" + session + " addr:" + host
+	}
+	return "addr:" + host
+}
+
+// isTrusted checks if a request carries the admin token or one of the trusted
+// tokens, which exempt its submissions from throttling
+func isTrusted(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return false
+	}
+	for _, trusted := range append([]string{options.AdminToken}, options.TrustedTokens...) {
+		if trusted != "" && subtle.ConstantTimeCompare([]byte(token), []byte(trusted)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns how long the contributor has to wait before submitting again.
+// If they can submit right away, it returns zero and reserves the interval
+// from now on for the submission, so that concurrent submissions of the
+// contributor are throttled while it is being committed. Submissions that
+// the contributor has to send again give the reservation back with release.
+func (t *submitThrottle) wait(key string, interval time.Duration) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	now := t.clock.Now()
+	if last, ok := t.last[key]; ok {
+		if remaining := interval - now.Sub(last); remaining > 0 {
+			return remaining
+		}
+	}
+	// Forget submissions that no longer affect throttling
+	for other, last := range t.last {
+		if now.Sub(last) >= interval {
+			delete(t.last, other)
+		}
+	}
+	t.last[key] = now
+	return 0
+}
+
+// release gives back the reservation of a submission that failed, so that
+// the contributor can correct and resend it right away. The reservation was
+// only made once the previous interval had passed, so there is nothing to
+// restore.
+func (t *submitThrottle) release(key string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.last, key)
+}
@@ -0,0 +1,215 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"archiscribe/lib"
+)
+
+// stoppedClock only advances when the test moves it forward
+type stoppedClock struct {
+	now time.Time
+}
+
+func (c *stoppedClock) Now() time.Time        { return c.now }
+func (c *stoppedClock) Sleep(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestSubmissionsAreThrottled(t *testing.T) {
+	const (
+		session = "0123456789abcdef0123456789abcdef"
+		other   = "fedcba9876543210fedcba9876543210"
+	)
+	type submission struct {
+		session string
+		addr    string
+		email   string
+		token   string
+		// Time since the first submission
+		after time.Duration
+		want  int
+	}
+	tests := []struct {
+		name        string
+		submissions []submission
+	}{
+		{"same session too fast", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "", 0, http.StatusOK},
+			{session, "192.0.2.1:4321", "erste@example.org", "", 10 * time.Second, http.StatusTooManyRequests},
+		}},
+		{"different session", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "", 0, http.StatusOK},
+			{other, "192.0.2.1:1234", "erste@example.org", "", 10 * time.Second, http.StatusOK},
+		}},
+		{"different address", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "", 0, http.StatusOK},
+			{session, "198.51.100.7:1234", "erste@example.org", "", 10 * time.Second, http.StatusOK},
+		}},
+		{"no session", []submission{
+			{"", "192.0.2.1:1234", "erste@example.org", "", 0, http.StatusOK},
+			{"", "192.0.2.1:1234", "zweite@example.org", "", 10 * time.Second, http.StatusTooManyRequests},
+		}},
+		{"new email for every submission", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "", 0, http.StatusOK},
+			{session, "192.0.2.1:1234", "zweite@example.org", "", 10 * time.Second, http.StatusTooManyRequests},
+		}},
+		{"spoofed trusted email", []submission{
+			{session, "192.0.2.1:1234", "vertraut@example.org", "", 0, http.StatusOK},
+			{session, "192.0.2.1:1234", "vertraut@example.org", "", 10 * time.Second, http.StatusTooManyRequests},
+		}},
+		{"wrong token", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "geraten", 0, http.StatusOK},
+			{session, "192.0.2.1:1234", "erste@example.org", "geraten", 10 * time.Second, http.StatusTooManyRequests},
+		}},
+		{"interval has passed", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "", 0, http.StatusOK},
+			{session, "192.0.2.1:1234", "erste@example.org", "", time.Minute, http.StatusOK},
+		}},
+		{"trusted token", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "vertraut", 0, http.StatusOK},
+			{session, "192.0.2.1:1234", "erste@example.org", "vertraut", time.Second, http.StatusOK},
+		}},
+		{"admin token", []submission{
+			{session, "192.0.2.1:1234", "erste@example.org", "geheim", 0, http.StatusOK},
+			{session, "192.0.2.1:1234", "erste@example.org", "geheim", time.Second, http.StatusOK},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMemoryCorpus(t)
+			useOptions(t, Options{
+				AdminToken:        "geheim",
+				MinSubmitInterval: time.Minute,
+				TrustedTokens:     []string{"vertraut"},
+			})
+			clock := useThrottleClock(t)
+
+			start := clock.now
+			for idx, sub := range tc.submissions {
+				clock.now = start.Add(sub.after)
+				task := lib.TaskDefinition{
+					Document: testDocument("wochenblatt_1871", fmt.Sprintf("Zeile %d", idx)),
+					Author:   "Test",
+					Email:    sub.email,
+				}
+				body, _ := json.Marshal(task)
+				req := httptest.NewRequest("PUT", "/api/documents", bytes.NewReader(body))
+				req.RemoteAddr = sub.addr
+				if sub.session != "" {
+					req.AddCookie(&http.Cookie{Name: sessionCookie, Value: sub.session})
+				}
+				if sub.token != "" {
+					req.Header.Set("Authorization", "Bearer "+sub.token)
+				}
+				rec := httptest.NewRecorder()
+				SubmitDocument(rec, req, nil)
+				if rec.Code != sub.want {
+					t.Fatalf("submission %d got status %d, want %d: %s", idx, rec.Code, sub.want, rec.Body)
+				}
+				if sub.want != http.StatusTooManyRequests {
+					continue
+				}
+				// The interval ends 50 seconds after the second submission
+				if got := rec.Header().Get("Retry-After"); got != "50" {
+					t.Errorf("submission %d has Retry-After %q, want 50", idx, got)
+				}
+			}
+		})
+	}
+}
+
+// blockingStore holds saved volumes back until it is released
+type blockingStore struct {
+	*lib.MemoryStore
+	release chan struct{}
+}
+
+func (s blockingStore) SaveVolume(doc lib.Document, reviewFlags map[string][]string, author string, email string, comment string) (*lib.Document, error) {
+	<-s.release
+	return s.MemoryStore.SaveVolume(doc, reviewFlags, author, email, comment)
+}
+
+// useThrottleClock replaces the throttle with an empty one on a stopped
+// clock for the duration of the test
+func useThrottleClock(t *testing.T) *stoppedClock {
+	t.Helper()
+	clock := &stoppedClock{now: time.Date(1871, 1, 18, 12, 0, 0, 0, time.UTC)}
+	previous := throttle
+	throttle = &submitThrottle{clock: clock, last: make(map[string]time.Time)}
+	t.Cleanup(func() { throttle = previous })
+	return clock
+}
+
+// submitAs sends a submission of a single line by a contributor
+func submitAs(email string, text string) *httptest.ResponseRecorder {
+	task := lib.TaskDefinition{
+		Document: testDocument("wochenblatt_1872", text),
+		Author:   "Test",
+		Email:    email,
+	}
+	body, _ := json.Marshal(task)
+	rec := httptest.NewRecorder()
+	SubmitDocument(rec, httptest.NewRequest("PUT", "/api/documents", bytes.NewReader(body)), nil)
+	return rec
+}
+
+func TestConcurrentSubmissionsAreThrottled(t *testing.T) {
+	memory, _ := useMemoryCorpus(t)
+	store := blockingStore{memory, make(chan struct{})}
+	corpus = store
+	useOptions(t, Options{MinSubmitInterval: time.Minute})
+	useThrottleClock(t)
+
+	// All submissions arrive while the first one is still being committed
+	const numSubmissions = 4
+	codes := make(chan int, numSubmissions)
+	for i := 0; i < numSubmissions; i++ {
+		go func(i int) {
+			codes <- submitAs("erste@example.org", fmt.Sprintf("Zeile %d", i)).Code
+		}(i)
+	}
+	counts := make(map[int]int)
+	answered := 0
+	timeout := time.After(5 * time.Second)
+waiting:
+	for answered < numSubmissions-1 {
+		select {
+		case code := <-codes:
+			counts[code]++
+			answered++
+		case <-timeout:
+			t.Errorf("only %d submissions were answered while one was committed", answered)
+			break waiting
+		}
+	}
+	close(store.release)
+	for ; answered < numSubmissions; answered++ {
+		counts[<-codes]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusTooManyRequests] != numSubmissions-1 {
+		t.Errorf("expected one submission to be committed and the others throttled, got %v", counts)
+	}
+}
+
+func TestRejectedSubmissionIsNotThrottled(t *testing.T) {
+	useMemoryCorpus(t)
+	useOptions(t, Options{MinSubmitInterval: time.Minute})
+	clock := useThrottleClock(t)
+
+	if rec := submitAs("erste@example.org", "Zeile mit\x07Steuerzeichen"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid submission got status %d: %s", rec.Code, rec.Body)
+	}
+	clock.now = clock.now.Add(10 * time.Second)
+	if rec := submitAs("erste@example.org", "Berichtigte Zeile"); rec.Code != http.StatusOK {
+		t.Fatalf("corrected submission got status %d: %s", rec.Code, rec.Body)
+	}
+	clock.now = clock.now.Add(10 * time.Second)
+	if rec := submitAs("erste@example.org", "Noch eine Zeile"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("submission after a committed one got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"archiscribe/lib"
+)
+
+// TLSOptions configures serving HTTPS without a reverse proxy, either with
+// a certificate from files or with certificates that are obtained from
+// Let's Encrypt automatically
+type TLSOptions struct {
+	// Paths of the PEM-encoded certificate chain and private key
+	CertFile string
+	KeyFile  string
+	// Domains to obtain certificates for, automatic certificates are
+	// disabled if empty
+	AutocertDomains []string
+	// Directory the obtained certificates are kept in, autocert below the
+	// cache directory if empty
+	AutocertCacheDir string
+	// Contact address for the certificate authority, optional
+	AutocertEmail string
+	// Port of a plain HTTP listener that redirects every request to HTTPS
+	// and answers the HTTP challenges for automatic certificates, no
+	// redirect if zero
+	RedirectPort int
+}
+
+// Enabled checks if the server is served over HTTPS
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || len(o.AutocertDomains) > 0
+}
+
+// Validate checks that certificate and key are set together and are not
+// mixed with automatic certificates
+func (o TLSOptions) Validate() error {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return fmt.Errorf("TLS needs both a certificate and a key")
+	}
+	if o.CertFile != "" && len(o.AutocertDomains) > 0 {
+		return fmt.Errorf("TLS certificates from files and automatic certificates are exclusive")
+	}
+	if o.RedirectPort > 0 && !o.Enabled() {
+		return fmt.Errorf("redirecting to HTTPS needs TLS to be enabled")
+	}
+	return nil
+}
+
+// certManager returns the manager for automatic certificates, nil if they
+// are disabled
+func (o TLSOptions) certManager() *autocert.Manager {
+	if len(o.AutocertDomains) == 0 {
+		return nil
+	}
+	cacheDir := o.AutocertCacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(lib.CacheDir, "autocert")
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(o.AutocertDomains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      o.AutocertEmail,
+	}
+}
+
+// redirectToHTTPS permanently redirects requests to the same URL on the
+// HTTPS port. The port is left out of the URL if it is the default one.
+func redirectToHTTPS(tlsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(tlsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
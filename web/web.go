@@ -1,16 +1,28 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image/png"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gobuffalo/packr"
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 
 	"archiscribe/lib"
@@ -19,26 +31,359 @@ import (
 var taskChan = make(chan lib.TaskDefinition)
 var store *lib.DocumentStore
 
+// corpus stores submitted documents, the git repository of store by default
+var corpus lib.CorpusStore
+var submissionLog *lib.SubmissionLog
+var options Options
+
+var errSubmittingTooFast = errors.New("submitting too fast, please try again later")
+var errShuttingDown = errors.New("server is shutting down, please try again later")
+
+// shutdownChan is closed once the server starts shutting down
+var shutdownChan = make(chan struct{})
+
+// isShuttingDown checks if the server stopped accepting new work
+func isShuttingDown() bool {
+	select {
+	case <-shutdownChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Options configures the web application
+type Options struct {
+	// Token that has to be sent as a bearer token to access the admin API,
+	// the admin API is disabled if this is empty
+	AdminToken string
+	// Maximum size of a submitted document in bytes
+	MaxSubmitBytes int64
+	// Maximum time for reading a submitted document, no limit if zero
+	SubmitTimeout time.Duration
+	// Group lines with identical OCR text so they are only transcribed once
+	MergeDuplicates bool
+	// How lines are picked from a volume, SelectRandom or SelectDifficult
+	LineSelection string
+	// Minimum time between two submissions of a contributor, no limit if
+	// zero
+	MinSubmitInterval time.Duration
+	// Bearer tokens that exempt submissions from the submission interval,
+	// in addition to the admin token
+	TrustedTokens []string
+	// Maximum number of lines that are stitched into a single image for
+	// context, stitching is disabled if zero
+	MaxStitchedLines int
+	// Lines with at least this OCR confidence are not given to humans, but
+	// committed with their OCR text as machine-verified. Disabled if zero.
+	AutoAcceptConfidence float64
+	// Number of transcribed lines after which a year is no longer served
+	// while other years are below it, no quota if zero. The lines are
+	// counted again every coverageInterval.
+	YearQuota int
+	// Maximum number of volumes kept in the volume cache across all years,
+	// not counting the ones in use, no limit if zero
+	VolumeCacheMaxEntries int
+	// Interval for compacting the volume cache, never compacted if zero
+	CompactInterval time.Duration
+	// Interval for querying Archive.org for new identifiers, never
+	// refreshed if zero
+	RefreshInterval time.Duration
+	// Record an opaque session identifier with every submitted line
+	RecordSessions bool
+	// Maximum time for finishing in-flight requests and commits when shutting
+	// down, unfinished submissions are replayed on the next start
+	ShutdownTimeout time.Duration
+	// How long the outcome of an asynchronous submission can be polled for
+	// after it finished, DefaultSubmissionStatusTTL if zero
+	SubmissionStatusTTL time.Duration
+	// Time to wait before committing a failed submission again, doubled
+	// after every failed attempt, DefaultRetryInterval if zero
+	RetryInterval time.Duration
+	// Origins that may call the API from other origins, "*" for any
+	// origin, same-origin only if empty
+	CORSOrigins []string
+	// Methods and headers that cross-origin requests may use,
+	// DefaultCORSMethods and DefaultCORSHeaders if empty
+	CORSMethods []string
+	CORSHeaders []string
+	// Level for compressing responses from gzip.HuffmanOnly to
+	// gzip.BestCompression, responses are not compressed if zero
+	CompressLevel int
+	// Minimum size of a compressed response in bytes,
+	// DefaultCompressMinSize if zero
+	CompressMinSize int
+	// Log every request with its status, response size and latency
+	AccessLog bool
+	// Log the start of request bodies with every request, for debugging
+	LogRequestBodies bool
+	// Serve HTTPS instead of plain HTTP, see TLSOptions
+	TLS TLSOptions
+	// Number of volumes prefetched at the same time across all years,
+	// runtime.NumCPU() if zero
+	PrefetchWorkers int
+	// Write submissions to a temporary directory instead of committing them
+	DryRun bool
+	// Serve Prometheus metrics at /metrics
+	Metrics bool
+	// Number of volumes with cached lines kept ready for every requested
+	// year, nothing is prefetched if zero, the server defaults to
+	// DefaultPrefetchDepth
+	PrefetchDepth int
+	// Script of the volumes that lines are picked from, lib.ScriptFraktur,
+	// lib.ScriptAntiqua or lib.ScriptAny, Fraktur if empty
+	ScriptFilter string
+}
+
+// Modes for picking the lines of a task
+const (
+	SelectRandom    = "random"
+	SelectDifficult = "difficult"
+)
+
+// Default limits for submissions, generous enough for large volumes
+const (
+	DefaultMaxSubmitBytes   = 32 << 20
+	DefaultSubmitTimeout    = 60 * time.Second
+	DefaultMaxStitchedLines = 5
+	DefaultCompactInterval  = 6 * time.Hour
+	DefaultShutdownTimeout  = 30 * time.Second
+
+	DefaultSubmissionStatusTTL = time.Hour
+	DefaultRetryInterval       = 30 * time.Second
+)
+
+// DefaultPrefetchDepth is the number of volumes kept ready for every year,
+// enough for a handful of transcribers working on the same year
+const DefaultPrefetchDepth = 3
+
 // APIError is for errors that are returned via the API
 type APIError struct {
-	Err  error `json:"error"`
-	Code int   `json:"code"`
+	Err   error                `json:"error"`
+	Code  int                  `json:"code"`
+	Lines []lib.LineValidation `json:"lines,omitempty"`
+}
+
+// MarshalJSON encodes the error with its message, since error values
+// don't serialize to anything useful by themselves
+func (e APIError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Err   string               `json:"error"`
+		Code  int                  `json:"code"`
+		Lines []lib.LineValidation `json:"lines,omitempty"`
+	}{e.Err.Error(), e.Code, e.Lines})
 }
 
 func writeAPIError(err error, code int, w http.ResponseWriter) {
 	apiErr := APIError{
 		Err:  err,
 		Code: code}
+	if valErr, ok := err.(*lib.ValidationError); ok {
+		apiErr.Lines = valErr.Lines
+	}
 	out, _ := json.MarshalIndent(apiErr, "", "  ")
-	w.WriteHeader(code)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write(out)
 }
 
-// SubmitDocument handles user-submitted documents
-func SubmitDocument(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// decodeErrorStatus determines the status code for a body that could not be read
+func decodeErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	var netErr net.Error
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusRequestTimeout
+	}
+	return http.StatusBadRequest
+}
+
+// decodeTask reads a submitted task from the request body, limited in size
+// and time by the options
+func decodeTask(w http.ResponseWriter, r *http.Request) (lib.TaskDefinition, error) {
 	var task lib.TaskDefinition
+	if options.MaxSubmitBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, options.MaxSubmitBytes)
+	}
+	ctrl := http.NewResponseController(w)
+	if options.SubmitTimeout > 0 {
+		if err := ctrl.SetReadDeadline(time.Now().Add(options.SubmitTimeout)); err != nil {
+			log.Warn().Err(err).Msg("Could not limit the time for reading the submission")
+		} else {
+			defer func() {
+				if err := ctrl.SetReadDeadline(time.Time{}); err != nil {
+					log.Warn().Err(err).Msg("Could not reset the read deadline")
+				}
+			}()
+		}
+	}
 	err := json.NewDecoder(r.Body).Decode(&task)
+	return task, err
+}
+
+// GetVolumeInfo returns the metadata of a volume on Archive.org
+func GetVolumeInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ident := ps.ByName("ident")
+	info, err := lib.GetVolumeInfo(ident)
+	if err == lib.ErrVolumeNotFound {
+		writeAPIError(err, http.StatusNotFound, w)
+		return
+	} else if err != nil {
+		log.Error().Err(err).Str("identifier", ident).Msg("Could not fetch volume info")
+		writeAPIError(err, http.StatusBadGateway, w)
+		return
+	}
+	writeJSON(w, info)
+}
+
+// GetContributorHistory lists the volumes a contributor worked on
+func GetContributorHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handle := ps.ByName("handle")
+	history, err := store.ContributorHistory(handle)
+	if err != nil {
+		log.Error().Err(err).Str("handle", handle).Msg("Could not read contributor history")
+		writeAPIError(err, http.StatusInternalServerError, w)
+		return
+	}
+	writeJSON(w, history)
+}
+
+// Line images may be cached by browsers and proxies for a year
+const lineImageCacheControl = "public, max-age=31536000, immutable"
+
+// GetLineImage serves a cached line image, or its thumbnail if the thumb
+// query parameter is set. Images carry a strong ETag and can be cached
+// forever, since the image of a line never changes.
+func GetLineImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if strings.ContainsAny(id, "/\\") || strings.HasPrefix(id, ".") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var imgPath string
+	thumb := r.URL.Query().Get("thumb") == "1"
+	if thumb {
+		thumbPath, err := lib.LineCache.GetThumbnailPath(id)
+		if err != nil {
+			log.Error().Err(err).Str("lineId", id).Msg("Could not create thumbnail")
+			writeAPIError(err, http.StatusInternalServerError, w)
+			return
+		}
+		imgPath = thumbPath
+	} else {
+		imgPath = lib.LineCache.GetLinePath(id)
+	}
+	if imgPath == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// Images cached before content addressing are tagged with the line
+	// identifier instead of the hash
+	etag := lib.LineCache.ContentHash(id)
+	if etag == "" {
+		etag = id
+	}
+	if thumb {
+		etag += "-thumb"
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Cache-Control", lineImageCacheControl)
+	// Answers If-None-Match with 304 Not Modified
+	http.ServeFile(w, r, imgPath)
+}
+
+// GetStitchedLines serves the images of a run of consecutive lines on the
+// same page as a single image, starting with the given line. The number of
+// lines is set with the count query parameter. The identifiers of the
+// included lines and their vertical offsets are sent in the X-Stitched-Lines
+// and X-Stitched-Offsets headers.
+func GetStitchedLines(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	sepIdx := strings.LastIndex(id, "_")
+	if options.MaxStitchedLines <= 0 || sepIdx <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	ident, lineID := id[:sepIdx], id[sepIdx+1:]
+	count := options.MaxStitchedLines
+	if countParam := r.URL.Query().Get("count"); countParam != "" {
+		parsed, err := strconv.Atoi(countParam)
+		if err != nil || parsed < 1 {
+			writeAPIError(fmt.Errorf("invalid count '%s'", countParam), http.StatusBadRequest, w)
+			return
+		}
+		if parsed < count {
+			count = parsed
+		}
+	}
+	lines, err := lib.FetchAllLinesContext(r.Context(), ident)
+	if err != nil {
+		writeAPIError(err, http.StatusBadGateway, w)
+		return
+	}
+	run := lib.ConsecutiveLines(lines, lineID, count)
+	if len(run) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	img, offsets, err := lib.LineCache.Stitch(ident, run)
+	if err != nil {
+		log.Error().Err(err).Str("lineId", id).Msg("Could not stitch lines")
+		writeAPIError(err, http.StatusInternalServerError, w)
+		return
+	}
+	lineIDs := make([]string, len(run))
+	offsetStrs := make([]string, len(run))
+	for idx, line := range run {
+		lineIDs[idx] = line.Identifier
+		offsetStrs[idx] = strconv.Itoa(offsets[idx])
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Stitched-Lines", strings.Join(lineIDs, ","))
+	w.Header().Set("X-Stitched-Offsets", strings.Join(offsetStrs, ","))
+	if err := png.Encode(w, img); err != nil {
+		log.Error().Err(err).Str("lineId", id).Msg("Could not send stitched lines")
+	}
+}
+
+// ValidationResult contains the validation results for a submission that
+// was not stored
+type ValidationResult struct {
+	// The submission would be accepted, i.e. no line failed hard validation
+	Valid bool                 `json:"valid"`
+	Lines []lib.LineValidation `json:"lines"`
+}
+
+// ValidateDocument runs the same validation as for submitting a document
+// without storing anything
+func ValidateDocument(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	task, err := decodeTask(w, r)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("documentId", task.Document.Identifier).
+			Msg("Could not decode document for validation")
+		writeAPIError(err, decodeErrorStatus(err), w)
+		return
+	}
+	doc := task.Document
+	doc.Lines = lib.ExpandDuplicateLines(doc.Lines)
+	result := ValidationResult{
+		Valid: true,
+		Lines: lib.Validation.ValidateDocument(doc),
+	}
+	for _, line := range result.Lines {
+		if line.Level == lib.ValidationHard {
+			result.Valid = false
+		}
+	}
+	writeJSON(w, result)
+}
+
+// SubmitDocument handles user-submitted documents
+func SubmitDocument(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	task, err := decodeTask(w, r)
 	task.ResultChan = make(chan lib.SubmitResult)
 	defer close(task.ResultChan)
 	if err != nil {
@@ -46,46 +391,202 @@ func SubmitDocument(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 			Err(err).
 			Str("documentId", task.Document.Identifier).
 			Msg("Could not decode submitted document")
-		writeAPIError(err, 500, w)
+		writeAPIError(err, decodeErrorStatus(err), w)
 	} else {
 		log.Info().
 			Bool("isUpdate", r.Method == "POST").
 			Int("numTranscriptions", len(task.Document.Lines)).
 			Str("documentId", task.Document.Identifier).
 			Msg("Received transcription")
-		stored, err := store.Save(task.Document, task.Author, task.Email, task.Comment)
+		if isShuttingDown() {
+			// The submission would be cut off by the shutdown, the client
+			// has to resubmit to the restarted server
+			w.Header().Set("Retry-After", "30")
+			writeAPIError(errShuttingDown, http.StatusServiceUnavailable, w)
+			countSubmission(task.Document, "unavailable")
+			return
+		}
+		contributor := contributorKey(r)
+		throttled := options.MinSubmitInterval > 0 && !isTrusted(r)
+		if throttled {
+			if wait := throttle.wait(contributor, options.MinSubmitInterval); wait > 0 {
+				log.Warn().
+					Str("documentId", task.Document.Identifier).
+					Dur("wait", wait).
+					Msg("Throttled submission")
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				writeAPIError(errSubmittingTooFast, http.StatusTooManyRequests, w)
+				countSubmission(task.Document, "throttled")
+				return
+			}
+		}
+		if accepted := takeAccepted(task.Document.Identifier, task.Document.Lines); len(accepted) > 0 {
+			task.Document.Lines = append(task.Document.Lines, accepted...)
+		}
+		if options.RecordSessions {
+			setSession(&task.Document, sessionID(w, r))
+		} else {
+			setSession(&task.Document, "")
+		}
+		// Dry-run submissions must not be replayed into the repository
+		var walID int64
+		if !options.DryRun {
+			walID, err = submissionLog.Append(task)
+		}
 		if err != nil {
 			log.Error().
 				Err(err).
 				Str("documentId", task.Document.Identifier).
-				Msg("Error storing document")
+				Msg("Could not log submission")
+			if throttled {
+				throttle.release(contributor)
+			}
 			writeAPIError(err, 500, w)
 			return
 		}
+		if r.URL.Query().Get("async") == "1" {
+			id, err := asyncSubmissions.add(&task)
+			if err == nil {
+				commitAsync(w, id, task, walID, throttled, contributor)
+				return
+			}
+			log.Warn().
+				Err(err).
+				Str("documentId", task.Document.Identifier).
+				Msg("Could not track asynchronous submission, committing it right away")
+		}
+		stored, code, err := commitSubmission(task, walID, throttled, contributor)
+		if err != nil && isRetriable(code) {
+			// The submission is committed again later, the client can poll
+			// for it instead of resubmitting
+			id, trackErr := asyncSubmissions.add(&task)
+			if trackErr == nil {
+				asyncSubmissions.retrying(id, err, 1)
+				retries.schedule(walID, task, id)
+				writeAccepted(w, id)
+				return
+			}
+			// The client is told that the submission failed and will
+			// resubmit it, so it must not be committed by a retry as well
+			if err := submissionLog.MarkDone(walID); err != nil {
+				log.Error().
+					Err(err).
+					Str("documentId", task.Document.Identifier).
+					Msg("Could not mark submission as done")
+			}
+			if throttled {
+				throttle.release(contributor)
+			}
+		}
+		if err != nil {
+			if err == lib.ErrLockTimeout {
+				// Another process is committing to the repository
+				w.Header().Set("Retry-After", "10")
+			}
+			writeAPIError(err, code, w)
+			return
+		}
 		js, _ := json.MarshalIndent(stored, "", "  ")
-		w.WriteHeader(http.StatusOK)
 		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 		w.Write(js)
 	}
 }
 
+// commitSubmission stores a submission that was written to the submission
+// log and marks it as done. Returns the stored document, or the error with
+// the HTTP status that it is reported with. The throttle reservation of a
+// submission that is not retried is released if it fails.
+func commitSubmission(task lib.TaskDefinition, walID int64, throttled bool, contributor string) (*lib.Document, int, error) {
+	stored, code, err := storeSubmission(task, walID)
+	if err != nil && throttled && !isRetriable(code) {
+		throttle.release(contributor)
+	}
+	return stored, code, err
+}
+
+// storeSubmission submits a logged task to the corpus, see commitSubmission
+func storeSubmission(task lib.TaskDefinition, walID int64) (*lib.Document, int, error) {
+	stored, err := lib.Submit(corpus, task.Document, task.Author, task.Email, task.Comment)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("documentId", task.Document.Identifier).
+			Msg("Error storing document")
+		if _, ok := err.(*lib.ValidationError); ok {
+			// Will never succeed, no point in replaying it
+			if !options.DryRun {
+				submissionLog.MarkDone(walID)
+			}
+			countSubmission(task.Document, "invalid")
+			return nil, http.StatusUnprocessableEntity, err
+		} else if err == lib.ErrLockTimeout {
+			countSubmission(task.Document, "unavailable")
+			return nil, http.StatusServiceUnavailable, err
+		}
+		countSubmission(task.Document, "error")
+		return nil, 500, err
+	}
+	if !options.DryRun {
+		if err := submissionLog.MarkDone(walID); err != nil {
+			log.Error().
+				Err(err).
+				Str("documentId", task.Document.Identifier).
+				Msg("Could not mark submission as done")
+		}
+	}
+	countSubmission(task.Document, "committed")
+	lib.StripSessions(stored)
+	return stored, http.StatusOK, nil
+}
+
+// requestedYear returns the year to serve lines from for a request, after
+// applying the quota. Writes an error and returns false for years outside of
+// the served range.
+func requestedYear(resp http.ResponseWriter, ps httprouter.Params) (int, bool) {
+	year, _ := strconv.Atoi(ps.ByName("year"))
+	if err := lib.CheckYear(year); err != nil {
+		log.Warn().Int("year", year).Msg("Lines requested for a year outside of the served range")
+		writeAPIError(err, http.StatusBadRequest, resp)
+		return 0, false
+	}
+	if options.YearQuota > 0 {
+		year = chooseYear(year)
+	}
+	noteRequestedYear(year)
+	return year, true
+}
+
 // ProduceLines begins generating OCR lines for a given identifier
 func ProduceLines(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
-	year, _ := strconv.Atoi(ps.ByName("year"))
+	year, ok := requestedYear(resp, ps)
+	if !ok {
+		return
+	}
 	taskSize, _ := strconv.Atoi(req.URL.Query().Get("taskSize"))
-	lineProd, err := newLineProducer(resp, taskSize, year)
+	sink, err := newSSESink(resp)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create line producer")
 		resp.WriteHeader(http.StatusInternalServerError)
-	} else {
-		lineProd.produceLines()
+	} else if err := newLineProducer(req.Context(), sink, taskSize, year).produceLines(); err == errNoCachedVolumes {
+		log.Warn().Int("year", year).Msg("No cached volumes to serve offline")
+		writeAPIError(err, http.StatusServiceUnavailable, resp)
+	} else if err != nil {
+		log.Error().Err(err).Int("year", year).Msg("Failed to produce lines")
+		writeAPIError(err, http.StatusNotFound, resp)
 	}
 }
 
 // ListDocuments returns a list of all documents
 func ListDocuments(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
-	documents := store.List()
+	documents, err := corpus.ListVolumes()
 	log.Info().Msg("Loading all documents from store")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list documents")
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	raw, err := json.Marshal(documents)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to serialize documents to JSON")
@@ -98,19 +599,52 @@ func ListDocuments(resp http.ResponseWriter, req *http.Request, ps httprouter.Pa
 
 // GetDocument returns a single document
 func GetDocument(resp http.ResponseWriter, req *http.Request, ps httprouter.Params) {
-	doc := store.Details(ps.ByName("ident"))
 	log.Info().Str("identifier", ps.ByName("ident")).Msg("Loading document from store")
+	doc, err := corpus.LoadVolume(ps.ByName("ident"))
+	if err == lib.ErrDocumentNotFound {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Error().Err(err).Str("identifier", ps.ByName("ident")).Msg("Failed to load document")
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	lib.StripSessions(doc)
 	raw, err := json.Marshal(doc)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
-	} else if doc.Identifier == "" {
-		resp.WriteHeader(http.StatusNotFound)
 	} else {
 		resp.Header().Add("Content-Type", "application/json")
 		resp.Write(raw)
 	}
 }
 
+// replaySubmissions commits all submissions from the write-ahead log that
+// were accepted, but not committed before the last shutdown
+func replaySubmissions() {
+	// Replayed submissions are committed one after another, waiting for a
+	// batch window for each would hold up the start
+	batching := lib.CommitBatching
+	lib.CommitBatching = lib.BatchOptions{}
+	defer func() { lib.CommitBatching = batching }()
+	for _, pending := range submissionLog.Pending() {
+		task := pending.Task
+		logger := log.With().Str("documentId", task.Document.Identifier).Logger()
+		logger.Info().Msg("Replaying submission from write-ahead log")
+		_, err := lib.Submit(corpus, task.Document, task.Author, task.Email, task.Comment)
+		if _, ok := err.(*lib.ValidationError); err != nil && !ok {
+			logger.Error().Err(err).Msg("Failed to replay submission")
+			retries.schedule(pending.ID, task, "")
+			continue
+		} else if err != nil {
+			logger.Error().Err(err).Msg("Dropping invalid submission")
+		}
+		if err := submissionLog.MarkDone(pending.ID); err != nil {
+			logger.Error().Err(err).Msg("Could not mark submission as done")
+		}
+	}
+}
+
 func addPrefix(prefix string, h http.Handler) http.Handler {
 	if prefix == "" {
 		return h
@@ -126,30 +660,42 @@ func addPrefix(prefix string, h http.Handler) http.Handler {
 	})
 }
 
-// Serve the web application
-func Serve(port int, repoPath string) {
-	s, err := lib.NewDocumentStore(repoPath)
-	if err != nil {
-		panic(err)
-	}
-	store = s
-	box := packr.NewBox("../client/dist")
-
+// newHandler routes the API and the client files from the box, wrapped in
+// the middleware that every request passes through
+func newHandler(box packr.Box) http.Handler {
 	router := httprouter.New()
 	router.GET("/", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		w.Write(box.Bytes("index.html"))
 	})
+	router.GET("/healthz", Healthz)
+	router.GET("/readyz", Readyz)
+	if options.Metrics {
+		router.Handler("GET", "/metrics", promhttp.Handler())
+	}
 	router.GET("/api/lines/:year", ProduceLines)
+	router.GET("/ws/progress/:year", ProgressSocket)
 	router.GET("/api/documents", ListDocuments)
 	router.POST("/api/documents", SubmitDocument)
 	router.GET("/api/documents/:ident", GetDocument)
 	router.PUT("/api/documents/:ident", SubmitDocument)
+	router.GET("/api/submissions/:taskId", GetSubmission)
+	router.POST("/api/validate", ValidateDocument)
+	router.GET("/api/volumes/:ident/info", GetVolumeInfo)
+	router.GET("/api/contributors/:handle", GetContributorHistory)
+	router.GET("/api/images/:id", GetLineImage)
+	router.GET("/api/images/:id/stitched", GetStitchedLines)
+	router.GET("/api/admin/debug/state", requireAdmin(DebugState))
+	router.GET("/api/admin/review", requireAdmin(ListFlagged))
+	router.DELETE("/api/admin/review/:ident/:line", requireAdmin(ClearFlags))
+	router.POST("/api/admin/review/:ident/:line/reject", requireAdmin(RejectLine))
+	router.GET("/api/admin/sessions/:session", requireAdmin(ListSessionLines))
+	router.POST("/api/admin/sessions/:session/retract", requireAdmin(RetractSession))
 
 	// NOTE: This is a bit clumsy, since Box.Open does not return an error
 	// that is recognized by os.IsNotExit, which is why we have to pass
 	// our own logic to return a 404 error for non-existing files.
 	fileServer := http.FileServer(box)
-	router.NotFound = func(w http.ResponseWriter, r *http.Request) {
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		upath := r.URL.Path
 		if !strings.HasPrefix(upath, "/") {
 			upath = "/" + upath
@@ -159,9 +705,166 @@ func Serve(port int, repoPath string) {
 		} else {
 			w.WriteHeader(http.StatusNotFound)
 		}
+	})
+	return withRequestID(withAccessLog(withRecovery(withCORS(withCompression(router)))))
+}
+
+// Serve the web application
+func Serve(port int, repoPath string, opts Options) {
+	s, err := lib.NewDocumentStore(repoPath)
+	if err != nil {
+		panic(err)
+	}
+	if lib.LFSLineImages {
+		if err := s.SetupLFS(); err != nil {
+			panic(err)
+		}
 	}
-	log.Info().Int("port", port).Msg("Serving application")
-	if err = http.ListenAndServe(fmt.Sprintf(":%d", port), router); err != nil {
-		log.Fatal().Err(err).Msg("Failed to serve application")
+	store = s
+	corpus = s
+	options = opts
+	if options.DryRun {
+		dryRun, err := lib.NewDryRunStore(s)
+		if err != nil {
+			panic(err)
+		}
+		corpus = dryRun
+		log.Warn().Str("dir", dryRun.Dir()).Msg("Dry run, submissions are not committed")
+	}
+	wal, err := lib.OpenSubmissionLog(filepath.Join(lib.CacheDir, "submissions.wal"))
+	if err != nil {
+		panic(err)
+	}
+	submissionLog = wal
+	if !options.DryRun {
+		// Pending submissions are kept for the next regular start
+		replaySubmissions()
+		retries.stopped = make(chan struct{})
+		go retryWorker()
+	}
+	if options.CompactInterval > 0 {
+		go compactCacheWorker()
+	}
+	if options.YearQuota > 0 {
+		go coverageWorker()
+	}
+	if lib.Blocked.Path() != "" {
+		go reloadBlocklistOnHangup()
+	}
+	if options.RefreshInterval > 0 && !lib.Offline {
+		go refreshIdentifiersWorker()
+	}
+	if options.PrefetchDepth > 0 {
+		if options.PrefetchWorkers == 0 {
+			options.PrefetchWorkers = runtime.NumCPU()
+		}
+		prefetcher.setWorkers(options.PrefetchWorkers)
+		log.Info().
+			Int("prefetchDepth", options.PrefetchDepth).
+			Int("prefetchWorkers", options.PrefetchWorkers).
+			Msg("Prefetching volumes")
+	}
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: newHandler(packr.NewBox("../client/dist"))}
+	servers := []*http.Server{server}
+	manager := options.TLS.certManager()
+	if manager != nil {
+		server.TLSConfig = manager.TLSConfig()
+	}
+	if options.TLS.RedirectPort > 0 {
+		handler := redirectToHTTPS(port)
+		if manager != nil {
+			// Answers the HTTP challenges and redirects everything else
+			handler = manager.HTTPHandler(handler)
+		}
+		redirect := &http.Server{Addr: fmt.Sprintf(":%d", options.TLS.RedirectPort), Handler: handler}
+		servers = append(servers, redirect)
+		go func() {
+			log.Info().Int("port", options.TLS.RedirectPort).Msg("Redirecting HTTP to HTTPS")
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("Failed to serve redirect to HTTPS")
+			}
+		}()
+	}
+	go func() {
+		log.Info().Int("port", port).Bool("tls", options.TLS.Enabled()).Msg("Serving application")
+		var err error
+		if options.TLS.Enabled() {
+			// Without files, the certificates come from the TLS config
+			err = server.ListenAndServeTLS(options.TLS.CertFile, options.TLS.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("Failed to serve application")
+		}
+	}()
+	awaitShutdown(servers...)
+}
+
+// awaitShutdown blocks until the process receives SIGINT or SIGTERM and then
+// shuts the servers down. New submissions are rejected, submissions that are
+// being committed get until the shutdown timeout to finish. Submissions cut
+// off by the timeout remain in the write-ahead log and are replayed on the
+// next start. A pending batch of submissions is committed right away.
+func awaitShutdown(servers ...*http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Info().Str("signal", sig.String()).Msg("Shutting down")
+	shutdown(servers...)
+}
+
+// shutdown stops accepting submissions, finishes the requests and commits
+// that are running until the shutdown timeout and closes the submission log
+func shutdown(servers ...*http.Server) {
+	close(shutdownChan)
+	if lib.CommitBatching.Enabled() {
+		// Submissions waiting for their batch would otherwise hold up the
+		// shutdown until the batch window ends
+		store.FlushBatch()
+	}
+	timeout := options.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Could not finish all requests before shutting down")
+		}
+	}
+	// Asynchronous submissions outlive their requests, they are replayed
+	// from the submission log if they are cut off
+	committed := make(chan struct{})
+	go func() {
+		asyncSubmissions.running.Wait()
+		close(committed)
+	}()
+	if !waitDone(ctx, committed) {
+		log.Warn().Msg("Could not finish all asynchronous submissions before shutting down")
+	}
+	if retries.stopped != nil && !waitDone(ctx, retries.stopped) {
+		log.Warn().Msg("Could not finish retrying submissions before shutting down")
+	}
+	if pending := submissionLog.NumPending(); pending > 0 {
+		log.Warn().
+			Int("numPending", pending).
+			Msg("Submissions are left in the submission log, they are replayed on the next start")
+	}
+	if err := submissionLog.Close(); err != nil {
+		log.Error().Err(err).Msg("Could not close submission log")
+	}
+	log.Info().Msg("Shut down")
+}
+
+// waitDone waits until done is closed or the context ends. Returns false if
+// the context ended first.
+func waitDone(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
@@ -0,0 +1,443 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/packr"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"archiscribe/lib"
+)
+
+func TestReplaySubmissions(t *testing.T) {
+	tests := []struct {
+		name string
+		docs []lib.Document
+		// Identifiers expected in the corpus after the replay
+		want []string
+	}{
+		{"empty log", nil, nil},
+		{
+			"pending submissions",
+			[]lib.Document{
+				testDocument("anzeiger_1870", "Erste Zeile", "Zweite Zeile"),
+				testDocument("anzeiger_1871", "Dritte Zeile"),
+			},
+			[]string{"anzeiger_1870", "anzeiger_1871"},
+		},
+		{
+			"invalid submission is dropped",
+			[]lib.Document{
+				testDocument("anzeiger_1872", "Vierte\x07Zeile"),
+				testDocument("anzeiger_1873", "Fünfte Zeile"),
+			},
+			[]string{"anzeiger_1873"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			memory, walPath := useMemoryCorpus(t)
+			// Log the submissions without marking them as done, like a crash
+			// before their commit would
+			for _, doc := range tc.docs {
+				if _, err := submissionLog.Append(lib.TaskDefinition{
+					Document: doc, Author: "Test", Email: "test@example.org",
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			submissionLog.Close()
+			wal, err := lib.OpenSubmissionLog(walPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			submissionLog = wal
+			if wal.NumPending() != len(tc.docs) {
+				t.Fatalf("expected %d pending submissions after reopening, got %d",
+					len(tc.docs), wal.NumPending())
+			}
+
+			replaySubmissions()
+
+			volumes, _ := memory.ListVolumes()
+			if len(volumes) != len(tc.want) {
+				t.Fatalf("got %d volumes in the corpus, want %v", len(volumes), tc.want)
+			}
+			for idx, doc := range volumes {
+				if doc.Identifier != tc.want[idx] {
+					t.Errorf("volume %d is %s, want %s", idx, doc.Identifier, tc.want[idx])
+				}
+				if len(doc.History) != 1 || doc.History[0].Author.Email != "test@example.org" {
+					t.Errorf("volume %s was not stored with its author: %+v", doc.Identifier, doc.History)
+				}
+			}
+			if wal.NumPending() != 0 || retries.size() != 0 {
+				t.Errorf("%d submissions still pending, %d queued for retry",
+					wal.NumPending(), retries.size())
+			}
+			if info, err := os.Stat(walPath); err != nil || info.Size() != 0 {
+				t.Errorf("log was not truncated after the replay: %v", err)
+			}
+		})
+	}
+}
+
+func TestSubmitDocumentReturnsCommit(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+	}{
+		{"new volume", "POST"},
+		{"update", "PUT"},
+	}
+	memory, _ := useMemoryCorpus(t)
+	useOptions(t, Options{})
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			task := lib.TaskDefinition{
+				Document: testDocument("anzeiger_1874", "Zeile für "+tc.name),
+				Author:   "Test",
+				Email:    "test@example.org",
+			}
+			body, _ := json.Marshal(task)
+			req := httptest.NewRequest(tc.method, "/api/documents", bytes.NewReader(body))
+			rec := httptest.NewRecorder()
+			SubmitDocument(rec, req, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+			// The headers as they were sent with the status
+			if contentType := rec.Result().Header.Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("got Content-Type %q, want application/json", contentType)
+			}
+			var got lib.Document
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := memory.LoadVolume("anzeiger_1874")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Commit == "" || got.Commit != stored.History[0].Commit {
+				t.Errorf("response has commit %q, want the last one of the volume %q",
+					got.Commit, stored.History[0].Commit)
+			}
+		})
+	}
+}
+
+// failingStore fails to save any volume with an error that may go away
+type failingStore struct {
+	*lib.MemoryStore
+}
+
+var errPushFailed = errors.New("push failed")
+
+func (failingStore) SaveVolume(lib.Document, map[string][]string, string, string, string) (*lib.Document, error) {
+	return nil, errPushFailed
+}
+
+func TestFailedSubmissionIsRetriedOnlyIfTracked(t *testing.T) {
+	tests := []struct {
+		name        string
+		trackerFull bool
+		want        int
+		// Whether the submission stays in the log and is queued for a retry
+		wantRetry bool
+	}{
+		{"tracked", false, http.StatusAccepted, true},
+		{"tracker full", true, http.StatusInternalServerError, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			memory, _ := useMemoryCorpus(t)
+			corpus = failingStore{memory}
+			useOptions(t, Options{})
+			previousTracker, previousRetries := asyncSubmissions, retries
+			asyncSubmissions = &submissionTracker{
+				clock:   lib.SystemClock,
+				entries: make(map[string]*trackedSubmission),
+			}
+			retries = &retryQueue{
+				clock:   lib.SystemClock,
+				entries: make(map[int64]*queuedSubmission),
+				wake:    make(chan struct{}, 1),
+			}
+			t.Cleanup(func() { asyncSubmissions, retries = previousTracker, previousRetries })
+			if tc.trackerFull {
+				pending := &lib.TaskDefinition{Document: testDocument("anzeiger_1881")}
+				for i := 0; i < maxTrackedSubmissions; i++ {
+					if _, err := asyncSubmissions.add(pending); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			task := lib.TaskDefinition{
+				Document: testDocument("anzeiger_1882", "Neunte Zeile"),
+				Author:   "Test",
+				Email:    "test@example.org",
+			}
+			body, _ := json.Marshal(task)
+			rec := httptest.NewRecorder()
+			SubmitDocument(rec, httptest.NewRequest("PUT", "/api/documents", bytes.NewReader(body)), nil)
+			if rec.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if queued := retries.size() == 1; queued != tc.wantRetry {
+				t.Errorf("%d submissions queued for retry, want retry %v", retries.size(), tc.wantRetry)
+			}
+			if pending := submissionLog.NumPending() == 1; pending != tc.wantRetry {
+				t.Errorf("%d submissions pending in the log, want retry %v",
+					submissionLog.NumPending(), tc.wantRetry)
+			}
+		})
+	}
+}
+
+func TestShutdownIsBoundedByTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether an asynchronous submission or the retry worker hangs
+		hangingSubmission bool
+		hangingRetries    bool
+	}{
+		{"nothing running", false, false},
+		{"hanging submission", true, false},
+		{"hanging retries", false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, walPath := useMemoryCorpus(t)
+			useOptions(t, Options{ShutdownTimeout: 50 * time.Millisecond})
+			previousShutdown, previousStopped := shutdownChan, retries.stopped
+			shutdownChan, retries.stopped = make(chan struct{}), make(chan struct{})
+			t.Cleanup(func() { shutdownChan, retries.stopped = previousShutdown, previousStopped })
+			var logged bytes.Buffer
+			previousLogger := log.Logger
+			log.Logger = zerolog.New(&logged)
+			t.Cleanup(func() { log.Logger = previousLogger })
+
+			if _, err := submissionLog.Append(lib.TaskDefinition{
+				Document: testDocument("anzeiger_1875", "Sechste Zeile"),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			release := make(chan struct{})
+			defer close(release)
+			if tc.hangingSubmission {
+				asyncSubmissions.running.Add(1)
+				go func() {
+					<-release
+					asyncSubmissions.running.Done()
+				}()
+			}
+			if !tc.hangingRetries {
+				close(retries.stopped)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				shutdown()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("shutdown did not return after its timeout")
+			}
+			if !strings.Contains(logged.String(), `"numPending":1`) {
+				t.Errorf("pending submission was not logged:\n%s", logged.String())
+			}
+			wal, err := lib.OpenSubmissionLog(walPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wal.Close()
+			if wal.NumPending() != 1 {
+				t.Errorf("expected the submission to be left for replay, got %d", wal.NumPending())
+			}
+		})
+	}
+}
+
+func TestSubmitDocumentBodyLimits(t *testing.T) {
+	task := lib.TaskDefinition{
+		Document: testDocument("anzeiger_1876", "Siebte Zeile", "Achte Zeile"),
+		Author:   "Test",
+		Email:    "test@example.org",
+	}
+	body, _ := json.Marshal(task)
+	tests := []struct {
+		name string
+		opts Options
+		// Pause in the middle of sending the body
+		stall time.Duration
+		want  int
+	}{
+		{"within limits", Options{MaxSubmitBytes: 1 << 20, SubmitTimeout: time.Second}, 0, http.StatusOK},
+		{"over the size limit", Options{MaxSubmitBytes: int64(len(body) / 2)}, 0, http.StatusRequestEntityTooLarge},
+		{"slow body", Options{SubmitTimeout: 50 * time.Millisecond}, 500 * time.Millisecond, http.StatusRequestTimeout},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMemoryCorpus(t)
+			// The middleware wraps the response writer like in production
+			tc.opts.AccessLog = true
+			tc.opts.CompressLevel = DefaultCompressLevel
+			useOptions(t, tc.opts)
+			server := httptest.NewServer(newHandler(packr.NewBox("../client/dist")))
+			defer server.Close()
+			reader, writer := io.Pipe()
+			go func() {
+				writer.Write(body[:len(body)/2])
+				time.Sleep(tc.stall)
+				writer.Write(body[len(body)/2:])
+				writer.Close()
+			}()
+			resp, err := http.Post(server.URL+"/api/documents", "application/json", reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateDocument(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		valid bool
+		// Expected level and reasons of every line
+		levels  []lib.ValidationLevel
+		reasons []string
+	}{
+		{
+			"one valid and one invalid line",
+			[]string{"Neunte Zeile", "Zehnte\x07Zeile"},
+			false,
+			[]lib.ValidationLevel{lib.ValidationOK, lib.ValidationHard},
+			[]string{"", lib.ReasonControlCharacter},
+		},
+		{
+			"soft-failing line",
+			[]string{"Elfte Zeile", "x"},
+			true,
+			[]lib.ValidationLevel{lib.ValidationOK, lib.ValidationSoft},
+			[]string{"", lib.ReasonTooShort},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			memory, _ := useMemoryCorpus(t)
+			useOptions(t, Options{})
+			doc := testDocument("anzeiger_1879", tc.lines...)
+			body, _ := json.Marshal(lib.TaskDefinition{Document: doc})
+			rec := httptest.NewRecorder()
+			ValidateDocument(rec, httptest.NewRequest("POST", "/api/validate", bytes.NewReader(body)), nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+			var result ValidationResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid != tc.valid {
+				t.Errorf("valid is %v, want %v", result.Valid, tc.valid)
+			}
+			if len(result.Lines) != len(tc.lines) {
+				t.Fatalf("got %d line results, want %d", len(result.Lines), len(tc.lines))
+			}
+			for idx, line := range result.Lines {
+				if line.Identifier != doc.Lines[idx].Identifier || line.Level != tc.levels[idx] ||
+					strings.Join(line.Reasons, ",") != tc.reasons[idx] {
+					t.Errorf("line %d: got %+v, want level %s with reasons %q",
+						idx, line, tc.levels[idx], tc.reasons[idx])
+				}
+			}
+			if volumes, _ := memory.ListVolumes(); len(volumes) != 0 || submissionLog.NumPending() != 0 {
+				t.Errorf("validation stored the document")
+			}
+		})
+	}
+}
+
+func TestLineImagesAreNotCompressed(t *testing.T) {
+	useCaches(t)
+	useOptions(t, Options{CompressLevel: DefaultCompressLevel, CompressMinSize: 1})
+	const id = "anzeiger_1880_9a0b1c2d"
+	img := testPNG(t, 640, 64)
+	cacheImage(t, id, img)
+
+	req := httptest.NewRequest("GET", "/api/images/"+id, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	newHandler(packr.NewBox("../client/dist")).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	if encoding := rec.Header().Get("Content-Encoding"); encoding != "" {
+		t.Errorf("image is sent with Content-Encoding %s", encoding)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("got Content-Type %s, want image/png", contentType)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), img) {
+		t.Errorf("served %d bytes that differ from the cached image", rec.Body.Len())
+	}
+}
+
+func TestGetLineImageThumbnail(t *testing.T) {
+	useCaches(t)
+	previous := lib.ThumbnailHeight
+	lib.ThumbnailHeight = 16
+	defer func() { lib.ThumbnailHeight = previous }()
+	const id = "anzeiger_1880_5c6d7e8f"
+	full := testPNG(t, 640, 64)
+	cacheImage(t, id, full)
+	tests := []struct {
+		name       string
+		query      string
+		wantHeight int
+		etagSuffix string
+	}{
+		{"full image", "", 64, ""},
+		{"thumbnail", "?thumb=1", 16, "-thumb"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/api/images/"+id+tc.query, nil)
+			GetLineImage(rec, req, httprouter.Params{{Key: "id", Value: id}})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d", rec.Code)
+			}
+			img, err := png.Decode(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds().Dy() != tc.wantHeight {
+				t.Errorf("served image has height %d, want %d", img.Bounds().Dy(), tc.wantHeight)
+			}
+			if etag := rec.Header().Get("ETag"); !strings.HasSuffix(etag, tc.etagSuffix+`"`) {
+				t.Errorf("unexpected ETag %s", etag)
+			}
+		})
+	}
+}